@@ -0,0 +1,198 @@
+package ogórek
+// Support for arrays of Python array module.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"reflect"
+)
+
+var pyArray = Class{Module: "array", Name: "array"}
+
+// arrayFormats describes machine formats of array data, as pickled by
+// array._array_reconstructor, indexed by machine format code.
+//
+// Codes 18-21 are UTF-16 and UTF-32 formats of unicode arrays, that are not
+// supported.
+var arrayFormats = [...]struct {
+	elem  reflect.Type
+	order binary.ByteOrder
+}{
+	{reflect.TypeOf(uint8(0)), binary.LittleEndian},
+	{reflect.TypeOf(int8(0)), binary.LittleEndian},
+	{reflect.TypeOf(uint16(0)), binary.LittleEndian},
+	{reflect.TypeOf(uint16(0)), binary.BigEndian},
+	{reflect.TypeOf(int16(0)), binary.LittleEndian},
+	{reflect.TypeOf(int16(0)), binary.BigEndian},
+	{reflect.TypeOf(uint32(0)), binary.LittleEndian},
+	{reflect.TypeOf(uint32(0)), binary.BigEndian},
+	{reflect.TypeOf(int32(0)), binary.LittleEndian},
+	{reflect.TypeOf(int32(0)), binary.BigEndian},
+	{reflect.TypeOf(uint64(0)), binary.LittleEndian},
+	{reflect.TypeOf(uint64(0)), binary.BigEndian},
+	{reflect.TypeOf(int64(0)), binary.LittleEndian},
+	{reflect.TypeOf(int64(0)), binary.BigEndian},
+	{reflect.TypeOf(float32(0)), binary.LittleEndian},
+	{reflect.TypeOf(float32(0)), binary.BigEndian},
+	{reflect.TypeOf(float64(0)), binary.LittleEndian},
+	{reflect.TypeOf(float64(0)), binary.BigEndian},
+}
+
+// arrayTypecodes maps typecodes of numeric arrays to Go element types.
+//
+// The sizes of C long are those of 64-bit Unix.
+var arrayTypecodes = map[string]reflect.Type{
+	"b": reflect.TypeOf(int8(0)),
+	"B": reflect.TypeOf(uint8(0)),
+	"h": reflect.TypeOf(int16(0)),
+	"H": reflect.TypeOf(uint16(0)),
+	"i": reflect.TypeOf(int32(0)),
+	"I": reflect.TypeOf(uint32(0)),
+	"l": reflect.TypeOf(int64(0)),
+	"L": reflect.TypeOf(uint64(0)),
+	"q": reflect.TypeOf(int64(0)),
+	"Q": reflect.TypeOf(uint64(0)),
+	"f": reflect.TypeOf(float32(0)),
+	"d": reflect.TypeOf(float64(0)),
+}
+
+// arrayEncoding describes how Go slices with elements of given kind are
+// encoded as arrays: typecode, and machine format code of little-endian data.
+var arrayEncoding = map[reflect.Kind]struct {
+	typecode string
+	mformat  int64
+}{
+	reflect.Int8:    {"b", 1},
+	reflect.Uint16:  {"H", 2},
+	reflect.Int16:   {"h", 4},
+	reflect.Uint32:  {"I", 6},
+	reflect.Int32:   {"i", 8},
+	reflect.Uint64:  {"Q", 10},
+	reflect.Int64:   {"q", 12},
+	reflect.Float32: {"f", 14},
+	reflect.Float64: {"d", 16},
+}
+
+// handleArrayCall serves handleCall for Arrays mode.
+//
+// It handles array._array_reconstructor(array.array, typecode, mformat, data),
+// as pickled by Python 3 at protocol ≥ 3, and array.array(typecode, items),
+// as pickled at lower protocols and by Python 2. Items may be given as raw
+// little-endian data, as pickled by older Python 2.
+func (d *Decoder) handleArrayCall(class Class, argv Tuple) error {
+	switch class {
+	case Class{"array", "_array_reconstructor"}:
+		if len(argv) != 4 {
+			return fmt.Errorf("array: _array_reconstructor: unexpected number of args %d", len(argv))
+		}
+		if cls, ok := argv[0].(Class); !(ok && cls == pyArray) {
+			return errCallNotHandled
+		}
+		mformat, ok := argv[2].(int64)
+		if !ok {
+			return fmt.Errorf("array: _array_reconstructor: mformat: expect int; got %T", argv[2])
+		}
+		if !(0 <= mformat && mformat < int64(len(arrayFormats))) {
+			return errCallNotHandled // unicode array, or unknown format
+		}
+		data, err := ValueOf(argv[3]).Bytes()
+		if err != nil {
+			return fmt.Errorf("array: _array_reconstructor: %s", err)
+		}
+		format := arrayFormats[mformat]
+		s, err := arrayFromData(format.elem, format.order, []byte(data))
+		if err != nil {
+			return fmt.Errorf("array: _array_reconstructor: %s", err)
+		}
+		d.push(s)
+		return nil
+
+	case pyArray:
+		if len(argv) != 2 {
+			return fmt.Errorf("array: array: unexpected number of args %d", len(argv))
+		}
+		typecode, err := AsString(argv[0])
+		if err != nil {
+			return fmt.Errorf("array: array: typecode: %s", err)
+		}
+		elem, ok := arrayTypecodes[typecode]
+		if !ok {
+			return errCallNotHandled // e.g. unicode array
+		}
+		// Python 2 used to pickle arrays with raw data of items in native
+		// byte order, e.g. array('l', '\x01\x00...')
+		switch data := argv[1].(type) {
+		case string, ByteString, Bytes:
+			s, err := arrayFromData(elem, binary.LittleEndian, []byte(reflect.ValueOf(data).String()))
+			if err != nil {
+				return fmt.Errorf("array: array: %s", err)
+			}
+			d.push(s)
+			return nil
+		}
+		items, err := ValueOf(argv[1]).Elems()
+		if err != nil {
+			return fmt.Errorf("array: array: %s", err)
+		}
+		s := reflect.MakeSlice(reflect.SliceOf(elem), len(items), len(items))
+		for i, item := range items {
+			err = storeInto(s.Index(i), item.Interface())
+			if err != nil {
+				return fmt.Errorf("array: array: %s", storeErrorAt(err, pathIndex(i)))
+			}
+		}
+		d.push(s.Interface())
+		return nil
+	}
+
+	return errCallNotHandled
+}
+
+// arrayFromData decodes raw data of array items with given element type and
+// byte order into Go slice.
+func arrayFromData(elem reflect.Type, order binary.ByteOrder, data []byte) (any, error) {
+	size := int(elem.Size())
+	if len(data)%size != 0 {
+		return nil, fmt.Errorf("data size %d is not a multiple of item size %d", len(data), size)
+	}
+	s := reflect.MakeSlice(reflect.SliceOf(elem), len(data)/size, len(data)/size)
+	err := binary.Read(bytes.NewReader(data), order, s.Interface())
+	if err != nil {
+		return nil, err
+	}
+	return s.Interface(), nil
+}
+
+// encodeTypedArray encodes Go slice of numbers as array.array.
+//
+// As Python does, at protocol ≥ 3 the array is encoded as
+// array._array_reconstructor call with raw data of the items, and as
+// array.array(typecode, items) call at lower protocols.
+func (e *Encoder) encodeTypedArray(rv reflect.Value) error {
+	enc := arrayEncoding[rv.Type().Elem().Kind()]
+
+	if e.config.Protocol < 3 {
+		items := make([]any, rv.Len())
+		for i := range items {
+			items[i] = rv.Index(i).Interface()
+		}
+		return e.encodeCall(&Call{Callable: pyArray, Args: Tuple{enc.typecode, items}})
+	}
+
+	// binary.Write accepts only slices of basic types, not of named ones
+	elem := arrayFormats[enc.mformat].elem
+	s := reflect.MakeSlice(reflect.SliceOf(elem), rv.Len(), rv.Len())
+	for i := 0; i < rv.Len(); i++ {
+		s.Index(i).Set(rv.Index(i).Convert(elem))
+	}
+	data := &bytes.Buffer{}
+	err := binary.Write(data, binary.LittleEndian, s.Interface())
+	if err != nil {
+		return err
+	}
+	return e.encodeCall(&Call{
+		Callable: Class{"array", "_array_reconstructor"},
+		Args:     Tuple{pyArray, enc.typecode, enc.mformat, Bytes(data.String())},
+	})
+}
@@ -0,0 +1,126 @@
+package ogórek
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestArrayDecode(t *testing.T) {
+	config := &DecoderConfig{Arrays: true}
+	for _, tt := range []struct {
+		data string
+		want any
+	}{
+		// array('i', [1, -2, 3]), as pickled by Python
+		{"carray\narray\np0\n(Vi\np1\n(lp2\nI1\naI-2\naI3\natp3\nRp4\n.", []int32{1, -2, 3}},
+		{"carray\narray\np0\n(S'i'\np1\n(lp2\nI1\naI-2\naI3\natp3\nRp4\n.", []int32{1, -2, 3}},
+		{"\x80\x02carray\narray\nq\x00X\x01\x00\x00\x00iq\x01]q\x02(K\x01J\xfe\xff\xff\xffK\x03e\x86q\x03Rq\x04.", []int32{1, -2, 3}},
+		{"\x80\x03carray\n_array_reconstructor\nq\x00(carray\narray\nq\x01X\x01\x00\x00\x00iq\x02K\x08C\x0c\x01\x00\x00\x00\xfe\xff\xff\xff\x03\x00\x00\x00q\x03tq\x04Rq\x05.", []int32{1, -2, 3}},
+		{"\x80\x04\x95N\x00\x00\x00\x00\x00\x00\x00\x8c\x05array\x94\x8c\x14_array_reconstructor\x94\x93\x94(\x8c\x05array\x94\x8c\x05array\x94\x93\x94\x8c\x01i\x94K\x08C\x0c\x01\x00\x00\x00\xfe\xff\xff\xff\x03\x00\x00\x00\x94t\x94R\x94.", []int32{1, -2, 3}},
+
+		// array('d', [1.5, -2]), array('l', [7]), array('B', b'ab'), array('q')
+		{"\x80\x02carray\narray\nq\x00X\x01\x00\x00\x00dq\x01]q\x02G?\xf8\x00\x00\x00\x00\x00\x00a\x86q\x03Rq\x04.", []float64{1.5}},
+		{"\x80\x04\x95R\x00\x00\x00\x00\x00\x00\x00\x8c\x05array\x94\x8c\x14_array_reconstructor\x94\x93\x94(\x8c\x05array\x94\x8c\x05array\x94\x93\x94\x8c\x01d\x94K\x10C\x10\x00\x00\x00\x00\x00\x00\xf8?\x00\x00\x00\x00\x00\x00\x00\xc0\x94t\x94R\x94.", []float64{1.5, -2}},
+		{"\x80\x03carray\n_array_reconstructor\nq\x00(carray\narray\nq\x01X\x01\x00\x00\x00lq\x02K\x0cC\x08\x07\x00\x00\x00\x00\x00\x00\x00q\x03tq\x04Rq\x05.", []int64{7}},
+		{"\x80\x03carray\n_array_reconstructor\nq\x00(carray\narray\nq\x01X\x01\x00\x00\x00Bq\x02K\x00C\x02abq\x03tq\x04Rq\x05.", []uint8("ab")},
+		{"\x80\x04\x95B\x00\x00\x00\x00\x00\x00\x00\x8c\x05array\x94\x8c\x14_array_reconstructor\x94\x93\x94(\x8c\x05array\x94\x8c\x05array\x94\x93\x94\x8c\x01q\x94K\x0cC\x00\x94t\x94R\x94.", []int64{}},
+
+		// array('l', [1, -2]), array('d', [1.5]) with raw data, as pickled by older Python 2
+		{"carray\narray\np0\n(S'l'\np1\nS'\\x01\\x00\\x00\\x00\\x00\\x00\\x00\\x00\\xfe\\xff\\xff\\xff\\xff\\xff\\xff\\xff'\np2\ntp3\nRp4\n.", []int64{1, -2}},
+		{"\x80\x02carray\narray\nq\x00U\x01lq\x01U\x10\x01\x00\x00\x00\x00\x00\x00\x00\xfe\xff\xff\xff\xff\xff\xff\xffq\x02\x86q\x03Rq\x04.", []int64{1, -2}},
+		{"\x80\x02carray\narray\nq\x00U\x01dq\x01U\x08\x00\x00\x00\x00\x00\x00\xf8?q\x02\x86q\x03Rq\x04.", []float64{1.5}},
+		{"\x80\x03carray\narray\nX\x01\x00\x00\x00HC\x04\x01\x02\x03\x04\x86R.", []uint16{0x0201, 0x0403}},
+
+		// big-endian data, e.g. from s390x
+		{"\x80\x03carray\n_array_reconstructor\n(carray\narray\nX\x01\x00\x00\x00HK\x03C\x04\x01\x02\x03\x04tR.", []uint16{0x0102, 0x0304}},
+		{"\x80\x03carray\n_array_reconstructor\n(carray\narray\nX\x01\x00\x00\x00fK\x0fC\x04?\xc0\x00\x00tR.", []float32{1.5}},
+
+		// unicode arrays are left as calls
+		{"\x80\x02carray\narray\nX\x01\x00\x00\x00u]X\x01\x00\x00\x00ha\x86R.", Call{Class{"array", "array"}, Tuple{"u", []any{"h"}}}},
+		{"\x80\x03carray\n_array_reconstructor\n(carray\narray\nX\x01\x00\x00\x00uK\x14C\x04h\x00\x00\x00tR.",
+			Call{Class{"array", "_array_reconstructor"}, Tuple{Class{"array", "array"}, "u", int64(20), Bytes("h\x00\x00\x00")}}},
+	} {
+		obj, err := NewDecoderWithConfig(strings.NewReader(tt.data), config).Decode()
+		if err != nil {
+			t.Errorf("%q: %s", tt.data, err)
+			continue
+		}
+		if !reflect.DeepEqual(obj, tt.want) {
+			t.Errorf("%q:\nhave: %#v\nwant: %#v", tt.data, obj, tt.want)
+		}
+	}
+
+	for _, data := range []string{
+		"carray\narray\n(Vb\n(lI128\natR.",
+		"carray\narray\n(Vd\n(lVx\natR.",
+		"carray\narray\n(Vd\ntR.",
+		"carray\narray\n(S'i'\nS'\\x01\\x02\\x03'\ntR.",
+		"\x80\x03carray\n_array_reconstructor\n(carray\narray\nX\x01\x00\x00\x00iK\x08C\x03\x01\x02\x03tR.",
+		"\x80\x03carray\n_array_reconstructor\n(carray\narray\nX\x01\x00\x00\x00iK\x08X\x01\x00\x00\x00xtR.",
+	} {
+		_, err := NewDecoderWithConfig(strings.NewReader(data), config).Decode()
+		if err == nil {
+			t.Errorf("%q: no error", data)
+		}
+	}
+
+	// without Arrays arrays are left as calls
+	obj, err := NewDecoder(strings.NewReader("carray\narray\n(Vd\n(ltR.")).Decode()
+	if want := (Call{Class{"array", "array"}, Tuple{"d", []any{}}}); !(err == nil && reflect.DeepEqual(obj, want)) {
+		t.Errorf("!Arrays: have %#v, %v  ; want %#v", obj, err, want)
+	}
+}
+
+func TestArrayEncode(t *testing.T) {
+	for _, tt := range []struct {
+		proto int
+		in    any
+		want  string
+	}{
+		// the same as CPython produces, modulo memoization
+		{0, []int32{1, -2, 3}, "carray\narray\n(S\"i\"\n(lI1\naI-2\naI3\natR."},
+		{2, []int32{1, -2, 3}, "\x80\x02carray\narray\nU\x01i](K\x01J\xfe\xff\xff\xffK\x03e\x86R."},
+		{3, []int32{1, -2, 3}, "\x80\x03carray\n_array_reconstructor\n(carray\narray\nX\x01\x00\x00\x00iK\x08C\x0c\x01\x00\x00\x00\xfe\xff\xff\xff\x03\x00\x00\x00tR."},
+		{3, []float64{1.5, -2}, "\x80\x03carray\n_array_reconstructor\n(carray\narray\nX\x01\x00\x00\x00dK\x10C\x10\x00\x00\x00\x00\x00\x00\xf8?\x00\x00\x00\x00\x00\x00\x00\xc0tR."},
+
+		// []byte stays bytearray
+		{3, []byte("ab"), "\x80\x03cbuiltins\nbytearray\nC\x02ab\x85R."},
+	} {
+		buf := &bytes.Buffer{}
+		err := NewEncoderWithConfig(buf, &EncoderConfig{Protocol: tt.proto, Arrays: true}).Encode(tt.in)
+		if err != nil {
+			t.Errorf("protocol %d: %#v: %s", tt.proto, tt.in, err)
+			continue
+		}
+		if buf.String() != tt.want {
+			t.Errorf("protocol %d: %#v:\nhave: %q\nwant: %q", tt.proto, tt.in, buf.String(), tt.want)
+		}
+	}
+
+	type celsius float32
+	for _, proto := range []int{0, 2, 4} {
+		for _, in := range []any{
+			[]int8{-1, 2}, []int16{-1, 2}, []int32{}, []int64{-1 << 63},
+			[]uint16{1, 2}, []uint32{1 << 31}, []uint64{1 << 62},
+			[]float32{1.5}, []float64{-0.5, 1e300},
+		} {
+			err := VerifyRoundTrip(in, &EncoderConfig{Protocol: proto, Arrays: true}, &DecoderConfig{Arrays: true})
+			if err != nil {
+				t.Errorf("protocol %d: %s", proto, err)
+			}
+		}
+
+		buf := &bytes.Buffer{}
+		err := NewEncoderWithConfig(buf, &EncoderConfig{Protocol: proto, Arrays: true}).Encode([]celsius{36.5})
+		if err != nil {
+			t.Errorf("protocol %d: %s", proto, err)
+			continue
+		}
+		obj, err := NewDecoderWithConfig(buf, &DecoderConfig{Arrays: true}).Decode()
+		if want := []float32{36.5}; !(err == nil && reflect.DeepEqual(obj, want)) {
+			t.Errorf("protocol %d: named type: have %#v, %v  ; want %#v", proto, obj, err, want)
+		}
+	}
+}
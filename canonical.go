@@ -0,0 +1,54 @@
+package ogórek
+// Canonical form of pickles.
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Canonicalize re-encodes pickle data into canonical form at given protocol.
+//
+// The canonical form depends only on the unpickled object, and not on how
+// the pickle was produced: the pickle is decoded and the object is encoded
+// back with dict keys and set elements sorted as with SortKeys, without
+// framing, and with memo used only for values that are referenced more
+// than once. This way semantically equal pickles, e.g. pickles of the same
+// dict produced by different picklers, become byte-identical and can be
+// compared or hashed directly, e.g. for deduplication or as cache keys.
+//
+// The pickle is decoded in PyDict mode, so that e.g. Python 2 str and
+// unicode objects are kept distinct, and identity of shared objects is
+// preserved as with PreserveRefs. Persistent references are kept as [Ref].
+// Recursive structures and out-of-band buffers are not supported.
+func Canonicalize(data []byte, protocol int) ([]byte, error) {
+	dec := NewDecoderBytes(data, &DecoderConfig{PyDict: true, PreserveRefs: true})
+	obj, err := dec.Decode()
+	if err != nil {
+		return nil, fmt.Errorf("canonicalize: %w", err)
+	}
+
+	config := &EncoderConfig{
+		Protocol:      protocol,
+		StrictUnicode: true, // str and unicode are distinguished in PyDict mode
+		Memoize:       true,
+		SortKeys:      true,
+	}
+
+	// first pass finds out which values are referenced via memo, and the
+	// second pass memoizes only them.
+	e := NewEncoderWithConfig(&countWriter{}, config)
+	e.memoUsed = make(map[any]bool)
+	err = e.Encode(obj)
+	if err != nil {
+		return nil, fmt.Errorf("canonicalize: %w", err)
+	}
+
+	buf := &bytes.Buffer{}
+	e.w = buf
+	e.memoOnlyUsed = true
+	err = e.Encode(obj)
+	if err != nil {
+		return nil, fmt.Errorf("canonicalize: %w", err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,53 @@
+package ogórek
+
+import (
+	"testing"
+)
+
+func TestCanonicalize(t *testing.T) {
+	testv := []struct {
+		name     string
+		pickles  []string // semantically equal pickles
+		protocol int
+		want     string
+	}{
+		{"dict", []string{
+			// {'b': 1, 'a': (2, 3)} at protocol 0
+			"(dp0\nVb\np1\nI1\nsVa\np2\n(I2\nI3\ntp3\ns.",
+			// {'a': (2, 3), 'b': 1} at protocol 4
+			"\x80\x04\x95\x15\x00\x00\x00\x00\x00\x00\x00}\x94(\x8c\x01a\x94K\x02K\x03\x86\x94\x8c\x01b\x94K\x01u.",
+		}, 4, "\x80\x04}(\x8c\x01aK\x02K\x03\x86\x8c\x01bK\x01u."},
+
+		{"shared", []string{
+			// l = [1]; [l, l, 's', 's']
+			"\x80\x02]q\x00(]q\x01K\x01ah\x01X\x01\x00\x00\x00sq\x02h\x02e.",
+			"\x80\x02((K\x01lq\x07h\x07X\x01\x00\x00\x00sX\x01\x00\x00\x00sl.",
+		}, 2, "\x80\x02](]K\x01aq\x00h\x00X\x01\x00\x00\x00sq\x01h\x01e."},
+
+		{"set", []string{
+			// {1, 2, 3}
+			"\x80\x04\x95\x0b\x00\x00\x00\x00\x00\x00\x00\x8f\x94(K\x01K\x02K\x03\x90.",
+			"\x80\x04\x8f(K\x03K\x01K\x02\x90.",
+			"\x80\x02c__builtin__\nset\nq\x00]q\x01(K\x03K\x02K\x01e\x85q\x02Rq\x03.",
+		}, 4, "\x80\x04\x8f(K\x01K\x02K\x03\x90."},
+	}
+
+	for _, tt := range testv {
+		for _, data := range tt.pickles {
+			c, err := Canonicalize([]byte(data), tt.protocol)
+			if err != nil {
+				t.Errorf("%s: %q: %s", tt.name, data, err)
+				continue
+			}
+			if string(c) != tt.want {
+				t.Errorf("%s: %q:\nhave: %s\nwant: %s", tt.name, data, pyquote(string(c)), pyquote(tt.want))
+			}
+		}
+	}
+
+	// recursive structures cannot be canonicalized
+	_, err := Canonicalize([]byte("\x80\x02]q\x00h\x00a."), 2)
+	if want := "canonicalize: pickle: encode: [0]: recursive structures are not supported"; !(err != nil && err.Error() == want) {
+		t.Errorf("recursive: have %v; want %s", err, want)
+	}
+}
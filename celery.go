@@ -0,0 +1,208 @@
+package ogórek
+// Helpers for Celery (https://docs.celeryq.dev) messages serialized with pickle.
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// CeleryTask represents body of Celery task message.
+//
+// In task message protocol 2, that is the default since Celery 4, the body
+// is (args, kwargs, embed) tuple, while task name and id are transferred in
+// message headers. In task message protocol 1 the body is a dict that also
+// carries task name and id.
+type CeleryTask struct {
+	Task string // task name; only for message protocol 1
+	ID   string // task id;   only for message protocol 1
+
+	Args   []any
+	Kwargs map[string]any
+	Embed  CeleryEmbed
+}
+
+// CeleryEmbed represents canvas options embedded into Celery task message.
+type CeleryEmbed struct {
+	Callbacks any
+	Errbacks  any
+	Chain     any
+	Chord     any
+}
+
+// CeleryResult represents task result metadata, as stored by Celery result backends.
+type CeleryResult struct {
+	TaskID    string
+	Status    string // e.g. "SUCCESS", "FAILURE", "RETRY", ...
+	Result    any    // task return value, or exception for failed tasks
+	Traceback any
+	Children  any
+	DateDone  any
+
+	// Exception is set if Result is an exception.
+	Exception *CeleryException
+}
+
+// CeleryException represents Python exception, stored as task result.
+type CeleryException struct {
+	Class Class
+	Args  Tuple
+}
+
+func (e *CeleryException) Error() string {
+	msg := fmt.Sprint([]any(e.Args))
+	if len(e.Args) == 1 {
+		msg = fmt.Sprint(e.Args[0])
+	}
+	return fmt.Sprintf("%s.%s: %s", e.Class.Module, e.Class.Name, msg)
+}
+
+// DecodeCeleryTask decodes pickled body of Celery task message.
+//
+// Both message protocols 1 and 2 are supported.
+func DecodeCeleryTask(body []byte) (*CeleryTask, error) {
+	obj, err := NewDecoder(bytes.NewReader(body)).Decode()
+	if err != nil {
+		return nil, fmt.Errorf("celery: task: %w", err)
+	}
+
+	task := &CeleryTask{}
+	switch obj := obj.(type) {
+	// protocol 2: (args, kwargs, embed)
+	case Tuple:
+		if len(obj) != 3 {
+			return nil, fmt.Errorf("celery: task: expect (args, kwargs, embed); got %d items", len(obj))
+		}
+		err = task.setArgs(obj[0], obj[1])
+		if err == nil {
+			err = task.Embed.set(obj[2])
+		}
+
+	// protocol 1: {"task": ..., "id": ..., "args": ..., "kwargs": ..., ...}
+	case map[any]any:
+		task.Task, err = AsString(obj["task"])
+		if err == nil {
+			task.ID, err = AsString(obj["id"])
+		}
+		if err == nil {
+			err = task.setArgs(obj["args"], obj["kwargs"])
+		}
+		if err == nil {
+			task.Embed.Callbacks = obj["callbacks"]
+			task.Embed.Errbacks = obj["errbacks"]
+			task.Embed.Chord = obj["chord"]
+		}
+
+	default:
+		return nil, fmt.Errorf("celery: task: unexpected body type %T", obj)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("celery: task: %s", err)
+	}
+	return task, nil
+}
+
+// EncodeCeleryTask writes task into w as pickled body of Celery task message.
+//
+// The body is emitted in message protocol 2. If config is nil, protocol 4,
+// as used by Celery by default, is used.
+func EncodeCeleryTask(w io.Writer, task *CeleryTask, config *EncoderConfig) error {
+	if config == nil {
+		config = &EncoderConfig{Protocol: 4}
+	}
+
+	args := Tuple(task.Args)
+	if args == nil {
+		args = Tuple{}
+	}
+	kwargs := task.Kwargs
+	if kwargs == nil {
+		kwargs = map[string]any{}
+	}
+	embed := map[string]any{
+		"callbacks": task.Embed.Callbacks,
+		"errbacks":  task.Embed.Errbacks,
+		"chain":     task.Embed.Chain,
+		"chord":     task.Embed.Chord,
+	}
+
+	return NewEncoderWithConfig(w, config).Encode(Tuple{args, kwargs, embed})
+}
+
+// DecodeCeleryResult decodes pickled task result metadata.
+func DecodeCeleryResult(data []byte) (*CeleryResult, error) {
+	obj, err := NewDecoder(bytes.NewReader(data)).Decode()
+	if err != nil {
+		return nil, fmt.Errorf("celery: result: %w", err)
+	}
+	meta, ok := obj.(map[any]any)
+	if !ok {
+		return nil, fmt.Errorf("celery: result: expect dict; got %T", obj)
+	}
+
+	res := &CeleryResult{
+		Result:    meta["result"],
+		Traceback: meta["traceback"],
+		Children:  meta["children"],
+		DateDone:  meta["date_done"],
+	}
+	res.Status, err = AsString(meta["status"])
+	if err != nil {
+		return nil, fmt.Errorf("celery: result: status: %s", err)
+	}
+	if id, ok := meta["task_id"]; ok {
+		res.TaskID, err = AsString(id)
+		if err != nil {
+			return nil, fmt.Errorf("celery: result: task_id: %s", err)
+		}
+	}
+
+	// exceptions are pickled as calls to exception class
+	if call, ok := res.Result.(Call); ok && res.Status != "SUCCESS" {
+		res.Exception = &CeleryException{Class: call.Callable, Args: call.Args}
+	}
+
+	return res, nil
+}
+
+// setArgs sets task args and kwargs from their decoded form.
+func (task *CeleryTask) setArgs(xargs, xkwargs any) error {
+	switch args := xargs.(type) {
+	case Tuple:
+		task.Args = args
+	case []any:
+		task.Args = args
+	case *[]any:
+		task.Args = *args
+	default:
+		return fmt.Errorf("args: expect tuple|list; got %T", xargs)
+	}
+
+	kwargs, ok := xkwargs.(map[any]any)
+	if !ok {
+		return fmt.Errorf("kwargs: expect dict; got %T", xkwargs)
+	}
+	task.Kwargs = make(map[string]any, len(kwargs))
+	for k, v := range kwargs {
+		key, err := AsString(k)
+		if err != nil {
+			return fmt.Errorf("kwargs: key: %s", err)
+		}
+		task.Kwargs[key] = v
+	}
+	return nil
+}
+
+// set sets embed options from their decoded form.
+func (embed *CeleryEmbed) set(xembed any) error {
+	m, ok := xembed.(map[any]any)
+	if !ok {
+		return fmt.Errorf("embed: expect dict; got %T", xembed)
+	}
+	embed.Callbacks = m["callbacks"]
+	embed.Errbacks = m["errbacks"]
+	embed.Chain = m["chain"]
+	embed.Chord = m["chord"]
+	return nil
+}
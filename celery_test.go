@@ -0,0 +1,95 @@
+package ogórek
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestCeleryTask(t *testing.T) {
+	// pickle.dumps(((1,'a'), {'x':2}, {'callbacks':None, 'errbacks':None, 'chain':None, 'chord':None}), protocol=4)
+	body2 := "\x80\x04\x95C\x00\x00\x00\x00\x00\x00\x00K\x01\x8c\x01a\x94\x86\x94}\x94\x8c\x01x\x94K\x02s}\x94" +
+		"(\x8c\x09callbacks\x94N\x8c\x08errbacks\x94N\x8c\x05chain\x94N\x8c\x05chord\x94Nu\x87\x94."
+
+	// pickle.dumps({'task':'tasks.add', 'id':'abc', 'args':(1,2), 'kwargs':{}, 'retries':0, 'eta':None, 'expires':None}, protocol=2)
+	body1 := "\x80\x02}q\x00(X\x04\x00\x00\x00taskq\x01X\x09\x00\x00\x00tasks.addq\x02X\x02\x00\x00\x00idq\x03" +
+		"X\x03\x00\x00\x00abcq\x04X\x04\x00\x00\x00argsq\x05K\x01K\x02\x86q\x06X\x06\x00\x00\x00kwargsq\x07}q\x08" +
+		"X\x07\x00\x00\x00retriesq\x09K\x00X\x03\x00\x00\x00etaq\nNX\x07\x00\x00\x00expiresq\x0bNu."
+
+	none := CeleryEmbed{None{}, None{}, None{}, None{}}
+	testv := []struct {
+		body string
+		task *CeleryTask
+	}{
+		{body2, &CeleryTask{Args: []any{int64(1), "a"}, Kwargs: map[string]any{"x": int64(2)}, Embed: none}},
+		{body1, &CeleryTask{Task: "tasks.add", ID: "abc", Args: []any{int64(1), int64(2)}, Kwargs: map[string]any{}}},
+	}
+
+	for _, tt := range testv {
+		task, err := DecodeCeleryTask([]byte(tt.body))
+		if err != nil {
+			t.Errorf("decode %q: %s", tt.body, err)
+			continue
+		}
+		if !reflect.DeepEqual(task, tt.task) {
+			t.Errorf("decode %q:\nhave: %#v\nwant: %#v", tt.body, task, tt.task)
+		}
+	}
+
+	// encode -> the same as python
+	buf := &bytes.Buffer{}
+	err := EncodeCeleryTask(buf, testv[0].task, &EncoderConfig{Protocol: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	task, err := DecodeCeleryTask(buf.Bytes())
+	if !(reflect.DeepEqual(task, testv[0].task) && err == nil) {
+		t.Errorf("encode/decode:\nhave: %#v, %v\nwant: %#v", task, err, testv[0].task)
+	}
+
+	// invalid bodies
+	for _, body := range []string{"K\x01.", "(K\x01t.", "K\x01}}\x87.", "(K\x01}}\x87.", ")(K\x01K\x02d}\x87."} {
+		_, err := DecodeCeleryTask([]byte(body))
+		if err == nil {
+			t.Errorf("decode %q: no error", body)
+		}
+	}
+}
+
+func TestCeleryResult(t *testing.T) {
+	// pickle.dumps({'status':'SUCCESS', 'result':3, 'traceback':None, 'children':[],
+	//               'date_done':'2024-01-02T03:04:05', 'task_id':'abc'}, protocol=4)
+	ok := "\x80\x04\x95o\x00\x00\x00\x00\x00\x00\x00}\x94(\x8c\x06status\x94\x8c\x07SUCCESS\x94\x8c\x06result\x94" +
+		"K\x03\x8c\x09traceback\x94N\x8c\x08children\x94]\x94\x8c\x09date_done\x94\x8c\x132024-01-02T03:04:05\x94" +
+		"\x8c\x07task_id\x94\x8c\x03abc\x94u."
+
+	// the same with 'status':'FAILURE', 'result':ValueError('bad', 1), 'traceback':'Traceback...'
+	fail := "\x80\x04\x95\xa1\x00\x00\x00\x00\x00\x00\x00}\x94(\x8c\x06status\x94\x8c\x07FAILURE\x94\x8c\x06result\x94" +
+		"\x8c\x08builtins\x94\x8c\nValueError\x94\x93\x94\x8c\x03bad\x94K\x01\x86\x94R\x94\x8c\x09traceback\x94" +
+		"\x8c\x0cTraceback...\x94\x8c\x08children\x94]\x94\x8c\x09date_done\x94\x8c\x132024-01-02T03:04:05\x94" +
+		"\x8c\x07task_id\x94\x8c\x03abc\x94u."
+
+	valueError := Class{"builtins", "ValueError"}
+	testv := []struct {
+		data string
+		res  *CeleryResult
+	}{
+		{ok, &CeleryResult{TaskID: "abc", Status: "SUCCESS", Result: int64(3), Traceback: None{},
+			Children: []any{}, DateDone: "2024-01-02T03:04:05"}},
+		{fail, &CeleryResult{TaskID: "abc", Status: "FAILURE",
+			Result: Call{valueError, Tuple{"bad", int64(1)}}, Traceback: "Traceback...",
+			Children: []any{}, DateDone: "2024-01-02T03:04:05",
+			Exception: &CeleryException{valueError, Tuple{"bad", int64(1)}}}},
+	}
+
+	for _, tt := range testv {
+		res, err := DecodeCeleryResult([]byte(tt.data))
+		if !(reflect.DeepEqual(res, tt.res) && err == nil) {
+			t.Errorf("decode %q:\nhave: %#v, %v\nwant: %#v", tt.data, res, err, tt.res)
+		}
+	}
+
+	if msg, want := testv[1].res.Exception.Error(), "builtins.ValueError: [bad 1]"; msg != want {
+		t.Errorf("exception: error: have %q  ; want %q", msg, want)
+	}
+}
@@ -0,0 +1,219 @@
+package ogórek
+// Support for pickles produced by cloudpickle (https://github.com/cloudpipe/cloudpickle).
+//
+// cloudpickle serializes dynamic functions, classes and modules by value
+// via calls to its own constructors. In CloudPickle mode the decoder
+// recognizes those calls and represents their results with the types below.
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Module represents Python module, as imported by cloudpickle's subimport
+// and dynamic_subimport.
+type Module struct {
+	Name string
+	Vars any // module variables for dynamic modules; nil otherwise
+}
+
+// Function represents dynamic Python function serialized by cloudpickle.
+type Function struct {
+	Code     any // code object; typically Call of types.CodeType
+	Globals  any // base globals of the function
+	Name     any
+	Defaults any
+	Closure  any // typically Tuple of *Cell
+	State    any // function state attached by _function_setstate or _fill_function
+}
+
+// Cell represents Python cell object, that is used in closures.
+type Cell struct {
+	Value any
+	Empty bool // whether the cell has no value
+}
+
+// DynamicClass represents dynamic Python class serialized by cloudpickle.
+type DynamicClass struct {
+	Constructor any // type constructor, e.g. Class{"__builtin__", "type"}
+	Name        any
+	Bases       any
+	Kwargs      any // type keyword arguments, e.g. metaclass
+	TrackerID   any // class tracker id, that cloudpickle uses to deduplicate classes
+	State       any // class state attached by _class_setstate
+}
+
+// isCloudpickle returns whether class is defined in cloudpickle.
+func isCloudpickle(class Class) bool {
+	return class.Module == "cloudpickle" || strings.HasPrefix(class.Module, "cloudpickle.")
+}
+
+// handleCloudpickleCall serves handleCall in CloudPickle mode.
+func (d *Decoder) handleCloudpickleCall(class Class, argv Tuple) error {
+	// types.FunctionType(code, globals, name, argdefs, closure), as emitted by older cloudpickle
+	if class == (Class{Module: "types", Name: "FunctionType"}) {
+		return d.cloudpickleMakeFunction(argv)
+	}
+
+	if !isCloudpickle(class) {
+		return errCallNotHandled
+	}
+
+	nargsOK := func(min, max int) error {
+		if !(min <= len(argv) && len(argv) <= max) {
+			return fmt.Errorf("cloudpickle: %s: unexpected number of args %d", class.Name, len(argv))
+		}
+		return nil
+	}
+
+	switch class.Name {
+	case "subimport":
+		if err := nargsOK(1, 1); err != nil {
+			return err
+		}
+		name, err := AsString(argv[0])
+		if err != nil {
+			return fmt.Errorf("cloudpickle: subimport: %s", err)
+		}
+		d.push(Module{Name: name})
+
+	case "dynamic_subimport":
+		if err := nargsOK(2, 2); err != nil {
+			return err
+		}
+		name, err := AsString(argv[0])
+		if err != nil {
+			return fmt.Errorf("cloudpickle: dynamic_subimport: %s", err)
+		}
+		d.push(Module{Name: name, Vars: argv[1]})
+
+	case "_builtin_type":
+		if err := nargsOK(1, 1); err != nil {
+			return err
+		}
+		name, err := AsString(argv[0])
+		if err != nil {
+			return fmt.Errorf("cloudpickle: _builtin_type: %s", err)
+		}
+		d.push(Class{Module: "types", Name: name})
+
+	case "_make_function":
+		return d.cloudpickleMakeFunction(argv)
+
+	// _make_skel_func(code, cell_count, base_globals)
+	case "_make_skel_func":
+		if err := nargsOK(2, 3); err != nil {
+			return err
+		}
+		f := &Function{Code: argv[0]}
+		if n, err := AsInt64(argv[1]); err == nil && n >= 0 {
+			closure := make(Tuple, n)
+			for i := range closure {
+				closure[i] = &Cell{Empty: true}
+			}
+			f.Closure = closure
+		}
+		if len(argv) == 3 {
+			f.Globals = argv[2]
+		}
+		d.push(f)
+
+	// _fill_function(func, state)  or  _fill_function(func, globals, defaults, dict, ...)
+	case "_fill_function":
+		if err := nargsOK(2, 6); err != nil {
+			return err
+		}
+		f, ok := argv[0].(*Function)
+		if !ok {
+			return fmt.Errorf("cloudpickle: _fill_function: expect function; got %T", argv[0])
+		}
+		if len(argv) == 2 {
+			f.State = argv[1]
+		} else {
+			f.State = argv[1:]
+		}
+		d.push(f)
+
+	case "_function_setstate":
+		if err := nargsOK(2, 2); err != nil {
+			return err
+		}
+		f, ok := argv[0].(*Function)
+		if !ok {
+			return fmt.Errorf("cloudpickle: _function_setstate: expect function; got %T", argv[0])
+		}
+		f.State = argv[1]
+		d.push(None{})
+
+	case "_make_empty_cell":
+		if err := nargsOK(0, 0); err != nil {
+			return err
+		}
+		d.push(&Cell{Empty: true})
+
+	case "_make_cell":
+		if err := nargsOK(0, 1); err != nil {
+			return err
+		}
+		cell := &Cell{Empty: true}
+		if len(argv) == 1 {
+			cell.Value, cell.Empty = argv[0], false
+		}
+		d.push(cell)
+
+	case "cell_set":
+		if err := nargsOK(2, 2); err != nil {
+			return err
+		}
+		cell, ok := argv[0].(*Cell)
+		if !ok {
+			return fmt.Errorf("cloudpickle: cell_set: expect cell; got %T", argv[0])
+		}
+		cell.Value, cell.Empty = argv[1], false
+		d.push(None{})
+
+	// _make_skeleton_class(type_constructor, name, bases, type_kwargs, class_tracker_id, extra)
+	case "_make_skeleton_class":
+		if err := nargsOK(5, 6); err != nil {
+			return err
+		}
+		d.push(&DynamicClass{
+			Constructor: argv[0],
+			Name:        argv[1],
+			Bases:       argv[2],
+			Kwargs:      argv[3],
+			TrackerID:   argv[4],
+		})
+
+	case "_class_setstate":
+		if err := nargsOK(2, 2); err != nil {
+			return err
+		}
+		c, ok := argv[0].(*DynamicClass)
+		if !ok {
+			return fmt.Errorf("cloudpickle: _class_setstate: expect class; got %T", argv[0])
+		}
+		c.State = argv[1]
+		d.push(c)
+
+	default:
+		return errCallNotHandled
+	}
+
+	return nil
+}
+
+// cloudpickleMakeFunction handles _make_function(code, globals, name, argdefs, closure).
+func (d *Decoder) cloudpickleMakeFunction(argv Tuple) error {
+	if !(2 <= len(argv) && len(argv) <= 5) {
+		return fmt.Errorf("cloudpickle: function: unexpected number of args %d", len(argv))
+	}
+	f := &Function{Code: argv[0], Globals: argv[1]}
+	for i, p := range []*any{&f.Name, &f.Defaults, &f.Closure} {
+		if 2+i < len(argv) {
+			*p = argv[2+i]
+		}
+	}
+	d.push(f)
+	return nil
+}
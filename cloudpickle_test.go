@@ -0,0 +1,134 @@
+package ogórek
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// pickles produced by cloudpickle 2.1 with protocol=2 for
+//
+//	import math
+//	y = 5
+//	def g():
+//	    z = 3
+//	    def h(k=2): return z + y
+//	    return h
+//	class C:
+//	    a = 1
+//
+// cloudpickle.dumps(math), cloudpickle.dumps(C) and cloudpickle.dumps(g()).
+const (
+	cloudpickleModule =
+		"\x80\x02ccloudpickle.cloudpickle\nsubimport\nq\x00X\x04\x00\x00\x00m" +
+		"athq\x01\x85q\x02Rq\x03."
+
+	cloudpickleClass =
+		"\x80\x02ccloudpickle.cloudpickle\n_make_skeleton_class\nq\x00(c__bui" +
+		"ltin__\ntype\nq\x01X\x01\x00\x00\x00Cq\x02c__builtin__\nobject\n" +
+		"q\x03\x85q\x04}q\x05X \x00\x00\x0049efc019a1914b3db4be31101a77c943q\x06N" +
+		"tq\x07Rq\x08ccloudpickle.cloudpickle_fast\n_class_setstate\nq\x09h" +
+		"\x08}q\n(X\n\x00\x00\x00__module__q\x0bX\x08\x00\x00\x00__main__q" +
+		"\x0cX\x01\x00\x00\x00aq\x0dK\x01X\x07\x00\x00\x00__doc__q\x0eNu}q\x0f" +
+		"\x86q\x10\x86R0."
+
+	cloudpickleFunction =
+		"\x80\x02ccloudpickle.cloudpickle\n_make_function\nq\x00(ccloudpickle" +
+		".cloudpickle\n_builtin_type\nq\x01X\x08\x00\x00\x00CodeTypeq\x02\x85" +
+		"q\x03Rq\x04(K\x01K\x00K\x00K\x01K\x02K\x13c_codecs\nencode\nq\x05X" +
+		"\x1b\x00\x00\x00\xc2\x95\x01\xc2\x97\x00\xc2\x89\x01t\x00\x00\x00\x00" +
+		"\x00\x00\x00\x00\x00\x00\x00z\x00\x00\x00S\x00q\x06X\x06\x00\x00\x00lati" +
+		"n1q\x07\x86q\x08Rq\x09N\x85q\nX\x01\x00\x00\x00yq\x0b\x85q\x0cX\x01" +
+		"\x00\x00\x00kq\x0d\x85q\x0eX\x07\x00\x00\x00<stdin>q\x0fX\x01\x00\x00" +
+		"\x00hq\x10X\x0c\x00\x00\x00g.<locals>.hq\x11K\x05h\x05X\x11\x00\x00\x00" +
+		"\xc3\xb8\xc2\x80\x00\xc2\x90q\xc2\x9d1\xc2\x91u\xc2\x90\x0cq\x12h\x07" +
+		"\x86q\x13Rq\x14c__builtin__\nbytes\nq\x15)Rq\x16X\x01\x00\x00\x00zq" +
+		"\x17\x85q\x18)tq\x19Rq\x1a}q\x1b(X\x0b\x00\x00\x00__package__q\x1cNX\x08" +
+		"\x00\x00\x00__name__q\x1dX\x08\x00\x00\x00__main__q\x1eX\x08\x00\x00\x00" +
+		"__file__q\x1fh\x0fuNNccloudpickle.cloudpickle\n_make_empty_cell\nq )" +
+		"Rq!\x85q\"tq#Rq$ccloudpickle.cloudpickle_fast\n_function_setstate\nq" +
+		"%h$}q&}q'(h\x1dh\x10X\x0c\x00\x00\x00__qualname__q(h\x11X\x0f\x00\x00" +
+		"\x00__annotations__q)}q*X\x0e\x00\x00\x00__kwdefaults__q+NX\x0c\x00\x00" +
+		"\x00__defaults__q,K\x02\x85q-X\n\x00\x00\x00__module__q.h\x1eX\x07\x00" +
+		"\x00\x00__doc__q/NX\x0b\x00\x00\x00__closure__q0ccloudpickle.cloudpickle" +
+		"\n_make_cell\nq1K\x03\x85q2Rq3\x85q4X\x17\x00\x00\x00_cloudpickle_su" +
+		"bmodulesq5]q6X\x0b\x00\x00\x00__globals__q7}q8h\x0bK\x05su\x86q9\x86R0."
+)
+
+func TestDecodeCloudpickle(t *testing.T) {
+	decode_ := func(data string, cloudpickle bool) (any, error) {
+		d := NewDecoderWithConfig(bytes.NewReader([]byte(data)), &DecoderConfig{CloudPickle: cloudpickle})
+		return d.Decode()
+	}
+	decode := func(data string, cloudpickle bool) any {
+		t.Helper()
+		obj, err := decode_(data, cloudpickle)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return obj
+	}
+
+	// without CloudPickle mode cloudpickle constructors are decoded as Call
+	for _, data := range []string{cloudpickleModule, cloudpickleClass} {
+		obj := decode(data, false)
+		call, ok := obj.(Call)
+		if !(ok && isCloudpickle(call.Callable)) {
+			t.Errorf("decode !cloudpickle: have %T  ; want Call(cloudpickle)", obj)
+		}
+	}
+	// ... and functions cannot be decoded at all, because their code is
+	// created via call to result of _builtin_type call.
+	_, err := decode_(cloudpickleFunction, false)
+	if want := "pickle: reduce: invalid class: ogórek.Call"; err == nil || err.Error() != want {
+		t.Errorf("decode function !cloudpickle: have error %v  ; want %q", err, want)
+	}
+
+	// module
+	if obj, want := decode(cloudpickleModule, true), (Module{Name: "math"}); obj != want {
+		t.Errorf("module: have %#v  ; want %#v", obj, want)
+	}
+
+	// class
+	obj := decode(cloudpickleClass, true)
+	c, ok := obj.(*DynamicClass)
+	if !ok {
+		t.Fatalf("class: have %T  ; want *DynamicClass", obj)
+	}
+	if c.Constructor != (Class{"__builtin__", "type"}) || c.Name != "C" ||
+		!reflect.DeepEqual(c.Bases, Tuple{Class{"__builtin__", "object"}}) {
+		t.Errorf("class: unexpected %#v", c)
+	}
+	state, ok := c.State.(Tuple)
+	if !(ok && len(state) == 2 && reflect.DeepEqual(state[0],
+		map[any]any{"__module__": "__main__", "a": int64(1), "__doc__": None{}})) {
+		t.Errorf("class: unexpected state %#v", c.State)
+	}
+
+	// function
+	obj = decode(cloudpickleFunction, true)
+	f, ok := obj.(*Function)
+	if !ok {
+		t.Fatalf("function: have %T  ; want *Function", obj)
+	}
+	if code, ok := f.Code.(Call); !(ok && code.Callable == (Class{"types", "CodeType"})) {
+		t.Errorf("function: code: have %#v  ; want Call(types.CodeType)", f.Code)
+	}
+	if f.Name != (None{}) || !reflect.DeepEqual(f.Closure, Tuple{&Cell{Empty: true}}) {
+		t.Errorf("function: unexpected %#v", f)
+	}
+	state, ok = f.State.(Tuple)
+	if !ok || len(state) != 2 {
+		t.Fatalf("function: unexpected state %#v", f.State)
+	}
+	slotstate := state[1].(map[any]any)
+	if name := slotstate["__name__"]; name != "h" {
+		t.Errorf("function: name: have %#v  ; want \"h\"", name)
+	}
+	if closure := slotstate["__closure__"]; !reflect.DeepEqual(closure, Tuple{&Cell{Value: int64(3)}}) {
+		t.Errorf("function: closure: have %#v", closure)
+	}
+	if globals := slotstate["__globals__"]; !reflect.DeepEqual(globals, map[any]any{"y": int64(5)}) {
+		t.Errorf("function: globals: have %#v", globals)
+	}
+}
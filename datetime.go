@@ -0,0 +1,570 @@
+package ogórek
+// Support for objects of Python datetime module (https://docs.python.org/3/library/datetime.html).
+//
+// date, time and datetime objects are pickled as calls to their classes with
+// the object state packed into bytes, followed by optional time zone. In
+// DateTime mode the decoder translates them into Date, Time and DateTime,
+// and time zones - datetime.timezone, zoneinfo.ZoneInfo and pytz zones -
+// into *time.Location. The encoder always emits Date, Time, DateTime and
+// *time.Location as datetime objects.
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Date represents Python datetime.date.
+type Date struct {
+	Year  int
+	Month time.Month
+	Day   int
+}
+
+// DateOf returns the date at which t occurs in its location.
+func DateOf(t time.Time) Date {
+	y, m, d := t.Date()
+	return Date{Year: y, Month: m, Day: d}
+}
+
+// IsValid returns whether d is a date that Python can represent.
+func (d Date) IsValid() bool {
+	return 1 <= d.Year && d.Year <= 9999 &&
+		time.January <= d.Month && d.Month <= time.December &&
+		1 <= d.Day && d.Day <= daysIn(d.Month, d.Year)
+}
+
+// String returns d in ISO 8601 format, e.g. "2024-01-15".
+func (d Date) String() string {
+	return fmt.Sprintf("%04d-%02d-%02d", d.Year, d.Month, d.Day)
+}
+
+// daysIn returns the number of days in month m of year y.
+func daysIn(m time.Month, y int) int {
+	return time.Date(y, m+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
+
+// Time represents Python datetime.time.
+type Time struct {
+	Hour, Minute, Second, Microsecond int
+
+	// Fold is 0 or 1 to disambiguate wall times, that repeat when clocks
+	// are moved back, e.g. at the end of daylight saving time. Fold is
+	// preserved only with protocol ≥ 4.
+	Fold int
+
+	// TZInfo is the time zone. It is *time.Location for time zones, that
+	// are recognized in DateTime mode, or the tzinfo object as decoded
+	// otherwise. TZInfo is nil for naive time.
+	TZInfo any
+}
+
+// IsValid returns whether t is a time that Python can represent.
+func (t Time) IsValid() bool {
+	return 0 <= t.Hour && t.Hour < 24 &&
+		0 <= t.Minute && t.Minute < 60 &&
+		0 <= t.Second && t.Second < 60 &&
+		0 <= t.Microsecond && t.Microsecond < 1000000 &&
+		(t.Fold == 0 || t.Fold == 1)
+}
+
+// String returns t in ISO 8601 format without time zone, e.g. "12:34:56" or
+// "12:34:56.000789" as Python does.
+func (t Time) String() string {
+	s := fmt.Sprintf("%02d:%02d:%02d", t.Hour, t.Minute, t.Second)
+	if t.Microsecond != 0 {
+		s += fmt.Sprintf(".%06d", t.Microsecond)
+	}
+	return s
+}
+
+// DateTime represents Python datetime.datetime.
+//
+// The datetime is aware if it has TZInfo, and naive otherwise.
+type DateTime struct {
+	Date
+	Time
+}
+
+// DateTimeOf returns aware datetime corresponding to t, with t's location
+// as TZInfo. Nanoseconds are truncated to microseconds.
+func DateTimeOf(t time.Time) DateTime {
+	return DateTime{
+		Date: DateOf(t),
+		Time: Time{
+			Hour:        t.Hour(),
+			Minute:      t.Minute(),
+			Second:      t.Second(),
+			Microsecond: t.Nanosecond() / 1000,
+			TZInfo:      t.Location(),
+		},
+	}
+}
+
+// In returns dt as time.Time in location loc.
+//
+// Aware datetime, that has *time.Location as TZInfo, is converted to loc
+// from its time zone. Other datetimes are interpreted as wall time in loc.
+func (dt DateTime) In(loc *time.Location) time.Time {
+	zone := loc
+	if l, ok := dt.TZInfo.(*time.Location); ok {
+		zone = l
+	}
+	t := time.Date(dt.Year, dt.Month, dt.Day, dt.Hour, dt.Minute, dt.Second, dt.Microsecond*1000, zone)
+	return t.In(loc)
+}
+
+// IsValid returns whether dt is a datetime that Python can represent.
+func (dt DateTime) IsValid() bool {
+	return dt.Date.IsValid() && dt.Time.IsValid()
+}
+
+// String returns dt in ISO 8601 format without time zone, e.g.
+// "2024-01-15 12:34:56" as Python does.
+func (dt DateTime) String() string {
+	return dt.Date.String() + " " + dt.Time.String()
+}
+
+// handleDateTimeCall serves handleCall in DateTime mode.
+func (d *Decoder) handleDateTimeCall(class Class, argv Tuple) error {
+	var obj any
+	var err error
+	switch class {
+	// date(state) or date(year, month, day)
+	case Class{"datetime", "date"}:
+		obj, err = datetimeDate(argv)
+
+	// time(state[, tzinfo]) or time(hour, minute, second, microsecond[, tzinfo])
+	case Class{"datetime", "time"}:
+		obj, err = datetimeTime(argv)
+
+	// datetime(state[, tzinfo]) or datetime(year, month, day, hour, ...[, tzinfo])
+	case Class{"datetime", "datetime"}:
+		obj, err = datetimeDateTime(argv)
+
+	// timezone(offset[, name])
+	case Class{"datetime", "timezone"}:
+		obj, err = datetimeTimezone(argv)
+
+	// ZoneInfo._unpickle(key, from_cache) or ZoneInfo(key)
+	case Class{"zoneinfo", "ZoneInfo._unpickle"}, Class{"zoneinfo", "ZoneInfo"}:
+		if !(len(argv) == 1 || (class.Name != "ZoneInfo" && len(argv) == 2)) {
+			err = fmt.Errorf("unexpected number of args %d", len(argv))
+			break
+		}
+		obj, err = loadLocation(argv[0])
+
+	// pytz._UTC() and pytz.utc
+	case Class{"pytz", "_UTC"}, Class{"pytz", "utc"}:
+		obj = time.UTC
+
+	// pytz._p(zone, ...) as pytz zones are reduced to
+	case Class{"pytz", "_p"}:
+		if len(argv) < 1 {
+			err = fmt.Errorf("unexpected number of args %d", len(argv))
+			break
+		}
+		obj, err = loadLocation(argv[0])
+
+	// pytz.FixedOffset(minutes)
+	case Class{"pytz", "FixedOffset"}:
+		if len(argv) != 1 {
+			err = fmt.Errorf("unexpected number of args %d", len(argv))
+			break
+		}
+		minutes, ok := argv[0].(int64)
+		if !ok || !(-1440 < minutes && minutes < 1440) {
+			err = fmt.Errorf("invalid offset %#v", argv[0])
+			break
+		}
+		obj = fixedZone(int(minutes) * 60)
+
+	default:
+		return errCallNotHandled
+	}
+
+	if err != nil {
+		return fmt.Errorf("%s.%s: %s", class.Module, class.Name, err)
+	}
+	d.push(obj)
+	return nil
+}
+
+// datetimeState returns state of datetime object, as given to its
+// constructor in pickles.
+//
+// The state is bytes with Python 3, and str, decoded as string or
+// ByteString, with Python 2.
+func datetimeState(x any) (state []byte, ok bool) {
+	switch x := x.(type) {
+	case Bytes:
+		return []byte(x), true
+	case []byte: // bytes in BytesAsSlice mode
+		return x, true
+	case ByteString:
+		return []byte(x), true
+	case string:
+		return []byte(x), true
+	}
+	return nil, false
+}
+
+// datetimeInts converts constructor arguments of datetime objects to ints.
+func datetimeInts(argv Tuple) ([]int, error) {
+	v := make([]int, len(argv))
+	for i, arg := range argv {
+		n, ok := arg.(int64)
+		if !ok {
+			return nil, fmt.Errorf("expect int; got %T", arg)
+		}
+		v[i] = int(n)
+	}
+	return v, nil
+}
+
+// datetimeTZInfo decodes optional tzinfo argument, that remains in argv
+// after the state or time fields.
+func datetimeTZInfo(argv Tuple) (any, error) {
+	switch len(argv) {
+	case 0:
+		return nil, nil
+	case 1:
+		if _, none := argv[0].(None); none {
+			return nil, nil
+		}
+		return argv[0], nil
+	}
+	return nil, fmt.Errorf("unexpected number of args %d", len(argv))
+}
+
+// datetimeDate decodes arguments of date constructor.
+func datetimeDate(argv Tuple) (date Date, err error) {
+	if len(argv) == 1 {
+		state, ok := datetimeState(argv[0])
+		if !ok || len(state) != 4 {
+			return date, fmt.Errorf("invalid state %#v", argv[0])
+		}
+		date = Date{
+			Year:  int(state[0])<<8 | int(state[1]),
+			Month: time.Month(state[2]),
+			Day:   int(state[3]),
+		}
+	} else {
+		if len(argv) != 3 {
+			return date, fmt.Errorf("unexpected number of args %d", len(argv))
+		}
+		v, err := datetimeInts(argv)
+		if err != nil {
+			return date, err
+		}
+		date = Date{Year: v[0], Month: time.Month(v[1]), Day: v[2]}
+	}
+
+	if !date.IsValid() {
+		return date, fmt.Errorf("invalid date %s", date)
+	}
+	return date, nil
+}
+
+// datetimeTime decodes arguments of time constructor.
+func datetimeTime(argv Tuple) (t Time, err error) {
+	if len(argv) > 0 {
+		if state, ok := datetimeState(argv[0]); ok {
+			if len(state) != 6 {
+				return t, fmt.Errorf("invalid state %#v", argv[0])
+			}
+			t = timeOfState(state)
+			argv = argv[1:]
+		} else {
+			n := len(argv)
+			if n > 4 {
+				n = 4
+			}
+			v, err := datetimeInts(argv[:n])
+			if err != nil {
+				return t, err
+			}
+			v = append(v, 0, 0, 0, 0)
+			t = Time{Hour: v[0], Minute: v[1], Second: v[2], Microsecond: v[3]}
+			argv = argv[n:]
+		}
+	}
+
+	t.TZInfo, err = datetimeTZInfo(argv)
+	if err != nil {
+		return t, err
+	}
+	if !t.IsValid() {
+		return t, fmt.Errorf("invalid time %s", t)
+	}
+	return t, nil
+}
+
+// timeOfState decodes 6 bytes of time state: hour with fold in the high bit,
+// minute, second and 3 bytes of microsecond.
+func timeOfState(state []byte) Time {
+	return Time{
+		Hour:        int(state[0] & 0x7f),
+		Minute:      int(state[1]),
+		Second:      int(state[2]),
+		Microsecond: int(state[3])<<16 | int(state[4])<<8 | int(state[5]),
+		Fold:        int(state[0] >> 7),
+	}
+}
+
+// datetimeDateTime decodes arguments of datetime constructor.
+func datetimeDateTime(argv Tuple) (dt DateTime, err error) {
+	if len(argv) < 1 {
+		return dt, fmt.Errorf("unexpected number of args %d", len(argv))
+	}
+	if state, ok := datetimeState(argv[0]); ok {
+		if len(state) != 10 {
+			return dt, fmt.Errorf("invalid state %#v", argv[0])
+		}
+		dt.Date = Date{
+			Year:  int(state[0])<<8 | int(state[1]),
+			Month: time.Month(state[2] & 0x7f),
+			Day:   int(state[3]),
+		}
+		dt.Time = timeOfState(state[4:])
+		dt.Fold = int(state[2] >> 7)
+		argv = argv[1:]
+	} else {
+		n := 0
+		for n < len(argv) && n < 7 {
+			if _, ok := argv[n].(int64); !ok {
+				break
+			}
+			n++
+		}
+		if n < 3 {
+			return dt, fmt.Errorf("invalid args %#v", argv)
+		}
+		v, err := datetimeInts(argv[:n])
+		if err != nil {
+			return dt, err
+		}
+		v = append(v, 0, 0, 0, 0)
+		dt.Date = Date{Year: v[0], Month: time.Month(v[1]), Day: v[2]}
+		dt.Time = Time{Hour: v[3], Minute: v[4], Second: v[5], Microsecond: v[6]}
+		argv = argv[n:]
+	}
+
+	dt.TZInfo, err = datetimeTZInfo(argv)
+	if err != nil {
+		return dt, err
+	}
+	if !dt.IsValid() {
+		return dt, fmt.Errorf("invalid datetime %s", dt)
+	}
+	return dt, nil
+}
+
+// datetimeTimezone decodes arguments of timezone constructor.
+func datetimeTimezone(argv Tuple) (*time.Location, error) {
+	if !(len(argv) == 1 || len(argv) == 2) {
+		return nil, fmt.Errorf("unexpected number of args %d", len(argv))
+	}
+	offset, err := timedeltaSeconds(argv[0])
+	if err != nil {
+		return nil, err
+	}
+	if !(-86400 < offset && offset < 86400) {
+		return nil, fmt.Errorf("offset %ds out of range", offset)
+	}
+	if len(argv) == 1 {
+		return fixedZone(offset), nil
+	}
+	name, err := AsString(argv[1])
+	if err != nil {
+		return nil, fmt.Errorf("name: %s", err)
+	}
+	return time.FixedZone(name, offset), nil
+}
+
+// timedeltaSeconds decodes whole number of seconds from timedelta(days,
+// seconds, microseconds) call.
+func timedeltaSeconds(x any) (int, error) {
+	call, ok := x.(Call)
+	if !(ok && call.Callable == (Class{"datetime", "timedelta"}) && 1 <= len(call.Args) && len(call.Args) <= 3) {
+		return 0, fmt.Errorf("expect timedelta; got %#v", x)
+	}
+	v, err := datetimeInts(call.Args)
+	if err != nil {
+		return 0, fmt.Errorf("timedelta: %s", err)
+	}
+	v = append(v, 0, 0)
+	if v[2] != 0 {
+		return 0, fmt.Errorf("timedelta: fractional seconds are not supported")
+	}
+	return v[0]*86400 + v[1], nil
+}
+
+// fixedZone returns location with fixed offset in seconds east of UTC, that
+// is named as Python names timezone objects without explicit name, e.g.
+// "UTC+01:00". Zero offset is time.UTC.
+func fixedZone(offset int) *time.Location {
+	if offset == 0 {
+		return time.UTC
+	}
+	return time.FixedZone(pyTZName(offset), offset)
+}
+
+// pyTZName returns Python default name of timezone with offset in seconds.
+func pyTZName(offset int) string {
+	sign := '+'
+	if offset < 0 {
+		sign, offset = '-', -offset
+	}
+	name := fmt.Sprintf("UTC%c%02d:%02d", sign, offset/3600, offset/60%60)
+	if s := offset % 60; s != 0 {
+		name += fmt.Sprintf(":%02d", s)
+	}
+	return name
+}
+
+// loadLocation loads IANA time zone by its key.
+func loadLocation(xkey any) (*time.Location, error) {
+	key, err := AsString(xkey)
+	if err != nil {
+		return nil, fmt.Errorf("key: %s", err)
+	}
+	if key == "" || key == "Local" {
+		return nil, fmt.Errorf("invalid key %q", key)
+	}
+	return time.LoadLocation(key)
+}
+
+// encodeDate encodes Date as datetime.date object.
+func (e *Encoder) encodeDate(d *Date) error {
+	if !d.IsValid() {
+		return fmt.Errorf("datetime: invalid date %s", d)
+	}
+	return e.encodeCall(&Call{
+		Callable: Class{Module: "datetime", Name: "date"},
+		Args:     Tuple{Bytes(dateState(d))},
+	})
+}
+
+// encodeTime encodes Time as datetime.time object.
+//
+// As with Python, fold is emitted only with protocol ≥ 4.
+func (e *Encoder) encodeTime(t *Time) error {
+	if !t.IsValid() {
+		return fmt.Errorf("datetime: invalid time %s", t)
+	}
+	args := Tuple{Bytes(e.timeState(t))}
+	if t.TZInfo != nil {
+		args = append(args, t.TZInfo)
+	}
+	return e.encodeCall(&Call{
+		Callable: Class{Module: "datetime", Name: "time"},
+		Args:     args,
+	})
+}
+
+// encodeDateTime encodes DateTime as datetime.datetime object.
+//
+// As with Python, fold is emitted only with protocol ≥ 4.
+func (e *Encoder) encodeDateTime(dt *DateTime) error {
+	if !dt.IsValid() {
+		return fmt.Errorf("datetime: invalid datetime %s", dt)
+	}
+	state := dateState(&dt.Date)
+	state = append(state, e.timeState(&dt.Time)...)
+	if state[4]&0x80 != 0 { // fold is stored in month for datetime
+		state[4] &^= 0x80
+		state[2] |= 0x80
+	}
+	args := Tuple{Bytes(state)}
+	if dt.TZInfo != nil {
+		args = append(args, dt.TZInfo)
+	}
+	return e.encodeCall(&Call{
+		Callable: Class{Module: "datetime", Name: "datetime"},
+		Args:     args,
+	})
+}
+
+// dateState returns state of date as Python packs it.
+func dateState(d *Date) []byte {
+	return []byte{byte(d.Year >> 8), byte(d.Year), byte(d.Month), byte(d.Day)}
+}
+
+// timeState returns state of time as Python packs it at encoder protocol.
+func (e *Encoder) timeState(t *Time) []byte {
+	hour := byte(t.Hour)
+	if t.Fold != 0 && e.config.Protocol >= 4 {
+		hour |= 0x80
+	}
+	us := t.Microsecond
+	return []byte{hour, byte(t.Minute), byte(t.Second), byte(us >> 16), byte(us >> 8), byte(us)}
+}
+
+// encodeLocation encodes time zone as datetime.timezone object for fixed
+// offset zones, or as zoneinfo.ZoneInfo object for IANA time zones, e.g.
+// "Europe/Berlin".
+func (e *Encoder) encodeLocation(loc *time.Location) error {
+	name := loc.String()
+	if loc != time.UTC && strings.Contains(name, "/") {
+		return e.encodeCall(&Call{
+			Callable: Class{Module: "zoneinfo", Name: "ZoneInfo"},
+			Args:     Tuple{Unicode(name)},
+		})
+	}
+
+	offset, ok := fixedOffset(loc)
+	if !ok {
+		return fmt.Errorf("datetime: time zone %q is neither fixed offset nor IANA time zone", name)
+	}
+	days, secs := offset/86400, offset%86400
+	if secs < 0 {
+		days, secs = days-1, secs+86400
+	}
+	args := Tuple{Call{
+		Callable: Class{Module: "datetime", Name: "timedelta"},
+		Args:     Tuple{int64(days), int64(secs), int64(0)},
+	}}
+	if loc != time.UTC && name != pyTZName(offset) {
+		args = append(args, Unicode(name))
+	}
+	return e.encodeCall(&Call{
+		Callable: Class{Module: "datetime", Name: "timezone"},
+		Args:     args,
+	})
+}
+
+// timezoneOffset returns offset of time zone loc, if loc is fixed offset zone,
+// as datetime.timezone is.
+//
+// IANA time zones, e.g. "Asia/Tokyo", are not fixed offset zones, even if
+// their offset does not change. They are told apart by name, as
+// encodeLocation does.
+func timezoneOffset(loc *time.Location) (offset int, ok bool) {
+	if loc != time.UTC && strings.Contains(loc.String(), "/") {
+		return 0, false
+	}
+	return fixedOffset(loc)
+}
+
+// fixedOffset returns offset of time zone loc, if it does not change.
+//
+// The offset is sampled at several instants, which is enough to tell fixed
+// zones from zones with daylight saving time.
+func fixedOffset(loc *time.Location) (offset int, ok bool) {
+	for i, t := range []time.Time{
+		time.Date(1970, time.January, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(1970, time.July, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2000, time.July, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2030, time.January, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2030, time.July, 1, 0, 0, 0, 0, time.UTC),
+	} {
+		_, off := t.In(loc).Zone()
+		if i > 0 && off != offset {
+			return 0, false
+		}
+		offset = off
+	}
+	return offset, true
+}
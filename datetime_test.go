@@ -0,0 +1,197 @@
+package ogórek
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestDecodeDateTime verifies decoding of datetime objects in DateTime mode.
+func TestDecodeDateTime(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		pickle string
+		want   any
+	}{
+		// pickles produced by CPython
+		{"cdatetime\ndate\np0\n(c_codecs\nencode\np1\n(V\x07\xe8\x01\x0f\np2\nVlatin1\np3\ntp4\nRp5\ntp6\nRp7\n.", Date{2024, time.January, 15}},
+		{"\x80\x02cdatetime\ndate\nq\x00c_codecs\nencode\nq\x01X\x05\x00\x00\x00\x07\xc3\xa8\x01\x0fq\x02X\x06\x00\x00\x00latin1q\x03\x86q\x04Rq\x05\x85q\x06Rq\x07.", Date{2024, time.January, 15}},
+		{"\x80\x03cdatetime\ndate\nq\x00C\x04\x07\xe8\x01\x0fq\x01\x85q\x02Rq\x03.", Date{2024, time.January, 15}},
+		{"\x80\x03cdatetime\ntime\nq\x00C\x06\x0c\"8\x00\x03\x15q\x01\x85q\x02Rq\x03.", Time{Hour: 12, Minute: 34, Second: 56, Microsecond: 789}},
+		{"\x80\x04\x95\"\x00\x00\x00\x00\x00\x00\x00\x8c\x08datetime\x94\x8c\x04time\x94\x93\x94C\x06\x81\x02\x03\x00\x00\x04\x94\x85\x94R\x94.", Time{Hour: 1, Minute: 2, Second: 3, Microsecond: 4, Fold: 1}},
+		{"\x80\x02cdatetime\ntime\nq\x00c_codecs\nencode\nq\x01X\x06\x00\x00\x00\x01\x02\x03\x00\x00\x04q\x02X\x06\x00\x00\x00latin1q\x03\x86q\x04Rq\x05cdatetime\ntimezone\nq\x06cdatetime\ntimedelta\nq\x07K\x00K\x00K\x00\x87q\x08Rq\t\x85q\nRq\x0b\x86q\x0cRq\r.",
+			Time{Hour: 1, Minute: 2, Second: 3, Microsecond: 4, TZInfo: time.UTC}},
+		{"\x80\x03cdatetime\ndatetime\nq\x00C\n\x07\xe8\x01\x0f\x0c\"8\x00\x03\x15q\x01\x85q\x02Rq\x03.",
+			DateTime{Date{2024, time.January, 15}, Time{Hour: 12, Minute: 34, Second: 56, Microsecond: 789}}},
+		{"\x80\x03cdatetime\ndatetime\nq\x00C\n\x07\xe8\x01\x0f\x0c\"8\x00\x03\x15q\x01cdatetime\ntimezone\nq\x02cdatetime\ntimedelta\nq\x03K\x00M\x10\x0eK\x00\x87q\x04Rq\x05\x85q\x06Rq\x07\x86q\x08Rq\t.",
+			DateTime{Date{2024, time.January, 15}, Time{Hour: 12, Minute: 34, Second: 56, Microsecond: 789, TZInfo: time.FixedZone("UTC+01:00", 3600)}}},
+		{"\x80\x04\x95*\x00\x00\x00\x00\x00\x00\x00\x8c\x08datetime\x94\x8c\x08datetime\x94\x93\x94C\n\x07\xe8\x8b\x03\x01\x1e\x00\x00\x00\x00\x94\x85\x94R\x94.",
+			DateTime{Date{2024, time.November, 3}, Time{Hour: 1, Minute: 30, Fold: 1}}},
+		{"\x80\x02cdatetime\ntimezone\nq\x00cdatetime\ntimedelta\nq\x01K\x00K\x00K\x00\x87q\x02Rq\x03\x85q\x04Rq\x05.", time.UTC},
+		{"\x80\x02cdatetime\ntimezone\nq\x00cdatetime\ntimedelta\nq\x01J\xff\xff\xff\xffJ(\x04\x01\x00K\x00\x87q\x02Rq\x03X\x01\x00\x00\x00Xq\x04\x86q\x05Rq\x06.",
+			time.FixedZone("X", -19800)},
+		{"\x80\x02c__builtin__\ngetattr\nq\x00czoneinfo\nZoneInfo\nq\x01X\t\x00\x00\x00_unpickleq\x02\x86q\x03Rq\x04X\r\x00\x00\x00Europe/Berlinq\x05K\x01\x86q\x06Rq\x07.", berlin},
+		// pytz
+		{"\x80\x02cpytz\n_UTC\nq\x00)Rq\x01.", time.UTC},
+		{"\x80\x02cpytz\n_p\nq\x00(X\x10\x00\x00\x00America/New_Yorkq\x01J\x9e\xba\xff\xffK\x00X\x03\x00\x00\x00LMTq\x02tq\x03Rq\x04.", newYork},
+		{"\x80\x02cpytz\nFixedOffset\nJ\xd4\xfe\xff\xff\x85R.", time.FixedZone("UTC-05:00", -18000)},
+		// Python 2
+		{"cdatetime\ndate\np0\n(S'\\x07\\xe8\\x01\\x0f'\np1\ntp2\nRp3\n.", Date{2024, time.January, 15}},
+
+		// other argument forms
+		{"\x80\x02cdatetime\ndate\nM\xe8\x07K\x02K\x1d\x87R.", Date{2024, time.February, 29}},
+		{"\x80\x02cdatetime\ntime\nK\x01K\x02\x86R.", Time{Hour: 1, Minute: 2}},
+		{"\x80\x02cdatetime\ntime\n)R.", Time{}},
+		{"\x80\x02cdatetime\ndatetime\n(M\xe8\x07K\x02K\x1dK\x01K\x02tR.", DateTime{Date{2024, time.February, 29}, Time{Hour: 1, Minute: 2}}},
+		{"\x80\x02cdatetime\ndatetime\n(M\xe8\x07K\x02K\x1dNtR.", DateTime{Date: Date{2024, time.February, 29}}},
+	} {
+		obj, err := NewDecoderWithConfig(strings.NewReader(tt.pickle), &DecoderConfig{DateTime: true}).Decode()
+		if err != nil {
+			t.Errorf("%q: %s", tt.pickle, err)
+			continue
+		}
+		if !reflect.DeepEqual(obj, tt.want) {
+			t.Errorf("%q:\nhave: %#v\nwant: %#v", tt.pickle, obj, tt.want)
+		}
+	}
+
+	// getattr(cls, name) callables are handled only in DateTime mode
+	_, err = NewDecoder(strings.NewReader("\x80\x02c__builtin__\ngetattr\nczoneinfo\nZoneInfo\nX\t\x00\x00\x00_unpickle\x86RX\r\x00\x00\x00Europe/BerlinK\x01\x86R.")).Decode()
+	if want := "pickle: reduce: invalid class: ogórek.Call"; !(err != nil && err.Error() == want) {
+		t.Errorf("getattr without DateTime: have %v  ; want %s", err, want)
+	}
+
+	// invalid arguments
+	for _, pickle := range []string{
+		"\x80\x03cdatetime\ndate\nC\x03\x07\xe8\x01\x85R.",
+		"\x80\x03cdatetime\ndate\nC\x04\x07\xe8\x02\x1e\x85R.",
+		"\x80\x02cdatetime\ndate\nM\xe8\x07K\x02\x86R.",
+		"\x80\x03cdatetime\ntime\nC\x06\x18\x00\x00\x00\x00\x00\x85R.",
+		"\x80\x03cdatetime\ntime\nC\x06\x00\x00\x00\x0f\x42\x40\x85R.",
+		"\x80\x02cdatetime\ntime\nX\x01\x00\x00\x00a\x85R.",
+	} {
+		_, err := NewDecoderWithConfig(strings.NewReader(pickle), &DecoderConfig{DateTime: true}).Decode()
+		if err == nil {
+			t.Errorf("%q: no error", pickle)
+		}
+	}
+
+	// without DateTime calls are left as is
+	obj, err := NewDecoder(strings.NewReader("\x80\x03cdatetime\ndate\nC\x04\x07\xe8\x01\x0f\x85R.")).Decode()
+	if want := (Call{Class{"datetime", "date"}, Tuple{Bytes("\x07\xe8\x01\x0f")}}); !(err == nil && reflect.DeepEqual(obj, want)) {
+		t.Errorf("!DateTime: have %#v, %v  ; want %#v", obj, err, want)
+	}
+}
+
+// TestEncodeDateTime verifies encoding of Date and Time.
+func TestEncodeDateTime(t *testing.T) {
+	for _, tt := range []struct {
+		in    any
+		proto int
+		want  string
+	}{
+		// the same as CPython produces, modulo memoization
+		{Date{2024, time.January, 15}, 3, "\x80\x03cdatetime\ndate\nC\x04\x07\xe8\x01\x0f\x85R."},
+		{Time{Hour: 12, Minute: 34, Second: 56, Microsecond: 789}, 3, "\x80\x03cdatetime\ntime\nC\x06\x0c\"8\x00\x03\x15\x85R."},
+		{Time{Hour: 1, Minute: 2, Second: 3, Microsecond: 4, Fold: 1}, 3, "\x80\x03cdatetime\ntime\nC\x06\x01\x02\x03\x00\x00\x04\x85R."},
+		{Time{Hour: 1, Minute: 2, Second: 3, Microsecond: 4, Fold: 1}, 4, "\x80\x04\x8c\x08datetime\x8c\x04time\x93C\x06\x81\x02\x03\x00\x00\x04\x85R."},
+		{&Date{2024, time.January, 15}, 0, "cdatetime\ndate\n(c_codecs\nencode\n(V\x07\xe8\x01\x0f\nS\"latin1\"\ntRtR."},
+		{DateTime{Date{2024, time.January, 15}, Time{Hour: 12, Minute: 34, Second: 56, Microsecond: 789}}, 3,
+			"\x80\x03cdatetime\ndatetime\nC\n\x07\xe8\x01\x0f\x0c\"8\x00\x03\x15\x85R."},
+		{DateTime{Date{2024, time.November, 3}, Time{Hour: 1, Minute: 30, Fold: 1}}, 4,
+			"\x80\x04\x8c\x08datetime\x8c\x08datetime\x93C\n\x07\xe8\x8b\x03\x01\x1e\x00\x00\x00\x00\x85R."},
+		{time.UTC, 3, "\x80\x03cdatetime\ntimezone\ncdatetime\ntimedelta\nK\x00K\x00K\x00\x87R\x85R."},
+		{time.FixedZone("UTC+01:00", 3600), 3, "\x80\x03cdatetime\ntimezone\ncdatetime\ntimedelta\nK\x00M\x10\x0eK\x00\x87R\x85R."},
+		{time.FixedZone("X", -19800), 3, "\x80\x03cdatetime\ntimezone\ncdatetime\ntimedelta\nJ\xff\xff\xff\xffJ(\x04\x01\x00K\x00\x87RX\x01\x00\x00\x00X\x86R."},
+	} {
+		buf := &bytes.Buffer{}
+		err := NewEncoderWithConfig(buf, &EncoderConfig{Protocol: tt.proto}).Encode(tt.in)
+		if err != nil {
+			t.Errorf("%#v: %s", tt.in, err)
+			continue
+		}
+		if buf.String() != tt.want {
+			t.Errorf("%#v: protocol %d:\nhave: %q\nwant: %q", tt.in, tt.proto, buf.String(), tt.want)
+		}
+	}
+
+	est5edt, err := time.LoadLocation("EST5EDT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, in := range []any{Date{2024, time.February, 30}, Date{}, Time{Hour: 24}, Time{Fold: 2}, DateTime{}, est5edt} {
+		err := NewEncoder(&bytes.Buffer{}).Encode(in)
+		if err == nil {
+			t.Errorf("%#v: no error", in)
+		}
+	}
+
+	// round trip
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tz := time.FixedZone("UTC+01:00", 3600)
+	for _, in := range []any{
+		Date{1, time.January, 1}, Date{9999, time.December, 31}, Time{23, 59, 59, 999999, 0, tz},
+		DateTime{Date{2024, time.March, 31}, Time{Hour: 2, Minute: 30, TZInfo: berlin}},
+		DateTime{Date{1, time.January, 1}, Time{TZInfo: time.FixedZone("X", -19800)}},
+		time.UTC, tz, berlin,
+	} {
+		err := VerifyRoundTrip(in, nil, &DecoderConfig{DateTime: true})
+		if err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestDateTimeString(t *testing.T) {
+	for _, tt := range []struct {
+		in   any
+		want string
+	}{
+		{Date{2024, time.January, 5}, "2024-01-05"},
+		{DateOf(time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)), "2001-02-03"},
+		{Time{Hour: 1, Minute: 2, Second: 3}, "01:02:03"},
+		{Time{Hour: 1, Minute: 2, Second: 3, Microsecond: 40}, "01:02:03.000040"},
+	} {
+		s := tt.in.(interface{ String() string }).String()
+		if s != tt.want {
+			t.Errorf("%#v: have %q  ; want %q", tt.in, s, tt.want)
+		}
+	}
+}
+
+func TestDateTimeIn(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tm := time.Date(2024, time.July, 1, 12, 30, 0, 789000, berlin)
+	dt := DateTimeOf(tm)
+	if want := (DateTime{Date{2024, time.July, 1}, Time{Hour: 12, Minute: 30, Microsecond: 789, TZInfo: berlin}}); !reflect.DeepEqual(dt, want) {
+		t.Errorf("DateTimeOf:\nhave: %#v\nwant: %#v", dt, want)
+	}
+	if have := dt.In(time.UTC); !have.Equal(tm) || have.Location() != time.UTC {
+		t.Errorf("aware In: have %s  ; want %s", have, tm.UTC())
+	}
+
+	dt.TZInfo = nil
+	if have, want := dt.In(time.UTC), time.Date(2024, time.July, 1, 12, 30, 0, 789000, time.UTC); !have.Equal(want) {
+		t.Errorf("naive In: have %s  ; want %s", have, want)
+	}
+	if s := dt.String(); s != "2024-07-01 12:30:00.000789" {
+		t.Errorf("String: %q", s)
+	}
+}
@@ -0,0 +1,464 @@
+package ogórek
+// Decoding of pickles into Go values of requested types.
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+)
+
+// DecodeTypeError is returned by [Decoder.DecodeInto] when a decoded value
+// cannot be stored into Go value of requested type.
+type DecodeTypeError struct {
+	Value  any          // decoded value
+	Type   reflect.Type // type of Go value, into which Value was to be stored
+	Reason string       // why Value cannot be stored, e.g. "overflow", if not just type mismatch
+	Path   string       // path to the Go value inside destination, e.g. ".Items[3].Name"
+}
+
+func (e *DecodeTypeError) Error() string {
+	s := fmt.Sprintf("pickle: decode: %scannot store %s into %s", pathPrefix(e.Path), Sprint("%#v", e.Value, nil), e.Type)
+	if e.Reason != "" {
+		s += ": " + e.Reason
+	}
+	return s
+}
+
+// PickleUnmarshaler is the interface implemented by types that can
+// reconstruct themselves from decoded objects.
+//
+// UnmarshalPickle is called by [Decoder.DecodeInto] with the object as it
+// was decoded by [Decoder.Decode], e.g. [Object] or map[any]any. None is
+// passed too, unless the destination is a pointer, which is then set to
+// nil. It is the type's responsibility to check the object and to copy any
+// data it wants to retain.
+type PickleUnmarshaler interface {
+	UnmarshalPickle(obj any) error
+}
+
+// DecodeInto decodes next pickle from the stream and stores the decoded
+// object into the value pointed to by v, similarly to json.Unmarshal.
+//
+// The object is first decoded as with [Decoder.Decode], and is then converted
+// to the type of the destination according to the following rules:
+//
+//   - objects are stored as is into destinations of type they are assignable
+//     to, e.g. into any;
+//   - int and long are stored into Go integers, and float into Go floats.
+//     Ints are also accepted for floats, and floats with integral value for
+//     integers. Numbers that overflow the destination are rejected;
+//   - str is stored into Go strings, and bytes, bytearray and Python 2 str
+//     into []byte;
+//   - list, tuple, set and frozenset are stored into slices and arrays item
+//     by item. Arrays must have the same length;
+//   - dict is stored into maps, with keys and values converted to map key
+//     and element types;
+//   - dict is stored into structs with entries corresponding to struct fields
+//     the same way the [Encoder] does: keys are taken from `pickle:"name"`
+//     tags, or are field names if the struct has no tags, and fields tagged
+//     with `pickle:"-"` are skipped. Dict entries without corresponding
+//     field are ignored;
+//   - tuple is stored into structs with positional `pickle:"0"` tags;
+//   - [NamedTuple] is stored as tuple, and into other structs by its field
+//     names;
+//   - instances of Python class are stored into structs marked with that
+//     class via blank field tag, e.g. `pickle:"myapp.models.Point"`, with
+//     arguments and state of the instance stored the same way as with
+//     [TypeRegistry];
+//   - None is stored as nil into pointers, maps and slices;
+//   - pointers are allocated as needed;
+//   - types that implement [PickleUnmarshaler] reconstruct themselves.
+//
+// If an object cannot be stored, *[DecodeTypeError] with path to the
+// offending destination is returned. The destination might be left
+// partially updated in such case.
+func (d *Decoder) DecodeInto(v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("pickle: decode: destination must be non-nil pointer; got %T", v)
+	}
+
+	obj, err := d.Decode()
+	if err != nil {
+		return err
+	}
+	return storeInto(rv.Elem(), obj)
+}
+
+// storeInto stores decoded object x into dst.
+func storeInto(dst reflect.Value, x any) error {
+	typ := dst.Type()
+	if u, ok := storeUnmarshaler(dst, x); ok {
+		return u.UnmarshalPickle(x)
+	}
+	if x != nil && reflect.TypeOf(x).AssignableTo(typ) {
+		dst.Set(reflect.ValueOf(x))
+		return nil
+	}
+
+	mismatch := func(reason string) error {
+		return &DecodeTypeError{Value: x, Type: typ, Reason: reason}
+	}
+
+	// lists and objects decoded with PreserveRefs
+	switch p := x.(type) {
+	case *[]any:
+		x = *p
+	case *Object:
+		x = *p
+	}
+	v := ValueOf(x)
+
+	if v.IsNone() {
+		switch dst.Kind() {
+		case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Interface:
+			dst.Set(reflect.Zero(typ))
+			return nil
+		}
+		return mismatch("")
+	}
+
+	switch dst.Kind() {
+	case reflect.Ptr:
+		if dst.IsNil() {
+			dst.Set(reflect.New(typ.Elem()))
+		}
+		return storeInto(dst.Elem(), x)
+
+	case reflect.Bool:
+		b, err := v.Bool()
+		if err != nil {
+			return mismatch("")
+		}
+		dst.SetBool(b)
+		return nil
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, ok := storeIntOf(x)
+		if !ok {
+			return mismatch("")
+		}
+		if !i.IsInt64() || dst.OverflowInt(i.Int64()) {
+			return mismatch("overflow")
+		}
+		dst.SetInt(i.Int64())
+		return nil
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		i, ok := storeIntOf(x)
+		if !ok {
+			return mismatch("")
+		}
+		if !i.IsUint64() || dst.OverflowUint(i.Uint64()) {
+			return mismatch("overflow")
+		}
+		dst.SetUint(i.Uint64())
+		return nil
+
+	case reflect.Float32, reflect.Float64:
+		if _, ok := x.(bool); ok {
+			return mismatch("")
+		}
+		f, err := AsFloat64(x, FloatRound)
+		if err != nil {
+			return mismatch("")
+		}
+		if !math.IsInf(f, 0) && dst.OverflowFloat(f) {
+			return mismatch("overflow")
+		}
+		dst.SetFloat(f)
+		return nil
+
+	case reflect.String:
+		var s string
+		var err error
+		if typ == reflect.TypeOf(Bytes("")) {
+			var b Bytes
+			b, err = v.Bytes()
+			s = string(b)
+		} else {
+			s, err = v.Str()
+		}
+		if err != nil {
+			return mismatch("")
+		}
+		dst.SetString(s)
+		return nil
+
+	case reflect.Slice:
+		if typ.Elem().Kind() == reflect.Uint8 {
+			b, err := v.Bytes()
+			if err != nil {
+				return mismatch("")
+			}
+			dst.SetBytes([]byte(b))
+			return nil
+		}
+
+		items, err := v.Elems()
+		if err != nil {
+			return mismatch("")
+		}
+		s := reflect.MakeSlice(typ, len(items), len(items))
+		for i, item := range items {
+			err = storeInto(s.Index(i), item.Interface())
+			if err != nil {
+				return storeErrorAt(err, pathIndex(i))
+			}
+		}
+		dst.Set(s)
+		return nil
+
+	case reflect.Array:
+		items, err := v.Elems()
+		if err != nil {
+			return mismatch("")
+		}
+		if len(items) != dst.Len() {
+			return mismatch(fmt.Sprintf("length %d != %d", len(items), dst.Len()))
+		}
+		for i, item := range items {
+			err = storeInto(dst.Index(i), item.Interface())
+			if err != nil {
+				return storeErrorAt(err, pathIndex(i))
+			}
+		}
+		return nil
+
+	case reflect.Map:
+		items, err := v.Items()
+		if err != nil {
+			return mismatch("")
+		}
+		m := reflect.MakeMapWithSize(typ, len(items))
+		for _, item := range items {
+			k := item.Key.Interface()
+			mk := reflect.New(typ.Key()).Elem()
+			err = storeInto(mk, k)
+			if err != nil {
+				return storeErrorAt(err, pathKey(k))
+			}
+			mv := reflect.New(typ.Elem()).Elem()
+			err = storeInto(mv, item.Value.Interface())
+			if err != nil {
+				return storeErrorAt(err, pathKey(k))
+			}
+			if !mapTrySetIndex(m, mk, mv) {
+				return mismatch(fmt.Sprintf("unhashable key %s", Sprint("%#v", k, nil)))
+			}
+		}
+		dst.Set(m)
+		return nil
+
+	case reflect.Struct:
+		return storeStruct(dst, x, mismatch)
+	}
+
+	return mismatch("")
+}
+
+// storeStruct serves storeInto for struct destinations.
+func storeStruct(dst reflect.Value, x any, mismatch func(reason string) error) error {
+	typ := dst.Type()
+	v := ValueOf(x)
+
+	switch typ {
+	case reflect.TypeOf(big.Int{}):
+		i, ok := storeIntOf(x)
+		if !ok {
+			return mismatch("")
+		}
+		dst.Set(reflect.ValueOf(i).Elem())
+		return nil
+
+	case reflect.TypeOf(Dict{}):
+		items, err := v.Items()
+		if err != nil {
+			return mismatch("")
+		}
+		d := NewDictWithSizeHint(len(items))
+		for _, item := range items {
+			k := item.Key.Interface()
+			if !dictTryAssign(d, k, item.Value.Interface()) {
+				return mismatch(fmt.Sprintf("unhashable key %s", Sprint("%#v", k, nil)))
+			}
+		}
+		dst.Set(reflect.ValueOf(d))
+		return nil
+	}
+
+	// our types, that represent Python objects, are not regular structs
+	if storeOpaque[typ] {
+		return mismatch("")
+	}
+
+	// struct marked with class <- instance of that class
+	if class, ok := getStructClass(typ); ok {
+		var obj Object
+		switch x := x.(type) {
+		case Object:
+			obj = x
+		case Call:
+			obj = Object{Class: x.Callable, Args: x.Args}
+		}
+		if obj.Class != (Class{}) {
+			if obj.Class != class {
+				return mismatch(fmt.Sprintf("class %s.%s != %s.%s", obj.Class.Module, obj.Class.Name, class.Module, class.Name))
+			}
+			err := storeArgs(dst, obj.Args, obj.KwArgs)
+			if err != nil {
+				return err
+			}
+			if obj.State != nil {
+				return storeState(dst, obj.State)
+			}
+			return nil
+		}
+	}
+
+	// struct with positional tags <- tuple
+	tupleFields, err := getStructTupleFields(dst)
+	if err != nil {
+		return err
+	}
+	if tupleFields != nil {
+		items, err := v.Elems()
+		if err != nil {
+			return mismatch("")
+		}
+		if len(items) != len(tupleFields) {
+			return mismatch(fmt.Sprintf("length %d != %d", len(items), len(tupleFields)))
+		}
+		for i, f := range tupleFields {
+			err = storeInto(dst.Field(f), items[i].Interface())
+			if err != nil {
+				return storeErrorAt(err, "." + typ.Field(f).Name)
+			}
+		}
+		return nil
+	}
+
+	// struct <- namedtuple, by field names
+	if t, ok := x.(NamedTuple); ok {
+		return storeStructItems(dst, t.items())
+	}
+
+	// struct <- dict
+	items, err := v.Items()
+	if err != nil {
+		return mismatch("")
+	}
+	return storeStructItems(dst, items)
+}
+
+// storeStructItems stores dict entries into fields of struct dst.
+//
+// Entries are matched to fields by key as getStructDictFields defines.
+// Entries without corresponding field are ignored.
+func storeStructItems(dst reflect.Value, items []ValueItem) error {
+	typ := dst.Type()
+	fields := make(map[string]int)
+	for _, f := range getStructDictFields(typ) {
+		fields[f.key] = f.field
+	}
+	for _, item := range items {
+		key, err := item.Key.Str()
+		if err != nil {
+			continue
+		}
+		i, ok := fields[key]
+		if !ok || !dst.Field(i).CanSet() {
+			continue
+		}
+		err = storeInto(dst.Field(i), item.Value.Interface())
+		if err != nil {
+			return storeErrorAt(err, "." + typ.Field(i).Name)
+		}
+	}
+	return nil
+}
+
+// storeUnmarshaler returns PickleUnmarshaler, that should handle storing x
+// into dst, if dst implements it.
+//
+// Nil pointers are allocated, except when x is None, which is stored as nil
+// as for any other pointer.
+func storeUnmarshaler(dst reflect.Value, x any) (_ PickleUnmarshaler, ok bool) {
+	if dst.Kind() == reflect.Ptr {
+		if _, none := x.(None); none || !dst.Type().Implements(unmarshalerType) {
+			return nil, false
+		}
+		if dst.IsNil() {
+			dst.Set(reflect.New(dst.Type().Elem()))
+		}
+		return dst.Interface().(PickleUnmarshaler), true
+	}
+	if dst.CanAddr() && dst.Addr().Type().Implements(unmarshalerType) {
+		return dst.Addr().Interface().(PickleUnmarshaler), true
+	}
+	return nil, false
+}
+
+var unmarshalerType = reflect.TypeOf((*PickleUnmarshaler)(nil)).Elem()
+
+// storeOpaque is the set of types, that are accepted by storeInto only as is.
+var storeOpaque = map[reflect.Type]bool{
+	reflect.TypeOf(None{}):         true,
+	reflect.TypeOf(Set{}):          true,
+	reflect.TypeOf(FrozenSet{}):    true,
+	reflect.TypeOf(Class{}):        true,
+	reflect.TypeOf(Call{}):         true,
+	reflect.TypeOf(Object{}):       true,
+	reflect.TypeOf(NamedTuple{}):   true,
+	reflect.TypeOf(Partial{}):      true,
+	reflect.TypeOf(Exception{}):    true,
+	reflect.TypeOf(Slice{}):        true,
+	reflect.TypeOf(Range{}):        true,
+	reflect.TypeOf(Dtype{}):        true,
+	reflect.TypeOf(NDArray{}):      true,
+	reflect.TypeOf(Ref{}):          true,
+	reflect.TypeOf(PickleBuffer{}): true,
+	reflect.TypeOf(MemoryView{}):   true,
+}
+
+// storeIntOf returns integer value of decoded number x.
+//
+// Floats are accepted only if they have integral value.
+func storeIntOf(x any) (_ *big.Int, ok bool) {
+	switch x := x.(type) {
+	case int64:
+		return big.NewInt(x), true
+	case *big.Int:
+		return new(big.Int).Set(x), true
+	case float64:
+		if math.IsInf(x, 0) || math.IsNaN(x) || x != math.Trunc(x) {
+			return nil, false
+		}
+		i, _ := big.NewFloat(x).Int(nil)
+		return i, true
+	}
+	return nil, false
+}
+
+// mapTrySetIndex is like m.SetMapIndex(k, v) but returns ok=false instead of
+// panicking if k is not hashable.
+func mapTrySetIndex(m, k, v reflect.Value) (ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			ok = false
+		}
+	}()
+
+	m.SetMapIndex(k, v)
+	return true
+}
+
+// storeErrorAt prepends elem to the path of error err, that occurred while
+// storing a value nested inside the destination.
+func storeErrorAt(err error, elem string) error {
+	if e, ok := err.(*DecodeTypeError); ok {
+		e.Path = elem + e.Path
+	}
+	return err
+}
@@ -0,0 +1,165 @@
+package ogórek
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"reflect"
+	"testing"
+)
+
+type intoPoint struct {
+	Y int16 `pickle:"1"`
+	X int16 `pickle:"0"`
+}
+
+type intoRecord struct {
+	Name   string         `pickle:"name"`
+	N      uint64         `pickle:"n"`
+	F      int            `pickle:"f"`
+	Ratio  float32        `pickle:"ratio"`
+	Items  []*intoPoint   `pickle:"items"`
+	Data   []byte         `pickle:"data"`
+	M      map[string]int `pickle:"m"`
+	P      *int           `pickle:"p"`
+	Big    big.Int        `pickle:"big"`
+	Tags   [2]string      `pickle:"tags"`
+	Any    any            `pickle:"any"`
+	hidden int
+}
+
+// decodeInto encodes obj and decodes it back into v with DecodeInto.
+func decodeInto(t *testing.T, obj any, v any) error {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	err := NewEncoderWithConfig(buf, &EncoderConfig{Protocol: 3}).Encode(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewDecoder(buf).DecodeInto(v)
+}
+
+func TestDecodeInto(t *testing.T) {
+	bigN, _ := new(big.Int).SetString("100000000000000000000", 10)
+	obj := map[any]any{
+		"name":  "x",
+		"n":     big.NewInt(1 << 40),
+		"f":     3.0,
+		"ratio": int64(2),
+		"items": []any{Tuple{int64(1), int64(2)}, None{}},
+		"data":  Bytes("ab"),
+		"m":     map[any]any{"a": int64(1)},
+		"p":     None{},
+		"big":   bigN,
+		"tags":  Tuple{"a", "b"},
+		"any":   Tuple{int64(1)},
+		"extra": int64(1), // ignored
+	}
+
+	p := 5
+	var r intoRecord
+	r.P = &p
+	err := decodeInto(t, obj, &r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := intoRecord{
+		Name:  "x",
+		N:     1 << 40,
+		F:     3,
+		Ratio: 2,
+		Items: []*intoPoint{{X: 1, Y: 2}, nil},
+		Data:  []byte("ab"),
+		M:     map[string]int{"a": 1},
+		P:     nil,
+		Big:   *bigN,
+		Tags:  [2]string{"a", "b"},
+		Any:   Tuple{int64(1)},
+	}
+	if !reflect.DeepEqual(r, want) {
+		t.Errorf("decode into:\nhave: %#v\nwant: %#v", r, want)
+	}
+
+	// pointers are allocated as needed
+	var pr *intoRecord
+	err = decodeInto(t, map[any]any{"name": "y"}, &pr)
+	if !(err == nil && pr != nil && pr.Name == "y") {
+		t.Errorf("decode into pointer: %v, %v", pr, err)
+	}
+
+	// errors
+	for _, tt := range []struct {
+		obj any
+		v   any
+		err string
+	}{
+		{int64(300), new(int8), "pickle: decode: cannot store 300 into int8: overflow"},
+		{int64(-1), new(uint), "pickle: decode: cannot store -1 into uint: overflow"},
+		{1.5, new(int), "pickle: decode: cannot store 1.5 into int"},
+		{"a", new(int), `pickle: decode: cannot store "a" into int`},
+		{Bytes("a"), new(string), `pickle: decode: cannot store ogórek.Bytes("a") into string`},
+		{None{}, new(int), "pickle: decode: cannot store ogórek.None{} into int"},
+		{Tuple{int64(1)}, new([2]int), "pickle: decode: cannot store ogórek.Tuple{1} into [2]int: length 1 != 2"},
+		{[]any{map[any]any{"items": []any{Tuple{int64(1), "x"}}}}, new([]intoRecord),
+			`pickle: decode: [0].Items[0].Y: cannot store "x" into int16`},
+		{map[any]any{"m": map[any]any{"a": 1.5}}, new(intoRecord),
+			`pickle: decode: .M.a: cannot store 1.5 into int`},
+		{map[any]any{int64(1): int64(2)}, new(map[string]int),
+			"pickle: decode: [1]: cannot store 1 into string"},
+		{map[any]any{"a": int64(1)}, new(Call), "pickle: decode: cannot store map[interface {}]interface {}{\"a\":1} into ogórek.Call"},
+	} {
+		err := decodeInto(t, tt.obj, tt.v)
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("%#v -> %T:\nhave: %v\nwant: %s", tt.obj, tt.v, err, tt.err)
+		}
+	}
+
+	err = NewDecoder(&bytes.Buffer{}).DecodeInto(intoRecord{})
+	if err == nil {
+		t.Errorf("decode into non-pointer: no error")
+	}
+}
+
+// intoColor is decoded from Python 'Color(r, g, b)' objects.
+type intoColor struct {
+	R, G, B int64
+}
+
+func (c *intoColor) UnmarshalPickle(obj any) error {
+	o, ok := obj.(Object)
+	if !(ok && o.Class == (Class{Module: "__main__", Name: "Color"})) {
+		return fmt.Errorf("not a Color: %#v", obj)
+	}
+	_, err := fmt.Sscanf(fmt.Sprint(o.Args...), "%d %d %d", &c.R, &c.G, &c.B)
+	return err
+}
+
+func TestDecodeIntoUnmarshaler(t *testing.T) {
+	color := func(r, g, b int64) Object {
+		return Object{Class: Class{Module: "__main__", Name: "Color"}, Args: Tuple{r, g, b}}
+	}
+
+	var v struct {
+		Fg intoColor             `pickle:"fg"`
+		Bg *intoColor            `pickle:"bg"`
+		M  map[string]*intoColor `pickle:"m"`
+	}
+	v.Bg = &intoColor{}
+	err := decodeInto(t, map[any]any{
+		"fg": color(1, 2, 3),
+		"bg": None{},
+		"m":  map[any]any{"x": color(4, 5, 6)},
+	}, &v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !(v.Fg == intoColor{1, 2, 3} && v.Bg == nil && *v.M["x"] == intoColor{4, 5, 6}) {
+		t.Errorf("decode into unmarshaler: %#v", v)
+	}
+
+	var c intoColor
+	err = decodeInto(t, None{}, &c)
+	if err == nil || err.Error() != "not a Color: ogórek.None{}" {
+		t.Errorf("decode into unmarshaler: error: %v", err)
+	}
+}
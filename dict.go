@@ -11,6 +11,8 @@ import (
 	"math/big"
 	"reflect"
 	"sort"
+	"strings"
+	"time"
 
 	"github.com/aristanetworks/gomap"
 )
@@ -18,21 +20,54 @@ import (
 // Dict represents dict from Python in PyDict mode.
 //
 // It mirrors Python with respect to which types are allowed to be used as
-// keys, and with respect to keys equality. For example Tuple is allowed to be
-// used as key, and all int(1), float64(1.0) and big.Int(1) are considered to be
-// equal.
+// keys, and with respect to keys equality. For example Tuple and [FrozenSet]
+// are allowed to be used as keys, and all int(1), float64(1.0) and big.Int(1)
+// are considered to be equal.
 //
 // For strings, similarly to Python3, [Bytes] and string are considered to be not
 // equal, even if their underlying content is the same. However with same
 // underlying content [ByteString], because it represents str type from Python2,
 // is treated equal to both [Bytes] and string.
 //
+// Similarly to dict in Python 3.7+, Dict preserves insertion order of its
+// entries, and the Encoder emits them in that order.
+//
 // See PyDict mode documentation in top-level package overview for details.
 //
 // Note: similarly to builtin map Dict is pointer-like type: its zero-value
 // represents nil dictionary that is empty and invalid to use Set on.
 type Dict struct {
-	m *gomap.Map[any, any]
+	d *dict
+}
+
+// dict holds data of a Dict.
+//
+// Most dictionaries have only a handful of keys, so small dictionaries are
+// looked up by scanning their entries, which is much cheaper than a gomap with
+// its seed and buckets. When the dictionary grows beyond dictSmallMax entries,
+// gomap index, that maps keys to their position in entries, is built.
+type dict struct {
+	entries  []dictEntry
+	index    *gomap.Map[any, int] // != nil after upgrade
+	ndeleted int                  // number of deleted entries; always 0 for small dict
+}
+
+// dictEntry is an entry of dict.
+type dictEntry struct {
+	h       uint64 // hash(dictSmallSeed, k); only for small dict
+	k, v    any
+	deleted bool // entry was removed from indexed dict
+}
+
+// dictSmallMax is the maximum number of entries kept in small dict.
+const dictSmallMax = 8
+
+// dictSmallSeed is used to hash keys of all small dicts.
+var dictSmallSeed = maphash.MakeSeed()
+
+// isSmall returns whether the dictionary is looked up without index.
+func (d Dict) isSmall() bool {
+	return d.d != nil && d.d.index == nil
 }
 
 // NewDict returns new empty dictionary.
@@ -42,7 +77,11 @@ func NewDict() Dict {
 
 // NewDictWithSizeHint returns new empty dictionary with preallocated space for size items.
 func NewDictWithSizeHint(size int) Dict {
-	return Dict{m: gomap.NewHint[any, any](size, equal, hash)}
+	d := &dict{entries: make([]dictEntry, 0, size)}
+	if size > dictSmallMax {
+		d.index = gomap.NewHint[any, int](size, equal, hash)
+	}
+	return Dict{d: d}
 }
 
 // NewDictWithData returns new dictionary with preset data.
@@ -78,21 +117,77 @@ func (d Dict) Get(key any) any {
 
 // Get_ is comma-ok version of Get.
 func (d Dict) Get_(key any) (value any, ok bool) {
-	return d.m.Get(key)
+	i := d.find(key)
+	if i < 0 {
+		return nil, false
+	}
+	return d.d.entries[i].v, true
+}
+
+// find returns position of first entry with key equal to the query, or -1.
+func (d Dict) find(key any) int {
+	if d.d == nil {
+		return -1
+	}
+
+	if d.d.index != nil {
+		i, ok := d.d.index.Get(key)
+		if !ok {
+			return -1
+		}
+		return i
+	}
+
+	h := hash(dictSmallSeed, key)
+	for i, e := range d.d.entries {
+		if !e.deleted && e.h == h && equal(e.k, key) {
+			return i
+		}
+	}
+	return -1
 }
 
 // Set sets key to be associated with value.
 //
 // Any previous keys, equal to the new key, are removed from the dictionary
-// before the assignment.
+// before the assignment. If there were such keys, the new entry takes the
+// place of the first of them in the iteration order. Otherwise the new entry
+// is appended to the end.
 //
 // Set panics if key's type is not allowed to be used as Dict key.
 func (d Dict) Set(key, value any) {
+	if d.d == nil {
+		panic("Set called on nil map")
+	}
+
 	// ByteString and container(with ByteString) are non-transitive equal types
 	// so  Set(ByteString)       should first remove Bytes and string,
 	// and Set(Tuple{ByteString) should first remove Tuple{Bytes} and Tuple{string}
-	d.Del(key)
-	d.m.Set(key, value)
+	pos := d.del(key)
+
+	var h uint64
+	if d.d.index == nil {
+		h = hash(dictSmallSeed, key)
+	}
+	e := dictEntry{h: h, k: key, v: value}
+
+	if pos >= 0 {
+		// del only marks entries as deleted, so pos is still valid
+		d.d.entries[pos] = e
+		d.d.ndeleted--
+	} else {
+		pos = len(d.d.entries)
+		d.d.entries = append(d.d.entries, e)
+	}
+
+	if d.d.index != nil {
+		d.d.index.Set(key, pos)
+	} else if len(d.d.entries) > dictSmallMax {
+		// too many entries -> build index
+		d.reindex()
+	}
+
+	d.compact()
 }
 
 // Del removes equal keys from the dictionary.
@@ -101,30 +196,110 @@ func (d Dict) Set(key, value any) {
 //
 // Del panics if key's type is not allowed to be used as Dict key.
 func (d Dict) Del(key any) {
-	// see comment in Set about ByteString and container(with ByteString)
+	d.del(key)
+	d.compact()
+}
+
+// del marks entries with key equal to the query as deleted.
+//
+// It returns position of the first deleted entry, or -1 if nothing was deleted.
+// The caller must compact the dictionary afterwards.
+func (d Dict) del(key any) (pos int) {
+	pos = -1
 	for {
-		d.m.Delete(key)
-		_, have := d.Get_(key)
-		if !have {
+		i := d.find(key)
+		if i < 0 {
+			break
+		}
+		if d.d.index != nil {
+			// Delete removes the same entry, that was found by Get
+			d.d.index.Delete(key)
+		}
+		d.d.entries[i] = dictEntry{deleted: true}
+		d.d.ndeleted++
+		if pos < 0 || i < pos {
+			pos = i
+		}
+		if d.d.index == nil {
+			// keep scanning small dict for other equal keys
+			h := hash(dictSmallSeed, key)
+			for j := i+1; j < len(d.d.entries); j++ {
+				e := d.d.entries[j]
+				if !e.deleted && e.h == h && equal(e.k, key) {
+					d.d.entries[j] = dictEntry{deleted: true}
+					d.d.ndeleted++
+				}
+			}
 			break
 		}
 	}
+	return pos
+}
+
+// compact removes deleted entries from the dictionary.
+//
+// Small dict is compacted on every deletion. Indexed dict is compacted only
+// when deleted entries make up more than half of all entries, so that
+// deletion costs amortized O(1).
+func (d Dict) compact() {
+	if d.d == nil || d.d.ndeleted == 0 {
+		return
+	}
+	if d.d.index != nil && d.d.ndeleted <= len(d.d.entries)/2 {
+		return
+	}
+
+	entries := d.d.entries[:0]
+	for _, e := range d.d.entries {
+		if !e.deleted {
+			entries = append(entries, e)
+		}
+	}
+	// don't retain deleted keys and values
+	for i := len(entries); i < len(d.d.entries); i++ {
+		d.d.entries[i] = dictEntry{}
+	}
+	d.d.entries = entries
+	d.d.ndeleted = 0
+
+	if d.d.index != nil {
+		d.reindex()
+	}
+}
+
+// reindex rebuilds index of the dictionary from its entries.
+func (d Dict) reindex() {
+	index := gomap.NewHint[any, int](len(d.d.entries), equal, hash)
+	for i, e := range d.d.entries {
+		if !e.deleted {
+			index.Set(e.k, i)
+		}
+	}
+	d.d.index = index
 }
 
 // Len returns the number of items in the dictionary.
 func (d Dict) Len() int {
-	return d.m.Len()
+	if d.d == nil {
+		return 0
+	}
+	return len(d.d.entries) - d.d.ndeleted
 }
 
 // Iter returns iterator over all elements in the dictionary.
 //
-// The order to visit entries is arbitrary.
+// The entries are visited in insertion order.
 func (d Dict) Iter() /* iter.Seq2 */ func(yield func(any, any) bool) {
-	it := d.m.Iter()
+	var entries []dictEntry
+	if d.d != nil {
+		entries = d.d.entries
+	}
 	return func(yield func(any, any) bool) {
-		for it.Next() {
-			cont := yield(it.Key(), it.Elem())
-			if !cont {
+		for _, e := range entries {
+			if e.deleted {
+				continue
+			}
+			if !yield(e.k, e.v) {
 				break
 			}
 		}
@@ -132,6 +307,9 @@ func (d Dict) Iter() /* iter.Seq2 */ func(yield func(any, any) bool) {
 }
 
 // String returns human-readable representation of the dictionary.
+//
+// All dictionary data is printed. Use [Sprint] to print huge dictionaries
+// with limited output size.
 func (d Dict) String() string {
 	return d.sprintf("%v")
 }
@@ -253,6 +431,14 @@ func kindOf(x any) kind {
 //
 //	EqTransitive = all \ {ByteString + containers with ByteString}
 func equal(xa, xb any) bool {
+	// namedtuples compare as tuples
+	if t, ok := xa.(NamedTuple); ok {
+		xa = t.Values
+	}
+	if t, ok := xb.(NamedTuple); ok {
+		xb = t.Values
+	}
+
 	// strings/bytes
 	switch a := xa.(type) {
 	case string:
@@ -405,6 +591,23 @@ func equal(xa, xb any) bool {
 		case Dict:	return eq_Dict_Dict(a, b)
 		default:        return false
 		}
+	case Set:
+		switch b := xb.(type) {
+		case Set:	return eq_Set_Set(a, b)
+		case FrozenSet:	return eq_Set_Set(a, b.s)
+		default:        return false
+		}
+	case FrozenSet:
+		switch b := xb.(type) {
+		case Set:	return eq_Set_Set(a.s, b)
+		case FrozenSet:	return eq_Set_Set(a.s, b.s)
+		default:        return false
+		}
+	case *time.Location:
+		switch b := xb.(type) {
+		case *time.Location:	return eq_Location_Location(a, b)
+		default:        return false
+		}
 	}
 
 	// structs  (also covers None, Class, Call etc...)
@@ -463,6 +666,21 @@ func eq_BigInt_BigInt(a, b *big.Int) bool {
 	return (a.Cmp(b) == 0)
 }
 
+// eq_Location_Location compares time zones as Python does: fixed offset
+// zones, e.g. datetime.timezone, by offset only, and other zones, e.g.
+// zoneinfo.ZoneInfo, by key.
+func eq_Location_Location(a, b *time.Location) bool {
+	if a == b {
+		return true
+	}
+	aoff, afixed := timezoneOffset(a)
+	boff, bfixed := timezoneOffset(b)
+	if afixed && bfixed {
+		return aoff == boff
+	}
+	return (a.String() == b.String())
+}
+
 func eq_Slice_Slice(a, b reflect.Value) bool {
 	al := a.Len()
 	bl := b.Len()
@@ -485,33 +703,13 @@ func eq_Struct_Struct(a, b reflect.Value) bool {
 	typ := a.Type()
 	l := typ.NumField()
 	for i := 0; i < l; i++ {
-		af := a.Field(i)
-		bf := b.Field(i)
-
 		// .Interface() is not allowed if the field is private.
-		// Work around the protection via unsafe. We may need to switch
-		// to struct copy if it is not addressable because Addr() is
-		// used in the workaround. https://stackoverflow.com/a/43918797/9456786
-		ftyp := typ.Field(i)
-		if !ftyp.IsExported() {
-			if !af.CanAddr() {
-				// switch a to addressable copy
-				a_ := reflect.New(typ).Elem()
-				a_.Set(a)
-				a = a_
-				af = a.Field(i)
-			}
-
-			if !bf.CanAddr() {
-				// switch b to addressable copy
-				b_ := reflect.New(typ).Elem()
-				b_.Set(b)
-				b = b_
-				bf = b.Field(i)
-			}
-
-			af = reflect.NewAt(ftyp.Type, af.Addr().UnsafePointer()).Elem()
-			bf = reflect.NewAt(ftyp.Type, bf.Addr().UnsafePointer()).Elem()
+		// structField works it around, if possible.
+		af, aok := structField(&a, i)
+		bf, bok := structField(&b, i)
+		if !(aok && bok) {
+			// private fields are not accessible -> fallback to exact comparison
+			return reflect.DeepEqual(a.Interface(), b.Interface())
 		}
 
 		if !equal(af.Interface(), bf.Interface()) {
@@ -667,6 +865,11 @@ func eq_Complex_Complex (a complex128, b complex128)  bool { return a == b }
 //
 // hash panics with "unhashable type: ..." if x is not allowed to be used as Dict key.
 func hash(seed maphash.Seed, x any) uint64 {
+	// namedtuples hash as tuples
+	if t, ok := x.(NamedTuple); ok {
+		x = t.Values
+	}
+
 	// strings/bytes use standard hash of string
 	switch v := x.(type) {
 	case string:     return maphash_String(seed, v)
@@ -741,7 +944,19 @@ func hash(seed maphash.Seed, x any) uint64 {
 	// kSlice  - skip
 	// kStruct - skip
 
-	case kPointer:  hash_Uint(uint64(r.Elem().UnsafeAddr()))
+	case kPointer:
+		// time zones are compared by offset or key - hash them accordingly
+		if loc, ok := x.(*time.Location); ok {
+			if offset, fixed := timezoneOffset(loc); fixed {
+				h.WriteString("timezone")
+				hash_Int(int64(offset))
+			} else {
+				h.WriteString("zone")
+				h.WriteString(loc.String())
+			}
+		} else {
+			hash_Uint(uint64(r.Elem().UnsafeAddr()))
+		}
 	}
 
 	if handled {
@@ -756,6 +971,19 @@ func hash(seed maphash.Seed, x any) uint64 {
 			hash_Uint(hash(seed, item))
 		}
 		return h.Sum64()
+
+	case FrozenSet:
+		// combine hashes of elements in order-independent way, as
+		// frozensets with the same elements are equal irregardless of
+		// their order.
+		sum := uint64(0)
+		v.Iter()(func(item any) bool {
+			sum += hash(seed, item)
+			return true
+		})
+		h.WriteString("frozenset")
+		hash_Uint(sum)
+		return h.Sum64()
 	}
 
 	// structs  (also covers None, Class, Call etc)
@@ -763,7 +991,7 @@ func hash(seed maphash.Seed, x any) uint64 {
 	case kStruct:
 		// our types that are handled specially by equal
 		switch x.(type) {
-		case Dict:
+		case Dict, Set:
 			goto unhashable
 		}
 
@@ -771,20 +999,11 @@ func hash(seed maphash.Seed, x any) uint64 {
 		h.WriteString(typ.Name())
 		l := typ.NumField()
 		for i := 0; i < l; i++ {
-			f := r.Field(i)
-
 			// .Interface() is not allowed if the field is private.
-			// Work it around via unsafe. See eq_Struct_Struct for details.
-			ftyp := typ.Field(i)
-			if !ftyp.IsExported() {
-				if !f.CanAddr() {
-					// switch r to addressable copy
-					r_ := reflect.New(typ).Elem()
-					r_.Set(r)
-					r = r_
-					f = r.Field(i)
-				}
-				f = reflect.NewAt(ftyp.Type, f.Addr().UnsafePointer()).Elem()
+			// structField works it around, if possible.
+			f, ok := structField(&r, i)
+			if !ok {
+				goto unhashable
 			}
 
 			hash_Uint(hash(seed, f.Interface()))
@@ -797,6 +1016,121 @@ unhashable:
 }
 
 
+// ---- order ----
+
+// key categories for compareKeys.
+const (
+	kcNumber = iota // bool, ints, floats except NaN, *big.Int
+	kcString        // string, ByteString, Unicode and other string kinds
+	kcBytes         // Bytes
+	kcTuple         // Tuple
+	kcOther         // everything else
+)
+
+// keyCategory returns to which category x belongs with respect to ordering.
+func keyCategory(x any) int {
+	switch x.(type) {
+	case Bytes:
+		return kcBytes
+	case Tuple:
+		return kcTuple
+	}
+
+	switch kindOf(x) {
+	case kBool, kInt, kUint, kBigInt:
+		return kcNumber
+	case kFloat:
+		if math.IsNaN(reflect.ValueOf(x).Float()) {
+			return kcOther // NaN is not ordered with other numbers
+		}
+		return kcNumber
+	}
+
+	if reflect.ValueOf(x).Kind() == reflect.String {
+		return kcString
+	}
+	return kcOther
+}
+
+// keyNumber returns exact value of number x of kcNumber category.
+func keyNumber(x any) *big.Float {
+	r := reflect.ValueOf(x)
+	f := new(big.Float)
+	switch kindOf(x) {
+	case kBool:
+		f.SetInt64(bint(r.Bool()))
+	case kInt:
+		f.SetInt64(r.Int())
+	case kUint:
+		f.SetUint64(r.Uint())
+	case kFloat:
+		f.SetFloat64(r.Float())
+	case kBigInt:
+		f.SetInt(x.(*big.Int))
+	}
+	return f
+}
+
+// compareKeys compares dict keys a and b and returns -1, 0 or +1.
+//
+// Keys that Python can compare with each other are ordered as Python orders
+// them: numbers by value, strings and bytes lexicographically, and tuples
+// element by element. Keys of different categories are ordered by category.
+// Keys that Python cannot order, and keys that are equal in Python but differ
+// in Go, e.g. 1 and 1.0, are ordered by their type and then by their
+// representation.
+func compareKeys(a, b any) int {
+	c := comparePy(a, b)
+	if c != 0 {
+		return c
+	}
+
+	c = strings.Compare(fmt.Sprintf("%T", a), fmt.Sprintf("%T", b))
+	if c != 0 {
+		return c
+	}
+	return strings.Compare(fmt.Sprintf("%#v", a), fmt.Sprintf("%#v", b))
+}
+
+// comparePy serves compareKeys and compares a and b as Python would.
+//
+// 0 is returned if a and b are equal in Python, or if Python cannot order them.
+func comparePy(a, b any) int {
+	ca := keyCategory(a)
+	cb := keyCategory(b)
+	if ca != cb {
+		if ca < cb {
+			return -1
+		}
+		return +1
+	}
+
+	switch ca {
+	case kcNumber:
+		return keyNumber(a).Cmp(keyNumber(b))
+	case kcString:
+		return strings.Compare(reflect.ValueOf(a).String(), reflect.ValueOf(b).String())
+	case kcBytes:
+		return strings.Compare(string(a.(Bytes)), string(b.(Bytes)))
+	case kcTuple:
+		ta := a.(Tuple)
+		tb := b.(Tuple)
+		for i := 0; i < len(ta) && i < len(tb); i++ {
+			c := comparePy(ta[i], tb[i])
+			if c != 0 {
+				return c
+			}
+		}
+		switch {
+		case len(ta) < len(tb):
+			return -1
+		case len(ta) > len(tb):
+			return +1
+		}
+	}
+	return 0
+}
+
 // ---- misc ----
 
 // bint returns int corresponding to bool.
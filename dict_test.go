@@ -3,9 +3,12 @@ package ogórek
 import (
 	"fmt"
 	"hash/maphash"
+	"math"
+	"math/big"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 )
 
 
@@ -38,6 +41,20 @@ func TestEqual(t *testing.T) {
 	i1  := 1;  i1_ := 1
 	obj := &Class{"a","b"};  obj_ := &Class{"a","b"}
 
+	// berlin and berlin_ are the same time zone loaded twice
+	berlin, err  := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	berlin_, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokyo, err   := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
 	// testv is vector of all test-cases
 	testv := []tAllEqual {
 		// numbers
@@ -160,20 +177,43 @@ func TestEqual(t *testing.T) {
 		E(D("a",1, Bytes("a"),1, ByteString("b"),2),
 		  D(ByteString("a"),1, "b",2, Bytes("b"),2)),
 
+		// Set, FrozenSet
+		E(NewSet(), NewFrozenSet(), FrozenSet{}),
+		E(NewSetWithData(1,"a"), NewFrozenSet("a",1.0), NewFrozenSet(bigInt("1"),"a")),
+		E(NewFrozenSet(Tuple{1}, NewFrozenSet(2)), NewFrozenSet(NewFrozenSet(2.0), Tuple{true})),
+
 		// structs
 		E(Class{"mod","cls"}, Class{"mod","cls"}),
 		E(Call{Class{"mod","cls"}, Tuple{"a","b",3}},
 		  Call{Class{"mod","cls"}, Tuple{ByteString("a"),"b",bigInt("3")}}),
 		E(Ref{1}, Ref{bigInt("1")}, Ref{1.0}),
-		E(tStructWithPrivate{"a",1}, tStructWithPrivate{ByteString("a"),bigInt("1")}),
-		E(tStructWithPrivate{"b",2}, tStructWithPrivate{"b",2.0}),
 
 		// pointers, as in builtin ==, are compared only by address
 		E(&i1), E(&i1_), E(&obj), E(&obj_),
 
+		// time zones: fixed by offset, others by key
+		E(time.UTC, time.FixedZone("UTC", 0), time.FixedZone("Z", 0)),
+		E(time.FixedZone("UTC+01:00", 3600), time.FixedZone("CET", 3600)),
+		E(berlin, berlin_),
+		E(time.FixedZone("UTC+09:00", 9*3600)),
+		E(tokyo),
+
 		// nil
 		E(nil),
 	}
+	// structs with private fields are compared with Python semantic only if
+	// the fields are accessible, i.e. not in purego build.
+	if structPrivateOK {
+		testv = append(testv,
+			E(tStructWithPrivate{"a",1}, tStructWithPrivate{ByteString("a"),bigInt("1")}),
+			E(tStructWithPrivate{"b",2}, tStructWithPrivate{"b",2.0}),
+		)
+	}
+	// IANA zone without DST is not equal to fixed zone with the same offset
+	if equal(tokyo, time.FixedZone("UTC+09:00", 9*3600)) {
+		t.Errorf("equal  Asia/Tokyo  UTC+09:00")
+	}
+
 	// automatically test equality on Tuples/list from ^^^ data
 	testvAddSequences := func() {
 		l := len(testv)
@@ -554,15 +594,18 @@ func TestDict(t *testing.T) {
 	d.Set(Class{"a","b"}, 1)
 	d.Set(Class{"c","d"}, 2)
 	d.Set(Ref{"a"}, 3)
-	d.Set(tStructWithPrivate{"x","y"}, 4)
-	assertData(Class{"a","b"},1, Class{"c","d"},2, Ref{"a"},3, tStructWithPrivate{"x","y"},4)
+	assertData(Class{"a","b"},1, Class{"c","d"},2, Ref{"a"},3)
 	assertGet(Class{"a","b"},               1)
 	assertGet(Class{"c","d"},               2)
 	assertGet(Class{"x","y"},               nil)
 	assertGet(Ref{"a"},                     3)
 	assertGet(Ref{"x"},                     nil)
-	assertGet(tStructWithPrivate{"x","y"},  4)
-	assertGet(tStructWithPrivate{"p","q"},  nil)
+	if structPrivateOK {
+		d.Set(tStructWithPrivate{"x","y"}, 4)
+		assertData(Class{"a","b"},1, Class{"c","d"},2, Ref{"a"},3, tStructWithPrivate{"x","y"},4)
+		assertGet(tStructWithPrivate{"x","y"},  4)
+		assertGet(tStructWithPrivate{"p","q"},  nil)
+	}
 
 	// pointers
 	i := 1
@@ -665,6 +708,59 @@ func TestDict(t *testing.T) {
 	assertPanics("nil.Set", "Set called on nil map", func() { d.Set(1, "x") })
 }
 
+// TestDictSmall verifies that Dict works correctly across switch from small
+// representation to indexed one.
+func TestDictSmall(t *testing.T) {
+	for _, hint := range []int{0, dictSmallMax, dictSmallMax+1} {
+		d := NewDictWithSizeHint(hint)
+		n := 3*dictSmallMax
+		for i := 0; i < n; i++ {
+			d.Set(int64(i), i)
+			// float and big.Int keys replace equal int keys
+			d.Set(float64(i), i)
+			d.Set(bigInt(fmt.Sprint(i)), i)
+			if l := d.Len(); l != i+1 {
+				t.Fatalf("hint=%d: set %d: len=%d", hint, i, l)
+			}
+			if small := d.isSmall(); small != (hint <= dictSmallMax && i < dictSmallMax) {
+				t.Errorf("hint=%d: set %d: small=%v", hint, i, small)
+			}
+			for j := 0; j <= i; j++ {
+				if v := d.Get(float64(j)); v != j {
+					t.Fatalf("hint=%d: set %d: get %d -> %v", hint, i, j, v)
+				}
+			}
+		}
+
+		// non-transitive ByteString keys
+		d = NewDictWithSizeHint(hint)
+		d.Set("a", 1)
+		d.Set(Bytes("a"), 2)
+		d.Set(ByteString("a"), 3)
+		if l := d.Len(); l != 1 {
+			t.Errorf("hint=%d: ByteString: len=%d", hint, l)
+		}
+
+		// deletion
+		d = NewDictWithSizeHint(hint)
+		for i := 0; i < dictSmallMax; i++ {
+			d.Set(i, i)
+		}
+		d.Del(3)
+		if _, ok := d.Get_(3); ok || d.Len() != dictSmallMax-1 {
+			t.Errorf("hint=%d: del: still have 3  ; len=%d", hint, d.Len())
+		}
+		sum := 0
+		d.Iter()(func(k, v any) bool {
+			sum += v.(int)
+			return true
+		})
+		if want := (dictSmallMax-1)*dictSmallMax/2 - 3; sum != want {
+			t.Errorf("hint=%d: iter: sum=%d  ; want %d", hint, sum, want)
+		}
+	}
+}
+
 
 // benchmarks for map and Dict compare them from performance point of view.
 
@@ -707,3 +803,103 @@ func BenchmarkDictGet(b *testing.B) {
 		}
 	})
 }
+
+func BenchmarkDictSmall(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		d := NewDict()
+		d.Set("a", 1)
+		d.Set("b", 2)
+		d.Set("c", 3)
+		_ = d.Get("b")
+	}
+}
+
+// TestDictOrder verifies that Dict preserves insertion order of its entries.
+func TestDictOrder(t *testing.T) {
+	keys := func(d Dict) []any {
+		var kv []any
+		d.Iter()(func(k, v any) bool {
+			kv = append(kv, k)
+			return true
+		})
+		return kv
+	}
+
+	for _, hint := range []int{0, dictSmallMax+1} {
+		n := 4*dictSmallMax
+		d := NewDictWithSizeHint(hint)
+		var want []any
+		for i := n-1; i >= 0; i-- {
+			d.Set(int64(i), i)
+			want = append(want, int64(i))
+		}
+		if got := keys(d); !reflect.DeepEqual(got, want) {
+			t.Fatalf("hint=%d: set: order:\nhave: %v\nwant: %v", hint, got, want)
+		}
+
+		// update of existing key keeps its position, even if the key is replaced
+		d.Set(float64(n-1), "x")
+		want[0] = float64(n-1)
+		if got := keys(d); !reflect.DeepEqual(got, want) {
+			t.Fatalf("hint=%d: update: order:\nhave: %v\nwant: %v", hint, got, want)
+		}
+
+		// deletion, including compaction of indexed dict, keeps order of remaining entries
+		var want2 []any
+		for _, k := range want {
+			var i int64
+			switch k := k.(type) {
+			case int64:
+				i = k
+			case float64:
+				i = int64(k)
+			}
+			if i%4 != 0 {
+				d.Del(k)
+			} else {
+				want2 = append(want2, k)
+			}
+		}
+		if got := keys(d); !reflect.DeepEqual(got, want2) {
+			t.Fatalf("hint=%d: del: order:\nhave: %v\nwant: %v", hint, got, want2)
+		}
+
+		// re-added key goes to the end
+		d.Set(int64(1), 1)
+		want2 = append(want2, int64(1))
+		if got := keys(d); !reflect.DeepEqual(got, want2) {
+			t.Fatalf("hint=%d: re-add: order:\nhave: %v\nwant: %v", hint, got, want2)
+		}
+		if l := d.Len(); l != len(want2) {
+			t.Fatalf("hint=%d: len=%d  ; want %d", hint, l, len(want2))
+		}
+	}
+}
+
+// verify ordering of dict keys.
+func TestCompareKeys(t *testing.T) {
+	// keys in ascending order
+	keyv := []any{
+		int64(-1), false, 0.5, true, 1.0, int64(1), big.NewInt(2), uint64(3), math.Inf(+1),
+		"", ByteString("a"), "a", "ab", "b", "ы",
+		Bytes(""), Bytes("a"),
+		Tuple{}, Tuple{int64(1), "b"}, Tuple{1.0, "c"}, Tuple{int64(2)},
+		math.NaN(), None{},
+	}
+
+	for i, a := range keyv {
+		for j, b := range keyv {
+			want := 0
+			switch {
+			case i < j:
+				want = -1
+			case i > j:
+				want = +1
+			}
+			if c := compareKeys(a, b); c != want {
+				t.Errorf("compareKeys(%#v, %#v) = %d  ; want %d", a, b, c, want)
+			}
+		}
+	}
+}
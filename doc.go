@@ -24,6 +24,72 @@
 //	float	←  floatX
 //	list	↔  []any
 //	tuple	↔  ogórek.Tuple
+//	list	←  ogórek.Sequence
+//	tuple	←  ogórek.Sequence   (IsTuple() = true)
+//
+// With PreserveRefs=y decoding mode lists are decoded as *[]any, so that
+// the same list, referenced from several places in the pickle, decodes to
+// the same Go object:
+//
+//	list	→  *[]any                             PreserveRefs=y mode
+//
+// Python sets are mirrored by types, that follow Python semantic of elements
+// equality, similarly to [ogórek.Dict] described below:
+//
+//	set        ↔  ogórek.Set
+//	frozenset  ↔  ogórek.FrozenSet
+//
+// Sets of Python 2 sets module are decoded into the same types:
+//
+//	sets.Set           →  ogórek.Set
+//	sets.ImmutableSet  →  ogórek.FrozenSet
+//
+// Some objects of Python standard library are mirrored by types as well:
+//
+//	slice              ↔  ogórek.Slice
+//	range, xrange      ↔  ogórek.Range
+//	functools.partial  ↔  ogórek.Partial
+//	exception          ↔  ogórek.Exception      Exceptions=y mode
+//
+// With Decimal=y encoding mode arbitrary-precision numbers are encoded
+// without loss of precision:
+//
+//	decimal.Decimal  ←  *big.Float, *big.Rat
+//
+// With DateTime=y decoding mode objects of Python datetime module are decoded
+// into Go types, while these types are always encoded as datetime objects:
+//
+//	datetime.date      ↔  ogórek.Date            DateTime=y mode
+//	datetime.time      ↔  ogórek.Time            DateTime=y mode
+//	datetime.datetime  ↔  ogórek.DateTime        DateTime=y mode
+//	datetime.timezone  ↔  *time.Location         DateTime=y mode
+//	zoneinfo.ZoneInfo  ↔  *time.Location         DateTime=y mode
+//	pytz zones         →  *time.Location         DateTime=y mode
+//
+// With Arrays=y mode numeric arrays of Python array module are mapped to Go
+// slices of corresponding types:
+//
+//	array.array  ↔  []intX, []uintX, []floatX    Arrays=y mode
+//
+// With NumPy=y decoding mode numpy scalars are decoded as Python numbers,
+// and numpy dtypes and arrays are decoded into [ogórek.Dtype] and
+// [ogórek.NDArray]:
+//
+//	numpy.bool_                  →  bool            NumPy=y mode
+//	numpy.intX, numpy.uintX      →  int64           NumPy=y mode
+//	numpy.uint64 > MaxInt64      →  *big.Int        NumPy=y mode
+//	numpy.floatX                 →  float64         NumPy=y mode
+//	numpy.dtype                  →  ogórek.Dtype    NumPy=y mode
+//	numpy.ndarray                →  ogórek.NDArray  NumPy=y mode
+//
+// Dumps of joblib, e.g. scikit-learn models, store data of numpy arrays out
+// of pickle opcodes, and are decoded by decoder from [NewJoblibDecoder].
+//
+// With Types set to [ogórek.TypeRegistry] instances of registered Python
+// classes are mapped to Go structs of corresponding types:
+//
+//	instance of class  ↔  registered struct
+//	namedtuple         ↔  ogórek.NamedTuple     registered via RegisterNamedTuple
 //
 //
 // For dicts there are two modes. In the first, default, mode Python dicts are
@@ -38,23 +104,54 @@
 //
 // With PyDict=y mode, however, Python dicts are decoded as [ogórek.Dict] which
 // mirrors behaviour of Python dict with respect to keys equality, and with
-// respect to which types are allowed to be used as keys.
+// respect to which types are allowed to be used as keys. ogórek.Dict also
+// preserves insertion order of its entries, so decode→encode keeps the order
+// of keys that Python 3 users see.
 //
 //      dict    ↔  ogórek.Dict                       PyDict=y mode
 //              ←  map[any]any
 //
+// With DictAsItems=y decoding mode dicts are instead decoded as []ogórek.KV
+// that keeps key/value pairs exactly as they appear in the pickle, including
+// duplicate keys. []ogórek.KV is always encoded as dict with entries in the
+// same order:
+//
+//      dict    ↔  []ogórek.KV                       DictAsItems=y mode
+//
+// Third-party map-like containers, e.g. ordered maps, are encoded as dict if
+// they implement [ogórek.Mapping]:
+//
+//      dict    ←  ogórek.Mapping
+//
+// Go structs are encoded as dict with entries for exported fields. Similarly
+// to encoding/json, keys can be set via `pickle:"name"` tags, `pickle:"-"`
+// skips a field, and `pickle:"name,omitempty"` omits the entry if the field
+// has empty value. Structs with positional `pickle:"0"`, `pickle:"1"`, ...
+// tags are encoded as tuple:
+//
+//      dict    ←  struct
+//      tuple   ←  struct with positional tags
+//
+// Structs, that are marked with Python class via tag of a blank field, e.g.
+// field `_ struct{}` tagged with `pickle:"myapp.models.Point"`, are encoded
+// as instances of that class with fields being the object state:
+//
+//      instance of class  ←  struct marked with class
+//
 //
 // For strings there are also two modes. In the first, default, mode both py2/py3
 // str and py2 unicode are decoded into string with py2 str being considered
 // as UTF-8 encoded. Correspondingly for protocol ≤ 2 Go string is encoded as
 // UTF-8 encoded py2 str, and for protocol ≥ 3 as py3 str / py2 unicode.
 // [ogórek.ByteString] can be used to produce bytestring objects after encoding
-// even for protocol ≥ 3. This mode tries to match Go string with str type of
+// even for protocol ≥ 3, and [ogórek.Unicode] can be used to produce unicode
+// objects even for protocol ≤ 2. This mode tries to match Go string with str type of
 // target Python depending on protocol version, but looses information after
 // decoding/encoding cycle:
 //
 //	py2/py3 str  ↔  string                       StrictUnicode=n mode, default
 //	py2 unicode  →  string
+//	py2 unicode  ←  ogórek.Unicode
 //	py2 str      ←  ogórek.ByteString
 //
 // However with StrictUnicode=y mode there is 1-1 mapping in between py2
@@ -71,6 +168,22 @@
 //
 //	bytes        ↔  ogórek.Bytes   (~)
 //	bytearray    ↔  []byte
+//	PickleBuffer →  ogórek.Bytes | []byte  (read-only | writable)
+//	             ←  ogórek.PickleBuffer
+//	memoryview   →  ogórek.Bytes | []byte  (read-only | writable)
+//	             ←  ogórek.MemoryView
+//
+// With BytesAsSlice=y decoding mode bytes are decoded as mutable []byte
+// instead, for consumers that want to process the data in place.
+//
+// At protocol ≤ 2 Python 3 pickles bytes as _codecs.encode(..., 'latin1')
+// call, and bytearray as bytearray(...) call at all protocols. With
+// RawCalls=y decoding mode such calls are not converted, and are decoded as
+// plain [Call] instead, for consumers that need the raw pickle structure,
+// e.g. to re-encode it as is:
+//
+//	_codecs.encode(...)  →  ogórek.Call          RawCalls=y mode
+//	bytearray(...)       →  ogórek.Call          RawCalls=y mode
 //
 //
 //
@@ -85,6 +198,23 @@
 //						ogórek.Tuple{"3.14"},
 //					}
 //
+// Instances of Python2 old-style classes, pickled via INST and OBJ opcodes,
+// are decoded as [Call] as well.
+
+// Objects, that are created via cls.__new__(cls, *args) instead of a call,
+// as Python does for new-style classes at protocol ≥ 2, or via
+// copyreg._reconstructor at lower protocols, are mapped to [Object]:
+//
+//	cls.__new__(cls, 1, x=2)   ↔    ogórek.Object{
+//						Class:  ogórek.Class{"mod", "cls"},
+//						Args:   ogórek.Tuple{1},
+//						KwArgs: map[string]any{"x": 2},
+//					}
+//
+// State of the objects, that is set via BUILD opcode, goes to Object.State.
+// Objects with state, that were created via call, are represented as Object
+// with Called=true.
+//
 // In particular on Go side it is thus by default safe to decode pickles from
 // untrusted sources(^).
 //
@@ -117,7 +247,9 @@
 //	})
 //	err := e.Encode(obj)
 //
-// See EncoderConfig.Protocol for details.
+// See EncoderConfig.Protocol for details. EncoderConfig.ProtocolMode can be
+// used to either get an error, or to automatically use higher protocol, when
+// encoded values cannot be natively represented at the requested protocol.
 //
 //
 // Persistent references
@@ -189,5 +321,6 @@
 // (^) contrary to Python implementation, where malicious pickle can cause the
 // decoder to run arbitrary code, including e.g. os.system("rm -rf /").
 //
-// (%) ogórek currently does not support out-of-band data.
+// (%) ogórek supports out-of-band data via DecoderConfig.Buffers on decoding
+// and via EncoderConfig.BufferCallback on encoding.
 package ogórek
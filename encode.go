@@ -1,34 +1,229 @@
 package ogórek
 
 import (
+	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
 	"math"
 	"math/big"
+	"net"
+	"net/netip"
 	"reflect"
+	"sort"
+	"strconv"
 	"strings"
+	"unicode"
+	"time"
 )
 
 const highestProtocol = 5 // highest protocol version we support generating
 
-// unicode is string that always encodes as unicode pickle object.
-// (regular string encodes to unicode pickle object only for protocol >= 3 by default)
-type unicode string
+// Unicode is string that always encodes as unicode pickle object.
+//
+// Regular Go string encodes to unicode pickle object only for protocol >= 3,
+// or in StrictUnicode mode. Unicode allows to force unicode for particular
+// strings at protocols ≤ 2, e.g. for producers that must hand py2 consumers
+// real unicode objects. The decoder never produces Unicode.
+type Unicode string
+
+// Mapping is implemented by map-like containers, e.g. ordered maps from
+// third-party libraries, that [Encoder] should encode as Python dict.
+//
+// Len returns the number of entries, and Iterate calls yield for every entry
+// until yield returns false. Iterate must yield exactly Len entries. Entries
+// are encoded in the iteration order.
+type Mapping interface {
+	Len() int
+	Iterate(yield func(k, v any) bool)
+}
+
+// Sequence is implemented by sequence-like containers, e.g. lazily generated
+// or streamed sequences, that [Encoder] should encode as Python list.
+//
+// Len returns the number of items, and Iterate calls yield for every item
+// until yield returns false. Iterate must yield exactly Len items. If the
+// container also has IsTuple method returning true, it is encoded as tuple
+// instead of list.
+type Sequence interface {
+	Len() int
+	Iterate(yield func(v any) bool)
+}
 
+// TypeError is returned by [Encoder] when a value of unsupported Go type is encountered.
+//
+// For unsupported value nested inside the encoded object, the error reads
+// "pickle: encode: <path>: no support for type ...". For unsupported encoded
+// object itself, the error reads just "no support for type ..." without
+// "pickle: encode:" prefix, as it was before Path was introduced, so that
+// existing code, that matches the error text, continues to work.
 type TypeError struct {
-	typ string
+	typ  string
+	Type reflect.Type // the unsupported type
+	Path string       // path to the value inside encoded object, e.g. ".results[3].payload"
 }
 
 func (te *TypeError) Error() string {
+	if te.Path != "" {
+		return fmt.Sprintf("pickle: encode: %s: no support for type '%s'", te.Path, te.typ)
+	}
 	return fmt.Sprintf("no support for type '%s'", te.typ)
 }
 
+// EncodeError is returned by [Encoder] when encoding of a value nested inside
+// the encoded object fails.
+//
+// [TypeError], [ProtocolError] and [LossyError] carry the path themselves and
+// are not wrapped into EncodeError.
+type EncodeError struct {
+	Path string // path to the value inside encoded object, e.g. ".results[3].payload"
+	Err  error
+}
+
+func (e *EncodeError) Error() string {
+	return fmt.Sprintf("pickle: encode: %s: %s", e.Path, e.Err)
+}
+
+func (e *EncodeError) Unwrap() error {
+	return e.Err
+}
+
+// errorAt prepends elem to the path of error err, that occurred while encoding
+// a value nested inside the encoded object.
+//
+// Errors of writing to the output are returned as is.
+func (e *Encoder) errorAt(err error, elem string) error {
+	if err == e.werr {
+		return err
+	}
+	switch err := err.(type) {
+	case *TypeError:
+		err.Path = elem + err.Path
+	case *ProtocolError:
+		err.Path = elem + err.Path
+	case *LossyError:
+		err.Path = elem + err.Path
+	case *EncodeError:
+		err.Path = elem + err.Path
+	default:
+		return &EncodeError{Path: elem, Err: err}
+	}
+	return err
+}
+
+// pathIndex returns path element for i-th item of a sequence.
+func pathIndex(i int) string {
+	return "[" + strconv.Itoa(i) + "]"
+}
+
+// pathFields returns function, that gives path element for i-th of fields of
+// struct type t, e.g. for fields of a struct with positional tags.
+func pathFields(t reflect.Type, fields []int) func(i int) string {
+	return func(i int) string {
+		return "." + t.Field(fields[i]).Name
+	}
+}
+
+// pathKey returns path element for item of a dict with key k.
+func pathKey(k any) string {
+	if s, ok := k.(string); ok && isPathIdent(s) {
+		return "." + s
+	}
+	return "[" + Sprint("%#v", k, nil) + "]"
+}
+
+// isPathIdent returns whether s can be used in path as .s .
+func isPathIdent(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, r := range s {
+		if !(r == '_' || unicode.IsLetter(r) || (i > 0 && unicode.IsDigit(r))) {
+			return false
+		}
+	}
+	return true
+}
+
+// ProtocolError is returned by [Encoder] in ProtocolStrict mode when a value
+// cannot be natively represented at configured protocol.
+type ProtocolError struct {
+	Value    any    // offending value
+	Protocol int    // minimum protocol that can represent Value
+	Path     string // path to Value inside encoded object
+}
+
+func (e *ProtocolError) Error() string {
+	return fmt.Sprintf("pickle: encode: %s%s: requires protocol >= %d", pathPrefix(e.Path), Sprint("%#v", e.Value, nil), e.Protocol)
+}
+
+// LossyError is returned by [Encoder] in Strict mode when encoding of a value
+// is known not to round-trip.
+type LossyError struct {
+	Value  any    // offending value
+	Reason string // why encoding of Value is lossy
+	Path   string // path to Value inside encoded object
+}
+
+func (e *LossyError) Error() string {
+	return fmt.Sprintf("pickle: encode: %s%s: lossy encoding: %s", pathPrefix(e.Path), Sprint("%#v", e.Value, nil), e.Reason)
+}
+
+// pathPrefix returns "<path>: " for non-empty path.
+func pathPrefix(path string) string {
+	if path == "" {
+		return ""
+	}
+	return path + ": "
+}
+
+// ProtocolMode specifies how [Encoder] handles values that cannot be natively
+// represented at configured protocol.
+type ProtocolMode int
+
+const (
+	// ProtocolEmulate requests to represent such values with constructs
+	// available at configured protocol, for example to encode Bytes as
+	// _codecs.encode call at protocol < 3. This is the default.
+	ProtocolEmulate ProtocolMode = iota
+
+	// ProtocolStrict requests to fail encoding with *ProtocolError.
+	ProtocolStrict
+
+	// ProtocolBump requests to transparently raise the protocol to the
+	// minimum one that can represent all encoded values natively.
+	ProtocolBump
+)
+
 // An Encoder encodes Go data structures into pickle byte stream
 type Encoder struct {
 	w      io.Writer
 	config *EncoderConfig
+
+	// memo of already emitted values -> their memo index.
+	// it is reset on every Encode call.
+	memo map[any]int
+
+	// last error of writing to w; such errors are not annotated with path.
+	werr error
+
+	// objects that are being encoded in Memoize mode
+	active map[any]bool
+
+	// types of encoded maps, slices and structs -> whether they implement
+	// Mapping or Sequence
+	containers map[reflect.Type]bool
+
+	// memo keys of values that were referenced via memo, if !nil.
+	// with memoOnlyUsed only such values are memoized. see Canonicalize.
+	memoUsed     map[any]bool
+	memoOnlyUsed bool
+
+	// state of EncodeSession
+	session   bool
+	shareMemo bool
+	sessProto int // protocol announced with PROTO in the session, or -1
 }
 
 // EncoderConfig allows to tune [Encoder].
@@ -51,6 +246,116 @@ type EncoderConfig struct {
 	// See StrictUnicode mode documentation in top-level package overview
 	// for details.
 	StrictUnicode bool
+
+	// DedupStrings, when true, requests the encoder to emit every distinct
+	// string or bytes value only once per pickle. Repeated occurrences of
+	// an equal value are emitted as memo references via GET opcodes,
+	// similarly to how CPython does for interned strings.
+	//
+	// This makes pickles with e.g. the same dict keys repeated many times
+	// significantly smaller.
+	DedupStrings bool
+
+	// Memoize, when true, requests the encoder to emit every map, slice,
+	// [Dict], [Set] and [FrozenSet] object, as well as every object
+	// referenced by pointer to struct or array, only once per pickle.
+	// Repeated occurrences of the same object, i.e. of the same map or
+	// pointer, or of a slice with the same underlying array and length, are
+	// emitted as memo references, so that, similarly to CPython pickler
+	// memo, the object is shared on the Python side. Memoize implies
+	// DedupStrings.
+	//
+	// Memoized objects must not be modified while being encoded, and, if
+	// the memo is shared by [EncodeSession], in between its pickles.
+	Memoize bool
+
+	// SortKeys, when true, requests the encoder to emit entries of maps,
+	// [Dict], [Mapping] containers and Go structs ordered by key, so that
+	// the same data always encodes to the same pickle. Keys are ordered as
+	// Python would order them, e.g. numbers by value and strings
+	// lexicographically, while keys that Python cannot compare are ordered
+	// by their type and representation. Elements of [Set] and [FrozenSet]
+	// are ordered the same way. Entries given as []KV are always emitted
+	// in their order.
+	SortKeys bool
+
+	// ProtocolMode specifies what to do with values that need protocol
+	// higher than Protocol to be represented natively. See [ProtocolMode]
+	// for details.
+	ProtocolMode ProtocolMode
+
+	// Strict, when true, requests the encoder to fail with *LossyError
+	// whenever encoding of a value is known not to round-trip, i.e. when
+	// decoding the pickle gives an object of different shape. Currently
+	// such values are:
+	//
+	//	- Go structs, that are encoded as dict, or as tuple if they use
+	//	  positional pickle tags;
+	//	- uint64 values > math.MaxInt64, that are decoded back as *big.Int.
+	Strict bool
+
+	// NetIP, when true, requests the encoder to emit Go IP types as objects
+	// of Python ipaddress module: netip.Addr and net.IP as ip_address,
+	// and netip.Prefix and net.IPNet as ip_network, or as ip_interface if
+	// the prefix has host bits set. Without NetIP those types are encoded
+	// as strings.
+	NetIP bool
+
+	// Decimal, when true, requests the encoder to emit big.Float and
+	// big.Rat as decimal.Decimal objects, so that arbitrary-precision
+	// numbers are transferred without loss of precision. It is an error
+	// to encode big.Rat, that has no finite decimal representation, in
+	// this mode.
+	Decimal bool
+
+	// Arrays, when true, requests the encoder to emit Go slices of
+	// fixed-size numbers, i.e. []int8, []int16, []int32, []int64,
+	// []uint16, []uint32, []uint64, []float32 and []float64, as array.array
+	// objects with corresponding typecodes. []byte is still encoded as
+	// bytearray. Without Arrays such slices are encoded as lists.
+	Arrays bool
+
+	// ExtensionRegistry, if !nil, maps classes to extension codes.
+	//
+	// It mirrors copyreg extension registry of Python: for protocol >= 2
+	// registered classes are emitted with compact EXT1, EXT2 or EXT4
+	// opcodes instead of full module and class names. Codes must be in
+	// range [1, 0x7fffffff]. The consumer must have the same extensions
+	// registered, e.g. via copyreg.add_extension or
+	// [DecoderConfig.ExtensionRegistry].
+	ExtensionRegistry map[Class]int
+
+	// BufferCallback, if !nil, enables emitting of buffers out-of-band
+	// with protocol ≥ 5.
+	//
+	// The encoder calls it for data of every Bytes, []byte and PickleBuffer
+	// value. As with buffer_callback argument of Python pickle.dumps, if
+	// BufferCallback returns false, the data is not put into the pickle,
+	// and is instead referred to via NEXT_BUFFER opcode (plus READONLY_BUFFER
+	// for read-only data). It is then the caller's responsibility to
+	// transfer the buffers to the consumer, e.g. as buffers argument of
+	// pickle.loads, or via [DecoderConfig.Buffers]. If BufferCallback
+	// returns true, the data is emitted in-band as usual. This way, for
+	// example, only large buffers can be transferred out-of-band.
+	//
+	// The encoder does not copy the data, and BufferCallback must not
+	// modify it.
+	BufferCallback func(data []byte, readOnly bool) (inBand bool)
+
+	// Framing, when true, requests the encoder to group pickles of
+	// protocol ≥ 4 into frames with FRAME opcodes, as CPython does. This
+	// allows consumers to read the pickle in large chunks instead of many
+	// small reads.
+	//
+	// FrameSize is the target size of frames; 64KiB if 0. Bytes and
+	// strings larger than that are emitted outside of frames.
+	Framing   bool
+	FrameSize int
+
+	// Types, if !nil, requests the encoder to emit Go types registered
+	// there as instances of corresponding Python classes. See
+	// [TypeRegistry] for details.
+	Types *TypeRegistry
 }
 
 // NewEncoder returns a new [Encoder] with the default configuration.
@@ -72,35 +377,306 @@ func NewEncoderWithConfig(w io.Writer, config *EncoderConfig) *Encoder {
 
 // Encode writes the pickle encoding of v to w, the encoder's writer
 func (e *Encoder) Encode(v any) error {
+	if e.config.ProtocolMode == ProtocolBump {
+		return e.encodeBump(v)
+	}
+	return e.encodeTop(v)
+}
+
+// encodeBump serves Encode in ProtocolBump mode.
+//
+// The pickle is first encoded into a buffer in strict mode, and the encoding
+// is retried with higher protocol when a value requires it.
+func (e *Encoder) encodeBump(v any) error {
+	w, config := e.w, e.config
+	defer func() {
+		e.w, e.config = w, config
+	}()
+
+	// shared memo has to be restored if encoding is retried
+	var memo map[any]int
+	if e.shareMemo {
+		memo = make(map[any]int, len(e.memo))
+		for k, idx := range e.memo {
+			memo[k] = idx
+		}
+	}
+
+	bconfig := *config
+	e.config = &bconfig
+	for {
+		buf := &bytes.Buffer{}
+		e.w = buf
+		err := e.encodeTop(v)
+
+		var perr *ProtocolError
+		if errors.As(err, &perr) && perr.Protocol > bconfig.Protocol {
+			bconfig.Protocol = perr.Protocol
+			if e.shareMemo {
+				e.memo = make(map[any]int, len(memo))
+				for k, idx := range memo {
+					e.memo[k] = idx
+				}
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		_, err = w.Write(buf.Bytes())
+		return err
+	}
+}
+
+// encodeTop serves Encode.
+func (e *Encoder) encodeTop(v any) error {
 	proto := e.config.Protocol
 	if !(0 <= proto && proto <= highestProtocol) {
 		return fmt.Errorf("pickle: encode: invalid protocol %d", proto)
 	}
 	// protocol >= 2  -> emit PROTO <protocol>
-	if proto >= 2 {
+	// in a session PROTO is emitted only once, unless the protocol changes
+	if proto >= 2 && !(e.session && proto == e.sessProto) {
 		err := e.emit(opProto, byte(proto))
 		if err != nil {
 			return err
 		}
 	}
 
+	if !e.shareMemo {
+		e.memo = nil
+	}
+
+	// protocol >= 4 with Framing -> the rest of the pickle goes into frames
+	if e.config.Framing && proto >= 4 {
+		f := newFramer(e.w, e.config.FrameSize)
+		e.w = f
+		defer func() {
+			e.w = f.w
+		}()
+	}
+
 	rv := reflectValueOf(v)
 	err := e.encode(rv)
 	if err != nil {
 		return err
 	}
-	return e.emit(opStop)
+	err = e.emit(opStop)
+	if err != nil {
+		return err
+	}
+	err = e.commitFrame(true)
+	if err != nil {
+		return err
+	}
+	if e.session && proto >= 2 {
+		e.sessProto = proto
+	}
+	return nil
+}
+
+// EncodeSession encodes multiple values into one stream of sequential pickles.
+//
+// The first pickle of the session starts with PROTO header, while subsequent
+// pickles omit it, and every value is terminated by STOP. This matches how
+// Python consumers read sequential pickles from one connection, e.g. by
+// calling load repeatedly on one pickle.Unpickler.
+//
+// If requested, the memo is shared in between the values of the session,
+// so that e.g. with DedupStrings a string emitted for one value is
+// referenced from memo by subsequent values. Such stream can be decoded
+// only by reading all pickles with one unpickler, that keeps its memo in
+// between loads, as both pickle.Unpickler and [Decoder] do.
+type EncodeSession struct {
+	e *Encoder
+}
+
+// NewEncodeSession returns new [EncodeSession] that emits pickles into w.
+//
+// config must not be nil.
+func NewEncodeSession(w io.Writer, config *EncoderConfig, shareMemo bool) *EncodeSession {
+	e := NewEncoderWithConfig(w, config)
+	e.session = true
+	e.shareMemo = shareMemo
+	e.sessProto = -1
+	return &EncodeSession{e: e}
+}
+
+// Encode writes the pickle encoding of v, terminated by STOP, to the session stream.
+func (s *EncodeSession) Encode(v any) error {
+	return s.e.Encode(v)
+}
+
+// EncodedSize returns the length of pickle encoding of v with the given configuration.
+//
+// The encoding is performed in dry-run mode without storing the output. This
+// can be used to e.g. preallocate output buffer or to check size limits before
+// doing the actual encoding.
+//
+// config must not be nil.
+func EncodedSize(v any, config *EncoderConfig) (int64, error) {
+	cw := &countWriter{}
+	e := NewEncoderWithConfig(cw, config)
+	err := e.Encode(v)
+	if err != nil {
+		return 0, err
+	}
+	return cw.n, nil
+}
+
+// countWriter is io.Writer wrapper that counts written bytes.
+//
+// If w is nil the data is discarded and only counted.
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+	if cw.w == nil {
+		cw.n += int64(len(p))
+		return len(p), nil
+	}
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+func (cw *countWriter) WriteString(s string) (int, error) {
+	if cw.w == nil {
+		cw.n += int64(len(s))
+		return len(s), nil
+	}
+	n, err := io.WriteString(cw.w, s)
+	cw.n += int64(n)
+	return n, err
+}
+
+// defaultFrameSize is the default target size of frames.
+// It matches frame size target of CPython pickler.
+const defaultFrameSize = 64 * 1024
+
+// frameSizeMin is the minimum size of data, that is put into frame with FRAME
+// opcode. Smaller data is emitted as is.
+const frameSizeMin = 4
+
+// framer accumulates encoder output into frames for protocol ≥ 4.
+type framer struct {
+	w     io.Writer // underlying writer
+	size  int       // target frame size
+	frame []byte    // data of current frame
+}
+
+func newFramer(w io.Writer, size int) *framer {
+	if size <= 0 {
+		size = defaultFrameSize
+	}
+	return &framer{w: w, size: size}
+}
+
+func (f *framer) Write(p []byte) (int, error) {
+	f.frame = append(f.frame, p...)
+	return len(p), nil
+}
+
+func (f *framer) WriteString(s string) (int, error) {
+	f.frame = append(f.frame, s...)
+	return len(s), nil
+}
+
+// commit writes out current frame, if it reached target size, or if force.
+func (f *framer) commit(force bool) error {
+	if len(f.frame) == 0 || !(force || len(f.frame) >= f.size) {
+		return nil
+	}
+
+	data := f.frame
+	f.frame = f.frame[:0]
+	if len(data) >= frameSizeMin {
+		var b = [1+8]byte{opFrame}
+		binary.LittleEndian.PutUint64(b[1:], uint64(len(data)))
+		_, err := f.w.Write(b[:])
+		if err != nil {
+			return err
+		}
+	}
+	_, err := f.w.Write(data)
+	return err
+}
+
+// writeLarge writes header and payload of an opcode outside of frames.
+func (f *framer) writeLarge(header, payload []byte) error {
+	err := f.commit(true)
+	if err != nil {
+		return err
+	}
+	_, err = f.w.Write(header)
+	if err != nil {
+		return err
+	}
+	_, err = f.w.Write(payload)
+	return err
+}
+
+// writeLargeString is like writeLarge, but for string payload.
+func (f *framer) writeLargeString(header []byte, payload string) error {
+	err := f.commit(true)
+	if err != nil {
+		return err
+	}
+	_, err = f.w.Write(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(f.w, payload)
+	return err
+}
+
+// EncodeN is like Encode, but also returns the number of bytes written to w.
+func (e *Encoder) EncodeN(v any) (int64, error) {
+	cw := &countWriter{w: e.w}
+	e.w = cw
+	defer func() {
+		e.w = cw.w
+	}()
+	err := e.Encode(v)
+	return cw.n, err
+}
+
+// haveProtocol returns whether value v, which can be natively represented
+// only starting from protocol proto, should be encoded natively.
+//
+// If it returns false, the value should be emulated with constructs available
+// at current protocol.
+func (e *Encoder) haveProtocol(v any, proto int) (bool, error) {
+	if e.config.Protocol >= proto {
+		return true, nil
+	}
+	if e.config.ProtocolMode == ProtocolEmulate {
+		return false, nil
+	}
+	return false, &ProtocolError{Value: v, Protocol: proto}
 }
 
 // emit writes byte vector into encoder output.
 func (e *Encoder) emitb(b []byte) error {
 	_, err := e.w.Write(b)
+	if err != nil {
+		e.werr = err
+	}
 	return err
 }
 
 // emits writes string into encoder output.
+//
+// The string is not copied if the output supports io.StringWriter.
 func (e *Encoder) emits(s string) error {
-	return e.emitb([]byte(s))
+	_, err := io.WriteString(e.w, s)
+	if err != nil {
+		e.werr = err
+	}
+	return err
 }
 
 // emit writes byte arguments into encoder output.
@@ -108,13 +684,104 @@ func (e *Encoder) emit(bv ...byte) error {
 	return e.emitb(bv)
 }
 
+// emitData writes opcode header followed by its data payload into encoder output.
+//
+// With framing, large payloads are written outside of frames, as CPython does.
+func (e *Encoder) emitData(header, data []byte) error {
+	f, ok := e.w.(*framer)
+	if !ok || len(data) < f.size {
+		err := e.emitb(header)
+		if err != nil {
+			return err
+		}
+		return e.emitb(data)
+	}
+
+	err := f.writeLarge(header, data)
+	if err != nil {
+		e.werr = err
+	}
+	return err
+}
+
+// emitDataString is like emitData, but for string payload, that is not copied
+// into []byte.
+func (e *Encoder) emitDataString(header []byte, data string) error {
+	f, ok := e.w.(*framer)
+	if !ok || len(data) < f.size {
+		err := e.emitb(header)
+		if err != nil {
+			return err
+		}
+		return e.emits(data)
+	}
+
+	err := f.writeLargeString(header, data)
+	if err != nil {
+		e.werr = err
+	}
+	return err
+}
+
+// commitFrame writes out current frame, if the output is framed and the frame
+// is big enough, or if force.
+func (e *Encoder) commitFrame(force bool) error {
+	f, ok := e.w.(*framer)
+	if !ok {
+		return nil
+	}
+	err := f.commit(force)
+	if err != nil {
+		e.werr = err
+	}
+	return err
+}
+
 // emitf writes formatted string into encoder output.
 func (e *Encoder) emitf(format string, argv ...any) error {
 	_, err := fmt.Fprintf(e.w, format, argv...)
+	if err != nil {
+		e.werr = err
+	}
 	return err
 }
 
 func (e *Encoder) encode(rv reflect.Value) error {
+	// every value starts at opcode boundary, where current frame can be ended
+	err := e.commitFrame(false)
+	if err != nil {
+		return err
+	}
+
+	if e.config.Memoize {
+		if key, ok := memoKey(rv); ok {
+			// object that is already being encoded -> it contains itself
+			if e.active[key] {
+				return errRecursive
+			}
+			if e.active == nil {
+				e.active = make(map[any]bool)
+			}
+			e.active[key] = true
+			defer delete(e.active, key)
+
+			return e.memoize(key, func() error {
+				return e.encode_(rv)
+			})
+		}
+	}
+	return e.encode_(rv)
+}
+
+var errRecursive = errors.New("recursive structures are not supported")
+
+func (e *Encoder) encode_(rv reflect.Value) error {
+	switch rk := rv.Kind(); rk {
+	case reflect.Map, reflect.Slice, reflect.Struct:
+		if ok, err := e.encodeContainer(rv); ok {
+			return err
+		}
+	}
 
 	switch rk := rv.Kind(); rk {
 
@@ -126,7 +793,7 @@ func (e *Encoder) encode(rv reflect.Value) error {
 		return e.encodeUint(rv.Uint())
 	case reflect.String:
 		switch rv.Interface().(type) {
-		case unicode:
+		case Unicode:
 			return e.encodeUnicode(rv.String())
 		case Bytes:
 			return e.encodeBytes(Bytes(rv.String()))
@@ -136,10 +803,20 @@ func (e *Encoder) encode(rv reflect.Value) error {
 			return e.encodeString(rv.String())
 		}
 	case reflect.Array, reflect.Slice:
+		if ip, ok := rv.Interface().(net.IP); ok {
+			return e.encodeNetIPAddr(netIPAddr(ip))
+		}
+		if e.config.Arrays && rk == reflect.Slice {
+			if _, ok := arrayEncoding[rv.Type().Elem().Kind()]; ok {
+				return e.encodeTypedArray(rv)
+			}
+		}
 		if rv.Type().Elem().Kind() == reflect.Uint8 {
 			return e.encodeByteArray(rv.Bytes())
 		} else if t, ok := rv.Interface().(Tuple); ok {
 			return e.encodeTuple(t)
+		} else if kv, ok := rv.Interface().([]KV); ok {
+			return e.encodeItems(kv)
 		} else {
 			return e.encodeArray(rv)
 		}
@@ -172,6 +849,14 @@ func (e *Encoder) encode(rv reflect.Value) error {
 			switch rv.Elem().Interface().(type) {
 			case None:
 				return e.encodeStruct(rv.Elem())
+			case time.Location:
+				return e.encodeLocation(rv.Interface().(*time.Location))
+			}
+		}
+
+		if !rv.IsNil() {
+			if ok, err := e.encodeContainer(rv); ok {
+				return err
 			}
 		}
 
@@ -180,13 +865,17 @@ func (e *Encoder) encode(rv reflect.Value) error {
 	case reflect.Invalid:
 		return e.emit(opNone)
 	default:
-		return &TypeError{typ: rk.String()}
+		return &TypeError{typ: rk.String(), Type: rv.Type()}
 	}
-
-	return nil
 }
 
 func (e *Encoder) encodeTuple(t Tuple) error {
+	return e.encodeTupleAt(t, pathIndex)
+}
+
+// encodeTupleAt is encodeTuple with elemPath providing path elements for
+// tuple items in errors, e.g. field names for structs with positional tags.
+func (e *Encoder) encodeTupleAt(t Tuple, elemPath func(i int) string) error {
 	l := len(t)
 
 	// protocol >= 2: [1-3]() -> TUPLE{1-3}
@@ -194,7 +883,7 @@ func (e *Encoder) encodeTuple(t Tuple) error {
 		for i := range t {
 			err := e.encode(reflectValueOf(t[i]))
 			if err != nil {
-				return err
+				return e.errorAt(err, elemPath(i))
 			}
 		}
 
@@ -226,7 +915,7 @@ func (e *Encoder) encodeTuple(t Tuple) error {
 	for i := 0; i < l; i++ {
 		err = e.encode(reflectValueOf(t[i]))
 		if err != nil {
-			return err
+			return e.errorAt(err, elemPath(i))
 		}
 	}
 
@@ -237,34 +926,146 @@ func (e *Encoder) encodeArray(arr reflect.Value) error {
 
 	l := arr.Len()
 
-	// protocol >= 1: ø list -> EMPTY_LIST
-	if e.config.Protocol >= 1 && l == 0 {
-		return e.emit(opEmptyList)
-	}
-
-	// MARK + ... + LIST
-	// TODO detect cycles and double references to the same object
-	err := e.emit(opMark)
+	b, err := e.emitListStart(l)
 	if err != nil {
 		return err
 	}
 
 	for i := 0; i < l; i++ {
+		err = b.begin()
+		if err != nil {
+			return err
+		}
+
 		v := arr.Index(i)
 		err = e.encode(v)
+		if err != nil {
+			return e.errorAt(err, pathIndex(i))
+		}
+
+		err = b.end()
 		if err != nil {
 			return err
 		}
 	}
 
-	return e.emit(opList)
+	return nil
 }
 
-func (e *Encoder) encodeBool(b bool) error {
-	// protocol >= 2  ->  NEWTRUE/NEWFALSE
-	if e.config.Protocol >= 2 {
-		op := opNewfalse
-		if b {
+// listBatchSize is how many list items are appended with one APPENDS opcode.
+// It matches batch size of CPython pickler.
+const listBatchSize = 1000
+
+// emitListStart emits empty list and returns batcher to append its l items.
+//
+// Similarly to CPython pickler, the items are appended in batches, so that
+// the unpickler does not need to keep the whole list data on its stack:
+//
+//	protocol 0:  MARK + LIST + (item + APPEND)*
+//	protocol 1+: EMPTY_LIST + (MARK + item* + APPENDS)*
+//
+// Batches of one item are emitted with APPEND.
+func (e *Encoder) emitListStart(l int) (*batcher, error) {
+	b := &batcher{e: e, l: l, size: listBatchSize, op1: opAppend, opN: opAppends}
+
+	var err error
+	if e.config.Protocol == 0 {
+		b.size = 1
+		err = e.emit(opMark, opList)
+	} else {
+		err = e.emit(opEmptyList)
+	}
+	return b, err
+}
+
+// encodeSequence encodes sequence-like container as list, or as tuple if the
+// container says so via IsTuple.
+func (e *Encoder) encodeSequence(s Sequence) error {
+	isTuple := false
+	if t, ok := s.(interface{ IsTuple() bool }); ok {
+		isTuple = t.IsTuple()
+	}
+	l := s.Len()
+
+	// protocol >= 1: ø list/tuple -> EMPTY_LIST/EMPTY_TUPLE
+	if e.config.Protocol >= 1 && l == 0 {
+		if isTuple {
+			return e.emit(opEmptyTuple)
+		}
+		return e.emit(opEmptyList)
+	}
+
+	// protocol >= 2: [1-3]() -> TUPLE{1-3}
+	small := isTuple && e.config.Protocol >= 2 && l <= 3
+
+	// list: empty list + batches of APPENDS
+	// tuple: general case MARK ... TUPLE
+	var b *batcher
+	var err error
+	switch {
+	case !isTuple:
+		b, err = e.emitListStart(l)
+	case !small:
+		err = e.emit(opMark)
+	}
+	if err != nil {
+		return err
+	}
+
+	n := 0
+	s.Iterate(func(v any) bool {
+		if n == l {
+			n++
+			return false
+		}
+		if b != nil {
+			err = b.begin()
+			if err != nil {
+				return false
+			}
+		}
+		err = e.encode(reflectValueOf(v))
+		if err != nil {
+			err = e.errorAt(err, pathIndex(n))
+			return false
+		}
+		n++
+		if b != nil {
+			err = b.end()
+		}
+		return err == nil
+	})
+	if err != nil {
+		return err
+	}
+	if n != l {
+		return fmt.Errorf("sequence: Len is %d, but Iterate yielded %s items", l, seqCount(n, l))
+	}
+
+	switch {
+	case !isTuple:
+		return nil
+	case small:
+		return e.emit([]byte{0, opTuple1, opTuple2, opTuple3}[l])
+	default:
+		return e.emit(opTuple)
+	}
+}
+
+// seqCount returns how many items or entries Iterate yielded: exact n, or
+// "more than l".
+func seqCount(n, l int) string {
+	if n > l {
+		return fmt.Sprintf("more than %d", l)
+	}
+	return strconv.Itoa(n)
+}
+
+func (e *Encoder) encodeBool(b bool) error {
+	// protocol >= 2  ->  NEWTRUE/NEWFALSE
+	if e.config.Protocol >= 2 {
+		op := opNewfalse
+		if b {
 			op = opNewtrue
 		}
 		return e.emit(op)
@@ -282,10 +1083,45 @@ func (e *Encoder) encodeBool(b bool) error {
 }
 
 func (e *Encoder) encodeBytes(byt Bytes) error {
+	return e.dedup(byt, func() error {
+		return e.encodeBytes_(byt)
+	})
+}
+
+var errP0123BytesTooLong = errors.New(`protocol 0-3: bytes: cannot represent bytes ≥ 4GiB`)
+
+func (e *Encoder) encodeBytes_(byt Bytes) error {
+	if e.config.BufferCallback != nil {
+		if ok, err := e.encodeOutOfBand([]byte(byt), true); ok || err != nil {
+			return err
+		}
+	}
+
 	l := len(byt)
 
+	// protocol >= 4  ->  BINBYTES8
+	if uint64(l) > math.MaxUint32 {
+		native, err := e.haveProtocol(byt, 4)
+		if err != nil {
+			return err
+		}
+		if !native {
+			return errP0123BytesTooLong
+		}
+
+		var b = [1+8]byte{opBinbytes8}
+
+		binary.LittleEndian.PutUint64(b[1:], uint64(l))
+		return e.emitDataString(b[:], string(byt))
+	}
+
+	native, err := e.haveProtocol(byt, 3)
+	if err != nil {
+		return err
+	}
+
 	// protocol >= 3  ->  BINBYTES*
-	if e.config.Protocol >= 3 {
+	if native {
 		if l < 256 {
 			err := e.emit(opShortBinbytes, byte(l))
 			if err != nil {
@@ -295,10 +1131,7 @@ func (e *Encoder) encodeBytes(byt Bytes) error {
 			var b = [1+4]byte{opBinbytes}
 
 			binary.LittleEndian.PutUint32(b[1:], uint32(l))
-			err := e.emitb(b[:])
-			if err != nil {
-				return err
-			}
+			return e.emitDataString(b[:], string(byt))
 		}
 
 		return e.emits(string(byt))
@@ -310,7 +1143,7 @@ func (e *Encoder) encodeBytes(byt Bytes) error {
 	for i := 0; i < l; i++ {
 		rlatin1[i] = rune(byt[i]) // decode as latin1
 	}
-	ulatin1 := unicode(rlatin1) // -> UTF8
+	ulatin1 := Unicode(rlatin1) // -> UTF8
 
 	return e.encodeCall(&Call{
 		Callable: Class{Module: "_codecs", Name: "encode"},
@@ -319,16 +1152,21 @@ func (e *Encoder) encodeBytes(byt Bytes) error {
 }
 
 func (e *Encoder) encodeByteArray(bv []byte) error {
+	native, err := e.haveProtocol(bv, 5)
+	if err != nil {
+		return err
+	}
+
 	// protocol >= 5  ->  BYTEARRAY8
-	if e.config.Protocol >= 5 {
+	if native {
+		if ok, err := e.encodeOutOfBand(bv, false); ok || err != nil {
+			return err
+		}
+
 		var b = [1+8]byte{opBytearray8}
 
 		binary.LittleEndian.PutUint64(b[1:], uint64(len(bv)))
-		err := e.emitb(b[:])
-		if err != nil {
-			return err
-		}
-		return e.emitb(bv)
+		return e.emitData(b[:], bv)
 	}
 
 	// TODO protocol <= 2: pickle can be shorter if we emit -> bytearray(unicode, encoding)
@@ -340,6 +1178,60 @@ func (e *Encoder) encodeByteArray(bv []byte) error {
 	})
 }
 
+// encodeOutOfBand emits data as out-of-band buffer if BufferCallback requests so.
+//
+// It returns ok=false if the data has to be emitted in-band.
+func (e *Encoder) encodeOutOfBand(data []byte, readOnly bool) (ok bool, err error) {
+	cb := e.config.BufferCallback
+	if cb == nil || e.config.Protocol < 5 || cb(data, readOnly) {
+		return false, nil
+	}
+
+	if readOnly {
+		return true, e.emit(opNextBuffer, opReadOnlyBuffer)
+	}
+	return true, e.emit(opNextBuffer)
+}
+
+func (e *Encoder) encodePickleBuffer(buf *PickleBuffer) error {
+	native, err := e.haveProtocol(buf, 5)
+	if err != nil {
+		return err
+	}
+
+	// protocol >= 5  ->  in-band buffer, as Python emits it without buffer_callback
+	if native {
+		if buf.ReadOnly {
+			return e.encodeBytes(Bytes(buf.Data))
+		}
+		return e.encodeByteArray(buf.Data)
+	}
+
+	// protocol <= 4  ->  pickle.PickleBuffer(bytes|bytearray)
+	var data any = buf.Data
+	if buf.ReadOnly {
+		data = Bytes(buf.Data)
+	}
+	return e.encodeCall(&Call{
+		Callable: Class{Module: "pickle", Name: "PickleBuffer"},
+		Args:     Tuple{data},
+	})
+}
+
+// encodeMemoryView encodes memoryview(bytes|bytearray).
+//
+// For protocol ≥ 5 bytes and bytearray are emitted as in-band buffers.
+func (e *Encoder) encodeMemoryView(m *MemoryView) error {
+	var data any = m.Data
+	if m.ReadOnly {
+		data = Bytes(m.Data)
+	}
+	return e.encodeCall(&Call{
+		Callable: pybuiltin(e.config.Protocol, "memoryview"),
+		Args:     Tuple{data},
+	})
+}
+
 func (e *Encoder) encodeString(s string) error {
 	// StrictUnicode || protocol >= 3 -> encode string as unicode object as py3 does
 	if e.config.StrictUnicode || e.config.Protocol >= 3 {
@@ -352,6 +1244,12 @@ func (e *Encoder) encodeString(s string) error {
 }
 
 func (e *Encoder) encodeByteString(s string) error {
+	return e.dedup(ByteString(s), func() error {
+		return e.encodeByteString_(s)
+	})
+}
+
+func (e *Encoder) encodeByteString_(s string) error {
 	l := len(s)
 
 	// protocol >= 1  ->  BINSTRING*
@@ -384,9 +1282,16 @@ func (e *Encoder) encodeByteString(s string) error {
 }
 
 var errP0UnicodeUTF8Only = errors.New(`protocol 0: unicode: raw-unicode-escape cannot represent invalid UTF-8`)
+var errP123UnicodeTooLong = errors.New(`protocol 1-3: unicode: cannot represent string ≥ 4GiB`)
 
 // encodeUnicode emits UTF-8 encoded string s as unicode pickle object.
 func (e *Encoder) encodeUnicode(s string) error {
+	return e.dedup(Unicode(s), func() error {
+		return e.encodeUnicode_(s)
+	})
+}
+
+func (e *Encoder) encodeUnicode_(s string) error {
 	// protocol >= 1  -> BINUNICODE*
 	if e.config.Protocol >= 1 {
 		l := len(s)
@@ -397,14 +1302,25 @@ func (e *Encoder) encodeUnicode(s string) error {
 			if err != nil {
 				return err
 			}
+		} else if uint64(l) > math.MaxUint32 {
+			// protocol >= 4  -> BINUNICODE8
+			native, err := e.haveProtocol(Unicode(s), 4)
+			if err != nil {
+				return err
+			}
+			if !native {
+				return errP123UnicodeTooLong
+			}
+
+			var b = [1+8]byte{opBinunicode8}
+
+			binary.LittleEndian.PutUint64(b[1:], uint64(l))
+			return e.emitDataString(b[:], s)
 		} else {
 			var b = [1+4]byte{opBinunicode}
 
 			binary.LittleEndian.PutUint32(b[1:], uint32(l))
-			err := e.emitb(b[:])
-			if err != nil {
-				return err
-			}
+			return e.emitDataString(b[:], s)
 		}
 
 		return e.emits(s)
@@ -461,6 +1377,10 @@ func (e *Encoder) encodeUint(u uint64) error {
 	}
 
 	// u > math.MaxInt64 and cannot be represented as int64
+	if e.config.Strict {
+		return &LossyError{Value: u, Reason: "uint64 > MaxInt64 decodes as *big.Int"}
+	}
+
 	// emit it as text INT
 	return e.emitf("%c%d\n", opInt, u)
 }
@@ -470,91 +1390,531 @@ func (e *Encoder) encodeLong(b *big.Int) error {
 	return e.emitf("%c%dL\n", opLong, b)
 }
 
+// encodeDecimal encodes decimal.Decimal(s).
+func (e *Encoder) encodeDecimal(s string) error {
+	return e.encodeCall(&Call{
+		Callable: Class{Module: "decimal", Name: "Decimal"},
+		Args:     Tuple{Unicode(s)},
+	})
+}
+
+// ratDecimal returns exact decimal representation of r.
+//
+// ok=false is returned if r has no finite decimal representation.
+func ratDecimal(r *big.Rat) (s string, ok bool) {
+	// r is finite decimal only if its denominator is 2^a·5^b; then
+	// max(a, b) digits after the point represent it exactly.
+	var q, m big.Int
+	two, five := big.NewInt(2), big.NewInt(5)
+	den := new(big.Int).Set(r.Denom())
+	a, b := 0, 0
+	for {
+		q.QuoRem(den, two, &m)
+		if m.Sign() != 0 {
+			break
+		}
+		den.Set(&q)
+		a++
+	}
+	for {
+		q.QuoRem(den, five, &m)
+		if m.Sign() != 0 {
+			break
+		}
+		den.Set(&q)
+		b++
+	}
+	if den.Cmp(big.NewInt(1)) != 0 {
+		return "", false
+	}
+	if b > a {
+		a = b
+	}
+	return r.FloatString(a), true
+}
+
 func (e *Encoder) encodeMap(m reflect.Value) error {
 
 	keys := m.MapKeys()
 
-	l := len(keys)
-
-	// protocol >= 1: ø dict -> EMPTY_DICT
-	if e.config.Protocol >= 1 && l == 0 {
-		return e.emit(opEmptyDict)
+	if e.config.SortKeys {
+		sort.Slice(keys, func(i, j int) bool {
+			return compareKeys(keys[i].Interface(), keys[j].Interface()) < 0
+		})
 	}
 
-	// MARK + ... + DICT
-	// TODO detect cycles and double references to the same object
-	// XXX sort keys, so the output is stable?
-	err := e.emit(opMark)
+	b, err := e.emitDictStart(len(keys))
 	if err != nil {
 		return err
 	}
 
 	for _, k := range keys {
-		err = e.encode(k)
+		err = b.begin()
 		if err != nil {
 			return err
 		}
+
+		err = e.encode(k)
+		if err != nil {
+			return e.errorAt(err, pathKey(k.Interface()))
+		}
 		v := m.MapIndex(k)
 
 		err = e.encode(v)
+		if err != nil {
+			return e.errorAt(err, pathKey(k.Interface()))
+		}
+
+		err = b.end()
 		if err != nil {
 			return err
 		}
 	}
 
-	return e.emit(opDict)
+	return nil
 }
 
 func (e *Encoder) encodeDict(d Dict) error {
-	l := d.Len()
-
-	// protocol >= 1: ø dict -> EMPTY_DICT
-	if e.config.Protocol >= 1 && l == 0 {
-		return e.emit(opEmptyDict)
+	if e.config.SortKeys {
+		kv := make([]KV, 0, d.Len())
+		d.Iter()(func(k, v any) bool {
+			kv = append(kv, KV{k, v})
+			return true
+		})
+		return e.encodeSortedItems(kv)
 	}
 
-	// MARK + ... + DICT
-	// TODO cycles + sort keys (see encodeMap for details)
-	err := e.emit(opMark)
+	b, err := e.emitDictStart(d.Len())
 	if err != nil {
 		return err
 	}
 
 	d.Iter()(func(k, v any) bool {
-		err = e.encode(reflectValueOf(k))
-		if err != nil {
+		err = e.encodeDictEntry(b, k, v)
+		return err == nil
+	})
+	return err
+}
+
+var (
+	mappingType  = reflect.TypeOf((*Mapping)(nil)).Elem()
+	sequenceType = reflect.TypeOf((*Sequence)(nil)).Elem()
+)
+
+// encodeContainer encodes rv if its type implements Mapping or Sequence.
+//
+// ok=false is returned if rv is not such a container.
+func (e *Encoder) encodeContainer(rv reflect.Value) (ok bool, err error) {
+	if !rv.CanInterface() {
+		return false, nil
+	}
+
+	// don't box every map, slice and struct into interface just to find
+	// out that it is not a container
+	typ := rv.Type()
+	container, known := e.containers[typ]
+	if !known {
+		container = typ.Implements(mappingType) || typ.Implements(sequenceType)
+		if e.containers == nil {
+			e.containers = make(map[reflect.Type]bool)
+		}
+		e.containers[typ] = container
+	}
+	if !container {
+		return false, nil
+	}
+
+	switch v := rv.Interface().(type) {
+	case Mapping:
+		return true, e.encodeMapping(v)
+	case Sequence:
+		return true, e.encodeSequence(v)
+	}
+	return false, nil
+}
+
+// encodeMapping encodes map-like container as dict with entries in its iteration order.
+func (e *Encoder) encodeMapping(m Mapping) error {
+	l := m.Len()
+	if e.config.SortKeys {
+		kv := make([]KV, 0, l)
+		m.Iterate(func(k, v any) bool {
+			kv = append(kv, KV{k, v})
+			return len(kv) <= l
+		})
+		if len(kv) != l {
+			return fmt.Errorf("mapping: Len is %d, but Iterate yielded %s entries", l, seqCount(len(kv), l))
+		}
+		return e.encodeSortedItems(kv)
+	}
+
+	b, err := e.emitDictStart(l)
+	if err != nil {
+		return err
+	}
+
+	n := 0
+	m.Iterate(func(k, v any) bool {
+		if n == l {
+			n++
 			return false
 		}
+		err = e.encodeDictEntry(b, k, v)
+		n++
+		return err == nil
+	})
+	if err != nil {
+		return err
+	}
+	if n != l {
+		return fmt.Errorf("mapping: Len is %d, but Iterate yielded %s entries", l, seqCount(n, l))
+	}
+	return nil
+}
 
-		err = e.encode(reflectValueOf(v))
+// encodeItems encodes key/value pairs as dict with entries in the given order.
+func (e *Encoder) encodeItems(kv []KV) error {
+	b, err := e.emitDictStart(len(kv))
+	if err != nil {
+		return err
+	}
+
+	for i, x := range kv {
+		err = b.begin()
+		if err != nil {
+			return err
+		}
+
+		err = e.encode(reflectValueOf(x.Key))
+		if err != nil {
+			return e.errorAt(err, pathIndex(i) + ".Key")
+		}
+		err = e.encode(reflectValueOf(x.Value))
+		if err != nil {
+			return e.errorAt(err, pathIndex(i) + ".Value")
+		}
+
+		err = b.end()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// encodeSortedItems encodes key/value pairs as dict with entries ordered by key.
+//
+// It serves SortKeys mode for containers that are not ordered by themselves.
+func (e *Encoder) encodeSortedItems(kv []KV) error {
+	sort.Slice(kv, func(i, j int) bool {
+		return compareKeys(kv[i].Key, kv[j].Key) < 0
+	})
+
+	b, err := e.emitDictStart(len(kv))
+	if err != nil {
+		return err
+	}
+
+	for _, x := range kv {
+		err = e.encodeDictEntry(b, x.Key, x.Value)
 		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// encodeDictEntry encodes one entry of dict, that is being emitted with b.
+func (e *Encoder) encodeDictEntry(b *batcher, k, v any) error {
+	err := b.begin()
+	if err != nil {
+		return err
+	}
+
+	err = e.encode(reflectValueOf(k))
+	if err != nil {
+		return e.errorAt(err, pathKey(k))
+	}
+	err = e.encode(reflectValueOf(v))
+	if err != nil {
+		return e.errorAt(err, pathKey(k))
+	}
+
+	return b.end()
+}
+
+// dictBatchSize is how many dict entries are set with one SETITEMS opcode.
+// It matches batch size of CPython pickler.
+const dictBatchSize = 1000
+
+// emitDictStart emits empty dict and returns batcher to set its l entries.
+//
+// Similarly to CPython pickler, the entries are set in batches, so that the
+// unpickler does not need to keep the whole dict data on its stack:
+//
+//	protocol 0:  MARK + DICT + (key + value + SETITEM)*
+//	protocol 1+: EMPTY_DICT + (MARK + (key + value)* + SETITEMS)*
+//
+// Batches of one entry are emitted with SETITEM.
+func (e *Encoder) emitDictStart(l int) (*batcher, error) {
+	b := &batcher{e: e, l: l, size: dictBatchSize, op1: opSetitem, opN: opSetitems}
+
+	var err error
+	if e.config.Protocol == 0 {
+		b.size = 1
+		err = e.emit(opMark, opDict)
+	} else {
+		err = e.emit(opEmptyDict)
+	}
+	return b, err
+}
+
+// batcher emits items of a container in batches, e.g. as
+// MARK + ... + SETITEMS, with every batch having at most size items.
+//
+// begin and end must be called before and after emitting every item.
+type batcher struct {
+	e    *Encoder
+	l    int  // total number of items
+	n    int  // number of already emitted items
+	size int  // maximum batch size
+	op1  byte // opcode to add batch of one item, e.g. SETITEM
+	opN  byte // opcode to add items after MARK, e.g. SETITEMS
+}
+
+func (b *batcher) begin() error {
+	if b.n % b.size == 0 && b.l - b.n > 1 && b.size > 1 {
+		return b.e.emit(opMark)
+	}
+	return nil
+}
+
+func (b *batcher) end() error {
+	b.n++
+	if b.n % b.size != 0 && b.n != b.l {
+		return nil
+	}
+	// number of items in the batch
+	if (b.n - 1) % b.size == 0 {
+		return b.e.emit(b.op1)
+	}
+	return b.e.emit(b.opN)
+}
+
+// setBatchSize is how many set elements are added with one ADDITEMS opcode.
+// It matches batch size of CPython pickler.
+const setBatchSize = 1000
+
+// encodeSet encodes set.
+func (e *Encoder) encodeSet(s Set) error {
+	s = e.sortedSet(s)
+
+	// protocol ≤ 3: builtins.set([...])
+	if e.config.Protocol < 4 {
+		return e.encodeCall(&Call{
+			Callable: pybuiltin(e.config.Protocol, "set"),
+			Args:     Tuple{setItems(s.Iter(), s.Len())},
+		})
+	}
+
+	// protocol ≥ 4: EMPTY_SET + (MARK + ... + ADDITEMS)*
+	err := e.emit(opEmptySet)
+	if err != nil {
+		return err
+	}
+
+	n, l := 0, s.Len()
+	s.Iter()(func(x any) bool {
+		if n % setBatchSize == 0 {
+			err = e.emit(opMark)
+			if err != nil {
+				return false
+			}
+		}
+
+		err = e.encode(reflectValueOf(x))
+		if err != nil {
+			err = e.errorAt(err, pathIndex(n))
 			return false
 		}
 
+		n++
+		if n % setBatchSize == 0 || n == l {
+			err = e.emit(opAddItems)
+		}
+		return err == nil
+	})
+	return err
+}
+
+// sortedSet returns s with elements ordered in SortKeys mode.
+func (e *Encoder) sortedSet(s Set) Set {
+	if !e.config.SortKeys {
+		return s
+	}
+	items := make([]any, 0, s.Len())
+	s.Iter()(func(x any) bool {
+		items = append(items, x)
+		return true
+	})
+	sort.Slice(items, func(i, j int) bool {
+		return compareKeys(items[i], items[j]) < 0
+	})
+	return NewSetWithData(items...)
+}
+
+// encodeFrozenSet encodes frozenset.
+func (e *Encoder) encodeFrozenSet(s FrozenSet) error {
+	s = FrozenSet{e.sortedSet(s.s)}
+
+	// protocol ≤ 3: builtins.frozenset([...])
+	if e.config.Protocol < 4 {
+		return e.encodeCall(&Call{
+			Callable: pybuiltin(e.config.Protocol, "frozenset"),
+			Args:     Tuple{setItems(s.Iter(), s.Len())},
+		})
+	}
+
+	// protocol ≥ 4: MARK + ... + FROZENSET
+	err := e.emit(opMark)
+	if err != nil {
+		return err
+	}
+
+	n := 0
+	s.Iter()(func(x any) bool {
+		err = e.encode(reflectValueOf(x))
+		if err != nil {
+			err = e.errorAt(err, pathIndex(n))
+			return false
+		}
+		n++
 		return true
 	})
 	if err != nil {
 		return err
 	}
 
-	return e.emit(opDict)
+	return e.emit(opFrozenSet)
 }
 
 func (e *Encoder) encodeCall(v *Call) error {
+	return e.encodeCallAt(v, pathArgs)
+}
+
+// encodeCallAt is encodeCall with argPath providing path elements for call
+// arguments in errors.
+func (e *Encoder) encodeCallAt(v *Call, argPath func(i int) string) error {
 	err := e.encodeClass(&v.Callable)
 	if err != nil {
 		return err
 	}
-	err = e.encodeTuple(v.Args)
+	err = e.encodeTupleAt(v.Args, argPath)
 	if err != nil {
 		return err
 	}
 	return e.emit(opReduce)
 }
 
+// pathArgs returns path element for i-th argument of Call or Object.
+func pathArgs(i int) string {
+	return ".Args" + pathIndex(i)
+}
+
+func (e *Encoder) encodeObject(v *Object) error {
+	err := e.encodeObjectNew(v)
+	if err != nil || v.State == nil {
+		return err
+	}
+
+	err = e.encode(reflectValueOf(v.State))
+	if err != nil {
+		return e.errorAt(err, ".State")
+	}
+	return e.emit(opBuild)
+}
+
+var errObjectCallKwArgs = errors.New("object: keyword arguments are not supported for call")
+
+// encodeObjectNew encodes creation of the object without its state.
+func (e *Encoder) encodeObjectNew(v *Object) error {
+	return e.encodeObjectNewAt(v, pathArgs)
+}
+
+// encodeObjectNewAt is encodeObjectNew with argPath providing path elements
+// for object arguments in errors.
+func (e *Encoder) encodeObjectNewAt(v *Object, argPath func(i int) string) error {
+	if v.Called {
+		if len(v.KwArgs) > 0 {
+			return errObjectCallKwArgs
+		}
+		return e.encodeCallAt(&Call{Callable: v.Class, Args: v.Args}, argPath)
+	}
+
+	if len(v.KwArgs) > 0 {
+		return e.encodeObjectEx(v)
+	}
+
+	// protocol < 2: NEWOBJ is not available -> copyreg.__newobj__(cls, *args)
+	if e.config.Protocol < 2 {
+		args := append(Tuple{v.Class}, v.Args...)
+		return e.encodeCallAt(&Call{Callable: pycopyreg(e.config.Protocol, "__newobj__"), Args: args},
+			func(i int) string {
+				if i == 0 {
+					return ".Class"
+				}
+				return argPath(i - 1)
+			})
+	}
+
+	err := e.encodeClass(&v.Class)
+	if err != nil {
+		return err
+	}
+	err = e.encodeTupleAt(v.Args, argPath)
+	if err != nil {
+		return err
+	}
+	return e.emit(opNewobj)
+}
+
+// encodeObjectEx encodes object with keyword arguments.
+func (e *Encoder) encodeObjectEx(v *Object) error {
+	// protocol < 4: NEWOBJ_EX is not available -> copyreg.__newobj_ex__(cls, args, kwargs)
+	if e.config.Protocol < 4 {
+		return e.encodeCallAt(&Call{
+			Callable: pycopyreg(e.config.Protocol, "__newobj_ex__"),
+			Args:     Tuple{v.Class, v.Args, v.KwArgs},
+		}, func(i int) string {
+			return [...]string{".Class", ".Args", ".KwArgs"}[i]
+		})
+	}
+
+	err := e.encodeClass(&v.Class)
+	if err != nil {
+		return err
+	}
+	err = e.encodeTuple(v.Args)
+	if err != nil {
+		return e.errorAt(err, ".Args")
+	}
+	err = e.encode(reflectValueOf(v.KwArgs))
+	if err != nil {
+		return e.errorAt(err, ".KwArgs")
+	}
+	return e.emit(opNewobjEx)
+}
+
 var errP0123GlobalStringLineOnly = errors.New(`protocol 0-3: global: module & name must be string without \n`)
 
 func (e *Encoder) encodeClass(v *Class) error {
+	// protocol >= 2: registered extension -> EXT{1,2,4}
+	if code, ok := e.config.ExtensionRegistry[*v]; ok && e.config.Protocol >= 2 {
+		return e.encodeExt(code)
+	}
+
 	// PEP 3154: Protocol 4 forbids use of the GLOBAL opcode and replaces
 	// it with STACK_GLOBAL.
 	if e.config.Protocol >= 4 {
@@ -576,6 +1936,20 @@ func (e *Encoder) encodeClass(v *Class) error {
 	return e.emitf("%c%s\n%s\n", opGlobal, v.Module, v.Name)
 }
 
+// encodeExt emits reference to class registered with extension code.
+func (e *Encoder) encodeExt(code int) error {
+	switch {
+	case code <= 0 || code > 0x7fffffff:
+		return fmt.Errorf("ext: invalid extension code %d", code)
+	case code <= 0xff:
+		return e.emit(opExt1, byte(code))
+	case code <= 0xffff:
+		return e.emit(opExt2, byte(code), byte(code>>8))
+	default:
+		return e.emit(opExt4, byte(code), byte(code>>8), byte(code>>16), byte(code>>24))
+	}
+}
+
 var errP0PersIDStringLineOnly = errors.New(`protocol 0: persistent ID must be string without \n`)
 
 func (e *Encoder) encodeRef(v *Ref) error {
@@ -592,7 +1966,7 @@ func (e *Encoder) encodeRef(v *Ref) error {
 	// protocol >= 1: we can use opBinpersid which allows arbitrary object as argument
 	err := e.encode(reflectValueOf(v.Pid))
 	if err != nil {
-		return err
+		return e.errorAt(err, ".Pid")
 	}
 	return e.emit(opBinpersid)
 }
@@ -607,56 +1981,278 @@ func (e *Encoder) encodeStruct(st reflect.Value) error {
 		return e.emit(opNone)
 	case Call:
 		return e.encodeCall(&v)
+	case Object:
+		return e.encodeObject(&v)
 	case Class:
 		return e.encodeClass(&v)
 	case Ref:
 		return e.encodeRef(&v)
+	case ZWeakRef:
+		return e.encodeRef(&Ref{v.ZPid().Pid()})
 	case big.Int:
 		return e.encodeLong(&v)
+	case big.Float:
+		if e.config.Decimal {
+			return e.encodeDecimal(v.Text('g', -1))
+		}
+	case big.Rat:
+		if e.config.Decimal {
+			d, ok := ratDecimal(&v)
+			if !ok {
+				return fmt.Errorf("decimal: %s has no finite decimal representation", &v)
+			}
+			return e.encodeDecimal(d)
+		}
 	case Dict:
 		return e.encodeDict(v)
+	case Set:
+		return e.encodeSet(v)
+	case FrozenSet:
+		return e.encodeFrozenSet(v)
+	case PickleBuffer:
+		return e.encodePickleBuffer(&v)
+	case MemoryView:
+		return e.encodeMemoryView(&v)
+	case netip.Addr:
+		return e.encodeNetIPAddr(v)
+	case netip.Prefix:
+		return e.encodeNetIPPrefix(v)
+	case net.IPNet:
+		return e.encodeNetIPPrefix(netIPPrefix(&v))
+	case Date:
+		return e.encodeDate(&v)
+	case Time:
+		return e.encodeTime(&v)
+	case DateTime:
+		return e.encodeDateTime(&v)
+	case NamedTuple:
+		return e.encodeNamedTuple(&v)
+	case Partial:
+		return e.encodePartial(&v)
+	case Exception:
+		return e.encodeException(&v)
+	case Slice:
+		return e.encodeSlice(&v)
+	case Range:
+		return e.encodeRange(&v)
+	case Dtype, DtypeField, NDArray:
+		// encoding them as dicts of Go fields would be misleading
+		return &TypeError{typ: typ.String(), Type: typ}
 	}
 
-	structTags := getStructTags(st)
+	class, ok := e.config.Types.classOf(typ)
+	if !ok {
+		class, ok = getStructClass(typ)
+	}
+	if ok {
+		return e.encodeInstance(class, st)
+	}
 
-	err := e.emit(opMark)
+	tupleFields, err := getStructTupleFields(st)
 	if err != nil {
 		return err
 	}
+	if e.config.Strict {
+		as := "dict"
+		if tupleFields != nil {
+			as = "tuple"
+		}
+		return &LossyError{Value: st.Interface(), Reason: "Go struct decodes as " + as}
+	}
+	if tupleFields != nil {
+		t := make(Tuple, len(tupleFields))
+		for i, f := range tupleFields {
+			t[i] = st.Field(f)
+		}
+		return e.encodeTupleAt(t, pathFields(typ, tupleFields))
+	}
 
-	if structTags != nil {
-		for f, i := range structTags {
-			err := e.encodeString(f)
-			if err != nil {
-				return err
-			}
+	return e.encodeStructDict(st, getStructDictEntries(st))
+}
 
-			err = e.encode(st.Field(i))
-			if err != nil {
-				return err
-			}
+// encodeStructDict encodes fields of struct st as dict with given entries.
+func (e *Encoder) encodeStructDict(st reflect.Value, entries []structDictField) error {
+	typ := st.Type()
+	if e.config.SortKeys {
+		sort.Slice(entries, func(i, j int) bool {
+			return entries[i].key < entries[j].key
+		})
+	}
+
+	b, err := e.emitDictStart(len(entries))
+	if err != nil {
+		return err
+	}
+
+	for _, x := range entries {
+		err = b.begin()
+		if err != nil {
+			return err
 		}
-	} else {
-		l := typ.NumField()
-		for i := 0; i < l; i++ {
-			fty := typ.Field(i)
-			if fty.PkgPath != "" {
-				continue // skip unexported names
-			}
 
-			err := e.encodeString(fty.Name)
-			if err != nil {
-				return err
-			}
+		err = e.encodeString(x.key)
+		if err != nil {
+			return err
+		}
 
-			err = e.encode(st.Field(i))
-			if err != nil {
-				return err
+		err = e.encode(st.Field(x.field))
+		if err != nil {
+			return e.errorAt(err, "." + typ.Field(x.field).Name)
+		}
+
+		err = b.end()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// dedup emits value via encode only if DedupStrings is enabled and an equal
+// value was not emitted before. If it was - memo reference to that value is
+// emitted instead.
+//
+// key must be comparable and must distinguish values by their pickle type,
+// e.g. unicode("a") vs ByteString("a").
+func (e *Encoder) dedup(key any, encode func() error) error {
+	if !(e.config.DedupStrings || e.config.Memoize) {
+		return encode()
+	}
+	return e.memoize(key, encode)
+}
+
+// memoize emits value via encode only if it was not emitted before under key.
+// If it was - memo reference to that value is emitted instead.
+func (e *Encoder) memoize(key any, encode func() error) error {
+	if e.memoOnlyUsed && !e.memoUsed[key] {
+		return encode()
+	}
+	if idx, ok := e.memo[key]; ok {
+		if e.memoUsed != nil {
+			e.memoUsed[key] = true
+		}
+		return e.emitMemoGet(idx)
+	}
+
+	err := encode()
+	if err != nil {
+		return err
+	}
+	return e.memoPut(key)
+}
+
+// identityKey is memo key of an object memoized by identity.
+type identityKey struct {
+	typ reflect.Type
+	ptr any // pointer to object data
+	len int
+}
+
+// memoKey returns key, under which value rv is memoized in Memoize mode.
+//
+// Maps, slices, Dict, Set and FrozenSet, and pointers to structs and arrays,
+// are memoized by identity, i.e. by pointer to their data, similarly to how
+// CPython memoizes objects by id. Other values are not memoized.
+func memoKey(rv reflect.Value) (key any, ok bool) {
+	switch rv.Kind() {
+	// pointer to e.g. Dict is memoized via the Dict itself
+	case reflect.Ptr:
+		if rv.IsNil() {
+			break
+		}
+		switch rv.Elem().Kind() {
+		case reflect.Struct, reflect.Array:
+			if _, ok := memoKey(rv.Elem()); !ok {
+				return identityKey{rv.Type(), rv.UnsafePointer(), 0}, true
 			}
 		}
+
+	case reflect.Map:
+		if !rv.IsNil() {
+			return identityKey{rv.Type(), rv.UnsafePointer(), 0}, true
+		}
+
+	// empty slices might share data pointer without being the same object
+	case reflect.Slice:
+		if rv.Len() > 0 {
+			return identityKey{rv.Type(), rv.UnsafePointer(), rv.Len()}, true
+		}
+
+	case reflect.Struct:
+		if !rv.CanInterface() {
+			break
+		}
+		var d Dict
+		switch v := rv.Interface().(type) {
+		case Dict:
+			d = v
+		case Set:
+			d = v.d
+		case FrozenSet:
+			d = v.s.d
+		}
+		if d.d != nil {
+			return identityKey{rv.Type(), d.d, 0}, true
+		}
+	}
+
+	return nil, false
+}
+
+// memoPut stores stack top into memo under next free index and remembers
+// that index for key.
+func (e *Encoder) memoPut(key any) error {
+	if e.memo == nil {
+		e.memo = make(map[any]int)
+	}
+	idx := len(e.memo)
+
+	var err error
+	// protocol >= 4  -> MEMOIZE
+	if e.config.Protocol >= 4 {
+		err = e.emit(opMemoize)
+	} else {
+		err = e.emitMemoPut(idx)
+	}
+	if err != nil {
+		return err
+	}
+
+	e.memo[key] = idx
+	return nil
+}
+
+// emitMemoPut emits storing of stack top into memo[idx] with PUT-family opcode.
+func (e *Encoder) emitMemoPut(idx int) error {
+	// protocol 0: PUT
+	if e.config.Protocol == 0 {
+		return e.emitf("%c%d\n", opPut, idx)
+	}
+
+	// protocol >= 1  -> BINPUT | LONG_BINPUT
+	if idx < 256 {
+		return e.emit(opBinput, byte(idx))
+	}
+	var b = [1+4]byte{opLongBinput}
+	binary.LittleEndian.PutUint32(b[1:], uint32(idx))
+	return e.emitb(b[:])
+}
+
+// emitMemoGet emits loading of memo[idx] onto the stack.
+func (e *Encoder) emitMemoGet(idx int) error {
+	// protocol 0: GET
+	if e.config.Protocol == 0 {
+		return e.emitf("%c%d\n", opGet, idx)
 	}
 
-	return e.emit(opDict)
+	// protocol >= 1  -> BINGET | LONG_BINGET
+	if idx < 256 {
+		return e.emit(opBinget, byte(idx))
+	}
+	var b = [1+4]byte{opLongBinget}
+	binary.LittleEndian.PutUint32(b[1:], uint32(idx))
+	return e.emitb(b[:])
 }
 
 func reflectValueOf(v any) reflect.Value {
@@ -668,28 +2264,186 @@ func reflectValueOf(v any) reflect.Value {
 	return rv
 }
 
-func getStructTags(ptr reflect.Value) map[string]int {
-	if ptr.Kind() != reflect.Struct {
-		return nil
+// structDictField is a Go struct field that is represented as dict entry.
+type structDictField struct {
+	key       string // dict key
+	field     int    // field index
+	omitEmpty bool   // whether the entry is omitted if the field has empty value
+}
+
+// getStructDictFields returns fields of struct type t that are represented as
+// dict entries, in field declaration order.
+//
+// If the struct has named pickle tags, e.g. `pickle:"name"`, only tagged
+// fields are represented with keys taken from the tags, or being field
+// names for tags without name, e.g. `pickle:",omitempty"`. Otherwise all
+// exported fields are represented with keys being field names. Fields
+// tagged with `pickle:"-"` are never represented.
+func getStructDictFields(t reflect.Type) []structDictField {
+	tags := make(map[string]int) // tag -> index of the last field with it
+	l := t.NumField()
+	for i := 0; i < l; i++ {
+		name, _, skip := parseStructTag(t.Field(i))
+		if !skip && name != "" {
+			tags[name] = i
+		}
+	}
+
+	var fields []structDictField
+	for i := 0; i < l; i++ {
+		fty := t.Field(i)
+		name, omitEmpty, skip := parseStructTag(fty)
+		if skip {
+			continue
+		}
+		if len(tags) != 0 {
+			if fty.Tag.Get("pickle") == "" {
+				continue // untagged
+			}
+			if name == "" {
+				name = fty.Name
+			} else if tags[name] != i {
+				continue // shadowed by later field with the same tag
+			}
+			fields = append(fields, structDictField{name, i, omitEmpty})
+		} else {
+			if fty.PkgPath != "" {
+				continue // skip unexported names
+			}
+			fields = append(fields, structDictField{fty.Name, i, omitEmpty})
+		}
+	}
+	return fields
+}
+
+// getStructDictEntries returns fields of struct st, that are emitted as dict
+// entries, i.e. fields from getStructDictFields without omitempty fields
+// that have empty value.
+func getStructDictEntries(st reflect.Value) []structDictField {
+	fields := getStructDictFields(st.Type())
+	entries := fields[:0]
+	for _, f := range fields {
+		if f.omitEmpty && isEmptyValue(st.Field(f.field)) {
+			continue
+		}
+		entries = append(entries, f)
 	}
+	return entries
+}
 
-	m := make(map[string]int)
+// parseStructTag parses `pickle:"name,opt1,opt2,..."` tag of struct field f.
+//
+// skip=true is returned for `pickle:"-"` and for blank fields, which are
+// used to mark the struct with its class. The only supported option is
+// omitempty; as with encoding/json, `pickle:"-,"` names the field "-".
+func parseStructTag(f reflect.StructField) (name string, omitEmpty, skip bool) {
+	tag := f.Tag.Get("pickle")
+	if tag == "-" || f.Name == "_" {
+		return "", false, true
+	}
+	name, opts, _ := strings.Cut(tag, ",")
+	for opts != "" {
+		var opt string
+		opt, opts, _ = strings.Cut(opts, ",")
+		if opt == "omitempty" {
+			omitEmpty = true
+		}
+	}
+	return name, omitEmpty, false
+}
 
-	t := ptr.Type()
+// getStructClass returns Python class, that struct type t is marked with via
+// blank field tag, e.g.
+//
+//	_ struct{} `pickle:"myapp.models.Point"`
+//
+// The class is given either as "module.Name", or as "module:QualName" for
+// nested classes and modules that are not separated from the name by dot.
+func getStructClass(t reflect.Type) (Class, bool) {
+	l := t.NumField()
+	for i := 0; i < l; i++ {
+		f := t.Field(i)
+		if f.Name != "_" {
+			continue
+		}
+		tag, _, _ := strings.Cut(f.Tag.Get("pickle"), ",")
+		module, name, ok := strings.Cut(tag, ":")
+		if !ok {
+			dot := strings.LastIndexByte(tag, '.')
+			if dot < 0 {
+				continue
+			}
+			module, name = tag[:dot], tag[dot+1:]
+		}
+		if module != "" && name != "" {
+			return Class{Module: module, Name: name}, true
+		}
+	}
+	return Class{}, false
+}
+
+// isEmptyValue returns whether v is empty as defined by omitempty tag option:
+// false, 0, nil pointer or interface, and empty string, array, slice or map.
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
 
+// getStructTupleFields returns indices of struct fields in the order of their
+// positional tags, e.g. `pickle:"0"`, `pickle:"1"`, ...
+//
+// Structs with positional tags are encoded as Python tuples. If st has no
+// positional tags, nil is returned. It is an error if positional tags are
+// mixed with named tags, or if positions do not form 0, 1, ..., n-1 sequence.
+func getStructTupleFields(st reflect.Value) ([]int, error) {
+	t := st.Type()
+
+	pos := make(map[int]int) // position -> field index
+	named := false
 	l := t.NumField()
-	numTags := 0
 	for i := 0; i < l; i++ {
-		field := t.Field(i).Tag.Get("pickle")
-		if field != "" {
-			m[field] = i
-			numTags++
+		tag, _, skip := parseStructTag(t.Field(i))
+		if skip || tag == "" {
+			continue
+		}
+		n, err := strconv.Atoi(tag)
+		if err != nil || n < 0 {
+			named = true
+			continue
 		}
+		if _, dup := pos[n]; dup {
+			return nil, fmt.Errorf("pickle: struct %s: duplicate tuple position %d", t, n)
+		}
+		pos[n] = i
 	}
 
-	if numTags == 0 {
-		return nil
+	if len(pos) == 0 {
+		return nil, nil
+	}
+	if named {
+		return nil, fmt.Errorf("pickle: struct %s: mixed tuple positions and named fields", t)
 	}
 
-	return m
+	fieldv := make([]int, len(pos))
+	for n := range fieldv {
+		i, ok := pos[n]
+		if !ok {
+			return nil, fmt.Errorf("pickle: struct %s: tuple position %d is missing", t, n)
+		}
+		fieldv[n] = i
+	}
+	return fieldv, nil
 }
@@ -0,0 +1,122 @@
+package ogórek
+// Support for Python exceptions.
+//
+// Exceptions are pickled as calls to exception class with exception args,
+// followed by BUILD with exception __dict__, if the exception has any
+// attributes. Tracebacks are not pickled.
+
+import (
+	"fmt"
+)
+
+// Exception represents instance of Python exception.
+type Exception struct {
+	Class Class
+	Args  Tuple
+	State any // exception attributes, e.g. __notes__; nil if there are none
+}
+
+// Error returns exception formatted as Python does in tracebacks, e.g.
+// "builtins.ValueError: bad value".
+func (e Exception) Error() string {
+	name := fmt.Sprintf("%s.%s", e.Class.Module, e.Class.Name)
+	switch len(e.Args) {
+	case 0:
+		return name
+	case 1:
+		return fmt.Sprintf("%s: %s", name, Sprint("%v", e.Args[0], nil))
+	default:
+		return fmt.Sprintf("%s: %s", name, Sprint("%v", e.Args, nil))
+	}
+}
+
+// RegisterException registers Python class as exception class.
+//
+// Instances of the class are decoded into [Exception]. Builtin exception
+// classes, e.g. ValueError, do not need to be registered, and are decoded
+// into Exception with [DecoderConfig.Exceptions].
+//
+// RegisterException panics if class is already registered.
+func (r *TypeRegistry) RegisterException(class Class) {
+	r.checkClass(class)
+	r.exceptions[class] = true
+}
+
+// isException returns whether class is registered as exception class.
+func (r *TypeRegistry) isException(class Class) bool {
+	return r != nil && r.exceptions[class]
+}
+
+// isBuiltinException returns whether class is builtin exception class, as
+// named by either py2 or py3.
+func isBuiltinException(class Class) bool {
+	switch class.Module {
+	case "builtins", "__builtin__", "exceptions":
+		return pyExceptions[class.Name]
+	}
+	return false
+}
+
+// pyExceptions is the set of builtin exception classes of py2 and py3.
+var pyExceptions = map[string]bool{
+	"ArithmeticError": true, "AssertionError": true, "AttributeError": true,
+	"BaseException": true, "BaseExceptionGroup": true, "BlockingIOError": true,
+	"BrokenPipeError": true, "BufferError": true, "BytesWarning": true,
+	"ChildProcessError": true, "ConnectionAbortedError": true, "ConnectionError": true,
+	"ConnectionRefusedError": true, "ConnectionResetError": true, "DeprecationWarning": true,
+	"EOFError": true, "EncodingWarning": true, "EnvironmentError": true,
+	"Exception": true, "ExceptionGroup": true, "FileExistsError": true,
+	"FileNotFoundError": true, "FloatingPointError": true, "FutureWarning": true,
+	"GeneratorExit": true, "IOError": true, "ImportError": true,
+	"ImportWarning": true, "IndentationError": true, "IndexError": true,
+	"InterruptedError": true, "IsADirectoryError": true, "KeyError": true,
+	"KeyboardInterrupt": true, "LookupError": true, "MemoryError": true,
+	"ModuleNotFoundError": true, "NameError": true, "NotADirectoryError": true,
+	"NotImplementedError": true, "OSError": true, "OverflowError": true,
+	"PendingDeprecationWarning": true, "PermissionError": true, "ProcessLookupError": true,
+	"RecursionError": true, "ReferenceError": true, "ResourceWarning": true,
+	"RuntimeError": true, "RuntimeWarning": true, "StopAsyncIteration": true,
+	"StopIteration": true, "SyntaxError": true, "SyntaxWarning": true,
+	"SystemError": true, "SystemExit": true, "TabError": true,
+	"TimeoutError": true, "TypeError": true, "UnboundLocalError": true,
+	"UnicodeDecodeError": true, "UnicodeEncodeError": true, "UnicodeError": true,
+	"UnicodeTranslateError": true, "UnicodeWarning": true, "UserWarning": true,
+	"ValueError": true, "Warning": true, "ZeroDivisionError": true,
+
+	// py2 only
+	"StandardError": true, "WindowsError": true,
+}
+
+// buildException serves BUILD for exceptions.
+//
+// The state is the exception __dict__.
+func (d *Decoder) buildException(e Exception, state any) error {
+	if e.State != nil {
+		return fmt.Errorf("pickle: build: %s.%s exception already has state", e.Class.Module, e.Class.Name)
+	}
+	if _, none := state.(None); !none {
+		e.State = state
+	}
+	d.stack[len(d.stack)-1] = e
+	d.updateCells(len(d.stack)-1, e)
+	return nil
+}
+
+// encodeException encodes Exception as call to its class followed by BUILD
+// with the state, as Python does.
+func (e *Encoder) encodeException(x *Exception) error {
+	args := x.Args
+	if args == nil {
+		args = Tuple{}
+	}
+	err := e.encodeCallAt(&Call{Callable: x.Class, Args: args}, pathArgs)
+	if err != nil || x.State == nil {
+		return err
+	}
+
+	err = e.encode(reflectValueOf(x.State))
+	if err != nil {
+		return e.errorAt(err, ".State")
+	}
+	return e.emit(opBuild)
+}
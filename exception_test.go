@@ -0,0 +1,110 @@
+package ogórek
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExceptionDecode(t *testing.T) {
+	types := NewTypeRegistry()
+	types.RegisterException(Class{"app", "MyErr"})
+	config := &DecoderConfig{Exceptions: true, Types: types}
+
+	valueError := func(module string) Exception {
+		return Exception{Class: Class{module, "ValueError"}, Args: Tuple{"bad", int64(1)}}
+	}
+	for _, tt := range []struct {
+		data string
+		want any
+	}{
+		// ValueError('bad', 1), as pickled by Python
+		{"cexceptions\nValueError\np0\n(Vbad\np1\nI1\ntp2\nRp3\n.", valueError("exceptions")},
+		{"\x80\x02cexceptions\nValueError\nq\x00X\x03\x00\x00\x00badq\x01K\x01\x86q\x02Rq\x03.", valueError("exceptions")},
+		{"\x80\x03cbuiltins\nValueError\nq\x00X\x03\x00\x00\x00badq\x01K\x01\x86q\x02Rq\x03.", valueError("builtins")},
+		{"\x80\x04\x95'\x00\x00\x00\x00\x00\x00\x00\x8c\x08builtins\x94\x8c\nValueError\x94\x93\x94\x8c\x03bad\x94K\x01\x86\x94R\x94.", valueError("builtins")},
+		// KeyError('k') with note
+		{"\x80\x03cbuiltins\nKeyError\nq\x00X\x01\x00\x00\x00kq\x01\x85q\x02Rq\x03}q\x04X\t\x00\x00\x00__notes__q\x05]q\x06X\x04\x00\x00\x00noteq\x07asb.",
+			Exception{Class: Class{"builtins", "KeyError"}, Args: Tuple{"k"}, State: map[any]any{"__notes__": []any{"note"}}}},
+		// app.MyErr('x') with code=5
+		{"\x80\x02capp\nMyErr\nq\x00X\x01\x00\x00\x00xq\x01\x85q\x02Rq\x03}q\x04X\x04\x00\x00\x00codeq\x05K\x05sb.",
+			Exception{Class: Class{"app", "MyErr"}, Args: Tuple{"x"}, State: map[any]any{"code": int64(5)}}},
+		// exception referenced twice via memo
+		{"\x80\x02(cbuiltins\nKeyError\n)Rq\x00}U\x01aK\x01sbh\x00l.",
+			[]any{Exception{Class{"builtins", "KeyError"}, Tuple{}, map[any]any{"a": int64(1)}}, Exception{Class{"builtins", "KeyError"}, Tuple{}, map[any]any{"a": int64(1)}}}},
+		// not an exception
+		{"cbuiltins\nValueErrors\n)R.", Call{Class{"builtins", "ValueErrors"}, Tuple{}}},
+	} {
+		obj, err := NewDecoderWithConfig(strings.NewReader(tt.data), config).Decode()
+		if err != nil {
+			t.Errorf("%q: %s", tt.data, err)
+			continue
+		}
+		if !reflect.DeepEqual(obj, tt.want) {
+			t.Errorf("%q:\nhave: %#v\nwant: %#v", tt.data, obj, tt.want)
+		}
+	}
+
+	_, err := NewDecoderWithConfig(strings.NewReader("cbuiltins\nKeyError\n)R(db(db."), config).Decode()
+	if err == nil {
+		t.Errorf("double build: no error")
+	}
+
+	// without Exceptions builtin exceptions are left as calls
+	obj, err := NewDecoderWithConfig(strings.NewReader("cbuiltins\nKeyError\n(Vk\ntR."), &DecoderConfig{Types: types}).Decode()
+	if want := (Call{Class{"builtins", "KeyError"}, Tuple{"k"}}); !(err == nil && reflect.DeepEqual(obj, want)) {
+		t.Errorf("!Exceptions: have %#v, %v  ; want %#v", obj, err, want)
+	}
+
+	// exceptions can be decoded into error
+	var e error
+	err = NewDecoderWithConfig(strings.NewReader("capp\nMyErr\n(Vx\ntR."), config).DecodeInto(&e)
+	var x Exception
+	if !(err == nil && errors.As(e, &x) && x.Class == Class{"app", "MyErr"}) {
+		t.Errorf("decode into error: %#v, %v", e, err)
+	}
+}
+
+func TestExceptionError(t *testing.T) {
+	for _, tt := range []struct {
+		e    Exception
+		want string
+	}{
+		{Exception{Class: Class{"builtins", "StopIteration"}}, "builtins.StopIteration"},
+		{Exception{Class: Class{"builtins", "ValueError"}, Args: Tuple{"bad value"}}, "builtins.ValueError: bad value"},
+		{Exception{Class: Class{"app", "MyErr"}, Args: Tuple{"x", int64(1)}}, "app.MyErr: [x 1]"},
+	} {
+		if s := tt.e.Error(); s != tt.want {
+			t.Errorf("%#v: have %q  ; want %q", tt.e, s, tt.want)
+		}
+	}
+}
+
+func TestExceptionEncode(t *testing.T) {
+	e := Exception{Class: Class{"builtins", "KeyError"}, Args: Tuple{"k"}, State: map[string]any{"a": int64(1)}}
+	buf := &bytes.Buffer{}
+	err := NewEncoderWithConfig(buf, &EncoderConfig{Protocol: 2}).Encode(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "\x80\x02cbuiltins\nKeyError\nU\x01k\x85R}U\x01aK\x01sb."; buf.String() != want {
+		t.Errorf("encode:\nhave: %q\nwant: %q", buf.String(), want)
+	}
+
+	for _, in := range []any{
+		Exception{Class: Class{"builtins", "KeyError"}, Args: Tuple{"k"}, State: map[any]any{"a": int64(1)}},
+		Exception{Class: Class{"builtins", "StopIteration"}, Args: Tuple{}},
+	} {
+		err = VerifyRoundTrip(in, nil, &DecoderConfig{Exceptions: true})
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	err = NewEncoder(&bytes.Buffer{}).Encode(Exception{Class: Class{"builtins", "KeyError"}, Args: Tuple{make(chan int)}})
+	if err == nil || !strings.Contains(err.Error(), ".Args[0]") {
+		t.Errorf("encode error: %v", err)
+	}
+}
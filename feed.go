@@ -0,0 +1,162 @@
+package ogórek
+// Push-based decoding of pickle streams that arrive in arbitrary chunks.
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// FeedDecoder is push-based decoder for pickle streams.
+//
+// Instead of reading from io.Reader, FeedDecoder is given input data via
+// Feed as it arrives, and returns decoded objects as soon as their pickles
+// are complete. It never blocks waiting for more data, which makes it
+// suitable for event-loop style servers.
+//
+// Similarly to [Decoder], the memo is shared in between consecutive pickles
+// of the stream.
+type FeedDecoder struct {
+	d   *Decoder
+	in  *feedReader // input of d
+	buf []byte      // not yet decoded input
+
+	// buf[:scan] are complete opcodes of current pickle;
+	// nop is their number.
+	scan int
+	nop  int
+}
+
+// feedReader serves complete pickles to Decoder of FeedDecoder.
+type feedReader struct {
+	data []byte
+}
+
+func (r *feedReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+// NewFeedDecoder returns new [FeedDecoder] with the specified configuration.
+//
+// config must not be nil. OnUnknownOpcode and OpcodeHandlers are not
+// supported by FeedDecoder, because boundaries of pickles with unknown or
+// redefined opcodes cannot be determined: FeedDecoder splits the input by
+// standard opcode formats only.
+func NewFeedDecoder(config *DecoderConfig) *FeedDecoder {
+	in := &feedReader{}
+	return &FeedDecoder{d: NewDecoderWithConfig(in, config), in: in}
+}
+
+// Feed appends data to the input stream and returns objects decoded from
+// pickles that became complete.
+//
+// Incomplete trailing pickle is retained and is decoded by subsequent calls
+// to Feed when its remaining data arrives. On error the objects decoded
+// before the error are returned, and the FeedDecoder must not be used anymore.
+func (f *FeedDecoder) Feed(data []byte) ([]any, error) {
+	f.buf = append(f.buf, data...)
+
+	var objv []any
+	for {
+		n, complete, err := opLen(f.buf[f.scan:])
+		if err != nil {
+			if oe, ok := err.(OpcodeError); ok {
+				oe.Pos = f.nop
+				err = oe
+			}
+			return objv, err
+		}
+		if !complete {
+			break
+		}
+		op := f.buf[f.scan]
+		f.scan += n
+		f.nop++
+		if op != opStop {
+			continue
+		}
+
+		// the pickle is complete -> decode it
+		f.in.data = f.buf[:f.scan]
+		obj, err := f.d.Decode()
+		if err != nil {
+			return objv, err
+		}
+		objv = append(objv, obj)
+
+		f.buf = append(f.buf[:0], f.buf[f.scan:]...)
+		f.scan = 0
+		f.nop = 0
+	}
+
+	return objv, nil
+}
+
+// Buffered returns the number of bytes of incomplete pickle retained by the decoder.
+func (f *FeedDecoder) Buffered() int {
+	return len(f.buf)
+}
+
+// opLen returns the length of opcode at the beginning of buf, including its argument.
+//
+// complete=false is returned if buf does not yet contain whole opcode.
+func opLen(buf []byte) (n int, complete bool, err error) {
+	if len(buf) == 0 {
+		return 0, false, nil
+	}
+
+	arg := &opcodes[buf[0]]
+	switch arg.Arg {
+	case ArgNone:
+		return 1, true, nil
+
+	case ArgLine, ArgLine2:
+		nline := 1
+		if arg.Arg == ArgLine2 {
+			nline = 2
+		}
+		for i := 1; i < len(buf); i++ {
+			if buf[i] == '\n' {
+				nline--
+				if nline == 0 {
+					return i+1, true, nil
+				}
+			}
+		}
+		return 0, false, nil
+
+	case ArgFixed:
+		n = 1 + arg.ArgSize
+		return n, len(buf) >= n, nil
+
+	case ArgCounted:
+		if len(buf) < 1+arg.ArgSize {
+			return 0, false, nil
+		}
+		var l uint64
+		switch arg.ArgSize {
+		case 1:
+			l = uint64(buf[1])
+		case 4:
+			l32 := binary.LittleEndian.Uint32(buf[1:])
+			if arg.SignedLen() && int32(l32) < 0 {
+				// invalid negative length - let the decoder report it
+				return 1+arg.ArgSize, true, nil
+			}
+			l = uint64(l32)
+		case 8:
+			l = binary.LittleEndian.Uint64(buf[1:])
+		}
+		if l > uint64(len(buf)) {
+			return 0, false, nil
+		}
+		n = 1 + arg.ArgSize + int(l)
+		return n, len(buf) >= n, nil
+	}
+
+	return 0, false, OpcodeError{Key: buf[0]}
+}
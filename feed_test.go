@@ -0,0 +1,99 @@
+package ogórek
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// TestFeedDecoder verifies that FeedDecoder decodes pickles fed in arbitrary chunks.
+func TestFeedDecoder(t *testing.T) {
+	for _, test := range tests {
+		test.WithEachMode(t, func(t *testing.T, decConfig DecoderConfig, encConfig EncoderConfig) {
+			for _, pickle := range test.picklev {
+				if pickle.err != nil || strings.HasPrefix(pickle.data, protoPrefixTemplate) {
+					continue
+				}
+
+				// two pickles in a row, so that the second one starts in
+				// the middle of a chunk.
+				input := pickle.data + pickle.data
+				for _, chunk := range []int{1, 3, len(input)} {
+					t.Run(fmt.Sprintf("%q/chunk=%d", pickle.data, chunk), func(t *testing.T) {
+						f := NewFeedDecoder(&decConfig)
+						var objv []any
+						for i := 0; i < len(input); i += chunk {
+							end := i + chunk
+							if end > len(input) {
+								end = len(input)
+							}
+							v, err := f.Feed([]byte(input[i:end]))
+							if err != nil {
+								t.Fatal(err)
+							}
+							objv = append(objv, v...)
+
+							// the first object must be returned as soon as its pickle is complete
+							if end >= len(pickle.data) && len(objv) == 0 {
+								t.Fatalf("no object after feeding %d bytes", end)
+							}
+						}
+
+						if len(objv) != 2 {
+							t.Fatalf("decoded %d objects  ; want 2", len(objv))
+						}
+						for _, obj := range objv {
+							if !deepEqual(obj, test.objectOut) {
+								t.Errorf("decode:\nhave: %#v\nwant: %#v", obj, test.objectOut)
+							}
+						}
+						if n := f.Buffered(); n != 0 {
+							t.Errorf("buffered %d bytes after complete pickles", n)
+						}
+					})
+				}
+			}
+		})
+	}
+}
+
+func TestFeedDecoderErrors(t *testing.T) {
+	// incomplete pickle is retained
+	f := NewFeedDecoder(&DecoderConfig{})
+	objv, err := f.Feed([]byte("I1\nI2\n"))
+	if !(len(objv) == 0 && err == nil && f.Buffered() == 6) {
+		t.Errorf("incomplete: have %v, %v, buffered=%d", objv, err, f.Buffered())
+	}
+	objv, err = f.Feed([]byte("0.I3"))
+	if !(len(objv) == 1 && objv[0] == int64(1) && err == nil && f.Buffered() == 2) {
+		t.Errorf("complete: have %v, %v, buffered=%d", objv, err, f.Buffered())
+	}
+
+	// unknown opcode
+	f = NewFeedDecoder(&DecoderConfig{})
+	objv, err = f.Feed([]byte("N.N\xff."))
+	if want := (OpcodeError{Key: 0xff, Pos: 1}); !(len(objv) == 1 && err == want) {
+		t.Errorf("unknown opcode: have %v, %v  ; want [None], %v", objv, err, want)
+	}
+
+	// BINBYTES length ≥ 2GiB is unsigned -> waiting for the data
+	f = NewFeedDecoder(&DecoderConfig{})
+	objv, err = f.Feed([]byte("\x80\x03B\x00\x00\x00\x80abc"))
+	if !(len(objv) == 0 && err == nil && f.Buffered() == 10) {
+		t.Errorf("large BINBYTES: have %v, %v, buffered=%d", objv, err, f.Buffered())
+	}
+
+	// negative BINSTRING length is invalid
+	f = NewFeedDecoder(&DecoderConfig{})
+	objv, err = f.Feed([]byte("T\x00\x00\x00\x80."))
+	if !(len(objv) == 0 && err != nil) {
+		t.Errorf("negative BINSTRING: have %v, %v  ; want error", objv, err)
+	}
+
+	// decoding error
+	f = NewFeedDecoder(&DecoderConfig{})
+	objv, err = f.Feed([]byte("N.0."))
+	if !(len(objv) == 1 && err == errStackUnderflow) {
+		t.Errorf("decoding error: have %v, %v  ; want [None], %v", objv, err, errStackUnderflow)
+	}
+}
@@ -0,0 +1,351 @@
+package ogórek
+// Size-limited formatting of potentially huge unpickled values.
+
+import (
+	"fmt"
+	"sort"
+	"unicode/utf8"
+)
+
+// FormatLimits specifies how much of a value is printed by [Sprint].
+//
+// Zero value of a limit means no limit.
+type FormatLimits struct {
+	// MaxDepth limits nesting of printed containers.
+	// Containers nested deeper are printed as "…".
+	MaxDepth int
+
+	// MaxItems limits how many items are printed for Dict, Set, FrozenSet, map, list and Tuple.
+	// The rest is elided as "…(+N items)".
+	MaxItems int
+
+	// MaxBytes limits how many bytes are printed for string, Bytes and ByteString.
+	// The rest is elided as "…(+N bytes)".
+	MaxBytes int
+}
+
+// DefaultFormatLimits are limits suitable for logging arbitrary unpickled values.
+var DefaultFormatLimits = FormatLimits{
+	MaxDepth: 8,
+	MaxItems: 32,
+	MaxBytes: 256,
+}
+
+// Sprint formats x with format ("%v" or "%#v") according to limits.
+//
+// It is similar to fmt.Sprintf(format, x), but elides data that go beyond
+// the limits. This way it is safe to log decoded values of arbitrary size.
+//
+// If limits is nil, DefaultFormatLimits are used.
+func Sprint(format string, x any, limits *FormatLimits) string {
+	if format != "%v" && format != "%#v" {
+		panic(fmt.Sprintf("Sprint: unsupported format %q", format))
+	}
+	if limits == nil {
+		limits = &DefaultFormatLimits
+	}
+	f := &limitedFormatter{limits: limits, gosyntax: format == "%#v"}
+	return f.sprint(x, 0)
+}
+
+// limitedFormatter serves Sprint.
+type limitedFormatter struct {
+	limits   *FormatLimits
+	gosyntax bool         // whether formatting is %#v
+	path     map[any]bool // pointers that are being formatted
+}
+
+// enter marks pointer p as being formatted and returns function to unmark it.
+func (f *limitedFormatter) enter(p any) func() {
+	if f.path == nil {
+		f.path = make(map[any]bool)
+	}
+	f.path[p] = true
+	return func() {
+		delete(f.path, p)
+	}
+}
+
+func (f *limitedFormatter) verb() string {
+	if f.gosyntax {
+		return "%#v"
+	}
+	return "%v"
+}
+
+func (f *limitedFormatter) sprint(x any, depth int) string {
+	switch x := x.(type) {
+	case string:
+		return f.sprintString(x, x, true)
+	case Bytes:
+		return f.sprintString(x, string(x), false)
+	case ByteString:
+		return f.sprintString(x, string(x), false)
+	case Unicode:
+		return f.sprintString(x, string(x), true)
+	}
+
+	// lists and objects decoded with PreserveRefs; they might be recursive
+	switch x := x.(type) {
+	case *[]any:
+		if f.path[x] {
+			return "&[…]"
+		}
+		defer f.enter(x)()
+		return "&" + f.sprint(*x, depth)
+	case *Object:
+		if f.path[x] {
+			return "&{…}"
+		}
+		defer f.enter(x)()
+		return "&" + f.sprint(*x, depth)
+	}
+
+	// containers
+	tooDeep := f.limits.MaxDepth > 0 && depth >= f.limits.MaxDepth
+	switch x := x.(type) {
+	case Dict:
+		if tooDeep {
+			return f.elided(x, "{…}")
+		}
+		vkv := make([]formattedKV, 0, x.Len())
+		x.Iter()(func(k, v any) bool {
+			vkv = append(vkv, formattedKV{f.sprint(k, depth+1), v})
+			return true
+		})
+		return f.typed(x, f.sprintKV(vkv, depth, "{", ", ", ": ", "}"))
+
+	case Set:
+		if tooDeep {
+			return f.elided(x, "{…}")
+		}
+		vx := make([]string, 0, x.Len())
+		x.Iter()(func(item any) bool {
+			vx = append(vx, f.sprint(item, depth+1))
+			return true
+		})
+		return f.typed(x, f.sprintSet(vx))
+
+	case FrozenSet:
+		if tooDeep {
+			return f.elided(x, "{…}")
+		}
+		vx := make([]string, 0, x.Len())
+		x.Iter()(func(item any) bool {
+			vx = append(vx, f.sprint(item, depth+1))
+			return true
+		})
+		return f.typed(x, f.sprintSet(vx))
+
+	case []KV:
+		if tooDeep {
+			return f.elided(x, "{…}")
+		}
+		vkv := make([]formattedKV, 0, len(x))
+		for _, kv := range x {
+			vkv = append(vkv, formattedKV{f.sprint(kv.Key, depth+1), kv.Value})
+		}
+		return f.typed(x, f.sprintKV(vkv, depth, "{", ", ", ": ", "}"))
+
+	case map[any]any:
+		if tooDeep {
+			return f.elided(x, "map[…]")
+		}
+		vkv := make([]formattedKV, 0, len(x))
+		for k, v := range x {
+			vkv = append(vkv, formattedKV{f.sprint(k, depth+1), v})
+		}
+		if f.gosyntax {
+			return f.typed(x, f.sprintKV(vkv, depth, "{", ", ", ":", "}"))
+		}
+		return f.sprintKV(vkv, depth, "map[", " ", ":", "]")
+
+	case map[string]any:
+		if tooDeep {
+			return f.elided(x, "map[…]")
+		}
+		vkv := make([]formattedKV, 0, len(x))
+		for k, v := range x {
+			vkv = append(vkv, formattedKV{f.sprint(k, depth+1), v})
+		}
+		if f.gosyntax {
+			return f.typed(x, f.sprintKV(vkv, depth, "{", ", ", ":", "}"))
+		}
+		return f.sprintKV(vkv, depth, "map[", " ", ":", "]")
+
+	case []any:
+		if tooDeep {
+			return f.elided(x, "[…]")
+		}
+		return f.typed(x, f.sprintList(x, depth))
+
+	case Tuple:
+		if tooDeep {
+			return f.elided(x, "[…]")
+		}
+		return f.typed(x, f.sprintList(x, depth))
+
+	case NamedTuple:
+		if tooDeep {
+			return f.elided(x, "{…}")
+		}
+		if f.gosyntax {
+			return fmt.Sprintf("%T{Class:%#v, Fields:%#v, Values:%s}", x, x.Class, x.Fields, f.sprint(x.Values, depth+1))
+		}
+		return fmt.Sprintf("{%v %v %s}", x.Class, x.Fields, f.sprint(x.Values, depth+1))
+
+	case Call:
+		if tooDeep {
+			return f.elided(x, "{…}")
+		}
+		if f.gosyntax {
+			return fmt.Sprintf("%T{Callable:%#v, Args:%s}", x, x.Callable, f.sprint(x.Args, depth+1))
+		}
+		return fmt.Sprintf("{%v %s}", x.Callable, f.sprint(x.Args, depth+1))
+
+	case Object:
+		if tooDeep {
+			return f.elided(x, "{…}")
+		}
+		// keyword arguments, state and call flag are shown only if present
+		if f.gosyntax {
+			s := fmt.Sprintf("%T{Class:%#v, Args:%s", x, x.Class, f.sprint(x.Args, depth+1))
+			if x.KwArgs != nil {
+				s += ", KwArgs:" + f.sprint(x.KwArgs, depth+1)
+			}
+			if x.State != nil {
+				s += ", State:" + f.sprint(x.State, depth+1)
+			}
+			if x.Called {
+				s += ", Called:true"
+			}
+			return s + "}"
+		}
+		s := fmt.Sprintf("{%v %s", x.Class, f.sprint(x.Args, depth+1))
+		if x.KwArgs != nil {
+			s += " " + f.sprint(x.KwArgs, depth+1)
+		}
+		if x.State != nil {
+			s += " " + f.sprint(x.State, depth+1)
+		}
+		if x.Called {
+			s += " called"
+		}
+		return s + "}"
+	}
+
+	return fmt.Sprintf(f.verb(), x)
+}
+
+// typed prefixes s with type of x in %#v mode.
+func (f *limitedFormatter) typed(x any, s string) string {
+	if f.gosyntax {
+		return fmt.Sprintf("%T", x) + s
+	}
+	return s
+}
+
+// elided returns representation of container x that is nested too deep.
+func (f *limitedFormatter) elided(x any, s string) string {
+	if f.gosyntax {
+		return f.typed(x, "{…}")
+	}
+	return s
+}
+
+// sprintString formats string-like x with data s, truncating s if it is too long.
+func (f *limitedFormatter) sprintString(x any, s string, text bool) string {
+	max := f.limits.MaxBytes
+	if max <= 0 || len(s) <= max {
+		return fmt.Sprintf(f.verb(), x)
+	}
+
+	// don't cut text in the middle of a character
+	n := max
+	for text && n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+
+	var head string
+	switch x.(type) {
+	case Bytes:
+		head = fmt.Sprintf(f.verb(), Bytes(s[:n]))
+	case ByteString:
+		head = fmt.Sprintf(f.verb(), ByteString(s[:n]))
+	case Unicode:
+		head = fmt.Sprintf(f.verb(), Unicode(s[:n]))
+	default:
+		head = fmt.Sprintf(f.verb(), s[:n])
+	}
+	return fmt.Sprintf("%s…(+%d bytes)", head, len(s)-n)
+}
+
+// sprintList formats list items with elision.
+func (f *limitedFormatter) sprintList(l []any, depth int) string {
+	open, sep, close := "[", " ", "]"
+	if f.gosyntax {
+		open, sep, close = "{", ", ", "}"
+	}
+
+	s := open
+	for i, x := range l {
+		if i > 0 {
+			s += sep
+		}
+		if max := f.limits.MaxItems; max > 0 && i >= max {
+			s += fmt.Sprintf("…(+%d items)", len(l)-i)
+			break
+		}
+		s += f.sprint(x, depth+1)
+	}
+	return s + close
+}
+
+// formattedKV is key/value pair with already formatted key.
+type formattedKV struct {
+	k string
+	v any
+}
+
+// sprintKV formats key/value pairs with elision.
+//
+// The pairs are sorted by key to get stable output. Values are formatted
+// only for printed pairs.
+func (f *limitedFormatter) sprintKV(vkv []formattedKV, depth int, open, sep, colon, close string) string {
+	sort.Slice(vkv, func(i, j int) bool {
+		return vkv[i].k < vkv[j].k
+	})
+
+	s := open
+	for i, kv := range vkv {
+		if i > 0 {
+			s += sep
+		}
+		if max := f.limits.MaxItems; max > 0 && i >= max {
+			s += fmt.Sprintf("…(+%d items)", len(vkv)-i)
+			break
+		}
+		s += kv.k + colon + f.sprint(kv.v, depth+1)
+	}
+	return s + close
+}
+
+// sprintSet formats already formatted set elements with elision.
+//
+// The elements are sorted to get stable output.
+func (f *limitedFormatter) sprintSet(vx []string) string {
+	sort.Strings(vx)
+
+	s := "{"
+	for i, x := range vx {
+		if i > 0 {
+			s += ", "
+		}
+		if max := f.limits.MaxItems; max > 0 && i >= max {
+			s += fmt.Sprintf("…(+%d items)", len(vx)-i)
+			break
+		}
+		s += x
+	}
+	return s + "}"
+}
@@ -0,0 +1,82 @@
+package ogórek
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSprint(t *testing.T) {
+	lim := &FormatLimits{MaxDepth: 2, MaxItems: 3, MaxBytes: 4}
+
+	testv := []struct {
+		in     any
+		limits *FormatLimits
+		outV   string // Sprint("%v")
+		outGo  string // Sprint("%#v")
+	}{
+		// no limits -> same as fmt
+		{"hello", &FormatLimits{}, `hello`, `"hello"`},
+		{Bytes("hello"), &FormatLimits{}, `hello`, `ogórek.Bytes("hello")`},
+		{[]any{int64(1), "a"}, &FormatLimits{}, `[1 a]`, `[]interface {}{1, "a"}`},
+		{NewDictWithData(int64(1), "a"), &FormatLimits{}, `{1: a}`, `ogórek.Dict{1: "a"}`},
+
+		// strings
+		{"hello", lim, `hell…(+1 bytes)`, `"hell"…(+1 bytes)`},
+		{"мир", lim, `ми…(+2 bytes)`, `"ми"…(+2 bytes)`},
+		{"aмир", lim, `aм…(+4 bytes)`, `"aм"…(+4 bytes)`},
+		{Bytes("aмир"), lim, "aм\xd0…(+3 bytes)", `ogórek.Bytes("aм\xd0")…(+3 bytes)`},
+		{ByteString("hello"), lim, `hell…(+1 bytes)`, `ogórek.ByteString("hell")…(+1 bytes)`},
+		{"abcd", lim, `abcd`, `"abcd"`},
+
+		// items
+		{[]any{int64(1), int64(2), int64(3), int64(4), int64(5)}, lim,
+			`[1 2 3 …(+2 items)]`, `[]interface {}{1, 2, 3, …(+2 items)}`},
+		{Tuple{int64(1), int64(2), int64(3)}, lim,
+			`[1 2 3]`, `ogórek.Tuple{1, 2, 3}`},
+		{NewDictWithData("a",int64(1), "b",int64(2), "c",int64(3), "d",int64(4)), lim,
+			`{a: 1, b: 2, c: 3, …(+1 items)}`, `ogórek.Dict{"a": 1, "b": 2, "c": 3, …(+1 items)}`},
+		{map[any]any{"a":int64(1), "b":int64(2), "c":int64(3), "d":int64(4)}, lim,
+			`map[a:1 b:2 c:3 …(+1 items)]`, `map[interface {}]interface {}{"a":1, "b":2, "c":3, …(+1 items)}`},
+		{map[string]any{"a":int64(1), "b":int64(2), "c":int64(3), "d":int64(4)}, lim,
+			`map[a:1 b:2 c:3 …(+1 items)]`, `map[string]interface {}{"a":1, "b":2, "c":3, …(+1 items)}`},
+
+		// depth
+		{[]any{[]any{[]any{int64(1)}}}, lim, `[[[…]]]`, `[]interface {}{[]interface {}{[]interface {}{…}}}`},
+		{Call{Class{"mod","f"}, Tuple{Tuple{int64(1)}}}, lim,
+			`{{mod f} [[…]]}`, `ogórek.Call{Callable:ogórek.Class{Module:"mod", Name:"f"}, Args:ogórek.Tuple{ogórek.Tuple{…}}}`},
+		{Object{Class: Class{"mod","C"}, Args: Tuple{Tuple{int64(1)}}}, lim,
+			`{{mod C} [[…]]}`, `ogórek.Object{Class:ogórek.Class{Module:"mod", Name:"C"}, Args:ogórek.Tuple{ogórek.Tuple{…}}}`},
+		{Object{Class: Class{"mod","C"}, Args: Tuple{}, KwArgs: map[string]any{"a": Tuple{int64(1)}}}, lim,
+			`{{mod C} [] map[a:[…]]}`, `ogórek.Object{Class:ogórek.Class{Module:"mod", Name:"C"}, Args:ogórek.Tuple{}, KwArgs:map[string]interface {}{"a":ogórek.Tuple{…}}}`},
+		{Object{Class: Class{"mod","C"}, Args: Tuple{}, State: Tuple{Tuple{}}, Called: true}, lim,
+			`{{mod C} [] [[…]] called}`, `ogórek.Object{Class:ogórek.Class{Module:"mod", Name:"C"}, Args:ogórek.Tuple{}, State:ogórek.Tuple{ogórek.Tuple{…}}, Called:true}`},
+		{&[]any{&[]any{&[]any{int64(1)}}}, lim, `&[&[&[…]]]`, `&[]interface {}{&[]interface {}{&[]interface {}{…}}}`},
+		{&Object{Class: Class{"mod","C"}, Args: Tuple{}}, lim,
+			`&{{mod C} []}`, `&ogórek.Object{Class:ogórek.Class{Module:"mod", Name:"C"}, Args:ogórek.Tuple{}}`},
+		{NewDictWithData("a", NewDictWithData("b", NewDictWithData("c", int64(1)))), lim,
+			`{a: {b: {…}}}`, `ogórek.Dict{"a": ogórek.Dict{"b": ogórek.Dict{…}}}`},
+	}
+
+	for _, tt := range testv {
+		for _, format := range []string{"%v", "%#v"} {
+			want := tt.outV
+			if format == "%#v" {
+				want = tt.outGo
+			}
+			out := Sprint(format, tt.in, tt.limits)
+			if out != want {
+				t.Errorf("Sprint(%q, %#v):\nhave: %s\nwant: %s", format, tt.in, out, want)
+			}
+		}
+	}
+
+	// default limits bound the output
+	l := make([]any, 1000)
+	for i := range l {
+		l[i] = strings.Repeat("x", 1000)
+	}
+	out := Sprint("%v", l, nil)
+	if len(out) > 10000 || !strings.HasSuffix(out, "…(+968 items)]") {
+		t.Errorf("Sprint(huge): not limited: len=%d  tail=%q", len(out), out[len(out)-20:])
+	}
+}
@@ -0,0 +1,220 @@
+package ogórek
+// Support for joblib (https://joblib.readthedocs.io) dumps.
+//
+// joblib.dump pickles numpy arrays as NumpyArrayWrapper objects, whose state
+// is (subclass, shape, order, dtype, allow_mmap[, numpy_array_alignment_bytes])
+// dict, and writes data of the array into the stream right after BUILD of the
+// wrapper, outside of pickle opcodes. The data is preceded by padding, that
+// aligns it to numpy_array_alignment_bytes, if that is set. Arrays of object
+// dtype are written there as separate pickle of the array at protocol 2.
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+// NewJoblibDecoder returns a new [Decoder], that decodes joblib dump from r,
+// as written by joblib.dump without compression.
+//
+// Arrays of the dump are decoded into [NDArray] with their data read from the
+// stream. NumPy decoding mode is implied, while the rest of config applies as
+// usual. config must not be nil.
+//
+// Only the array layout, that joblib uses since version 0.10, is supported.
+func NewJoblibDecoder(r io.Reader, config *DecoderConfig) *Decoder {
+	c := *config
+	c.NumPy = true
+	d := NewDecoderWithConfig(r, &c)
+	d.joblib = true
+	return d
+}
+
+// isJoblibArrayWrapper returns whether x is NumpyArrayWrapper without state,
+// i.e. wrapper, whose BUILD is being handled.
+//
+// Wrappers of joblib copy, vendored by old scikit-learn, are recognized too.
+func isJoblibArrayWrapper(x any) bool {
+	var obj Object
+	switch x := x.(type) {
+	case Object:
+		obj = x
+	case *Object:
+		obj = *x
+	default:
+		return false
+	}
+	switch obj.Class.Module {
+	case "joblib.numpy_pickle", "sklearn.externals.joblib.numpy_pickle":
+		return obj.Class.Name == "NumpyArrayWrapper" && obj.State == nil
+	}
+	return false
+}
+
+// buildJoblibArray serves BUILD for joblib array wrappers.
+//
+// It reads the array data, that follows the BUILD opcode, and replaces the
+// wrapper with the array.
+func (d *Decoder) buildJoblibArray(state any) error {
+	a, alignment, err := joblibArrayState(state)
+	if err != nil {
+		return fmt.Errorf("pickle: build: joblib: NumpyArrayWrapper: %s", err)
+	}
+
+	// the data is written outside of frames
+	if d.config.StrictFrames && d.frameEnd >= 0 {
+		if d.pos() != d.frameEnd {
+			return fmt.Errorf("pickle: frame: joblib array data starts %d bytes before frame end", d.frameEnd - d.pos())
+		}
+		d.frameEnd = -1
+	}
+
+	if a.Dtype.Kind == 'O' {
+		a, err = d.loadJoblibObjectArray(a)
+	} else {
+		a.Data, err = d.loadJoblibArrayData(a, alignment)
+	}
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return fmt.Errorf("pickle: joblib: array data: %w", err)
+	}
+
+	d.stack[len(d.stack)-1] = a
+	d.updateCells(len(d.stack)-1, a)
+	return nil
+}
+
+// joblibArrayState decodes state of NumpyArrayWrapper into array without
+// data and alignment of the data; alignment is 0 if the data is not aligned.
+func joblibArrayState(state any) (a NDArray, alignment int, err error) {
+	items, err := ValueOf(state).Items()
+	if err != nil {
+		return a, 0, err
+	}
+	seen := map[string]bool{}
+	for _, item := range items {
+		key, err := AsString(item.Key.Interface())
+		if err != nil {
+			return a, 0, fmt.Errorf("state key: %s", err)
+		}
+		seen[key] = true
+		v := item.Value.Interface()
+		switch key {
+		case "subclass":
+			// arrays of ndarray subclasses, e.g. numpy.matrix, are decoded as plain arrays
+			if _, ok := v.(Class); !ok {
+				return a, 0, fmt.Errorf("subclass: expect class; got %T", v)
+			}
+		case "shape":
+			a.Shape, err = numpyShape(v)
+			if err != nil {
+				return a, 0, fmt.Errorf("shape: %s", err)
+			}
+		case "order":
+			order, err := AsString(v)
+			if !(err == nil && (order == "C" || order == "F")) {
+				return a, 0, fmt.Errorf("invalid order %s", Sprint("%#v", v, nil))
+			}
+			a.FortranOrder = order == "F"
+		case "dtype":
+			var ok bool
+			a.Dtype, ok = v.(Dtype)
+			if !ok {
+				return a, 0, fmt.Errorf("expect dtype; got %T", v)
+			}
+		case "allow_mmap":
+			// only affects how joblib loads the array
+			if _, ok := v.(bool); !ok {
+				return a, 0, fmt.Errorf("allow_mmap: expect bool; got %T", v)
+			}
+		case "numpy_array_alignment_bytes":
+			if _, none := v.(None); none {
+				break
+			}
+			alignment, err = numpyInt(v)
+			if !(err == nil && 0 < alignment && alignment < 256) {
+				return a, 0, fmt.Errorf("invalid alignment %s", Sprint("%#v", v, nil))
+			}
+		}
+	}
+	for _, key := range []string{"shape", "order", "dtype"} {
+		if !seen[key] {
+			return a, 0, fmt.Errorf("no %s in state", key)
+		}
+	}
+	return a, alignment, nil
+}
+
+// loadJoblibArrayData reads raw data of array a, that is written with given
+// alignment.
+func (d *Decoder) loadJoblibArrayData(a NDArray, alignment int) ([]byte, error) {
+	// padding: length byte followed by length bytes
+	if alignment != 0 {
+		n, err := d.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		_, err = io.CopyN(io.Discard, d.r, int64(n))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	size, err := a.dataSize()
+	if err != nil {
+		return nil, err
+	}
+	// don't allow malicious shape without data to make us out of memory
+	prealloc := size
+	const maxgrow = 0x10000
+	if prealloc > maxgrow {
+		prealloc = maxgrow
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, prealloc))
+	_, err = io.CopyN(buf, d.r, int64(size))
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// loadJoblibObjectArray decodes separate pickle of object array described by
+// wrapper array a.
+//
+// Objects of the nested pickle are accounted in MaxExpandedSize of the dump.
+func (d *Decoder) loadJoblibObjectArray(a NDArray) (NDArray, error) {
+	config := d.config
+	max := config.MaxExpandedSize
+	if max > 0 {
+		// the nested pickle has at least one object
+		c := *config
+		c.MaxExpandedSize = max - (d.stats.Objects + d.expanded)
+		if c.MaxExpandedSize < 1 {
+			return a, errExpandedSize(max)
+		}
+		config = &c
+	}
+	nested := NewDecoderWithConfig(nil, config)
+	nested.r, nested.rc, nested.input = d.r, d.rc, d.input
+	v, err := nested.Decode()
+	d.r = nested.r // the reader is replaced when large frames are prefetched
+	d.warnings = append(d.warnings, nested.warnings...)
+	d.expanded += nested.stats.Objects + nested.expanded
+	if err != nil {
+		if max > 0 && d.stats.Objects + d.expanded > max {
+			err = errExpandedSize(max) // report the limit of the dump
+		}
+		return a, err
+	}
+	array, ok := v.(NDArray)
+	if !(ok && array.Dtype.Kind == 'O') {
+		return a, fmt.Errorf("expect array of objects; got %T", v)
+	}
+	if !(reflect.DeepEqual(array.Shape, a.Shape) && array.FortranOrder == a.FortranOrder) {
+		return a, fmt.Errorf("array does not match its wrapper")
+	}
+	return array, nil
+}
@@ -0,0 +1,89 @@
+package ogórek
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// joblib dumps of {'a': numpy.arange(6).reshape(2, 3), 'b': [f, f],
+// 'o': numpy.array([1, 'x'], dtype=object), 'n': 5}, where f is
+// numpy.array([1.5, 2], dtype='>f4', order='F'), in the format of joblib.dump.
+var joblibDumps = []struct {
+	proto int
+	data  string
+}{
+	{2, "\x80\x02}q\x00(X\x01\x00\x00\x00aq\x01cjoblib.numpy_pickle\nNumpyArrayWrapper\nq\x02)\x81q\x03}q\x04(X\x08\x00\x00\x00subclassq\x05cnumpy\nndarray\nq\x06X\x05\x00\x00\x00shapeq\x07K\x02K\x03\x86q\x08X\x05\x00\x00\x00orderq\tX\x01\x00\x00\x00Cq\nX\x05\x00\x00\x00dtypeq\x0bcnumpy\ndtype\nq\x0cX\x02\x00\x00\x00i8q\r\x89\x88\x87q\x0eRq\x0f(K\x03X\x01\x00\x00\x00<q\x10NNNJ\xff\xff\xff\xffJ\xff\xff\xff\xffK\x00tq\x11bX\n\x00\x00\x00allow_mmapq\x12\x88X\x1b\x00\x00\x00numpy_array_alignment_bytesq\x13K\x10ub\x07\xff\xff\xff\xff\xff\xff\xff\x00\x01\x02\x03\x04\x05\x06\x07\x08\t\n\x0b\x0c\r\x0e\x0f\x10\x11\x12\x13\x14\x15\x16\x17\x18\x19\x1a\x1b\x1c\x1d\x1e\x1f !\"#$%&'()*+,-./X\x01\x00\x00\x00bq\x14]q\x15(h\x02)\x81q\x16}q\x17(h\x05h\x06h\x07K\x02\x85q\x18h\tX\x01\x00\x00\x00Fq\x19h\x0bh\x0cX\x02\x00\x00\x00f4q\x1a\x89\x88\x87q\x1bRq\x1c(K\x03X\x01\x00\x00\x00>q\x1dNNNJ\xff\xff\xff\xffJ\xff\xff\xff\xffK\x00tq\x1ebh\x12\x88h\x13K\x10ub\x08\xff\xff\xff\xff\xff\xff\xff\xff?\xc0\x00\x00@\x00\x00\x00h\x02)\x81q\x1f}q (h\x05h\x06h\x07h\x18h\th\x19h\x0bh\x1ch\x12\x88h\x13K\x10ub\x04\xff\xff\xff\xff?\xc0\x00\x00@\x00\x00\x00eX\x01\x00\x00\x00oq!h\x02)\x81q\"}q#(h\x05h\x06h\x07h\x18h\th\nh\x0bh\x0cX\x02\x00\x00\x00O8q$\x89\x88\x87q%Rq&(K\x03X\x01\x00\x00\x00|q'NNNJ\xff\xff\xff\xffJ\xff\xff\xff\xffK?tq(bh\x12\x89h\x13K\x10ub\x80\x02cnumpy.core.multiarray\n_reconstruct\nq\x00cnumpy\nndarray\nq\x01K\x00\x85q\x02c_codecs\nencode\nq\x03X\x01\x00\x00\x00bq\x04X\x06\x00\x00\x00latin1q\x05\x86q\x06Rq\x07\x87q\x08Rq\t(K\x01K\x02\x85q\ncnumpy\ndtype\nq\x0bX\x02\x00\x00\x00O8q\x0c\x89\x88\x87q\rRq\x0e(K\x03X\x01\x00\x00\x00|q\x0fNNNJ\xff\xff\xff\xffJ\xff\xff\xff\xffK?tq\x10b\x89]q\x11(K\x01X\x01\x00\x00\x00xq\x12etq\x13b.X\x01\x00\x00\x00nq)K\x05u."},
+	{4, "\x80\x04\x95\xd4\x00\x00\x00\x00\x00\x00\x00}\x94(\x8c\x01a\x94\x8c\x13joblib.numpy_pickle\x94\x8c\x11NumpyArrayWrapper\x94\x93\x94)\x81\x94}\x94(\x8c\x08subclass\x94\x8c\x05numpy\x94\x8c\x07ndarray\x94\x93\x94\x8c\x05shape\x94K\x02K\x03\x86\x94\x8c\x05order\x94\x8c\x01C\x94\x8c\x05dtype\x94h\x08h\x0f\x93\x94\x8c\x02i8\x94\x89\x88\x87\x94R\x94(K\x03\x8c\x01<\x94NNNJ\xff\xff\xff\xffJ\xff\xff\xff\xffK\x00t\x94b\x8c\nallow_mmap\x94\x88\x8c\x1bnumpy_array_alignment_bytes\x94K\x10ub\x10\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\x00\x01\x02\x03\x04\x05\x06\x07\x08\t\n\x0b\x0c\r\x0e\x0f\x10\x11\x12\x13\x14\x15\x16\x17\x18\x19\x1a\x1b\x1c\x1d\x1e\x1f !\"#$%&'()*+,-./\x95P\x00\x00\x00\x00\x00\x00\x00\x8c\x01b\x94]\x94(h\x04)\x81\x94}\x94(h\x07h\nh\x0bK\x02\x85\x94h\r\x8c\x01F\x94h\x0fh\x10\x8c\x02f4\x94\x89\x88\x87\x94R\x94(K\x03\x8c\x01>\x94NNNJ\xff\xff\xff\xffJ\xff\xff\xff\xffK\x00t\x94bh\x16\x88h\x17K\x10ub\x06\xff\xff\xff\xff\xff\xff?\xc0\x00\x00@\x00\x00\x00\x95!\x00\x00\x00\x00\x00\x00\x00h\x04)\x81\x94}\x94(h\x07h\nh\x0bh\x1ch\rh\x1dh\x0fh h\x16\x88h\x17K\x10ub\r\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff?\xc0\x00\x00@\x00\x00\x00\x95J\x00\x00\x00\x00\x00\x00\x00e\x8c\x01o\x94h\x04)\x81\x94}\x94(h\x07h\nh\x0bh\x1ch\rh\x0eh\x0fh\x10\x8c\x02O8\x94\x89\x88\x87\x94R\x94(K\x03\x8c\x01|\x94NNNJ\xff\xff\xff\xffJ\xff\xff\xff\xffK?t\x94bh\x16\x89h\x17K\x10ub\x80\x02cnumpy.core.multiarray\n_reconstruct\nq\x00cnumpy\nndarray\nq\x01K\x00\x85q\x02c_codecs\nencode\nq\x03X\x01\x00\x00\x00bq\x04X\x06\x00\x00\x00latin1q\x05\x86q\x06Rq\x07\x87q\x08Rq\t(K\x01K\x02\x85q\ncnumpy\ndtype\nq\x0bX\x02\x00\x00\x00O8q\x0c\x89\x88\x87q\rRq\x0e(K\x03X\x01\x00\x00\x00|q\x0fNNNJ\xff\xff\xff\xffJ\xff\xff\xff\xffK?tq\x10b\x89]q\x11(K\x01X\x01\x00\x00\x00xq\x12etq\x13b.\x95\x08\x00\x00\x00\x00\x00\x00\x00\x8c\x01n\x94K\x05u."},
+}
+
+func TestJoblibDecode(t *testing.T) {
+	f4 := NDArray{Dtype: Dtype{Kind: 'f', ItemSize: 4, ByteOrder: '>'}, Shape: []int{2}, FortranOrder: true, Data: []byte("?\xc0\x00\x00@\x00\x00\x00")}
+	want := map[any]any{
+		"a": NDArray{Dtype: Dtype{Kind: 'i', ItemSize: 8, ByteOrder: '<'}, Shape: []int{2, 3}, Data: []byte("\x00\x01\x02\x03\x04\x05\x06\x07\x08\t\n\x0b\x0c\r\x0e\x0f\x10\x11\x12\x13\x14\x15\x16\x17\x18\x19\x1a\x1b\x1c\x1d\x1e\x1f !\"#$%&'()*+,-./")},
+		"b": []any{f4, f4},
+		"o": NDArray{Dtype: Dtype{Kind: 'O', ItemSize: 8, ByteOrder: '|'}, Shape: []int{2}, Objects: []any{int64(1), "x"}},
+		"n": int64(5),
+	}
+	configs := []*DecoderConfig{{}, {StrictFrames: true}}
+	for _, tt := range joblibDumps {
+		for _, config := range configs {
+			d := NewJoblibDecoder(strings.NewReader(tt.data), config)
+			obj, err := d.Decode()
+			if err != nil {
+				t.Errorf("protocol %d: %s", tt.proto, err)
+				continue
+			}
+			if !reflect.DeepEqual(obj, want) {
+				t.Errorf("protocol %d:\nhave: %#v\nwant: %#v", tt.proto, obj, want)
+			}
+			if n := d.Stats().Bytes; n != int64(len(tt.data)) {
+				t.Errorf("protocol %d: decoded %d bytes  ; want %d", tt.proto, n, len(tt.data))
+			}
+		}
+	}
+	if configs[0].NumPy {
+		t.Errorf("NewJoblibDecoder modified config")
+	}
+
+	// the data of arrays is required
+	data := joblibDumps[0].data
+	_, err := NewJoblibDecoder(strings.NewReader(data[:strings.Index(data, "./X")]), &DecoderConfig{}).Decode()
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("truncated data: have %v  ; want %v", err, io.ErrUnexpectedEOF)
+	}
+
+	// memo references to the wrapper see the array
+	data = "\x80\x02cjoblib.numpy_pickle\nNumpyArrayWrapper\n)\x81q\x00}(X\x05\x00\x00\x00shapeK\x02\x85X\x05\x00\x00\x00orderX\x01\x00\x00\x00CX\x05\x00\x00\x00dtypecnumpy\ndtype\nX\x02\x00\x00\x00i1\x89\x88\x87R(K\x03X\x01\x00\x00\x00|NNNJ\xff\xff\xff\xffJ\xff\xff\xff\xffK\x00tbub\x01\x02h\x00\x86."
+	i1 := NDArray{Dtype: Dtype{Kind: 'i', ItemSize: 1, ByteOrder: '|'}, Shape: []int{2}, Data: []byte{1, 2}}
+	obj, err := NewJoblibDecoder(strings.NewReader(data), &DecoderConfig{}).Decode()
+	if want := (Tuple{i1, i1}); !(err == nil && reflect.DeepEqual(obj, want)) {
+		t.Errorf("memo:\nhave: %#v, %v\nwant: %#v", obj, err, want)
+	}
+
+	// objects of nested pickles count in MaxExpandedSize of the dump: the
+	// nested pickle with 2¹⁰ shared tuples fits the limit alone, but not
+	// together with the rest of the dump
+	data = strings.Replace(joblibDumps[0].data, "(K\x01X", "(K\x01" + strings.Repeat("q`h`\x86", 10) + "X", 1)
+	_, err = NewJoblibDecoder(strings.NewReader(data), &DecoderConfig{MaxExpandedSize: 2100}).Decode()
+	if want := "pickle: joblib: array data: pickle: expanded size of decoded data exceeds limit 2100"; !(err != nil && err.Error() == want) {
+		t.Errorf("nested expanded size: have %v  ; want %s", err, want)
+	}
+	_, err = NewJoblibDecoder(strings.NewReader(data), &DecoderConfig{MaxExpandedSize: 2300}).Decode()
+	if err != nil {
+		t.Errorf("nested expanded size: %s", err)
+	}
+
+	for _, data := range []string{
+		// no dtype, invalid order
+		"\x80\x02cjoblib.numpy_pickle\nNumpyArrayWrapper\n)\x81}(X\x05\x00\x00\x00shape)X\x05\x00\x00\x00orderX\x01\x00\x00\x00Cub.",
+		"\x80\x02cjoblib.numpy_pickle\nNumpyArrayWrapper\n)\x81}(X\x05\x00\x00\x00shape)X\x05\x00\x00\x00orderX\x01\x00\x00\x00XX\x05\x00\x00\x00dtypeNub.",
+	} {
+		_, err := NewJoblibDecoder(strings.NewReader(data), &DecoderConfig{}).Decode()
+		if err == nil {
+			t.Errorf("%q: no error", data)
+		}
+	}
+}
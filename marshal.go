@@ -0,0 +1,51 @@
+package ogórek
+// Convenience functions to encode and decode in-memory pickles.
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Marshal returns pickle of v encoded with the default configuration.
+//
+// It is a shortcut for encoding v with [Encoder] into a bytes.Buffer.
+func Marshal(v any) ([]byte, error) {
+	return MarshalWithConfig(v, &EncoderConfig{})
+}
+
+// MarshalWithConfig is similar to Marshal, but encodes v with the specified configuration.
+//
+// config must not be nil.
+func MarshalWithConfig(v any, config *EncoderConfig) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	err := NewEncoderWithConfig(buf, config).Encode(v)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Unmarshal decodes pickle data with the default configuration and stores
+// the result into the value pointed to by v.
+//
+// The decoded object is stored the same way as with [Decoder.DecodeInto].
+// In particular v can point to any to receive the object as is. data must
+// contain exactly one pickle; trailing data after it is reported as error.
+func Unmarshal(data []byte, v any) error {
+	return UnmarshalWithConfig(data, v, &DecoderConfig{})
+}
+
+// UnmarshalWithConfig is similar to Unmarshal, but decodes data with the specified configuration.
+//
+// config must not be nil.
+func UnmarshalWithConfig(data []byte, v any, config *DecoderConfig) error {
+	d := NewDecoderBytes(data, config)
+	err := d.DecodeInto(v)
+	if err != nil {
+		return err
+	}
+	if n := d.pos(); n < int64(len(data)) {
+		return fmt.Errorf("pickle: unmarshal: %d bytes of trailing data after pickle", int64(len(data))-n)
+	}
+	return nil
+}
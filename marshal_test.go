@@ -0,0 +1,65 @@
+package ogórek
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMarshal(t *testing.T) {
+	data, err := Marshal([]any{int64(1), "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "(lI1\naS\"a\"\na."
+	if string(data) != want {
+		t.Errorf("marshal:\nhave: %q\nwant: %q", data, want)
+	}
+
+	data, err = MarshalWithConfig(map[string]int64{"n": 300}, &EncoderConfig{Protocol: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = "\x80\x02}U\x01nM,\x01s."
+	if string(data) != want {
+		t.Errorf("marshal with config:\nhave: %q\nwant: %q", data, want)
+	}
+
+	var obj any
+	err = Unmarshal(data, &obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(obj, map[any]any{"n": int64(300)}) {
+		t.Errorf("unmarshal: %#v", obj)
+	}
+
+	var s struct{ N int16 `pickle:"n"` }
+	err = Unmarshal(data, &s)
+	if !(err == nil && s.N == 300) {
+		t.Errorf("unmarshal into struct: %v, %v", s, err)
+	}
+
+	err = UnmarshalWithConfig(data, &obj, &DecoderConfig{PyDict: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := obj.(Dict); !ok {
+		t.Errorf("unmarshal with config: %#v", obj)
+	}
+
+	var x int8
+	err = Unmarshal([]byte("I300\n."), &x)
+	if _, ok := err.(*DecodeTypeError); !ok {
+		t.Errorf("unmarshal overflow: %v", err)
+	}
+
+	err = Unmarshal([]byte("I1\n.I2\n."), &x)
+	if err == nil || err.Error() != "pickle: unmarshal: 4 bytes of trailing data after pickle" {
+		t.Errorf("unmarshal trailing data: %v", err)
+	}
+
+	err = Unmarshal([]byte("I1\n"), &x)
+	if err == nil {
+		t.Errorf("unmarshal truncated: no error")
+	}
+}
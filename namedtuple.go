@@ -0,0 +1,102 @@
+package ogórek
+// Support for Python namedtuples.
+//
+// Instances of namedtuple classes, e.g. created by collections.namedtuple or
+// typing.NamedTuple, are pickled as cls.__new__(cls, *values), or as
+// cls(*values), without field names. The names are known only to the class
+// itself, so namedtuple classes have to be registered with their fields for
+// the decoder to produce self-describing NamedTuple values.
+
+import (
+	"fmt"
+)
+
+// NamedTuple represents instance of Python namedtuple.
+//
+// Values are the tuple items, and Fields are names of corresponding fields.
+// As in Python, NamedTuple is equal to [Tuple] with the same values.
+type NamedTuple struct {
+	Class  Class
+	Fields []string
+	Values Tuple
+}
+
+// Get returns value of field with given name.
+func (t NamedTuple) Get(field string) (_ any, ok bool) {
+	for i, f := range t.Fields {
+		if f == field && i < len(t.Values) {
+			return t.Values[i], true
+		}
+	}
+	return nil, false
+}
+
+// items returns fields of t as dict entries.
+func (t NamedTuple) items() []ValueItem {
+	n := len(t.Fields)
+	if len(t.Values) < n {
+		n = len(t.Values)
+	}
+	items := make([]ValueItem, n)
+	for i := 0; i < n; i++ {
+		items[i] = ValueItem{ValueOf(t.Fields[i]), ValueOf(t.Values[i])}
+	}
+	return items
+}
+
+// RegisterNamedTuple registers Python class as namedtuple with given fields.
+//
+// Instances of the class are decoded into [NamedTuple] with Fields set to
+// fields. For example collections.namedtuple('Point', 'x y') defined in
+// module geo is registered as
+//
+//	r.RegisterNamedTuple(Class{"geo", "Point"}, "x", "y")
+//
+// Namedtuples can be also decoded directly into Go structs by registering
+// them via [TypeRegistry.Register] with struct types that have positional
+// `pickle:"0"` tags.
+//
+// RegisterNamedTuple panics if class is already registered.
+func (r *TypeRegistry) RegisterNamedTuple(class Class, fields ...string) {
+	r.checkClass(class)
+	r.namedTuples[class] = append([]string(nil), fields...)
+}
+
+// namedTupleFields returns fields of namedtuple class registered via
+// RegisterNamedTuple.
+func (r *TypeRegistry) namedTupleFields(class Class) ([]string, bool) {
+	if r == nil {
+		return nil, false
+	}
+	fields, ok := r.namedTuples[class]
+	return fields, ok
+}
+
+// newNamedTuple creates NamedTuple with fields out of obj instance of
+// namedtuple class.
+//
+// Keyword arguments, if any, are put into their fields positions.
+func newNamedTuple(obj Object, fields []string) (NamedTuple, error) {
+	t := NamedTuple{Class: obj.Class, Fields: fields}
+	if len(obj.Args)+len(obj.KwArgs) != len(fields) {
+		return t, fmt.Errorf("namedtuple: %d values for %d fields", len(obj.Args)+len(obj.KwArgs), len(fields))
+	}
+
+	t.Values = append(make(Tuple, 0, len(fields)), obj.Args...)
+	for _, f := range fields[len(obj.Args):] {
+		v, ok := obj.KwArgs[f]
+		if !ok {
+			return t, fmt.Errorf("namedtuple: missing value for field %q", f)
+		}
+		t.Values = append(t.Values, v)
+	}
+	return t, nil
+}
+
+// encodeNamedTuple encodes NamedTuple as instance of its class created via
+// cls.__new__(cls, *values), as Python does.
+func (e *Encoder) encodeNamedTuple(t *NamedTuple) error {
+	return e.encodeObjectNewAt(&Object{Class: t.Class, Args: t.Values}, func(i int) string {
+		return ".Values" + pathIndex(i)
+	})
+}
@@ -0,0 +1,141 @@
+package ogórek
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNamedTupleDecode(t *testing.T) {
+	types := NewTypeRegistry()
+	types.RegisterNamedTuple(Class{"app", "P"}, "x", "y")
+	want := NamedTuple{Class{"app", "P"}, []string{"x", "y"}, Tuple{int64(1), "a"}}
+
+	for _, data := range []string{
+		// app.P(1, 'a') with P = namedtuple('P', 'x y'), as pickled by Python
+		"\x80\x02capp\nP\nq\x00K\x01X\x01\x00\x00\x00aq\x01\x86q\x02\x81q\x03.",
+		"\x80\x04\x95\x17\x00\x00\x00\x00\x00\x00\x00\x8c\x03app\x94\x8c\x01P\x94\x93\x94K\x01\x8c\x01a\x94\x86\x94\x81\x94.",
+		"ccopy_reg\n_reconstructor\np0\n(capp\nP\np1\nc__builtin__\ntuple\np2\n(I1\nVa\np3\ntp4\ntp5\nRp6\n.",
+		// app.P(1, 'a') as call
+		"capp\nP\n(I1\nVa\ntR.",
+		// app.P.__new__(app.P, 1, y='a')
+		"\x80\x04\x8c\x03app\x8c\x01P\x93K\x01\x85}\x8c\x01y\x8c\x01as\x92.",
+	} {
+		for _, preserveRefs := range []bool{false, true} {
+			obj, err := NewDecoderWithConfig(strings.NewReader(data), &DecoderConfig{Types: types, PreserveRefs: preserveRefs}).Decode()
+			if err != nil {
+				t.Errorf("%q: %s", data, err)
+				continue
+			}
+			if !reflect.DeepEqual(obj, want) {
+				t.Errorf("%q:\nhave: %#v\nwant: %#v", data, obj, want)
+			}
+		}
+	}
+
+	for _, tt := range []struct {
+		data string
+		err  string
+	}{
+		{"capp\nP\n(I1\ntR.", "app.P: namedtuple: 1 values for 2 fields"},
+		{"\x80\x04\x8c\x03app\x8c\x01P\x93K\x01\x85}\x8c\x01z\x8c\x01as\x92.", `app.P: namedtuple: missing value for field "y"`},
+	} {
+		_, err := NewDecoderWithConfig(strings.NewReader(tt.data), &DecoderConfig{Types: types}).Decode()
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%q: error:\nhave: %v\nwant: %s", tt.data, err, tt.err)
+		}
+	}
+
+	// namedtuple behaves as tuple
+	if x, ok := want.Get("y"); !(ok && x == "a") {
+		t.Errorf("get y: %#v, %v", x, ok)
+	}
+	if _, ok := want.Get("z"); ok {
+		t.Errorf("get z: ok")
+	}
+	if !equal(want, Tuple{int64(1), "a"}) || equal(want, Tuple{int64(1), "b"}) {
+		t.Errorf("namedtuple is not compared as tuple")
+	}
+	if v, err := ValueOf(want).Index(1); !(err == nil && v.Interface() == "a" && ValueOf(want).Kind() == KindTuple) {
+		t.Errorf("value: index 1: %v, %v", v, err)
+	}
+	d := NewDict()
+	d.Set(Tuple{int64(1), "a"}, int64(1))
+	if x := d.Get(want); x != int64(1) {
+		t.Errorf("dict: get namedtuple: %#v", x)
+	}
+}
+
+func TestNamedTupleDecodeInto(t *testing.T) {
+	types := NewTypeRegistry()
+	types.RegisterNamedTuple(Class{"app", "P"}, "x", "y")
+
+	var v struct {
+		P struct {
+			Y string `pickle:"y"`
+			X int    `pickle:"x"`
+		} `pickle:"p"`
+		Pos regPair `pickle:"pos"`
+	}
+	// {'p': app.P(1, 'a'), 'pos': app.P('b', 2)}
+	data := "(dVp\ncapp\nP\n(I1\nVa\ntRsVpos\ncapp\nP\n(Vb\nI2\ntRs."
+	err := NewDecoderWithConfig(strings.NewReader(data), &DecoderConfig{Types: types}).DecodeInto(&v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !(v.P.X == 1 && v.P.Y == "a" && v.Pos == regPair{"b", 2}) {
+		t.Errorf("decode into: %#v", v)
+	}
+}
+
+func TestNamedTupleEncode(t *testing.T) {
+	nt := NamedTuple{Class{"app", "P"}, []string{"x", "y"}, Tuple{int64(1), "a"}}
+	for _, tt := range []struct {
+		proto int
+		want  string
+	}{
+		{2, "\x80\x02capp\nP\nK\x01U\x01a\x86\x81."},
+		{1, "ccopy_reg\n__newobj__\n(capp\nP\nK\x01U\x01atR."},
+	} {
+		buf := &bytes.Buffer{}
+		err := NewEncoderWithConfig(buf, &EncoderConfig{Protocol: tt.proto}).Encode(nt)
+		if err != nil {
+			t.Errorf("protocol %d: %s", tt.proto, err)
+			continue
+		}
+		if buf.String() != tt.want {
+			t.Errorf("protocol %d:\nhave: %q\nwant: %q", tt.proto, buf.String(), tt.want)
+		}
+	}
+
+	types := NewTypeRegistry()
+	types.RegisterNamedTuple(Class{"app", "P"}, "x", "y")
+	err := VerifyRoundTrip(nt, nil, &DecoderConfig{Types: types})
+	if err != nil {
+		t.Error(err)
+	}
+
+	err = NewEncoder(&bytes.Buffer{}).Encode(NamedTuple{Class{"app", "P"}, []string{"x"}, Tuple{make(chan int)}})
+	if err == nil || !strings.Contains(err.Error(), ".Values[0]") {
+		t.Errorf("encode error: %v", err)
+	}
+}
+
+func TestNamedTupleRegister(t *testing.T) {
+	types := NewTypeRegistry()
+	types.RegisterNamedTuple(Class{"app", "P"}, "x", "y")
+	for _, register := range []func(){
+		func() { types.RegisterNamedTuple(Class{"app", "P"}, "z") },
+		func() { types.Register(Class{"app", "P"}, reflect.TypeOf(regPair{})) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("duplicate registration: no panic")
+				}
+			}()
+			register()
+		}()
+	}
+}
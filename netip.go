@@ -0,0 +1,253 @@
+package ogórek
+// Support for objects of Python ipaddress module (https://docs.python.org/3/library/ipaddress.html).
+//
+// ipaddress objects are pickled as calls to their classes with address in
+// integer, string or packed form. In NetIP mode the decoder translates them
+// into netip.Addr and netip.Prefix, and the encoder emits Go IP types as
+// ipaddress objects.
+
+import (
+	"fmt"
+	"math/big"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// handleNetIPCall serves handleCall in NetIP mode.
+func (d *Decoder) handleNetIPCall(class Class, argv Tuple) error {
+	if class.Module != "ipaddress" {
+		return errCallNotHandled
+	}
+
+	var version int
+	name := class.Name
+	switch {
+	case strings.HasPrefix(name, "IPv4"):
+		version, name = 4, name[4:]
+	case strings.HasPrefix(name, "IPv6"):
+		version, name = 6, name[4:]
+	case name == "ip_address":
+		name = "Address"
+	case name == "ip_network":
+		name = "Network"
+	case name == "ip_interface":
+		name = "Interface"
+	default:
+		return errCallNotHandled
+	}
+
+	switch name {
+	// IPv{4,6}Address(addr), ip_address(addr)
+	case "Address":
+		if len(argv) != 1 {
+			return fmt.Errorf("ipaddress: %s: unexpected number of args %d", class.Name, len(argv))
+		}
+		addr, err := netipAddr(version, argv[0])
+		if err != nil {
+			return fmt.Errorf("ipaddress: %s: %s", class.Name, err)
+		}
+		d.push(addr)
+
+	// IPv{4,6}Network(addr[, strict]), ip_network(addr[, strict])
+	// IPv{4,6}Interface(addr),         ip_interface(addr)
+	case "Network", "Interface":
+		if !(len(argv) == 1 || (name == "Network" && len(argv) == 2)) {
+			return fmt.Errorf("ipaddress: %s: unexpected number of args %d", class.Name, len(argv))
+		}
+		prefix, err := netipPrefix(version, argv[0])
+		if err != nil {
+			return fmt.Errorf("ipaddress: %s: %s", class.Name, err)
+		}
+		if name == "Network" {
+			prefix = prefix.Masked()
+		}
+		d.push(prefix)
+
+	default:
+		return errCallNotHandled
+	}
+
+	return nil
+}
+
+// netipAddr decodes address argument of ipaddress constructors.
+//
+// version is 4 or 6 to require address of that IP version, or 0 to accept both.
+func netipAddr(version int, x any) (addr netip.Addr, err error) {
+	switch x := x.(type) {
+	case string:
+		addr, err = netip.ParseAddr(x)
+	case ByteString:
+		addr, err = netip.ParseAddr(string(x))
+
+	// packed form
+	case Bytes:
+		var ok bool
+		addr, ok = netip.AddrFromSlice([]byte(x))
+		if !ok {
+			return addr, fmt.Errorf("packed address: invalid length %d", len(x))
+		}
+
+	// integer form
+	case int64, *big.Int:
+		var i big.Int
+		switch x := x.(type) {
+		case int64:
+			i.SetInt64(x)
+		case *big.Int:
+			i.Set(x)
+		}
+		if i.Sign() < 0 || i.BitLen() > 128 || (version == 4 && i.BitLen() > 32) {
+			return addr, fmt.Errorf("integer address out of range: %s", &i)
+		}
+		if version == 4 || (version == 0 && i.BitLen() <= 32) {
+			var b [4]byte
+			addr = netip.AddrFrom4(*(*[4]byte)(i.FillBytes(b[:])))
+		} else {
+			var b [16]byte
+			addr = netip.AddrFrom16(*(*[16]byte)(i.FillBytes(b[:])))
+		}
+
+	default:
+		return addr, fmt.Errorf("expect int|str|bytes; got %T", x)
+	}
+	if err != nil {
+		return addr, err
+	}
+
+	switch {
+	case version == 4 && !addr.Is4():
+		return addr, fmt.Errorf("%s is not IPv4 address", addr)
+	case version == 6 && !addr.Is6():
+		return addr, fmt.Errorf("%s is not IPv6 address", addr)
+	}
+	return addr, nil
+}
+
+// netipPrefix decodes address argument of ipaddress network and interface constructors.
+//
+// The argument can be "addr/prefixlen" string, (addr, prefixlen) tuple, or
+// address alone, that is treated as prefix of full length.
+func netipPrefix(version int, x any) (netip.Prefix, error) {
+	var addrx, bitsx any
+	switch x := x.(type) {
+	case string:
+		addrx, bitsx = splitPrefix(x)
+	case ByteString:
+		addrx, bitsx = splitPrefix(string(x))
+	case Tuple:
+		if len(x) != 2 {
+			return netip.Prefix{}, fmt.Errorf("expect (addr, prefixlen); got %d items", len(x))
+		}
+		addrx, bitsx = x[0], x[1]
+	default:
+		addrx = x
+	}
+
+	addr, err := netipAddr(version, addrx)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+
+	bits := addr.BitLen()
+	switch b := bitsx.(type) {
+	case nil:
+		// full length
+	case int64:
+		bits = int(b)
+	case string:
+		bits, err = strconv.Atoi(b)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid prefix length %q", b)
+		}
+	default:
+		return netip.Prefix{}, fmt.Errorf("prefixlen: expect int|str; got %T", bitsx)
+	}
+
+	prefix := netip.PrefixFrom(addr.WithZone(""), bits)
+	if !prefix.IsValid() {
+		return prefix, fmt.Errorf("invalid prefix length %d for %s", bits, addr)
+	}
+	return prefix, nil
+}
+
+// splitPrefix splits "addr/prefixlen" into addr and prefixlen.
+//
+// prefixlen is returned as nil if s does not have it.
+func splitPrefix(s string) (addr, prefixlen any) {
+	i := strings.LastIndexByte(s, '/')
+	if i < 0 {
+		return s, nil
+	}
+	return s[:i], s[i+1:]
+}
+
+// encodeNetIPAddr encodes IP address as ipaddress object in NetIP mode, or as string.
+//
+// Invalid address is encoded as None.
+func (e *Encoder) encodeNetIPAddr(addr netip.Addr) error {
+	if !addr.IsValid() {
+		return e.emit(opNone)
+	}
+	return e.encodeNetIP("ip_address", addr.String())
+}
+
+// encodeNetIPPrefix encodes IP prefix as ipaddress object in NetIP mode, or as string.
+//
+// Masked prefix is encoded as network, while prefix with host bits set is
+// encoded as interface. Invalid prefix is encoded as None.
+func (e *Encoder) encodeNetIPPrefix(prefix netip.Prefix) error {
+	if !prefix.IsValid() {
+		return e.emit(opNone)
+	}
+	f := "ip_network"
+	if prefix != prefix.Masked() {
+		f = "ip_interface"
+	}
+	return e.encodeNetIP(f, prefix.String())
+}
+
+// encodeNetIP encodes ipaddress.<f>(s) call in NetIP mode, or s as string.
+func (e *Encoder) encodeNetIP(f, s string) error {
+	if !e.config.NetIP {
+		return e.encodeString(s)
+	}
+	return e.encodeCall(&Call{
+		Callable: Class{Module: "ipaddress", Name: f},
+		Args:     Tuple{Unicode(s)},
+	})
+}
+
+// netIPAddr converts net.IP to netip.Addr.
+//
+// IPv4 addresses, that net.IP often keeps in 16-byte form, are converted to IPv4 netip.Addr.
+func netIPAddr(ip net.IP) netip.Addr {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	addr, _ := netip.AddrFromSlice(ip)
+	return addr
+}
+
+// netIPPrefix converts net.IPNet to netip.Prefix.
+//
+// IPv4-mapped IPv6 networks, e.g. with 16-byte mask, are converted to IPv4
+// prefix, unless the mask is shorter than /96.
+func netIPPrefix(ipnet *net.IPNet) netip.Prefix {
+	addr := netIPAddr(ipnet.IP)
+	ones, bits := ipnet.Mask.Size()
+	if bits == 128 && addr.Is4() {
+		if ones >= 96 {
+			ones -= 96
+		} else {
+			// the mask is wider than IPv4 address - keep the network IPv6
+			addr = netip.AddrFrom16(addr.As16())
+		}
+	}
+	if !addr.IsValid() || bits == 0 {
+		return netip.Prefix{}
+	}
+	return netip.PrefixFrom(addr, ones)
+}
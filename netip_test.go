@@ -0,0 +1,128 @@
+package ogórek
+
+import (
+	"bytes"
+	"net"
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestDecodeNetIP verifies decoding of ipaddress objects in NetIP mode.
+func TestDecodeNetIP(t *testing.T) {
+	addr := netip.MustParseAddr
+	prefix := netip.MustParsePrefix
+
+	for _, tt := range []struct {
+		pickle string
+		want   any
+	}{
+		// pickles produced by CPython
+		{"\x80\x02cipaddress\nIPv4Address\nq\x00J\x04\x03\x02\x01\x85q\x01Rq\x02.", addr("1.2.3.4")},
+		{"cipaddress\nIPv4Address\np0\n(I16909060\ntp1\nRp2\n.", addr("1.2.3.4")},
+		{"\x80\x02cipaddress\nIPv6Address\nq\x00X\x03\x00\x00\x00::1q\x01\x85q\x02Rq\x03.", addr("::1")},
+		{"\x80\x02cipaddress\nIPv6Address\nq\x00X\x0c\x00\x00\x00fe80::1%eth0q\x01\x85q\x02Rq\x03.", addr("fe80::1%eth0")},
+		{"\x80\x02cipaddress\nIPv4Network\nq\x00X\n\x00\x00\x0010.0.0.0/8q\x01\x85q\x02Rq\x03.", prefix("10.0.0.0/8")},
+		{"\x80\x02cipaddress\nIPv6Network\nq\x00X\t\x00\x00\x00fe80::/64q\x01\x85q\x02Rq\x03.", prefix("fe80::/64")},
+		{"\x80\x02cipaddress\nIPv4Interface\nq\x00X\x0b\x00\x00\x0010.1.2.3/24q\x01\x85q\x02Rq\x03.", prefix("10.1.2.3/24")},
+		{"\x80\x02cipaddress\nIPv6Interface\nq\x00X\n\x00\x00\x00fe80::1/64q\x01\x85q\x02Rq\x03.", prefix("fe80::1/64")},
+
+		// other argument forms
+		{"\x80\x03cipaddress\nIPv4Address\nC\x04\x01\x02\x03\x04\x85R.", addr("1.2.3.4")},
+		{"\x80\x03cipaddress\nIPv6Address\nK\x01\x85R.", addr("::1")},
+		{"\x80\x03cipaddress\nip_address\nK\x01\x85R.", addr("0.0.0.1")},
+		{"\x80\x03cipaddress\nip_address\n\x8a\x05\x00\x00\x00\x00\x01\x85R.", addr("::1:0:0")},
+		{"\x80\x03cipaddress\nip_network\nX\x0a\x00\x00\x0010.1.0.0/8\x85R.", prefix("10.0.0.0/8")},
+		{"\x80\x03cipaddress\nIPv4Network\nX\x08\x00\x00\x0010.0.0.0K\x08\x86\x85R.", prefix("10.0.0.0/8")},
+		{"\x80\x03cipaddress\nIPv4Network\nX\x08\x00\x00\x0010.0.0.1\x85R.", prefix("10.0.0.1/32")},
+	} {
+		obj, err := NewDecoderWithConfig(strings.NewReader(tt.pickle), &DecoderConfig{NetIP: true}).Decode()
+		if err != nil {
+			t.Errorf("%q: %s", tt.pickle, err)
+			continue
+		}
+		if !reflect.DeepEqual(obj, tt.want) {
+			t.Errorf("%q:\nhave: %#v\nwant: %#v", tt.pickle, obj, tt.want)
+		}
+	}
+
+	// invalid arguments
+	for _, pickle := range []string{
+		"\x80\x03cipaddress\nIPv4Address\nX\x03\x00\x00\x00::1\x85R.",
+		"\x80\x03cipaddress\nIPv6Address\nX\x07\x00\x00\x001.2.3.4\x85R.",
+		"\x80\x03cipaddress\nIPv4Address\nJ\xff\xff\xff\xff\x85R.",
+		"\x80\x03cipaddress\nIPv4Address\nC\x03\x01\x02\x03\x85R.",
+		"\x80\x03cipaddress\nIPv4Network\nX\x0b\x00\x00\x0010.0.0.0/33\x85R.",
+		"\x80\x03cipaddress\nIPv4Address\n)R.",
+	} {
+		_, err := NewDecoderWithConfig(strings.NewReader(pickle), &DecoderConfig{NetIP: true}).Decode()
+		if err == nil {
+			t.Errorf("%q: no error", pickle)
+		}
+	}
+
+	// without NetIP calls are left as is
+	obj, err := NewDecoder(strings.NewReader("\x80\x03cipaddress\nIPv6Address\nK\x01\x85R.")).Decode()
+	if want := (Call{Class{"ipaddress", "IPv6Address"}, Tuple{int64(1)}}); !(err == nil && reflect.DeepEqual(obj, want)) {
+		t.Errorf("!NetIP: have %#v, %v  ; want %#v", obj, err, want)
+	}
+}
+
+// TestEncodeNetIP verifies encoding of Go IP types.
+func TestEncodeNetIP(t *testing.T) {
+	addr := netip.MustParseAddr
+	prefix := netip.MustParsePrefix
+	_, ipnet, _ := net.ParseCIDR("10.0.0.0/8")
+
+	for _, tt := range []struct {
+		in   any
+		want any // decoded with NetIP=y
+		str  any // encoded with NetIP=n
+	}{
+		{addr("1.2.3.4"),           addr("1.2.3.4"),           "1.2.3.4"},
+		{addr("fe80::1%eth0"),      addr("fe80::1%eth0"),      "fe80::1%eth0"},
+		{net.ParseIP("1.2.3.4"),    addr("1.2.3.4"),           "1.2.3.4"},
+		{net.ParseIP("::1"),        addr("::1"),               "::1"},
+		{prefix("10.0.0.0/8"),      prefix("10.0.0.0/8"),      "10.0.0.0/8"},
+		{prefix("10.1.2.3/24"),     prefix("10.1.2.3/24"),     "10.1.2.3/24"},
+		{prefix("fe80::/64"),       prefix("fe80::/64"),       "fe80::/64"},
+		{ipnet,                     prefix("10.0.0.0/8"),      "10.0.0.0/8"},
+		{&net.IPNet{IP: net.ParseIP("10.0.0.0"), Mask: net.CIDRMask(104, 128)},
+			prefix("10.0.0.0/8"), "10.0.0.0/8"},
+		{&net.IPNet{IP: net.ParseIP("::ffff:10.0.0.0"), Mask: net.CIDRMask(80, 128)},
+			prefix("::ffff:10.0.0.0/80"), "::ffff:10.0.0.0/80"},
+		{netip.Addr{},              None{},                    None{}},
+		{net.IP(nil),               None{},                    None{}},
+	} {
+		for _, netIP := range []bool{false, true} {
+			buf := &bytes.Buffer{}
+			err := NewEncoderWithConfig(buf, &EncoderConfig{Protocol: 3, NetIP: netIP}).Encode(tt.in)
+			if err != nil {
+				t.Errorf("%v: netip=%v: encode: %s", tt.in, netIP, err)
+				continue
+			}
+			obj, err := NewDecoderWithConfig(buf, &DecoderConfig{NetIP: true}).Decode()
+			if err != nil {
+				t.Errorf("%v: netip=%v: decode: %s", tt.in, netIP, err)
+				continue
+			}
+			want := tt.str
+			if netIP {
+				want = tt.want
+			}
+			if !reflect.DeepEqual(obj, want) {
+				t.Errorf("%v: netip=%v:\nhave: %#v\nwant: %#v", tt.in, netIP, obj, want)
+			}
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	err := NewEncoderWithConfig(buf, &EncoderConfig{Protocol: 3, NetIP: true}).Encode(prefix("10.1.2.3/24"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "\x80\x03cipaddress\nip_interface\nX\x0b\x00\x00\x0010.1.2.3/24\x85R."; buf.String() != want {
+		t.Errorf("ip_interface:\nhave: %q\nwant: %q", buf.String(), want)
+	}
+}
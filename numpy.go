@@ -0,0 +1,571 @@
+package ogórek
+// Support for numpy objects.
+//
+// numpy dtypes are pickled as numpy.dtype(typestr, align, copy) call followed
+// by BUILD with (version, byteorder, subarray, names, fields, elsize,
+// alignment, flags[, metadata]) state.
+//
+// numpy arrays are pickled as numpy.core.multiarray._reconstruct(ndarray, (0,),
+// b'b') call followed by BUILD with (version, shape, dtype, is_fortran, data)
+// state, and at protocol 5 as numpy.core.numeric._frombuffer(buffer, dtype,
+// shape, order) call.
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Dtype represents numpy data type object, that describes layout of array
+// items.
+//
+// Dtype is only decoded from pickles: the encoder does not emit it back as
+// numpy.dtype, and returns [TypeError] for it and for [DtypeField].
+type Dtype struct {
+	// Kind is the kind of data: 'b' for bool, 'i' and 'u' for signed and
+	// unsigned integers, 'f' for floats, 'c' for complex numbers, 'M' and
+	// 'm' for datetime64 and timedelta64, 'O' for objects, 'S' for bytes,
+	// 'U' for str and 'V' for void, e.g. structured dtypes.
+	Kind byte
+
+	// ItemSize is the size of one item in bytes.
+	ItemSize int
+
+	// ByteOrder is '<' for little-endian data, '>' for big-endian data,
+	// and '|' if byte order is not applicable, e.g. for 1-byte items.
+	ByteOrder byte
+
+	// Unit is the unit of datetime64 and timedelta64 items, e.g. "ns" or
+	// "10s"; empty for other kinds and for generic datetimes.
+	Unit string
+
+	// Fields are fields of structured dtype in their order; nil for other
+	// dtypes.
+	Fields []DtypeField
+
+	// Base and Shape describe subarray dtype: Shape-d array of Base items.
+	// Base is nil for other dtypes.
+	Base  *Dtype
+	Shape []int
+}
+
+// DtypeField is a field of structured [Dtype].
+type DtypeField struct {
+	Name   string
+	Type   Dtype
+	Offset int
+	Title  any // nil if the field has no title
+}
+
+// String returns dtype string as numpy dtype.str does, e.g. "<i8" or "<M8[ns]".
+func (dt Dtype) String() string {
+	size := dt.ItemSize
+	if dt.Kind == 'U' {
+		size /= 4
+	}
+	s := fmt.Sprintf("%c%c%d", dt.ByteOrder, dt.Kind, size)
+	if dt.Unit != "" {
+		s += "[" + dt.Unit + "]"
+	}
+	return s
+}
+
+// NDArray represents numpy array.
+//
+// NDArray is only decoded from pickles: the encoder does not emit it back as
+// numpy.ndarray, and returns [TypeError] for it.
+type NDArray struct {
+	// Dtype is the type of array items.
+	Dtype Dtype
+
+	// Shape is the size of the array in each dimension; it is empty for
+	// 0-dimensional arrays.
+	Shape []int
+
+	// FortranOrder is whether the items are laid out in Fortran, i.e.
+	// column-major, order instead of C, i.e. row-major, order.
+	FortranOrder bool
+
+	// Data is raw data of the items in their order and with byte order of
+	// Dtype; nil for arrays of object dtype.
+	Data []byte
+
+	// Objects are the items of arrays of object dtype in their order; nil
+	// for other arrays.
+	Objects []any
+}
+
+// Size returns number of items in the array, i.e. product of its shape.
+func (a NDArray) Size() int {
+	n := 1
+	for _, dim := range a.Shape {
+		n *= dim
+	}
+	return n
+}
+
+var (
+	pyNumPyDtype   = Class{Module: "numpy", Name: "dtype"}
+	pyNumPyNDArray = Class{Module: "numpy", Name: "ndarray"}
+)
+
+// isNumPyMultiarray returns whether class is name from numpy.core.multiarray,
+// or from numpy._core.multiarray as the module is called since numpy 2.
+func isNumPyMultiarray(class Class, name string) bool {
+	switch class.Module {
+	case "numpy.core.multiarray", "numpy._core.multiarray":
+		return class.Name == name
+	}
+	return false
+}
+
+// isNumPyNumeric is like isNumPyMultiarray, but for numpy.core.numeric module.
+func isNumPyNumeric(class Class, name string) bool {
+	switch class.Module {
+	case "numpy.core.numeric", "numpy._core.numeric":
+		return class.Name == name
+	}
+	return false
+}
+
+// handleNumPyCall serves handleCall for NumPy mode.
+func (d *Decoder) handleNumPyCall(class Class, argv Tuple) error {
+	switch {
+	// dtype(typestr, align, copy) creates dtype, which BUILD then completes
+	case class == pyNumPyDtype:
+		if !(1 <= len(argv) && len(argv) <= 3) {
+			return fmt.Errorf("numpy: dtype: unexpected number of args %d", len(argv))
+		}
+		typestr, err := AsString(argv[0])
+		if err != nil {
+			return fmt.Errorf("numpy: dtype: %s", err)
+		}
+		if len(typestr) < 2 {
+			return fmt.Errorf("numpy: dtype: invalid type string %q", typestr)
+		}
+		size, err := strconv.Atoi(typestr[1:])
+		if err != nil {
+			return fmt.Errorf("numpy: dtype: invalid type string %q", typestr)
+		}
+		dt := Dtype{Kind: typestr[0], ItemSize: size}
+		if dt.Kind == 'U' {
+			dt.ItemSize *= 4 // UCS4 characters
+		}
+		d.push(dt)
+		return nil
+
+	// scalar(dtype, data) creates numpy scalar from raw data
+	case isNumPyMultiarray(class, "scalar"):
+		if len(argv) != 2 {
+			return fmt.Errorf("numpy: scalar: unexpected number of args %d", len(argv))
+		}
+		dt, ok := argv[0].(Dtype)
+		if !ok {
+			return fmt.Errorf("numpy: scalar: expect dtype; got %T", argv[0])
+		}
+		if dt.Kind == 'O' {
+			d.push(argv[1]) // scalar of object dtype is the object itself
+			return nil
+		}
+		data, err := numpyData(argv[1])
+		if err != nil {
+			return fmt.Errorf("numpy: scalar: %s", err)
+		}
+		v, ok, err := numpyScalar(dt, data)
+		if err != nil {
+			return fmt.Errorf("numpy: scalar: %s", err)
+		}
+		if !ok {
+			return errCallNotHandled
+		}
+		d.push(v)
+		return nil
+
+	// _reconstruct(ndarray, (0,), b'b') creates empty array, which BUILD
+	// then fills; arrays of ndarray subclasses are left as calls
+	case isNumPyMultiarray(class, "_reconstruct"):
+		if len(argv) != 3 {
+			return fmt.Errorf("numpy: _reconstruct: unexpected number of args %d", len(argv))
+		}
+		if cls, ok := argv[0].(Class); !(ok && cls == pyNumPyNDArray) {
+			return errCallNotHandled
+		}
+		d.push(NDArray{})
+		return nil
+
+	// _frombuffer(buffer, dtype, shape, order) creates array from its data
+	case isNumPyNumeric(class, "_frombuffer"):
+		if len(argv) != 4 {
+			return fmt.Errorf("numpy: _frombuffer: unexpected number of args %d", len(argv))
+		}
+		data, err := numpyData(argv[0])
+		if err != nil {
+			return fmt.Errorf("numpy: _frombuffer: %s", err)
+		}
+		dt, ok := argv[1].(Dtype)
+		if !ok {
+			return fmt.Errorf("numpy: _frombuffer: expect dtype; got %T", argv[1])
+		}
+		a := NDArray{Dtype: dt, Data: data}
+		a.Shape, err = numpyShape(argv[2])
+		if err != nil {
+			return fmt.Errorf("numpy: _frombuffer: shape: %s", err)
+		}
+		order, err := AsString(argv[3])
+		if !(err == nil && (order == "C" || order == "F")) {
+			return fmt.Errorf("numpy: _frombuffer: invalid order %s", Sprint("%#v", argv[3], nil))
+		}
+		a.FortranOrder = order == "F"
+		err = a.checkData()
+		if err != nil {
+			return fmt.Errorf("numpy: _frombuffer: %s", err)
+		}
+		d.push(a)
+		return nil
+	}
+
+	return errCallNotHandled
+}
+
+// buildNDArray serves BUILD for numpy arrays.
+func (d *Decoder) buildNDArray(a NDArray, state any) error {
+	if a.Dtype.Kind != 0 {
+		return fmt.Errorf("pickle: build: numpy.ndarray: array already has state")
+	}
+	err := a.setState(state)
+	if err != nil {
+		return fmt.Errorf("pickle: build: numpy.ndarray: %s", err)
+	}
+	d.stack[len(d.stack)-1] = a
+	d.updateCells(len(d.stack)-1, a)
+	return nil
+}
+
+// setState sets array properties from its pickled state.
+func (a *NDArray) setState(state any) error {
+	t, ok := state.(Tuple)
+	if ok && len(t) == 5 {
+		t = t[1:] // version
+	}
+	if !(ok && len(t) == 4) {
+		return fmt.Errorf("invalid state %s", Sprint("%#v", state, nil))
+	}
+	var err error
+	a.Shape, err = numpyShape(t[0])
+	if err != nil {
+		return fmt.Errorf("shape: %s", err)
+	}
+	a.Dtype, ok = t[1].(Dtype)
+	if !ok {
+		return fmt.Errorf("expect dtype; got %T", t[1])
+	}
+	a.FortranOrder, ok = t[2].(bool)
+	if !ok {
+		return fmt.Errorf("is_fortran: expect bool; got %T", t[2])
+	}
+
+	if a.Dtype.Kind == 'O' {
+		a.Objects, ok = t[3].([]any)
+		if !ok {
+			return fmt.Errorf("expect list of objects; got %T", t[3])
+		}
+	} else {
+		a.Data, err = numpyData(t[3])
+		if err != nil {
+			return err
+		}
+	}
+	return a.checkData()
+}
+
+// dataSize returns size of array data in bytes, or number of items for
+// arrays of object dtype.
+func (a *NDArray) dataSize() (int, error) {
+	n := 1
+	if a.Dtype.Kind != 'O' {
+		n = a.Dtype.ItemSize
+	}
+	for _, dim := range a.Shape {
+		if dim < 0 {
+			return 0, fmt.Errorf("negative dimension %d", dim)
+		}
+		if dim != 0 && n > math.MaxInt/dim {
+			return 0, fmt.Errorf("size of %v array overflows int", a.Shape)
+		}
+		n *= dim
+	}
+	return n, nil
+}
+
+// checkData verifies that array data matches array shape and dtype.
+func (a *NDArray) checkData() error {
+	n, err := a.dataSize()
+	if err != nil {
+		return err
+	}
+	have := len(a.Data)
+	if a.Dtype.Kind == 'O' {
+		have = len(a.Objects)
+	}
+	if have != n {
+		return fmt.Errorf("%s%v array: invalid data size %d", a.Dtype, a.Shape, have)
+	}
+	return nil
+}
+
+// buildDtype serves BUILD for numpy dtypes.
+func (d *Decoder) buildDtype(dt Dtype, state any) error {
+	if dt.ByteOrder != 0 {
+		return fmt.Errorf("pickle: build: numpy.dtype: dtype already has state")
+	}
+	err := dt.setState(state)
+	if err != nil {
+		return fmt.Errorf("pickle: build: numpy.dtype: %s", err)
+	}
+	d.stack[len(d.stack)-1] = dt
+	d.updateCells(len(d.stack)-1, dt)
+	return nil
+}
+
+// setState sets dtype properties from its pickled state.
+func (dt *Dtype) setState(state any) error {
+	t, ok := state.(Tuple)
+	if !(ok && len(t) >= 8) {
+		return fmt.Errorf("invalid state %s", Sprint("%#v", state, nil))
+	}
+	byteorder, err := AsString(t[1])
+	if !(err == nil && len(byteorder) == 1 && strings.Contains("<>|=", byteorder)) {
+		return fmt.Errorf("invalid byte order %s", Sprint("%#v", t[1], nil))
+	}
+	dt.ByteOrder = byteorder[0]
+	if dt.ByteOrder == '=' {
+		dt.ByteOrder = '<' // native order of little-endian machines, which are the norm
+	}
+
+	// subarray: (base, shape)
+	if _, none := t[2].(None); !none {
+		sub, ok := t[2].(Tuple)
+		if !(ok && len(sub) == 2) {
+			return fmt.Errorf("invalid subarray %s", Sprint("%#v", t[2], nil))
+		}
+		base, ok := sub[0].(Dtype)
+		if !ok {
+			return fmt.Errorf("subarray: expect dtype; got %T", sub[0])
+		}
+		dt.Base = &base
+		dt.Shape, err = numpyShape(sub[1])
+		if err != nil {
+			return fmt.Errorf("subarray: shape: %s", err)
+		}
+	}
+
+	// structured dtype: names and {name: (dtype, offset[, title])}
+	if _, none := t[3].(None); !none {
+		names, err := ValueOf(t[3]).Elems()
+		if err != nil {
+			return fmt.Errorf("names: %s", err)
+		}
+		fields := map[string]Value{}
+		items, err := ValueOf(t[4]).Items()
+		if err != nil {
+			return fmt.Errorf("fields: %s", err)
+		}
+		for _, item := range items {
+			name, err := AsString(item.Key.Interface())
+			if err != nil {
+				return fmt.Errorf("fields: %s", err)
+			}
+			fields[name] = item.Value
+		}
+
+		dt.Fields = make([]DtypeField, len(names))
+		for i, n := range names {
+			name, err := AsString(n.Interface())
+			if err != nil {
+				return fmt.Errorf("names: %s", err)
+			}
+			f, err := fields[name].Elems()
+			if !(err == nil && (len(f) == 2 || len(f) == 3)) {
+				return fmt.Errorf("field %q: invalid description", name)
+			}
+			ftype, ok := f[0].Interface().(Dtype)
+			if !ok {
+				return fmt.Errorf("field %q: expect dtype; got %T", name, f[0].Interface())
+			}
+			offset, err := numpyInt(f[1].Interface())
+			if err != nil {
+				return fmt.Errorf("field %q: offset: %s", name, err)
+			}
+			dt.Fields[i] = DtypeField{Name: name, Type: ftype, Offset: offset}
+			if len(f) == 3 {
+				dt.Fields[i].Title = f[2].Interface()
+			}
+		}
+	}
+
+	// elsize is -1 for dtypes, whose item size is determined by type string
+	elsize, err := numpyInt(t[5])
+	if err != nil {
+		return fmt.Errorf("elsize: %s", err)
+	}
+	if elsize >= 0 {
+		dt.ItemSize = elsize
+	}
+
+	// datetime metadata: (metadata, (unit, num, 1, 1))
+	if dt.Kind == 'M' || dt.Kind == 'm' {
+		if len(t) < 9 {
+			return fmt.Errorf("no datetime metadata")
+		}
+		meta, ok := t[8].(Tuple)
+		if !(ok && len(meta) == 2) {
+			return fmt.Errorf("invalid datetime metadata %s", Sprint("%#v", t[8], nil))
+		}
+		unit, ok := meta[1].(Tuple)
+		if !(ok && len(unit) >= 2) {
+			return fmt.Errorf("invalid datetime metadata %s", Sprint("%#v", t[8], nil))
+		}
+		var name string
+		switch x := unit[0].(type) {
+		case Bytes:
+			name = string(x)
+		default:
+			name, err = AsString(x)
+		}
+		if err != nil {
+			return fmt.Errorf("datetime unit: %s", err)
+		}
+		num, err := numpyInt(unit[1])
+		if err != nil {
+			return fmt.Errorf("datetime unit: %s", err)
+		}
+		if name != "generic" {
+			dt.Unit = name
+			if num != 1 {
+				dt.Unit = strconv.Itoa(num) + name
+			}
+		}
+	}
+
+	return nil
+}
+
+// numpyInt returns value of integer from numpy state.
+func numpyInt(x any) (int, error) {
+	n, err := AsInt64(x)
+	if err != nil {
+		return 0, err
+	}
+	if int64(int(n)) != n {
+		return 0, fmt.Errorf("%d overflows int", n)
+	}
+	return int(n), nil
+}
+
+// numpyData returns raw data from numpy state.
+//
+// Python 2 pickles the data as str.
+func numpyData(x any) ([]byte, error) {
+	if s, ok := x.(string); ok {
+		return []byte(s), nil
+	}
+	data, err := ValueOf(x).Bytes()
+	return []byte(data), err
+}
+
+// numpyShape returns array shape from numpy state.
+func numpyShape(x any) ([]int, error) {
+	dims, err := ValueOf(x).Elems()
+	if err != nil {
+		return nil, err
+	}
+	shape := make([]int, len(dims))
+	for i, dim := range dims {
+		shape[i], err = numpyInt(dim.Interface())
+		if err != nil {
+			return nil, err
+		}
+	}
+	return shape, nil
+}
+
+// numpyScalar decodes data of numpy scalar of given dtype.
+//
+// Integers and floats are decoded the same way as Python numbers are: into
+// int64, or *big.Int if uint64 value overflows it, and into float64. ok is
+// false for types that are not supported, e.g. complex or datetime64.
+func numpyScalar(dt Dtype, data []byte) (v any, ok bool, err error) {
+	if !strings.ContainsRune("biuf", rune(dt.Kind)) {
+		return nil, false, nil
+	}
+	size := dt.ItemSize
+	if len(data) != size {
+		return nil, false, fmt.Errorf("%s: invalid data size %d", dt, len(data))
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if dt.ByteOrder == '>' {
+		order = binary.BigEndian
+	}
+
+	var u uint64
+	switch size {
+	case 1:
+		u = uint64(data[0])
+	case 2:
+		u = uint64(order.Uint16(data))
+	case 4:
+		u = uint64(order.Uint32(data))
+	case 8:
+		u = order.Uint64(data)
+	default:
+		return nil, false, nil
+	}
+
+	switch dt.Kind {
+	case 'b':
+		if size == 1 {
+			return u != 0, true, nil
+		}
+	case 'i':
+		shift := 64 - 8*size
+		return int64(u<<shift) >> shift, true, nil
+	case 'u':
+		if u > math.MaxInt64 {
+			return new(big.Int).SetUint64(u), true, nil
+		}
+		return int64(u), true, nil
+	case 'f':
+		switch size {
+		case 2:
+			return float16(uint16(u)), true, nil
+		case 4:
+			return float64(math.Float32frombits(uint32(u))), true, nil
+		case 8:
+			return math.Float64frombits(u), true, nil
+		}
+	}
+	return nil, false, nil
+}
+
+// float16 converts IEEE 754 half-precision number to float64.
+func float16(h uint16) float64 {
+	sign := 1.0
+	if h&0x8000 != 0 {
+		sign = -1
+	}
+	exp := int(h>>10) & 0x1f
+	frac := float64(h & 0x3ff)
+	switch exp {
+	case 0:
+		return sign * math.Ldexp(frac, -24)
+	case 0x1f:
+		if frac != 0 {
+			return math.NaN()
+		}
+		return math.Inf(int(sign))
+	}
+	return sign * math.Ldexp(1024+frac, exp-25)
+}
@@ -0,0 +1,210 @@
+package ogórek
+
+import (
+	"math"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// numpyScalarPickle returns pickle of numpy scalar with given dtype type
+// string, byte order and data, as numpy pickles it at protocol 3.
+func numpyScalarPickle(typestr, byteorder, data string) string {
+	return "\x80\x03cnumpy.core.multiarray\nscalar\ncnumpy\ndtype\n" +
+		"X" + string([]byte{byte(len(typestr)), 0, 0, 0}) + typestr + "K\x00K\x01\x87R" +
+		"(K\x03X\x01\x00\x00\x00" + byteorder + "NNNJ\xff\xff\xff\xffJ\xff\xff\xff\xffK\x00tb" +
+		"C" + string([]byte{byte(len(data))}) + data + "\x86R."
+}
+
+func TestNumPyScalarDecode(t *testing.T) {
+	config := &DecoderConfig{NumPy: true}
+	for _, tt := range []struct {
+		data string
+		want any
+	}{
+		// numpy.int64(5) at protocol 2, and numpy.float32(1.5) by numpy 2
+		{"\x80\x02cnumpy.core.multiarray\nscalar\nq\x00cnumpy\ndtype\nq\x01X\x02\x00\x00\x00i8q\x02K\x00K\x01\x87q\x03Rq\x04(K\x03X\x01\x00\x00\x00<q\x05NNNJ\xff\xff\xff\xffJ\xff\xff\xff\xffK\x00tq\x06bc_codecs\nencode\nq\x07X\x08\x00\x00\x00\x05\x00\x00\x00\x00\x00\x00\x00q\x08X\x06\x00\x00\x00latin1q\t\x86q\nRq\x0b\x86q\x0cRq\r.", int64(5)},
+		{"\x80\x04\x8c\x16numpy._core.multiarray\x94\x8c\x06scalar\x94\x93\x94\x8c\x05numpy\x94\x8c\x05dtype\x94\x93\x94\x8c\x02f4\x94\x89\x88\x87\x94R\x94(K\x03\x8c\x01<\x94NNNJ\xff\xff\xff\xffJ\xff\xff\xff\xffK\x00t\x94bC\x04\x00\x00\xc0?\x94\x86\x94R\x94.", 1.5},
+
+		{numpyScalarPickle("b1", "|", "\x01"), true},
+		{numpyScalarPickle("b1", "|", "\x00"), false},
+		{numpyScalarPickle("i1", "|", "\xff"), int64(-1)},
+		{numpyScalarPickle("u1", "|", "\xff"), int64(255)},
+		{numpyScalarPickle("i2", "<", "\xfe\xff"), int64(-2)},
+		{numpyScalarPickle("i2", ">", "\xff\xfe"), int64(-2)},
+		{numpyScalarPickle("u4", "<", "\x01\x02\x03\x04"), int64(0x04030201)},
+		{numpyScalarPickle("i8", ">", "\x80\x00\x00\x00\x00\x00\x00\x00"), int64(math.MinInt64)},
+		{numpyScalarPickle("u8", "<", "\xff\xff\xff\xff\xff\xff\xff\xff"), new(big.Int).SetUint64(math.MaxUint64)},
+		{numpyScalarPickle("f2", "<", "\x00\x3e"), 1.5},
+		{numpyScalarPickle("f2", "<", "\x01\x00"), math.Ldexp(1, -24)},
+		{numpyScalarPickle("f2", "<", "\x00\xfc"), math.Inf(-1)},
+		{numpyScalarPickle("f8", ">", "\x3f\xf8\x00\x00\x00\x00\x00\x00"), 1.5},
+
+		// scalar of object dtype is the object itself
+		{strings.Replace(numpyScalarPickle("O8", "|", ""), "C\x00", "K\x07", 1), int64(7)},
+
+		// unsupported scalars are left as calls
+		{numpyScalarPickle("c8", "<", "\x00\x00\xc0?\x00\x00\x00\x00"), Call{Class{"numpy.core.multiarray", "scalar"},
+			Tuple{Dtype{Kind: 'c', ItemSize: 8, ByteOrder: '<'}, Bytes("\x00\x00\xc0?\x00\x00\x00\x00")}}},
+	} {
+		obj, err := NewDecoderWithConfig(strings.NewReader(tt.data), config).Decode()
+		if err != nil {
+			t.Errorf("%q: %s", tt.data, err)
+			continue
+		}
+		if !reflect.DeepEqual(obj, tt.want) {
+			t.Errorf("%q:\nhave: %#v\nwant: %#v", tt.data, obj, tt.want)
+		}
+	}
+
+	// NaN does not compare equal
+	obj, err := NewDecoderWithConfig(strings.NewReader(numpyScalarPickle("f4", "<", "\x00\x00\xc0\x7f")), config).Decode()
+	if f, ok := obj.(float64); !(err == nil && ok && math.IsNaN(f)) {
+		t.Errorf("float32 NaN: have %#v, %v", obj, err)
+	}
+
+	for _, data := range []string{
+		numpyScalarPickle("i4", "<", "\x01\x02"),
+		"\x80\x03cnumpy.core.multiarray\nscalar\nK\x01C\x01\x00\x86R.",
+		"\x80\x03cnumpy.core.multiarray\nscalar\nK\x01\x85R.",
+	} {
+		_, err := NewDecoderWithConfig(strings.NewReader(data), config).Decode()
+		if err == nil {
+			t.Errorf("%q: no error", data)
+		}
+	}
+
+	// without NumPy scalars are left as calls
+	obj, err = NewDecoder(strings.NewReader(numpyScalarPickle("i1", "|", "\x01"))).Decode()
+	if _, ok := obj.(Call); !(err == nil && ok) {
+		t.Errorf("!NumPy: have %#v, %v", obj, err)
+	}
+}
+
+func TestNumPyDtypeDecode(t *testing.T) {
+	i8 := Dtype{Kind: 'i', ItemSize: 8, ByteOrder: '<'}
+	f8 := Dtype{Kind: 'f', ItemSize: 8, ByteOrder: '<'}
+	for _, tt := range []struct {
+		data string
+		want Dtype
+		str  string
+	}{
+		// dtypes as pickled by numpy at protocol 2
+		{"\x80\x02cnumpy\ndtype\nq\x00X\x02\x00\x00\x00i8q\x01\x89\x88\x87q\x02Rq\x03(K\x03X\x01\x00\x00\x00<q\x04NNNJ\xff\xff\xff\xffJ\xff\xff\xff\xffK\x00tq\x05b.",
+			i8, "<i8"},
+		{"\x80\x02cnumpy\ndtype\nq\x00X\x02\x00\x00\x00f4q\x01\x89\x88\x87q\x02Rq\x03(K\x03X\x01\x00\x00\x00>q\x04NNNJ\xff\xff\xff\xffJ\xff\xff\xff\xffK\x00tq\x05b.",
+			Dtype{Kind: 'f', ItemSize: 4, ByteOrder: '>'}, ">f4"},
+		{"\x80\x02cnumpy\ndtype\nq\x00X\x02\x00\x00\x00b1q\x01\x89\x88\x87q\x02Rq\x03(K\x03X\x01\x00\x00\x00|q\x04NNNJ\xff\xff\xff\xffJ\xff\xff\xff\xffK\x00tq\x05b.",
+			Dtype{Kind: 'b', ItemSize: 1, ByteOrder: '|'}, "|b1"},
+		{"\x80\x02cnumpy\ndtype\nq\x00X\x02\x00\x00\x00U5q\x01\x89\x88\x87q\x02Rq\x03(K\x03X\x01\x00\x00\x00<q\x04NNNK\x14K\x04K\x08tq\x05b.",
+			Dtype{Kind: 'U', ItemSize: 20, ByteOrder: '<'}, "<U5"},
+
+		// datetime64[ns], timedelta64[10s]
+		{"\x80\x02cnumpy\ndtype\nq\x00X\x02\x00\x00\x00M8q\x01\x89\x88\x87q\x02Rq\x03(K\x04X\x01\x00\x00\x00<q\x04NNNJ\xff\xff\xff\xffJ\xff\xff\xff\xffK\x00}q\x05(c_codecs\nencode\nq\x06X\x02\x00\x00\x00nsq\x07X\x06\x00\x00\x00latin1q\x08\x86q\tRq\nK\x01K\x01K\x01tq\x0b\x86q\x0ctq\rb.",
+			Dtype{Kind: 'M', ItemSize: 8, ByteOrder: '<', Unit: "ns"}, "<M8[ns]"},
+		{"\x80\x02cnumpy\ndtype\nq\x00X\x02\x00\x00\x00m8q\x01\x89\x88\x87q\x02Rq\x03(K\x04X\x01\x00\x00\x00<q\x04NNNJ\xff\xff\xff\xffJ\xff\xff\xff\xffK\x00}q\x05(c_codecs\nencode\nq\x06X\x01\x00\x00\x00sq\x07X\x06\x00\x00\x00latin1q\x08\x86q\tRq\nK\nK\x01K\x01tq\x0b\x86q\x0ctq\rb.",
+			Dtype{Kind: 'm', ItemSize: 8, ByteOrder: '<', Unit: "10s"}, "<m8[10s]"},
+
+		// [('a', '<i8'), (('title b', 'b'), '<f8', (2,))]
+		{"\x80\x02cnumpy\ndtype\nq\x00X\x03\x00\x00\x00V24q\x01\x89\x88\x87q\x02Rq\x03(K\x03X\x01\x00\x00\x00|q\x04NX\x01\x00\x00\x00aq\x05X\x01\x00\x00\x00bq\x06\x86q\x07}q\x08(h\x05h\x00X\x02\x00\x00\x00i8q\t\x89\x88\x87q\nRq\x0b(K\x03X\x01\x00\x00\x00<q\x0cNNNJ\xff\xff\xff\xffJ\xff\xff\xff\xffK\x00tq\rbK\x00\x86q\x0eh\x06h\x00X\x03\x00\x00\x00V16q\x0f\x89\x88\x87q\x10Rq\x11(K\x03h\x04h\x00X\x02\x00\x00\x00f8q\x12\x89\x88\x87q\x13Rq\x14(K\x03h\x0cNNNJ\xff\xff\xff\xffJ\xff\xff\xff\xffK\x00tq\x15bK\x02\x85q\x16\x86q\x17NNK\x10K\x08K\x00tq\x18bK\x08X\x07\x00\x00\x00title bq\x19\x87q\x1auK\x18K\x01K\x10tq\x1bb.",
+			Dtype{Kind: 'V', ItemSize: 24, ByteOrder: '|', Fields: []DtypeField{
+				{Name: "a", Type: i8, Offset: 0},
+				{Name: "b", Type: Dtype{Kind: 'V', ItemSize: 16, ByteOrder: '|', Base: &f8, Shape: []int{2}}, Offset: 8, Title: "title b"},
+			}}, "|V24"},
+	} {
+		obj, err := NewDecoderWithConfig(strings.NewReader(tt.data), &DecoderConfig{NumPy: true}).Decode()
+		if err != nil {
+			t.Errorf("%q: %s", tt.data, err)
+			continue
+		}
+		if !reflect.DeepEqual(obj, tt.want) {
+			t.Errorf("%q:\nhave: %#v\nwant: %#v", tt.data, obj, tt.want)
+		}
+		if s := tt.want.String(); s != tt.str {
+			t.Errorf("%#v.String(): have %q  ; want %q", tt.want, s, tt.str)
+		}
+	}
+
+	for _, data := range []string{
+		"cnumpy\ndtype\n(Vx\ntR.",
+		"cnumpy\ndtype\n(Vi8\ntR(I3\nV?\nNNNI-1\nI-1\nI0\ntb.",
+		"cnumpy\ndtype\n(Vi8\ntR(I3\nV<\nNNNI-1\nI-1\nI0\ntb(I3\nV<\nNNNI-1\nI-1\nI0\ntb.",
+		"cnumpy\ndtype\n(VM8\ntR(I3\nV<\nNNNI-1\nI-1\nI0\ntb.",
+		"cnumpy\ndtype\n(VV8\ntR(I3\nV|\nN(Va\ntN(dI8\nI1\nI16\ntb.",
+	} {
+		_, err := NewDecoderWithConfig(strings.NewReader(data), &DecoderConfig{NumPy: true}).Decode()
+		if err == nil {
+			t.Errorf("%q: no error", data)
+		}
+	}
+}
+
+// verify that dtypes and arrays are not encoded as dicts of their Go fields.
+func TestNumPyDtypeEncode(t *testing.T) {
+	i8 := Dtype{Kind: 'i', ItemSize: 8, ByteOrder: '<'}
+	for _, tt := range []struct {
+		obj   any
+		errOk string
+	}{
+		{i8,                          "no support for type 'ogórek.Dtype'"},
+		{&i8,                         "no support for type 'ogórek.Dtype'"},
+		{[]any{DtypeField{Type: i8}}, "pickle: encode: [0]: no support for type 'ogórek.DtypeField'"},
+		{NDArray{Dtype: i8, Shape: []int{0}}, "no support for type 'ogórek.NDArray'"},
+	} {
+		_, err := Marshal(tt.obj)
+		if err == nil || err.Error() != tt.errOk {
+			t.Errorf("%#v:\nhave: %v\nwant: %s", tt.obj, err, tt.errOk)
+		}
+	}
+}
+
+func TestNumPyArrayDecode(t *testing.T) {
+	i8 := Dtype{Kind: 'i', ItemSize: 8, ByteOrder: '<'}
+	i2 := Dtype{Kind: 'i', ItemSize: 2, ByteOrder: '<'}
+	O8 := Dtype{Kind: 'O', ItemSize: 8, ByteOrder: '|'}
+	for _, tt := range []struct {
+		data string
+		want NDArray
+	}{
+		// numpy.arange(6).reshape(2, 3) and numpy.array([1, 'x'], dtype=object) at protocol 2
+		{"\x80\x02cnumpy.core.multiarray\n_reconstruct\nq\x00cnumpy\nndarray\nq\x01K\x00\x85q\x02c_codecs\nencode\nq\x03X\x01\x00\x00\x00bq\x04X\x06\x00\x00\x00latin1q\x05\x86q\x06Rq\x07\x87q\x08Rq\t(K\x01K\x02K\x03\x86q\ncnumpy\ndtype\nq\x0bX\x02\x00\x00\x00i8q\x0c\x89\x88\x87q\rRq\x0e(K\x03X\x01\x00\x00\x00<q\x0fNNNJ\xff\xff\xff\xffJ\xff\xff\xff\xffK\x00tq\x10b\x89h\x03X0\x00\x00\x00\x00\x01\x02\x03\x04\x05\x06\x07\x08\t\n\x0b\x0c\r\x0e\x0f\x10\x11\x12\x13\x14\x15\x16\x17\x18\x19\x1a\x1b\x1c\x1d\x1e\x1f !\"#$%&'()*+,-./q\x11h\x05\x86q\x12Rq\x13tq\x14b.",
+			NDArray{Dtype: i8, Shape: []int{2, 3}, Data: []byte("\x00\x01\x02\x03\x04\x05\x06\x07\x08\t\n\x0b\x0c\r\x0e\x0f\x10\x11\x12\x13\x14\x15\x16\x17\x18\x19\x1a\x1b\x1c\x1d\x1e\x1f !\"#$%&'()*+,-./")}},
+		{"\x80\x02cnumpy.core.multiarray\n_reconstruct\nq\x00cnumpy\nndarray\nq\x01K\x00\x85q\x02c_codecs\nencode\nq\x03X\x01\x00\x00\x00bq\x04X\x06\x00\x00\x00latin1q\x05\x86q\x06Rq\x07\x87q\x08Rq\t(K\x01K\x02\x85q\ncnumpy\ndtype\nq\x0bX\x02\x00\x00\x00O8q\x0c\x89\x88\x87q\rRq\x0e(K\x03X\x01\x00\x00\x00|q\x0fNNNJ\xff\xff\xff\xffJ\xff\xff\xff\xffK?tq\x10b\x89]q\x11(K\x01X\x01\x00\x00\x00xq\x12etq\x13b.",
+			NDArray{Dtype: O8, Shape: []int{2}, Objects: []any{int64(1), "x"}}},
+
+		// numpy.array([1, 2], dtype='i2') by Python 2 at protocol 0
+		{"cnumpy.core.multiarray\n_reconstruct\np0\n(cnumpy\nndarray\np1\n(I0\ntp2\nS'b'\np3\ntp4\nRp5\n(I1\n(I2\ntp6\ncnumpy\ndtype\np7\n(S'i2'\np8\nI0\nI1\ntp9\nRp10\n(I3\nS'<'\np11\nNNNI-1\nI-1\nI0\ntp12\nbI00\nS'\\x01\\x00\\x02\\x00'\np13\ntp14\nb.",
+			NDArray{Dtype: i2, Shape: []int{2}, Data: []byte("\x01\x00\x02\x00")}},
+
+		// numpy.array([[1, 2], [3, 4]], dtype='i2', order='F') at protocol 5
+		{"\x80\x05\x95v\x00\x00\x00\x00\x00\x00\x00\x8c\x12numpy.core.numeric\x94\x8c\x0b_frombuffer\x94\x93\x94(C\x08\x01\x00\x02\x00\x03\x00\x04\x00\x94\x8c\x05numpy\x94\x8c\x05dtype\x94\x93\x94\x8c\x02i2\x94\x89\x88\x87\x94R\x94(K\x03\x8c\x01<\x94NNNJ\xff\xff\xff\xffJ\xff\xff\xff\xffK\x00t\x94bK\x02K\x02\x86\x94\x8c\x01F\x94t\x94R\x94.",
+			NDArray{Dtype: i2, Shape: []int{2, 2}, FortranOrder: true, Data: []byte("\x01\x00\x02\x00\x03\x00\x04\x00")}},
+	} {
+		obj, err := NewDecoderWithConfig(strings.NewReader(tt.data), &DecoderConfig{NumPy: true}).Decode()
+		if err != nil {
+			t.Errorf("%q: %s", tt.data, err)
+			continue
+		}
+		if !reflect.DeepEqual(obj, tt.want) {
+			t.Errorf("%q:\nhave: %#v\nwant: %#v", tt.data, obj, tt.want)
+		}
+	}
+
+	if n := (NDArray{Shape: []int{2, 3}}).Size(); n != 6 {
+		t.Errorf("Size: have %d  ; want 6", n)
+	}
+
+	for _, data := range []string{
+		// data size does not match shape, not a dtype, overflowing shape
+		"cnumpy.core.multiarray\n_reconstruct\n(cnumpy\nndarray\n(I0\ntS'b'\ntR(I1\n(I3\ntcnumpy\ndtype\n(S'i2'\nI0\nI1\ntR(I3\nS'<'\nNNNI-1\nI-1\nI0\ntbI00\nS'\\x01\\x00\\x02\\x00'\ntb.",
+		"cnumpy.core.multiarray\n_reconstruct\n(cnumpy\nndarray\n(I0\ntS'b'\ntR(I1\n(I2\ntI0\nI00\nS'\\x01\\x00\\x02\\x00'\ntb.",
+		"cnumpy.core.multiarray\n_reconstruct\n(cnumpy\nndarray\n(I0\ntS'b'\ntR(I1\n(I4294967296\nI4294967296\nI4294967296\ntcnumpy\ndtype\n(S'i2'\nI0\nI1\ntR(I3\nS'<'\nNNNI-1\nI-1\nI0\ntbI00\nS''\ntb.",
+		"cnumpy.core.numeric\n_frombuffer\n(S'\\x01\\x00'\ncnumpy\ndtype\n(S'i2'\nI0\nI1\ntR(I3\nS'<'\nNNNI-1\nI-1\nI0\ntb(I1\ntS'X'\ntR.",
+	} {
+		_, err := NewDecoderWithConfig(strings.NewReader(data), &DecoderConfig{NumPy: true}).Decode()
+		if err == nil {
+			t.Errorf("%q: no error", data)
+		}
+	}
+}
@@ -129,6 +129,11 @@ type None struct{}
 // Tuple is a representation of Python's tuple.
 type Tuple []any
 
+// KV is a key/value pair, for example an item of Python's dict.
+type KV struct {
+	Key, Value any
+}
+
 // Bytes represents Python's bytes.
 type Bytes string
 
@@ -137,15 +142,37 @@ type Bytes string
 // See StrictUnicode mode documentation in top-level package overview for details.
 type ByteString string
 
-// make Bytes, ByteString and unicode to be represented by %#v distinctly from string
-// (without GoString %#v emits just "..." for all string, Bytes and unicode)
+// PickleBuffer represents Python's pickle.PickleBuffer.
+//
+// When pickled in-band, Python emits the data of a PickleBuffer directly, and
+// so the decoder never produces PickleBuffer: read-only buffers are decoded as
+// [Bytes], and writable buffers as []byte. PickleBuffer is provided for the
+// encoder to be able to emit such pickles.
+type PickleBuffer struct {
+	Data     []byte
+	ReadOnly bool
+}
+
+// MemoryView represents Python's memoryview over bytes or bytearray.
+//
+// The decoder unwraps memoryview(bytes|bytearray) calls into [Bytes] or []byte
+// correspondingly, and so never produces MemoryView. MemoryView is provided for
+// the encoder to emit memoryview(bytes|bytearray) call, with the data passed
+// as in-band buffer for protocol ≥ 5.
+type MemoryView struct {
+	Data     []byte
+	ReadOnly bool
+}
+
+// make Bytes, ByteString and Unicode to be represented by %#v distinctly from string
+// (without GoString %#v emits just "..." for all string, Bytes and Unicode)
 func (v Bytes) GoString() string {
 	return fmt.Sprintf("%T(%#v)", v, string(v))
 }
 func (v ByteString) GoString() string {
 	return fmt.Sprintf("%T(%#v)", v, string(v))
 }
-func (v unicode) GoString() string {
+func (v Unicode) GoString() string {
 	return fmt.Sprintf("%T(%#v)", v, string(v))
 }
 
@@ -153,6 +180,7 @@ func (v unicode) GoString() string {
 // Decoder is a decoder for pickle streams.
 type Decoder struct {
 	r      *bufio.Reader
+	rc     *countReader // underlying reader of r
 	config *DecoderConfig
 	stack  []any
 	memo   map[string]any
@@ -166,6 +194,75 @@ type Decoder struct {
 
 	// protocol version seen in last PROTO opcode; 0 by default.
 	protocol int
+
+	// end position of current frame; -1 if we are not inside a frame.
+	// only maintained in StrictFrames mode.
+	frameEnd int64
+
+	// statistics about last Decode call
+	stats DecoderStats
+
+	// table of interned strings; used in InternStrings mode
+	internTab map[string]string
+
+	// position of currently handled opcode
+	opPos int64
+
+	// problems recovered from during last Decode in Lenient mode
+	warnings []DecodeWarning
+
+	// whole input for decoders created by NewDecoderBytes; nil otherwise
+	input []byte
+
+	// logical size of objects loaded from memo during current Decode, in
+	// excess of 1 object per load, of objects duplicated by DUP, and of
+	// nested pickles of joblib dumps; only maintained with MaxExpandedSize.
+	expanded int64
+
+	// start position of last Decode
+	start int64
+
+	// index of next out-of-band buffer in config.Buffers
+	nextBuffer int
+
+	// memo cells of containers, that are still on the stack and might be
+	// modified by replacing their stack entry; ordered by stack slot.
+	cells []*memoCell
+
+	// whether array data follows joblib array wrappers; see NewJoblibDecoder
+	joblib bool
+}
+
+// memoCell is memo entry of a container, that is represented by value, e.g.
+// []any, and that is modified after it was memoized, e.g. by APPEND.
+//
+// While the container is on the stack, the cell is updated together with
+// the container's stack entry, so that memo references to the container,
+// that come after the modifications, see the modified value.
+type memoCell struct {
+	v    any
+	slot int // stack slot of the container
+}
+
+// DecodeWarning represents a problem that decoder recovered from in Lenient mode.
+type DecodeWarning struct {
+	Pos int64 // position of the opcode that caused the problem
+	Err error
+}
+
+func (w DecodeWarning) Error() string {
+	return fmt.Sprintf("position %d: %s", w.Pos, w.Err)
+}
+
+// DecoderStats represents statistics about decoding of one pickle.
+//
+// See [Decoder.Stats] for details.
+type DecoderStats struct {
+	Bytes    int64 // number of bytes consumed from input stream
+	Opcodes  int64 // number of executed opcodes
+	Objects  int64 // number of objects pushed onto decoder stack, including those loaded from memo
+	MemoSize int   // number of entries in decoder memo
+	MaxDepth int   // maximum depth of decoder stack
 }
 
 // DecoderConfig allows to tune [Decoder].
@@ -194,6 +291,244 @@ type DecoderConfig struct {
 	// instead of builtin map. See PyDict mode documentation in top-level
 	// package overview for details.
 	PyDict bool
+
+	// DictAsItems, when true, requests to decode Python dicts as []KV
+	// with key/value pairs in the order they appear in the pickle.
+	//
+	// Keys are not hashed nor compared in this mode, and so any key type
+	// is accepted and duplicate keys are preserved. This is useful for
+	// auditing and transcoding. DictAsItems takes precedence over PyDict.
+	DictAsItems bool
+
+	// StrictFrames, when true, requests the decoder to verify consistency
+	// of FRAME opcodes with the data that follows them.
+	//
+	// In this mode it is an error if an opcode runs past the end of current
+	// frame, if a new frame starts before the previous one is finished, or
+	// if the pickle stops before its last frame ends. Frames, that are
+	// declared longer than the remaining input, are reported right at
+	// FRAME opcode, if the decoder can see that upfront.
+	StrictFrames bool
+
+	// Trace, if !nil, is called by decoder for every opcode it handles.
+	//
+	// It is passed the opcode, its byte position in the input stream and
+	// the depth of decoder stack before the opcode is executed. Trace can
+	// be used for debugging, e.g. to log the sequence of decoded opcodes.
+	Trace func(op byte, pos int, stackDepth int)
+
+	// JSONSafe, when true, requests the decoder to return only values
+	// composed of nil, bool, int64, float64, string, []any and
+	// map[string]any. Pickles with objects that cannot be represented this
+	// way fail to decode. See [AsJSONSafe] for details of the conversion.
+	JSONSafe bool
+
+	// NumbersAsFloat, when true, requests the decoder to decode all Python
+	// numbers - int, long and float - as float64, similarly to JSON. Bools
+	// are still decoded as bool.
+	//
+	// Integers, that float64 cannot represent exactly, are converted according
+	// to FloatPolicy, and decoding fails if the policy does not allow the
+	// conversion. Note that distinct int keys of a dict can collide after
+	// conversion; the last entry wins in such case.
+	NumbersAsFloat bool
+	FloatPolicy    FloatPolicy
+
+	// InternStrings, when true, requests the decoder to make equal small
+	// strings, that it decodes, share the same storage. This reduces memory
+	// usage when decoding pickles with many repeated strings, for example
+	// dict keys, that were not memoized by the producer.
+	//
+	// The intern table is maintained for the whole lifetime of the decoder.
+	InternStrings bool
+
+	// Lenient, when true, requests the decoder to recover from problems
+	// that do not prevent decoding of the rest of the pickle, instead of
+	// failing the whole decode. Such problems are recorded as warnings
+	// available via [Decoder.Warnings] and the decoder substitutes a
+	// placeholder for the problematic value:
+	//
+	//	- a call that failed to be handled is left as Call,
+	//	- a reference that PersistentLoad failed to load is left as Ref,
+	//	- a dict item with key of invalid type is dropped,
+	//	- an extension code missing from ExtensionRegistry is loaded as
+	//	  Class{"copyreg", "ext<code>"}.
+	//
+	// The warnings are located by position of the problematic opcode in the
+	// input, not by path in the decoded object, which is not yet assembled
+	// when the problem is detected.
+	//
+	// This mode is useful for forensic and data recovery purposes.
+	Lenient bool
+
+	// OnUnknownOpcode, if !nil, is called by decoder when it sees an
+	// opcode it does not know.
+	//
+	// The hook is given the opcode and the reader of the input stream
+	// positioned right after the opcode. It should consume the opcode
+	// argument from r, if the opcode has one, and return nil for the
+	// decoding to continue as if the opcode was not present. If the hook
+	// returns an error, decoding is aborted with that error.
+	//
+	// This allows to tolerate pickles from newer producers that use
+	// opcodes not yet supported by ogórek.
+	OnUnknownOpcode func(op byte, r io.Reader) error
+
+	// OpcodeHandlers, if !nil, registers handlers for custom opcodes.
+	//
+	// When the decoder sees an opcode with registered handler, it calls the
+	// handler instead of decoding the opcode by itself. The handler should
+	// consume the opcode argument, if the opcode has one, and update the
+	// stack to reflect the effect of the opcode via [DecoderState]. If the
+	// handler returns an error, decoding is aborted with that error.
+	//
+	// This allows to support in-house pickle extensions and experimental
+	// opcodes without forking ogórek. Registered handlers take precedence
+	// over builtin decoding of the opcodes and over OnUnknownOpcode. nil
+	// handlers are ignored.
+	OpcodeHandlers map[byte]func(s *DecoderState) error
+
+	// ExtensionRegistry, if !nil, maps extension codes to classes.
+	//
+	// It mirrors copyreg extension registry of Python, via which pickles
+	// can refer to frequently used classes with EXT1, EXT2 and EXT4 opcodes
+	// instead of full module and class names. The decoder resolves the
+	// codes to classes as if they were referred to via GLOBAL. It is an
+	// error if a pickle uses code, that is not in the registry.
+	ExtensionRegistry map[int]Class
+
+	// CloudPickle, when true, requests the decoder to recognize calls to
+	// constructors of cloudpickle, with which it serializes dynamic
+	// functions, classes and modules. Such calls are decoded into *Function,
+	// *DynamicClass, *Cell and Module instead of nested Call objects.
+	CloudPickle bool
+
+	// Buffers, if !nil, provides out-of-band buffers for pickles of
+	// protocol 5, that were produced with buffer_callback on Python side.
+	//
+	// It mirrors buffers argument of pickle.loads: every NEXT_BUFFER opcode
+	// takes next buffer from Buffers. The buffers are consumed in order for
+	// the whole lifetime of the decoder. Writable buffers are decoded as
+	// []byte referencing the buffer data directly, while buffers marked as
+	// read-only are decoded as [Bytes], similarly to in-band buffers.
+	Buffers [][]byte
+
+	// ZeroCopy, when true, requests decoders created by [NewDecoderBytes]
+	// to not copy data of bytearrays emitted with BYTEARRAY8 opcode.
+	// Instead the resulting []byte references the input buffer directly.
+	//
+	// This avoids second copy of large binary payloads, but the caller
+	// must not modify the input while decoded objects are in use, and
+	// modifying decoded bytearray modifies the input. The option has no
+	// effect for decoders created with io.Reader input.
+	ZeroCopy bool
+
+	// BytesAsSlice, when true, requests the decoder to decode Python bytes
+	// as []byte instead of [Bytes]. This is useful for consumers that want
+	// to process the data in place, e.g. to decrypt or decompress it.
+	//
+	// Note that in this mode bytes and bytearray decode to the same Go
+	// type, and that []byte encodes back as bytearray. Bytes used as dict
+	// keys are still decoded as [Bytes], because []byte cannot be used as
+	// a map key.
+	BytesAsSlice bool
+
+	// MaxExpandedSize, if > 0, limits logical size of decoded data.
+	//
+	// The logical size is the number of objects in decoded data as if
+	// every reference to memoized or DUP-ed object was a separate copy of
+	// it. A
+	// small pickle can memoize a container and reference it many times
+	// from other memoized containers, producing data with exponentially
+	// huge logical size ("billion laughs"). Consumers that traverse
+	// decoded data, e.g. to convert it to JSON, are then overwhelmed.
+	//
+	// With this limit decoding fails as soon as the logical size exceeds
+	// it. The work spent on the accounting is bounded by the limit itself.
+	MaxExpandedSize int64
+
+	// MaxLineLength, if > 0, limits length of lines, that are used as
+	// arguments by text opcodes of protocol 0, e.g. INT, STRING or GLOBAL.
+	//
+	// Without the limit the decoder accumulates whole line in memory,
+	// and so a malicious stream, e.g. INT with gigabytes of digits, can
+	// make it run out of memory.
+	MaxLineLength int
+
+	// NetIP, when true, requests the decoder to decode objects of Python
+	// ipaddress module into netip types: addresses into netip.Addr, and
+	// networks and interfaces into netip.Prefix. Prefixes of networks are
+	// masked, while prefixes of interfaces retain host bits.
+	NetIP bool
+
+	// DateTime, when true, requests the decoder to decode objects of Python
+	// datetime module into Go types: dates into [Date], times into [Time],
+	// and datetimes into [DateTime]. Time zones - datetime.timezone, as
+	// well as zoneinfo.ZoneInfo and pytz zones - are decoded into
+	// *time.Location.
+	DateTime bool
+
+	// Exceptions, when true, requests the decoder to decode instances of
+	// Python builtin exception classes, e.g. ValueError, into [Exception].
+	// Application exception classes can be registered for this via
+	// [TypeRegistry.RegisterException].
+	Exceptions bool
+
+	// Arrays, when true, requests the decoder to decode numeric arrays of
+	// Python array module into Go slices of corresponding types, e.g.
+	// array('i') into []int32 and array('d') into []float64. The type is
+	// determined by size of the array items as recorded in the pickle, and
+	// arrays of C long, pickled at protocol < 3, are decoded into []int64.
+	// Unicode arrays are left as calls.
+	Arrays bool
+
+	// NumPy, when true, requests the decoder to decode numpy scalars, e.g.
+	// numpy.int32(5) or numpy.float32(1.5), into Go values the same way as
+	// Python numbers are decoded: booleans into bool, integers into int64,
+	// or *big.Int if uint64 value does not fit, and floats into float64.
+	// Scalars of other types, e.g. complex or datetime64, are left as calls.
+	// numpy dtypes are decoded into [Dtype], and arrays into [NDArray].
+	NumPy bool
+
+	// RawCalls, when true, requests the decoder to not apply built-in
+	// handling of calls, that e.g. converts _codecs.encode(..., 'latin1')
+	// into Bytes and bytearray(...) into []byte, and to always return such
+	// calls as Call. This is useful for transcoders that must preserve the
+	// structure of original pickle. Calls of explicitly enabled modes,
+	// e.g. NetIP, are still handled.
+	RawCalls bool
+
+	// CallHandlers, if !nil, maps classes to functions, that translate calls
+	// of those classes, e.g. myapp.Money('1.50', 'EUR'), into Go values
+	// during decoding. A handler is invoked with arguments of the call, and
+	// the value it returns replaces the call in the decoded object. An error
+	// returned by the handler fails the decoding.
+	//
+	// Handlers take precedence over built-in handling of calls, and are
+	// applied in RawCalls mode as well. They are consulted only for calls,
+	// i.e. for REDUCE, INST and OBJ opcodes, and not for objects created via
+	// cls.__new__, for which [TypeRegistry] can be used. nil handlers are
+	// ignored.
+	CallHandlers map[Class]func(args Tuple) (any, error)
+
+	// Types, if !nil, requests the decoder to create instances of classes
+	// registered there as values of corresponding Go types instead of
+	// Object and Call. See [TypeRegistry] for details.
+	Types *TypeRegistry
+
+	// PreserveRefs, when true, requests the decoder to preserve identity
+	// of objects referenced via memo: all references to the same Python
+	// object decode to the same Go object, so that aliasing of shared
+	// sub-objects is kept. For this lists are decoded as *[]any, and
+	// objects as *[Object], instead of []any and Object. Dicts and sets
+	// are shared as they are. Dicts decoded with DictAsItems are not
+	// shared.
+	//
+	// PreserveRefs also allows to decode recursive structures, e.g. list
+	// that contains itself, which cannot be represented with []any values.
+	// Without PreserveRefs a list or object, referenced from inside itself,
+	// is seen there as it was at the time of the reference, e.g. empty.
+	PreserveRefs bool
 }
 
 // NewDecoder returns a new [Decoder] with the default configuration.
@@ -207,9 +542,11 @@ func NewDecoder(r io.Reader) *Decoder {
 //
 // config must not be nil.
 func NewDecoderWithConfig(r io.Reader, config *DecoderConfig) *Decoder {
-	reader := bufio.NewReader(r)
+	rc := &countReader{r: r}
+	reader := bufio.NewReader(rc)
 	return &Decoder{
 		r:        reader,
+		rc:       rc,
 		config:   config,
 		stack:    make([]any, 0),
 		memo:     make(map[string]any),
@@ -217,152 +554,78 @@ func NewDecoderWithConfig(r io.Reader, config *DecoderConfig) *Decoder {
 	}
 }
 
+// NewDecoderBytes returns a new [Decoder] with the specified configuration,
+// that decodes the pickle stream from in-memory data.
+//
+// config must not be nil. See [DecoderConfig.ZeroCopy] for how decoded
+// objects can reference data.
+func NewDecoderBytes(data []byte, config *DecoderConfig) *Decoder {
+	d := NewDecoderWithConfig(bytes.NewReader(data), config)
+	d.input = data
+	return d
+}
+
 // Decode decodes the pickle stream and returns the result or an error.
 func (d *Decoder) Decode() (any, error) {
+	d.stats = DecoderStats{}
+	d.warnings = nil
+	d.expanded = 0
+	start := d.pos()
+	d.start = start
+	defer func() {
+		d.stats.Bytes = d.pos() - start
+		d.stats.MemoSize = len(d.memo)
+	}()
 
 	insn := 0
-loop:
+	d.frameEnd = -1
 	for {
 		key, err := d.r.ReadByte()
 		if err != nil {
 			if err == io.EOF && insn != 0 {
-				err = io.ErrUnexpectedEOF
+				err = d.errUnexpectedEOF()
 			}
 			return nil, err
 		}
 
 		insn++
+		d.stats.Opcodes++
+		d.opPos = d.pos() - 1
 
-		switch key {
-		case opMark:
-			d.mark()
-		case opStop:
-			break loop
-		case opPop:
-			_, err = d.pop()
-		case opPopMark:
-			d.popMark()
-		case opDup:
-			err = d.dup()
-		case opFloat:
-			err = d.loadFloat()
-		case opInt:
-			err = d.loadInt()
-		case opBinint:
-			err = d.loadBinInt()
-		case opBinint1:
-			err = d.loadBinInt1()
-		case opLong:
-			err = d.loadLong()
-		case opBinint2:
-			err = d.loadBinInt2()
-		case opNone:
-			err = d.loadNone()
-		case opPersid:
-			err = d.loadPersid()
-		case opBinpersid:
-			err = d.loadBinPersid()
-		case opReduce:
-			err = d.reduce()
-		case opString:
-			err = d.loadString()
-		case opBinstring:
-			err = d.loadBinString()
-		case opShortBinstring:
-			err = d.loadShortBinString()
-		case opUnicode:
-			err = d.loadUnicode()
-		case opBinunicode:
-			err = d.loadBinUnicode()
-		case opAppend:
-			err = d.loadAppend()
-		case opBuild:
-			err = d.build()
-		case opGlobal:
-			err = d.global()
-		case opDict:
-			err = d.loadDict()
-		case opEmptyDict:
-			err = d.loadEmptyDict()
-		case opAppends:
-			err = d.loadAppends()
-		case opGet:
-			err = d.get()
-		case opBinget:
-			err = d.binGet()
-		case opInst:
-			err = d.inst()
-		case opLong1:
-			err = d.loadLong1()
-		case opNewfalse:
-			err = d.loadBool(false)
-		case opNewtrue:
-			err = d.loadBool(true)
-		case opLongBinget:
-			err = d.longBinGet()
-		case opList:
-			err = d.loadList()
-		case opEmptyList:
-			d.push([]any{})
-		case opObj:
-			err = d.obj()
-		case opPut:
-			err = d.loadPut()
-		case opBinput:
-			err = d.binPut()
-		case opLongBinput:
-			err = d.longBinPut()
-		case opSetitem:
-			err = d.loadSetItem()
-		case opTuple:
-			err = d.loadTuple()
-		case opTuple1:
-			err = d.loadTuple1()
-		case opTuple2:
-			err = d.loadTuple2()
-		case opTuple3:
-			err = d.loadTuple3()
-		case opEmptyTuple:
-			d.push(Tuple{})
-		case opSetitems:
-			err = d.loadSetItems()
-		case opBinfloat:
-			err = d.binFloat()
-		case opBinbytes:
-			err = d.loadBinBytes()
-		case opShortBinbytes:
-			err = d.loadShortBinBytes()
-		case opFrame:
-			err = d.loadFrame()
-		case opShortBinUnicode:
-			err = d.loadShortBinUnicode()
-		case opStackGlobal:
-			err = d.stackGlobal()
-		case opMemoize:
-			err = d.loadMemoize()
-		case opBytearray8:
-			err = d.loadBytearray8()
-		case opNextBuffer:
-			err = d.loadNextBuffer()
-		case opReadOnlyBuffer:
-			err = d.readOnlyBuffer()
-		case opProto:
-			var v byte
-			v, err = d.r.ReadByte()
-			if err == nil && !(0 <= v && v <= 5) {
-				// We support protocol opcodes for up to protocol 5.
-				//
-				// The PROTO opcode documentation says protocol version must be in [2, 256).
-				// However CPython also loads PROTO with version 0 and 1 without error.
-				// So we allow all supported versions as PROTO argument.
-				err = ErrInvalidPickleVersion
-			}
-			if err == nil {
-				d.protocol = int(v)
+		if trace := d.config.Trace; trace != nil {
+			trace(key, int(d.opPos), len(d.stack))
+		}
+
+		// the map lookup is not free, and most decoders have no custom handlers
+		var custom func(s *DecoderState) error
+		if d.config.OpcodeHandlers != nil {
+			custom = d.config.OpcodeHandlers[key]
+		}
+		if custom != nil {
+			err = custom(&DecoderState{d})
+		} else if h := dispatch[key]; h != nil {
+			err = h(d)
+		} else {
+			hook := d.config.OnUnknownOpcode
+			if hook == nil {
+				return nil, OpcodeError{key, insn}
 			}
+			err = hook(key, d.r)
+		}
+		if err == errStop {
+			break
+		}
 
-		default:
-			return nil, OpcodeError{key, insn}
+		if err == nil && key != opFrame {
+			err = d.frameCheck()
+		}
+
+		if max := d.config.MaxExpandedSize; err == nil && max > 0 && d.stats.Objects + d.expanded > max {
+			err = errExpandedSize(max)
+		}
+
+		if l := len(d.stack); l > d.stats.MaxDepth {
+			d.stats.MaxDepth = l
 		}
 
 		if err != nil {
@@ -371,13 +634,200 @@ loop:
 			}
 			// EOF from individual opcode decoder is unexpected end of stream
 			if err == io.EOF {
-				err = io.ErrUnexpectedEOF
+				err = d.errUnexpectedEOF()
 			}
 			return nil, err
 		}
 	}
 
-	return d.popUser()
+	v, err := d.popUser()
+	if err == nil && d.config.NumbersAsFloat {
+		v, err = asFloatNumbers(v, d.config.FloatPolicy)
+		if err != nil {
+			v = nil
+		}
+	}
+	if err == nil && d.config.JSONSafe {
+		v, err = AsJSONSafe(v)
+		if err != nil {
+			v = nil
+		}
+	}
+	return v, err
+}
+
+// errExpandedSize returns error about exceeding MaxExpandedSize.
+func errExpandedSize(max int64) error {
+	return fmt.Errorf("pickle: expanded size of decoded data exceeds limit %d", max)
+}
+
+// errStop is internal error via which STOP handler signals the end of pickle.
+var errStop = errors.New("pickle: stop")
+
+// dispatch maps opcodes to their handlers.
+//
+// Opcodes without handler are unknown to the decoder.
+var dispatch [256]func(d *Decoder) error
+
+func init() {
+	dispatch = [256]func(d *Decoder) error{
+		opMark:            func(d *Decoder) error { d.mark(); return nil },
+		opStop:            (*Decoder).loadStop,
+		opPop:             func(d *Decoder) error { _, err := d.pop(); return err },
+		opPopMark:         (*Decoder).popMark,
+		opDup:             (*Decoder).dup,
+		opFloat:           (*Decoder).loadFloat,
+		opInt:             (*Decoder).loadInt,
+		opBinint:          (*Decoder).loadBinInt,
+		opBinint1:         (*Decoder).loadBinInt1,
+		opLong:            (*Decoder).loadLong,
+		opBinint2:         (*Decoder).loadBinInt2,
+		opNone:            (*Decoder).loadNone,
+		opPersid:          (*Decoder).loadPersid,
+		opBinpersid:       (*Decoder).loadBinPersid,
+		opReduce:          (*Decoder).reduce,
+		opString:          (*Decoder).loadString,
+		opBinstring:       (*Decoder).loadBinString,
+		opShortBinstring:  (*Decoder).loadShortBinString,
+		opUnicode:         (*Decoder).loadUnicode,
+		opBinunicode:      (*Decoder).loadBinUnicode,
+		opBinunicode8:     (*Decoder).loadBinUnicode8,
+		opAppend:          (*Decoder).loadAppend,
+		opBuild:           (*Decoder).build,
+		opGlobal:          (*Decoder).global,
+		opDict:            (*Decoder).loadDict,
+		opEmptyDict:       (*Decoder).loadEmptyDict,
+		opAppends:         (*Decoder).loadAppends,
+		opGet:             (*Decoder).get,
+		opBinget:          (*Decoder).binGet,
+		opInst:            (*Decoder).inst,
+		opLong1:           (*Decoder).loadLong1,
+		opNewfalse:        func(d *Decoder) error { return d.loadBool(false) },
+		opNewtrue:         func(d *Decoder) error { return d.loadBool(true) },
+		opLongBinget:      (*Decoder).longBinGet,
+		opList:            (*Decoder).loadList,
+		opEmptyList:       func(d *Decoder) error { d.pushList([]any{}); return nil },
+		opObj:             (*Decoder).obj,
+		opNewobj:          (*Decoder).newobj,
+		opNewobjEx:        (*Decoder).newobjEx,
+		opPut:             (*Decoder).loadPut,
+		opBinput:          (*Decoder).binPut,
+		opLongBinput:      (*Decoder).longBinPut,
+		opSetitem:         (*Decoder).loadSetItem,
+		opTuple:           (*Decoder).loadTuple,
+		opTuple1:          (*Decoder).loadTuple1,
+		opTuple2:          (*Decoder).loadTuple2,
+		opTuple3:          (*Decoder).loadTuple3,
+		opEmptyTuple:      func(d *Decoder) error { d.push(Tuple{}); return nil },
+		opSetitems:        (*Decoder).loadSetItems,
+		opBinfloat:        (*Decoder).binFloat,
+		opBinbytes:        (*Decoder).loadBinBytes,
+		opBinbytes8:       (*Decoder).loadBinBytes8,
+		opShortBinbytes:   (*Decoder).loadShortBinBytes,
+		opFrame:           (*Decoder).loadFrame,
+		opShortBinUnicode: (*Decoder).loadShortBinUnicode,
+		opStackGlobal:     (*Decoder).stackGlobal,
+		opMemoize:         (*Decoder).loadMemoize,
+		opBytearray8:      (*Decoder).loadBytearray8,
+		opNextBuffer:      (*Decoder).loadNextBuffer,
+		opReadOnlyBuffer:  (*Decoder).readOnlyBuffer,
+		opProto:           (*Decoder).loadProto,
+		opEmptySet:        func(d *Decoder) error { d.push(NewSet()); return nil },
+		opAddItems:        (*Decoder).loadAddItems,
+		opFrozenSet:       (*Decoder).loadFrozenSet,
+		opExt1:            func(d *Decoder) error { return d.loadExt(1) },
+		opExt2:            func(d *Decoder) error { return d.loadExt(2) },
+		opExt4:            func(d *Decoder) error { return d.loadExt(4) },
+	}
+}
+
+// errUnexpectedEOF returns error for input that ends in the middle of a pickle.
+//
+// In StrictFrames mode the error tells how much data of current frame is missing.
+func (d *Decoder) errUnexpectedEOF() error {
+	if d.config.StrictFrames && d.frameEnd >= 0 {
+		return fmt.Errorf("pickle: frame: data ends %d bytes before frame end: %w", d.frameEnd - d.pos(), io.ErrUnexpectedEOF)
+	}
+	return io.ErrUnexpectedEOF
+}
+
+// loadStop handles STOP opcode.
+func (d *Decoder) loadStop() error {
+	err := d.frameCheck()
+	if err == nil && d.frameEnd >= 0 {
+		err = fmt.Errorf("pickle: frame: pickle stops %d bytes before frame end", d.frameEnd - d.pos())
+	}
+	if err == nil {
+		err = errStop
+	}
+	return err
+}
+
+// loadProto handles PROTO opcode.
+func (d *Decoder) loadProto() error {
+	v, err := d.r.ReadByte()
+	if err != nil {
+		return err
+	}
+	if !(0 <= v && v <= 5) {
+		// We support protocol opcodes for up to protocol 5.
+		//
+		// The PROTO opcode documentation says protocol version must be in [2, 256).
+		// However CPython also loads PROTO with version 0 and 1 without error.
+		// So we allow all supported versions as PROTO argument.
+		return ErrInvalidPickleVersion
+	}
+	d.protocol = int(v)
+	return nil
+}
+
+// DecodeN is like Decode, but also returns the number of bytes consumed from the input stream.
+func (d *Decoder) DecodeN() (any, int64, error) {
+	v, err := d.Decode()
+	return v, d.stats.Bytes, err
+}
+
+// Stats returns statistics about the last Decode call.
+//
+// It can be used by applications to e.g. export metrics about decoding
+// and to spot pathological inputs.
+func (d *Decoder) Stats() DecoderStats {
+	return d.stats
+}
+
+// Warnings returns problems recovered from during the last Decode call in Lenient mode.
+func (d *Decoder) Warnings() []DecodeWarning {
+	return d.warnings
+}
+
+// warn records err as warning if decoder is in Lenient mode.
+//
+// It returns whether err was recorded. If not, the caller should fail with err.
+func (d *Decoder) warn(err error) bool {
+	if !d.config.Lenient {
+		return false
+	}
+	d.warnings = append(d.warnings, DecodeWarning{Pos: d.opPos, Err: err})
+	return true
+}
+
+// countReader is io.Reader wrapper that counts how many bytes were read.
+type countReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+// pos returns current position in the input stream.
+//
+// It is the number of bytes consumed by the decoder so far.
+func (d *Decoder) pos() int64 {
+	return d.rc.n - int64(d.r.Buffered())
 }
 
 // readLine reads next line from pickle stream.
@@ -394,6 +844,15 @@ func (d *Decoder) readLine() ([]byte, error) {
 		data, err = d.r.ReadSlice('\n')
 		d.line = append(d.line, data...)
 
+		// the limit does not include trailing \n
+		l := len(d.line)
+		if l > 0 && d.line[l-1] == '\n' {
+			l--
+		}
+		if max := d.config.MaxLineLength; max > 0 && l > max {
+			return nil, fmt.Errorf("pickle: line longer than %d bytes", max)
+		}
+
 		// either have read till \n or got another error
 		if err != bufio.ErrBufferFull {
 			break
@@ -424,7 +883,8 @@ func userOK(objv ...any) error {
 
 // Push a marker
 func (d *Decoder) mark() {
-	d.push(mark{})
+	d.pruneCells(len(d.stack))
+	d.stack = append(d.stack, mark{})
 }
 
 // Return the position of the topmost marker
@@ -440,7 +900,31 @@ func (d *Decoder) marker() (int, error) {
 
 // Append a new value
 func (d *Decoder) push(v any) {
+	d.pruneCells(len(d.stack))
 	d.stack = append(d.stack, v)
+	d.stats.Objects++
+}
+
+// pushBytes pushes Python bytes with given data onto the stack.
+//
+// The data is decoded as Bytes, or, in BytesAsSlice mode, as copy of data.
+func (d *Decoder) pushBytes(data []byte) {
+	if d.config.BytesAsSlice {
+		d.push(append([]byte{}, data...))
+	} else {
+		d.push(Bytes(data))
+	}
+}
+
+// dictKey returns key to use in Python dict.
+//
+// In BytesAsSlice mode bytes are decoded as []byte, that cannot be used as
+// dict key. Such keys are converted back to Bytes.
+func (d *Decoder) dictKey(key any) any {
+	if b, ok := key.([]byte); ok && d.config.BytesAsSlice {
+		return Bytes(b)
+	}
+	return key
 }
 
 // Pop a value
@@ -478,7 +962,12 @@ func (d *Decoder) popUser() (any, error) {
 
 // Discard the stack through to the topmost marker
 func (d *Decoder) popMark() error {
-	return errNotImplemented
+	k, err := d.marker()
+	if err != nil {
+		return err
+	}
+	d.stack = d.stack[:k]
+	return nil
 }
 
 // Duplicate the top stack item
@@ -486,7 +975,15 @@ func (d *Decoder) dup() error {
 	if len(d.stack) < 1 {
 		return errStackUnderflow
 	}
-	d.stack = append(d.stack, d.stack[len(d.stack)-1])
+	v := d.stack[len(d.stack)-1]
+	d.pruneCells(len(d.stack))
+	d.stack = append(d.stack, v)
+
+	// the duplicate is another reference to the object, as if loaded from memo
+	if max := d.config.MaxExpandedSize; max > 0 {
+		budget := max - (d.stats.Objects + d.expanded)
+		d.expanded += expandedSize(v, budget)
+	}
 	return nil
 }
 
@@ -668,7 +1165,11 @@ func (d *Decoder) handleRef(ref Ref) error {
 	if load := d.config.PersistentLoad; load != nil {
 		obj, err := load(ref)
 		if err != nil {
-			return fmt.Errorf("pickle: handleRef: %s", err)
+			err = fmt.Errorf("pickle: handleRef: %s", err)
+			if !d.warn(err) {
+				return err
+			}
+			obj = ref
 		}
 		if obj == nil {
 			// PersistentLoad asked to leave the reference as is.
@@ -698,14 +1199,47 @@ func (d *Decoder) reduce() error {
 		return fmt.Errorf("pickle: reduce: invalid args: %T", xargs)
 	}
 	class, ok := xclass.(Class)
+	if !ok && d.config.DateTime {
+		// e.g. ZoneInfo._unpickle at protocol < 4
+		class, ok = getattrClass(xclass)
+	}
 	if !ok {
 		return fmt.Errorf("pickle: reduce: invalid class: %T", xclass)
 	}
 
+	return d.call(class, args)
+}
+
+// getattrClass returns class attribute x refers to, if x is getattr(cls, name)
+// call, as with which e.g. classmethods used as constructors are pickled.
+//
+// The attribute is represented as Class with name "<cls>.<name>". It is used
+// only in DateTime mode, so that decoding of such calls does not change
+// otherwise.
+func getattrClass(x any) (_ Class, ok bool) {
+	call, ok := x.(Call)
+	if !(ok && isBuiltin(call.Callable, "getattr") && len(call.Args) == 2) {
+		return Class{}, false
+	}
+	class, ok := call.Args[0].(Class)
+	if !ok {
+		return Class{}, false
+	}
+	name, err := AsString(call.Args[1])
+	if err != nil {
+		return Class{}, false
+	}
+	return Class{Module: class.Module, Name: class.Name + "." + name}, true
+}
+
+// call pushes result of class(*args) onto the stack.
+//
+// it serves REDUCE, INST and OBJ opcode handlers.
+func (d *Decoder) call(class Class, args Tuple) error {
 	// try to handle the call.
 	// If the call is unknown - represent it symbolically with Call{...} .
 	err := d.handleCall(class, args)
-	if err == errCallNotHandled {
+	if err == errCallNotHandled || (err != nil && d.warn(err)) {
 		d.push(Call{Callable: class, Args: args})
 		err = nil
 	}
@@ -713,6 +1247,99 @@ func (d *Decoder) reduce() error {
 	return err
 }
 
+// Object represents Python object created via cls.__new__(cls, *Args, **KwArgs)
+// with optional state.
+//
+// Contrary to [Call], which represents cls(*Args), __init__ is not invoked
+// for such objects. Python pickles instances of new-style classes this way
+// at protocol ≥ 2 via NEWOBJ opcode, and via NEWOBJ_EX opcode at protocol ≥ 4
+// if there are keyword arguments. At lower protocols they are pickled via
+// copyreg._reconstructor call, that is decoded into Object as well.
+//
+// State is the object state, as returned by __getstate__ on Python side -
+// typically a dict with object attributes, or a tuple (dict, slots). It is
+// set by BUILD opcode, which also turns [Call] into Object with Called=true.
+type Object struct {
+	Class  Class
+	Args   Tuple
+	KwArgs map[string]any // nil if there are no keyword arguments
+	State  any            // nil if the object has no state
+	Called bool           // whether the object was created via Class(*Args) instead of Class.__new__
+}
+
+// newobj handles NEWOBJ opcode.
+func (d *Decoder) newobj() error {
+	if len(d.stack) < 2 {
+		return errStackUnderflow
+	}
+	xargs := d.xpop()
+	xclass := d.xpop()
+	args, ok := xargs.(Tuple)
+	if !ok {
+		return fmt.Errorf("pickle: newobj: invalid args: %T", xargs)
+	}
+	class, ok := xclass.(Class)
+	if !ok {
+		return fmt.Errorf("pickle: newobj: invalid class: %T", xclass)
+	}
+
+	return d.pushObject(Object{Class: class, Args: args})
+}
+
+// newobjEx handles NEWOBJ_EX opcode.
+func (d *Decoder) newobjEx() error {
+	if len(d.stack) < 3 {
+		return errStackUnderflow
+	}
+	xkwargs := d.xpop()
+	xargs := d.xpop()
+	xclass := d.xpop()
+	class, args, kwargs, err := newobjExArgs(xclass, xargs, xkwargs)
+	if err != nil {
+		return fmt.Errorf("pickle: newobj_ex: %s", err)
+	}
+
+	return d.pushObject(Object{Class: class, Args: args, KwArgs: kwargs})
+}
+
+// newobjExArgs checks and converts arguments of cls.__new__(cls, *args, **kwargs)
+// as given to NEWOBJ_EX opcode or copyreg.__newobj_ex__ call.
+func newobjExArgs(xclass, xargs, xkwargs any) (class Class, args Tuple, kwargs map[string]any, err error) {
+	class, ok := xclass.(Class)
+	if !ok {
+		return Class{}, nil, nil, fmt.Errorf("invalid class: %T", xclass)
+	}
+	args, ok = xargs.(Tuple)
+	if !ok {
+		return Class{}, nil, nil, fmt.Errorf("invalid args: %T", xargs)
+	}
+	kwargs, err = kwargsOf(xkwargs)
+	if err != nil {
+		return Class{}, nil, nil, err
+	}
+	return class, args, kwargs, nil
+}
+
+// kwargsOf converts dict of keyword arguments to map. nil is returned if
+// the dict is empty.
+func kwargsOf(xkwargs any) (kwargs map[string]any, err error) {
+	items, err := ValueOf(xkwargs).Items()
+	if err != nil {
+		return nil, fmt.Errorf("invalid kwargs: %T", xkwargs)
+	}
+	if len(items) > 0 {
+		kwargs = make(map[string]any, len(items))
+	}
+	for _, item := range items {
+		k, err := AsString(item.Key.Interface())
+		if err != nil {
+			return nil, fmt.Errorf("invalid kwargs key: %s", err)
+		}
+		kwargs[k] = item.Value.Interface()
+	}
+	return kwargs, nil
+}
+
 // errCallNotHandled is internal error via which handleCall signals that it did
 // not handled the call.
 var errCallNotHandled = errors.New("handleCall: call not handled")
@@ -721,6 +1348,116 @@ var errCallNotHandled = errors.New("handleCall: call not handled")
 //
 // for example _codecs.encode(..., 'latin1') is handled as conversion to []byte.
 func (d *Decoder) handleCall(class Class, argv Tuple) error {
+	if handle := d.config.CallHandlers[class]; handle != nil {
+		v, err := handle(argv)
+		if err != nil {
+			return fmt.Errorf("%s.%s: %w", class.Module, class.Name, err)
+		}
+		d.push(v)
+		return nil
+	}
+
+	if d.config.CloudPickle {
+		err := d.handleCloudpickleCall(class, argv)
+		if err != errCallNotHandled {
+			return err
+		}
+	}
+
+	if d.config.NetIP {
+		err := d.handleNetIPCall(class, argv)
+		if err != errCallNotHandled {
+			return err
+		}
+	}
+
+	if d.config.DateTime {
+		err := d.handleDateTimeCall(class, argv)
+		if err != errCallNotHandled {
+			return err
+		}
+	}
+
+	if d.config.Arrays {
+		err := d.handleArrayCall(class, argv)
+		if err != errCallNotHandled {
+			return err
+		}
+	}
+
+	if d.config.NumPy {
+		err := d.handleNumPyCall(class, argv)
+		if err != errCallNotHandled {
+			return err
+		}
+	}
+
+	if (d.config.Exceptions && isBuiltinException(class)) || d.config.Types.isException(class) {
+		d.push(Exception{Class: class, Args: argv})
+		return nil
+	}
+
+	if d.config.Types != nil {
+		ok, err := d.newInstance(Object{Class: class, Args: argv, Called: true})
+		if ok {
+			return err
+		}
+	}
+
+	if d.config.RawCalls {
+		return errCallNotHandled
+	}
+
+	// handle copyreg.__newobj__(cls, *args) -> Object, as emitted instead
+	// of NEWOBJ at protocols < 2
+	if isCopyreg(class, "__newobj__") && len(argv) >= 1 {
+		cls, ok := argv[0].(Class)
+		if !ok {
+			return fmt.Errorf("__newobj__: invalid class: %T", argv[0])
+		}
+		return d.pushObject(Object{Class: cls, Args: append(Tuple{}, argv[1:]...)})
+	}
+
+	// handle copyreg.__newobj_ex__(cls, args, kwargs) -> Object, as
+	// emitted instead of NEWOBJ_EX at protocols < 4
+	if isCopyreg(class, "__newobj_ex__") && len(argv) == 3 {
+		cls, args, kwargs, err := newobjExArgs(argv[0], argv[1], argv[2])
+		if err != nil {
+			return fmt.Errorf("__newobj_ex__: %s", err)
+		}
+		return d.pushObject(Object{Class: cls, Args: args, KwArgs: kwargs})
+	}
+
+	// handle copyreg._reconstructor(cls, base, state) -> Object, as emitted
+	// for instances of new-style classes at protocols < 2
+	if isCopyreg(class, "_reconstructor") && len(argv) == 3 {
+		cls, ok := argv[0].(Class)
+		if !ok {
+			return fmt.Errorf("_reconstructor: invalid class: %T", argv[0])
+		}
+		base, ok := argv[1].(Class)
+		if !ok {
+			return fmt.Errorf("_reconstructor: invalid base: %T", argv[1])
+		}
+		return d.pushObject(reconstructorObject(cls, base, argv[2], d.config.Types))
+	}
+
+	// handle functools.partial(func, *args) -> Partial, which BUILD
+	// completes with the rest of partial state
+	if class == pyPartial {
+		return d.handlePartialCall(argv)
+	}
+
+	// handle slice(start, stop, step) -> Slice
+	if isBuiltin(class, "slice") {
+		return d.handleSliceCall(argv)
+	}
+
+	// handle range(start, stop, step) and py2 xrange(...) -> Range
+	if isBuiltin(class, "range") || isBuiltin(class, "xrange") {
+		return d.handleRangeCall(argv)
+	}
+
 	// for protocols <= 2 Python3 encodes bytes as `_codecs.encode(byt.decode('latin1'), 'latin1')`
 	if class.Module == "_codecs" && class.Name == "encode" &&
 		len(argv) == 2 && stringEQ(argv[1], "latin1") {
@@ -731,7 +1468,7 @@ func (d *Decoder) handleCall(class Class, argv Tuple) error {
 			return fmt.Errorf("_codecs.encode: %s", err)
 		}
 
-		d.push(Bytes(data))
+		d.pushBytes(data)
 		return nil
 	}
 
@@ -739,12 +1476,17 @@ func (d *Decoder) handleCall(class Class, argv Tuple) error {
 	if class == pybuiltin(d.protocol, "bytearray") {
 		// bytearray(bytes(...))
 		if len(argv) == 1 {
-			data, ok := argv[0].(Bytes)
-			if !ok {
+			var data []byte
+			switch arg := argv[0].(type) {
+			case Bytes:
+				data = []byte(arg)
+			case []byte: // bytes in BytesAsSlice mode
+				data = append([]byte(nil), arg...)
+			default:
 				return fmt.Errorf("bytearray: want (bytes,)  ; got (%T,)", argv[0])
 			}
 
-			d.push([]byte(data))
+			d.push(data)
 			return nil
 		}
 
@@ -755,13 +1497,272 @@ func (d *Decoder) handleCall(class Class, argv Tuple) error {
 			if err != nil {
 				return fmt.Errorf("bytearray: %s", err)
 			}
-
-			d.push([]byte(data))
-			return nil
+
+			d.push([]byte(data))
+			return nil
+		}
+	}
+
+	// handle pickle.PickleBuffer(bytes|bytearray) -> Bytes | []byte
+	// the same way as in-band buffers are decoded with protocol 5.
+	// memoryview(bytes|bytearray) is unwrapped the same way.
+	if (isPickleBuffer(class) || isBuiltin(class, "memoryview")) && len(argv) == 1 {
+		switch arg := argv[0].(type) {
+		case Bytes:
+			d.pushBytes([]byte(arg))
+		case ByteString:
+			d.pushBytes([]byte(arg))
+		case []byte:
+			d.push(arg)
+		default:
+			return fmt.Errorf("%s: want (bytes|bytearray,)  ; got (%T,)", class.Name, arg)
+		}
+		return nil
+	}
+
+	// handle set(list) -> Set and frozenset(list) -> FrozenSet, as sets
+	// are pickled at protocols ≤ 3
+	if (isBuiltin(class, "set") || isBuiltin(class, "frozenset")) && len(argv) <= 1 {
+		s, err := d.setFromArgs(argv)
+		if err != nil {
+			return fmt.Errorf("%s: %s", class.Name, err)
+		}
+		if class.Name == "frozenset" {
+			d.push(FrozenSet{s})
+		} else {
+			d.push(s)
+		}
+		return nil
+	}
+
+	// handle bytes(...) -> Bytes(...)
+	if isBuiltin(class, "bytes") {
+		data, err := decodeBytesCall(argv)
+		if err != nil {
+			return fmt.Errorf("bytes: %s", err)
+		}
+		d.pushBytes([]byte(data))
+		return nil
+	}
+
+	// handle str(...) -> string
+	// py2 str is bytestring, while py3 str is unicode.
+	if isBuiltin(class, "str") {
+		text, err := decodeStrCall(argv)
+		if err != nil {
+			return fmt.Errorf("str: %s", err)
+		}
+		if class.Module == "__builtin__" {
+			d.pushByteString(text)
+		} else {
+			d.push(text)
+		}
+		return nil
+	}
+
+	return errCallNotHandled
+}
+
+// setFromArgs creates set from arguments of set(...) call.
+//
+// The arguments should be either empty, or single list or tuple of elements.
+func (d *Decoder) setFromArgs(argv Tuple) (Set, error) {
+	var items []any
+	if len(argv) == 1 {
+		switch arg := argv[0].(type) {
+		case []any:
+			items = arg
+		case *[]any:
+			items = *arg
+		case Tuple:
+			items = arg
+		default:
+			return Set{}, fmt.Errorf("want (list|tuple,)  ; got (%T,)", arg)
+		}
+	}
+
+	s := NewSetWithSizeHint(len(items))
+	for _, x := range items {
+		x = d.dictKey(x)
+		if !setTryAdd(s, x) {
+			return Set{}, fmt.Errorf("invalid item type %T", x)
+		}
+	}
+	return s, nil
+}
+
+// pySetsClass returns class of x, if x is instance of sets.Set or
+// sets.ImmutableSet from Python 2 sets module, that is yet without state.
+//
+// Such instances are created via copy_reg._reconstructor at protocols < 2,
+// and via NEWOBJ at protocol 2.
+func pySetsClass(x any) (Class, bool) {
+	var obj Object
+	switch x := x.(type) {
+	case Object:
+		obj = x
+	case *Object:
+		obj = *x
+	default:
+		return Class{}, false
+	}
+	if !(obj.Class.Module == "sets" && len(obj.Args) == 0 && obj.State == nil) {
+		return Class{}, false
+	}
+	switch obj.Class.Name {
+	case "Set", "ImmutableSet":
+		return obj.Class, true
+	}
+	return Class{}, false
+}
+
+// buildPySet handles BUILD of sets.Set and sets.ImmutableSet, whose state is
+// ({elem: True, ...},), and ({elem: True, ...}, hashcode) correspondingly.
+// They become Set and FrozenSet.
+func (d *Decoder) buildPySet(class Class, state any) error {
+	t, ok := state.(Tuple)
+	if !(ok && (len(t) == 1 || len(t) == 2)) {
+		return fmt.Errorf("pickle: build: sets.%s: invalid state %s", class.Name, Sprint("%#v", state, nil))
+	}
+	items, err := ValueOf(t[0]).Items()
+	if err != nil {
+		return fmt.Errorf("pickle: build: sets.%s: %s", class.Name, err)
+	}
+	s := NewSetWithSizeHint(len(items))
+	for _, item := range items {
+		x := d.dictKey(item.Key.Interface())
+		if !setTryAdd(s, x) {
+			return fmt.Errorf("pickle: build: sets.%s: invalid item type %T", class.Name, x)
+		}
+	}
+
+	var v any = s
+	if class.Name == "ImmutableSet" {
+		v = FrozenSet{s}
+	}
+	d.stack[len(d.stack)-1] = v
+	d.updateCells(len(d.stack)-1, v)
+	return nil
+}
+
+// isBuiltin returns whether class is Python builtin name from either py2 or py3.
+func isBuiltin(class Class, name string) bool {
+	return class.Name == name && (class.Module == "builtins" || class.Module == "__builtin__")
+}
+
+// isCopyreg returns whether class is name from copyreg module, as named by
+// either py2 or py3.
+func isCopyreg(class Class, name string) bool {
+	return class.Name == name && (class.Module == "copyreg" || class.Module == "copy_reg")
+}
+
+// reconstructorObject returns Object created by copyreg._reconstructor(cls,
+// base, state).
+//
+// _reconstructor creates the object via object.__new__(cls), or via
+// base.__new__(cls, state) for subclasses of builtin types, e.g. of dict.
+// For namedtuples the values in state become the arguments, the same way as
+// they are when namedtuple is pickled via NEWOBJ.
+func reconstructorObject(cls, base Class, state any, types *TypeRegistry) Object {
+	if isBuiltin(base, "object") {
+		return Object{Class: cls, Args: Tuple{}}
+	}
+	if values, ok := state.(Tuple); ok && isBuiltin(base, "tuple") {
+		if _, isNT := types.namedTupleFields(cls); isNT {
+			return Object{Class: cls, Args: values}
+		}
+	}
+	return Object{Class: cls, Args: Tuple{state}}
+}
+
+// isPickleBuffer returns whether class is pickle.PickleBuffer.
+func isPickleBuffer(class Class) bool {
+	return class.Name == "PickleBuffer" && (class.Module == "pickle" || class.Module == "_pickle")
+}
+
+// decodeBytesCall decodes data from arguments of bytes(...) call.
+//
+// Supported forms are bytes(), bytes(bytes), bytes(unicode, encoding) and
+// bytes([int, ...]).
+func decodeBytesCall(argv Tuple) (string, error) {
+	switch len(argv) {
+	case 0:
+		return "", nil
+
+	case 1:
+		switch arg := argv[0].(type) {
+		case Bytes:
+			return string(arg), nil
+		case ByteString:
+			return string(arg), nil
+		case []byte:
+			return string(arg), nil
+		case *[]any:
+			return decodeBytesCall(Tuple{*arg})
+		case []any:
+			data := make([]byte, len(arg))
+			for i, x := range arg {
+				b, ok := x.(int64)
+				if !(ok && 0 <= b && b < 0x100) {
+					return "", fmt.Errorf("want [int ∈ range(256)]  ; got %#v at [%d]", x, i)
+				}
+				data[i] = byte(b)
+			}
+			return string(data), nil
+		}
+
+	case 2:
+		text, ok := argv[0].(string)
+		if !ok {
+			break
+		}
+		switch {
+		case stringEQ(argv[1], "latin1") || stringEQ(argv[1], "latin-1"):
+			data, err := decodeLatin1Bytes(text)
+			return string(data), err
+		case stringEQ(argv[1], "utf-8") || stringEQ(argv[1], "utf8"):
+			return text, nil
+		}
+	}
+
+	return "", fmt.Errorf("unsupported arguments %#v", argv)
+}
+
+// decodeStrCall decodes text from arguments of str(...) call.
+//
+// Supported forms are str(), str(string) and str(bytes, encoding).
+func decodeStrCall(argv Tuple) (string, error) {
+	switch len(argv) {
+	case 0:
+		return "", nil
+
+	case 1:
+		text, err := AsString(argv[0])
+		if err == nil {
+			return text, nil
+		}
+
+	case 2:
+		data, err := AsBytes(argv[0])
+		if b, ok := argv[0].([]byte); ok {
+			data, err = Bytes(b), nil
+		}
+		if err != nil {
+			break
+		}
+		switch {
+		case stringEQ(argv[1], "latin1") || stringEQ(argv[1], "latin-1"):
+			r := make([]rune, len(data))
+			for i := range r {
+				r[i] = rune(data[i]) // decode as latin1
+			}
+			return string(r), nil
+		case stringEQ(argv[1], "utf-8") || stringEQ(argv[1], "utf8"):
+			return string(data), nil
 		}
 	}
 
-	return errCallNotHandled
+	return "", fmt.Errorf("unsupported arguments %#v", argv)
 }
 
 // pushByteString pushes str as either ByteString or string depending on StrictUnicode setting.
@@ -773,6 +1774,48 @@ func (d *Decoder) pushByteString(str string) {
 	}
 }
 
+// limits for string interning in InternStrings mode.
+const (
+	maxInternLen  = 64      // only strings not longer than this are interned
+	maxInternSize = 1 << 16 // maximum number of entries in the intern table
+)
+
+// intern returns string with content of b.
+//
+// In InternStrings mode, if equal string was interned before, that string is
+// returned instead of allocating a new one.
+func (d *Decoder) intern(b []byte) string {
+	if !d.config.InternStrings || len(b) > maxInternLen {
+		return string(b)
+	}
+	if s, ok := d.internTab[string(b)]; ok { // NOTE no allocation for string(b) here
+		return s
+	}
+	return d.internAdd(string(b))
+}
+
+// internString is like intern but for s that is already string.
+func (d *Decoder) internString(s string) string {
+	if !d.config.InternStrings || len(s) > maxInternLen {
+		return s
+	}
+	if si, ok := d.internTab[s]; ok {
+		return si
+	}
+	return d.internAdd(s)
+}
+
+// internAdd adds s to the intern table, if the table is not yet full.
+func (d *Decoder) internAdd(s string) string {
+	if d.internTab == nil {
+		d.internTab = make(map[string]string)
+	}
+	if len(d.internTab) < maxInternSize {
+		d.internTab[s] = s
+	}
+	return s
+}
+
 // Push a string
 func (d *Decoder) loadString() error {
 	line, err := d.readLine()
@@ -803,7 +1846,7 @@ func (d *Decoder) loadString() error {
 		return err
 	}
 
-	d.pushByteString(s)
+	d.pushByteString(d.internString(s))
 	return nil
 }
 
@@ -820,7 +1863,7 @@ func (d *Decoder) bufLoadBinData4() error {
 }
 
 // bufLoadBinData8 decodes `len(LE64) [len]data into d.buf .
-// it serves loadBytearray8 (and TODO loadBinBytes8, loadBinUnicode8)
+// it serves loadBytearray8, loadBinBytes8 and loadBinUnicode8
 func (d *Decoder) bufLoadBinData8() error {
 	var b [8]byte
 	_, err := io.ReadFull(d.r, b[:])
@@ -857,7 +1900,7 @@ func (d *Decoder) loadBinString() error {
 	if err != nil {
 		return err
 	}
-	d.pushByteString(d.buf.String())
+	d.pushByteString(d.intern(d.buf.Bytes()))
 	return nil
 }
 
@@ -866,7 +1909,16 @@ func (d *Decoder) loadBinBytes() error {
 	if err != nil {
 		return err
 	}
-	d.push(Bytes(d.buf.Bytes()))
+	d.pushBytes(d.buf.Bytes())
+	return nil
+}
+
+func (d *Decoder) loadBinBytes8() error {
+	err := d.bufLoadBinData8()
+	if err != nil {
+		return err
+	}
+	d.pushBytes(d.buf.Bytes())
 	return nil
 }
 
@@ -892,7 +1944,7 @@ func (d *Decoder) loadShortBinString() error {
 	if err != nil {
 		return err
 	}
-	d.pushByteString(d.buf.String())
+	d.pushByteString(d.intern(d.buf.Bytes()))
 	return nil
 }
 
@@ -901,7 +1953,7 @@ func (d *Decoder) loadShortBinBytes() error {
 	if err != nil {
 		return err
 	}
-	d.push(Bytes(d.buf.Bytes()))
+	d.pushBytes(d.buf.Bytes())
 	return nil
 }
 
@@ -916,7 +1968,7 @@ func (d *Decoder) loadUnicode() error {
 		return err
 	}
 
-	d.push(text)
+	d.push(d.internString(text))
 	return nil
 }
 
@@ -936,7 +1988,16 @@ func (d *Decoder) loadBinUnicode() error {
 		}
 		rawB = append(rawB, n)
 	}
-	d.push(string(rawB))
+	d.push(d.intern(rawB))
+	return nil
+}
+
+func (d *Decoder) loadBinUnicode8() error {
+	err := d.bufLoadBinData8()
+	if err != nil {
+		return err
+	}
+	d.push(d.intern(d.buf.Bytes()))
 	return nil
 }
 
@@ -951,16 +2012,80 @@ func (d *Decoder) loadAppend() error {
 	}
 	switch l.(type) {
 	case []any:
-		l := l.([]any)
-		d.stack[len(d.stack)-1] = append(l, v)
+		l := append(l.([]any), v)
+		d.stack[len(d.stack)-1] = l
+		d.updateCells(len(d.stack)-1, l)
+	case *[]any:
+		l := l.(*[]any)
+		*l = append(*l, v)
 	default:
 		return fmt.Errorf("pickle: loadAppend: expected a list, got %T", l)
 	}
 	return nil
 }
 
+// build handles BUILD opcode: it sets state of the object on top of the stack.
+//
+// The object has to be either [Object] or [Call], which becomes Object with
+// the state.
 func (d *Decoder) build() error {
-	return errNotImplemented
+	if len(d.stack) < 2 {
+		return errStackUnderflow
+	}
+	state, err := d.popUser()
+	if err != nil {
+		return err
+	}
+
+	if d.joblib && isJoblibArrayWrapper(d.stack[len(d.stack)-1]) {
+		return d.buildJoblibArray(state)
+	}
+	if class, ok := pySetsClass(d.stack[len(d.stack)-1]); ok && !d.config.RawCalls {
+		return d.buildPySet(class, state)
+	}
+
+	var obj Object
+	switch x := d.stack[len(d.stack)-1].(type) {
+	case Object:
+		obj = x
+	case *Object:
+		if x.State != nil {
+			return fmt.Errorf("pickle: build: %s.%s object already has state", x.Class.Module, x.Class.Name)
+		}
+		x.State = state
+		return nil
+	case Call:
+		obj = Object{Class: x.Callable, Args: x.Args, Called: true}
+	case Partial:
+		return d.buildPartial(x, state)
+	case Exception:
+		return d.buildException(x, state)
+	case Dtype:
+		return d.buildDtype(x, state)
+	case NDArray:
+		return d.buildNDArray(x, state)
+	default:
+		if ok, err := d.buildInstance(x, state); ok {
+			return err
+		}
+		err = fmt.Errorf("pickle: build: unsupported object %T", x)
+		if d.warn(err) {
+			return nil
+		}
+		return err
+	}
+	if obj.State != nil {
+		return fmt.Errorf("pickle: build: %s.%s object already has state", obj.Class.Module, obj.Class.Name)
+	}
+
+	obj.State = state
+	var v any = obj
+	if d.config.PreserveRefs {
+		v = &obj
+	}
+	d.stack[len(d.stack)-1] = v
+	d.updateCells(len(d.stack)-1, v)
+	return nil
 }
 
 // Class represents a Python class.
@@ -983,6 +2108,30 @@ func (d *Decoder) global() error {
 	return nil
 }
 
+// loadExt handles EXT1, EXT2 and EXT4 opcodes with n-byte extension code.
+func (d *Decoder) loadExt(n int) error {
+	var b [4]byte
+	_, err := io.ReadFull(d.r, b[:n])
+	if err != nil {
+		return err
+	}
+	code := int(int32(binary.LittleEndian.Uint32(b[:])))
+	if code <= 0 {
+		return fmt.Errorf("pickle: ext: invalid extension code %d", code)
+	}
+
+	class, ok := d.config.ExtensionRegistry[code]
+	if !ok {
+		err := fmt.Errorf("pickle: ext: unregistered extension code %d", code)
+		if !d.warn(err) {
+			return err
+		}
+		class = Class{"copyreg", fmt.Sprintf("ext%d", code)}
+	}
+	d.push(class)
+	return nil
+}
+
 // mapTryAssign tries to do `m[key] = value`.
 //
 // It checks whether key is of appropriate type, and if yes - succeeds.
@@ -1039,7 +2188,9 @@ func (d *Decoder) loadDict() error {
 	}
 
 	var m any
-	if d.config.PyDict {
+	if d.config.DictAsItems {
+		m = d.loadDictItems(items)
+	} else if d.config.PyDict {
 		m, err = d.loadDictDict(items)
 	} else {
 		m, err = d.loadDictMap(items)
@@ -1049,16 +2200,20 @@ func (d *Decoder) loadDict() error {
 		return err
 	}
 
-	d.stack = append(d.stack[:k], m)
+	d.stack = d.stack[:k]
+	d.push(m)
 	return nil
 }
 
 func (d *Decoder) loadDictMap(items []any) (map[any]any, error) {
 	m := make(map[any]any, len(items)/2)
 	for i := 0; i < len(items); i += 2 {
-		key := items[i]
+		key := d.dictKey(items[i])
 		if !mapTryAssign(m, key, items[i+1]) {
-			return nil, fmt.Errorf("pickle: loadDict: map: invalid key type %T", key)
+			err := fmt.Errorf("pickle: loadDict: map: invalid key type %T", key)
+			if !d.warn(err) {
+				return nil, err
+			}
 		}
 	}
 	return m, nil
@@ -1067,18 +2222,31 @@ func (d *Decoder) loadDictMap(items []any) (map[any]any, error) {
 func (d *Decoder) loadDictDict(items []any) (Dict, error) {
 	m := NewDictWithSizeHint(len(items)/2)
 	for i := 0; i < len(items); i += 2 {
-		key := items[i]
+		key := d.dictKey(items[i])
 		if !dictTryAssign(m, key, items[i+1]) {
-			return Dict{}, fmt.Errorf("pickle: loadDict: Dict: invalid key type %T", key)
+			err := fmt.Errorf("pickle: loadDict: Dict: invalid key type %T", key)
+			if !d.warn(err) {
+				return Dict{}, err
+			}
 		}
 	}
 	return m, nil
 }
 
+// loadDictItems serves loadDict in DictAsItems mode.
+func (d *Decoder) loadDictItems(items []any) []KV {
+	kv := make([]KV, len(items)/2)
+	for i := range kv {
+		kv[i] = KV{d.dictKey(items[2*i]), items[2*i+1]}
+	}
+	return kv
+}
 
 func (d *Decoder) loadEmptyDict() error {
 	var m any
-	if d.config.PyDict {
+	if d.config.DictAsItems {
+		m = []KV{}
+	} else if d.config.PyDict {
 		m = NewDict()
 	} else {
 		m = make(map[any]any, 0)
@@ -1104,6 +2272,11 @@ func (d *Decoder) loadAppends() error {
 			l = append(l, v)
 		}
 		d.stack = append(d.stack[:k-1], l)
+		d.updateCells(k-1, l)
+	case *[]any:
+		l := l.(*[]any)
+		*l = append(*l, d.stack[k+1:]...)
+		d.stack = d.stack[:k]
 	default:
 		return fmt.Errorf("pickle: loadAppends: expected a list, got %T", l)
 	}
@@ -1119,7 +2292,7 @@ func (d *Decoder) get() error {
 	if !ok {
 		return fmt.Errorf("pickle: memo: key error %q", line)
 	}
-	d.push(v)
+	d.pushMemo(v)
 	return nil
 }
 
@@ -1133,12 +2306,137 @@ func (d *Decoder) binGet() error {
 	if !ok {
 		return fmt.Errorf("pickle: memo: key error %d", b)
 	}
-	d.push(v)
+	d.pushMemo(v)
 	return nil
 }
 
+// pushMemo pushes object loaded from memo onto the stack.
+//
+// With MaxExpandedSize it also accounts logical size of the object.
+func (d *Decoder) pushMemo(v any) {
+	if c, ok := v.(*memoCell); ok {
+		v = c.v
+	}
+	d.push(v)
+	if max := d.config.MaxExpandedSize; max > 0 {
+		budget := max - (d.stats.Objects + d.expanded)
+		d.expanded += expandedSize(v, budget) - 1
+	}
+}
+
+// expandedSize returns the number of objects in v, including v itself.
+//
+// The counting stops as soon as the number exceeds budget, so the result is
+// exact only if it is <= budget.
+func expandedSize(v any, budget int64) int64 {
+	n := int64(0)
+	var walk func(v any)
+	walk = func(v any) {
+		n++
+		if n > budget {
+			return
+		}
+		// objects decoded with PreserveRefs
+		switch p := v.(type) {
+		case *[]any:
+			v = *p
+		case *Object:
+			v = *p
+		}
+		switch v := v.(type) {
+		case []any:
+			for _, x := range v {
+				if walk(x); n > budget {
+					return
+				}
+			}
+		case Tuple:
+			for _, x := range v {
+				if walk(x); n > budget {
+					return
+				}
+			}
+		case map[any]any:
+			for k, x := range v {
+				if walk(k); n > budget {
+					return
+				}
+				if walk(x); n > budget {
+					return
+				}
+			}
+		case Dict:
+			v.Iter()(func(k, x any) bool {
+				walk(k)
+				if n <= budget {
+					walk(x)
+				}
+				return n <= budget
+			})
+		case Set:
+			v.Iter()(func(x any) bool {
+				walk(x)
+				return n <= budget
+			})
+		case FrozenSet:
+			v.Iter()(func(x any) bool {
+				walk(x)
+				return n <= budget
+			})
+		case []KV:
+			for _, x := range v {
+				if walk(x.Key); n > budget {
+					return
+				}
+				if walk(x.Value); n > budget {
+					return
+				}
+			}
+		case Call:
+			walk(v.Args)
+		case Object:
+			walk(v.Args)
+			for _, x := range v.KwArgs {
+				if walk(x); n > budget {
+					return
+				}
+			}
+			if v.State != nil && n <= budget {
+				walk(v.State)
+			}
+		case Ref:
+			walk(v.Pid)
+		}
+	}
+	walk(v)
+	return n
+}
+
+// inst handles INST opcode: class instance with class given by module and
+// name lines, and constructor arguments taken from the stack up to the mark.
+//
+// Such objects, as produced by Python2 for old-style classes, are decoded
+// the same way as class(*args) call.
 func (d *Decoder) inst() error {
-	return errNotImplemented
+	module, err := d.readLine()
+	if err != nil {
+		return err
+	}
+	smodule := string(module)
+	name, err := d.readLine()
+	if err != nil {
+		return err
+	}
+	sname := string(name)
+
+	k, err := d.marker()
+	if err != nil {
+		return err
+	}
+	args := append(Tuple{}, d.stack[k+1:]...)
+	d.stack = d.stack[:k]
+
+	return d.call(Class{Module: smodule, Name: sname}, args)
 }
 
 func (d *Decoder) longBinGet() error {
@@ -1152,7 +2450,7 @@ func (d *Decoder) longBinGet() error {
 	if !ok {
 		return fmt.Errorf("pickle: memo: key error %d", v)
 	}
-	d.push(vv)
+	d.pushMemo(vv)
 	return nil
 }
 
@@ -1168,7 +2466,33 @@ func (d *Decoder) loadList() error {
 	}
 
 	v := append([]any{}, d.stack[k+1:]...)
-	d.stack = append(d.stack[:k], v)
+	d.stack = d.stack[:k]
+	d.pushList(v)
+	return nil
+}
+
+// pushList pushes list onto the stack, as *[]any with PreserveRefs.
+func (d *Decoder) pushList(l []any) {
+	if d.config.PreserveRefs {
+		d.push(&l)
+	} else {
+		d.push(l)
+	}
+}
+
+// pushObject pushes object onto the stack, as *Object with PreserveRefs.
+//
+// Objects of classes registered in Types are pushed as instances of
+// corresponding Go types.
+func (d *Decoder) pushObject(obj Object) error {
+	if ok, err := d.newInstance(obj); ok {
+		return err
+	}
+	if d.config.PreserveRefs {
+		d.push(&obj)
+	} else {
+		d.push(obj)
+	}
 	return nil
 }
 
@@ -1179,7 +2503,8 @@ func (d *Decoder) loadTuple() error {
 	}
 
 	v := append(Tuple{}, d.stack[k+1:]...)
-	d.stack = append(d.stack[:k], v)
+	d.stack = d.stack[:k]
+	d.push(v)
 	return nil
 }
 
@@ -1194,7 +2519,8 @@ func (d *Decoder) tupleN(n int) error {
 		return err
 	}
 	v := append(Tuple{}, d.stack[k:]...)
-	d.stack = append(d.stack[:k], v)
+	d.stack = d.stack[:k]
+	d.push(v)
 	return nil
 }
 
@@ -1210,8 +2536,27 @@ func (d *Decoder) loadTuple3() error {
 	return d.tupleN(3)
 }
 
+// obj handles OBJ opcode: class instance with class and constructor
+// arguments taken from the stack up to the mark.
+//
+// It is binary counterpart of INST.
 func (d *Decoder) obj() error {
-	return errNotImplemented
+	k, err := d.marker()
+	if err != nil {
+		return err
+	}
+	if len(d.stack) < k+2 {
+		return errStackUnderflow
+	}
+	xclass := d.stack[k+1]
+	class, ok := xclass.(Class)
+	if !ok {
+		return fmt.Errorf("pickle: obj: invalid class: %T", xclass)
+	}
+	args := append(Tuple{}, d.stack[k+2:]...)
+	d.stack = d.stack[:k]
+
+	return d.call(class, args)
 }
 
 // memoTop puts top of the stack into memo[key]; the stack is not changed.
@@ -1226,10 +2571,52 @@ func (d *Decoder) memoTop(key string) error {
 		return err
 	}
 
-	d.memo[key] = obj
+	// containers, that are modified by replacing their stack entry, are
+	// memoized via cells
+	cell := d.config.Types.isInstance(obj)
+	switch obj.(type) {
+	case []any, []KV, Object, Call, Partial, Exception, Dtype, NDArray:
+		cell = true
+	}
+	if cell {
+		slot := len(d.stack) - 1
+		d.pruneCells(slot + 1)
+		c := &memoCell{v: obj, slot: slot}
+		d.cells = append(d.cells, c)
+		d.memo[key] = c
+	} else {
+		d.memo[key] = obj
+	}
 	return nil
 }
 
+// pruneCells stops tracking cells of stack slots >= n, as their containers
+// are no longer on the stack.
+func (d *Decoder) pruneCells(n int) {
+	i := len(d.cells)
+	for i > 0 && d.cells[i-1].slot >= n {
+		i--
+	}
+	if i < len(d.cells) {
+		for j := i; j < len(d.cells); j++ {
+			d.cells[j] = nil
+		}
+		d.cells = d.cells[:i]
+	}
+}
+
+// updateCells updates memo cells of container at stack slot to its new value v.
+//
+// Note: copies of the container, that were loaded from memo before, do not
+// see the update. This happens for recursive containers, e.g. list that
+// contains itself, which can be fully decoded only with PreserveRefs.
+func (d *Decoder) updateCells(slot int, v any) {
+	d.pruneCells(slot + 1)
+	for i := len(d.cells) - 1; i >= 0 && d.cells[i].slot == slot; i-- {
+		d.cells[i].v = v
+	}
+}
+
 func (d *Decoder) loadPut() error {
 	line, err := d.readLine()
 	if err != nil {
@@ -1261,7 +2648,7 @@ func (d *Decoder) loadSetItem() error {
 		return errStackUnderflow
 	}
 	v := d.xpop()
-	k := d.xpop()
+	k := d.dictKey(d.xpop())
 	if err := userOK(k, v); err != nil {
 		return err
 	}
@@ -1269,12 +2656,22 @@ func (d *Decoder) loadSetItem() error {
 	switch m := m.(type) {
 	case map[any]any:
 		if !mapTryAssign(m, k, v) {
-			return fmt.Errorf("pickle: loadSetItem: map: invalid key type %T", k)
+			err := fmt.Errorf("pickle: loadSetItem: map: invalid key type %T", k)
+			if !d.warn(err) {
+				return err
+			}
 		}
 	case Dict:
 		if !dictTryAssign(m, k, v) {
-			return fmt.Errorf("pickle: loadSetItem: Dict: invalid key type %T", k)
+			err := fmt.Errorf("pickle: loadSetItem: Dict: invalid key type %T", k)
+			if !d.warn(err) {
+				return err
+			}
 		}
+	case []KV:
+		m = append(m, KV{k, v})
+		d.stack[len(d.stack)-1] = m
+		d.updateCells(len(d.stack)-1, m)
 	default:
 		return fmt.Errorf("pickle: loadSetItem: expected a map or Dict, got %T", m)
 	}
@@ -1297,18 +2694,30 @@ func (d *Decoder) loadSetItems() error {
 	switch m := l.(type) {
 	case map[any]any:
 		for i := k + 1; i < len(d.stack); i += 2 {
-			key := d.stack[i]
+			key := d.dictKey(d.stack[i])
 			if !mapTryAssign(m, key, d.stack[i+1]) {
-				return fmt.Errorf("pickle: loadSetItems: map: invalid key type %T", key)
+				err := fmt.Errorf("pickle: loadSetItems: map: invalid key type %T", key)
+				if !d.warn(err) {
+					return err
+				}
 			}
 		}
 	case Dict:
 		for i := k + 1; i < len(d.stack); i += 2 {
-			key := d.stack[i]
+			key := d.dictKey(d.stack[i])
 			if !dictTryAssign(m, key, d.stack[i+1]) {
-				return fmt.Errorf("pickle: loadSetItems: Dict: invalid key type %T", key)
+				err := fmt.Errorf("pickle: loadSetItems: Dict: invalid key type %T", key)
+				if !d.warn(err) {
+					return err
+				}
 			}
 		}
+	case []KV:
+		for i := k + 1; i < len(d.stack); i += 2 {
+			m = append(m, KV{d.dictKey(d.stack[i]), d.stack[i+1]})
+		}
+		d.updateCells(k-1, m)
+		l = m
 
 	default:
 		return fmt.Errorf("pickle: loadSetItems: expected a map or Dict, got %T", m)
@@ -1317,6 +2726,53 @@ func (d *Decoder) loadSetItems() error {
 	return nil
 }
 
+func (d *Decoder) loadAddItems() error {
+	k, err := d.marker()
+	if err != nil {
+		return err
+	}
+	if k < 1 {
+		return errStackUnderflow
+	}
+
+	s, ok := d.stack[k-1].(Set)
+	if !ok {
+		return fmt.Errorf("pickle: loadAddItems: expected a set, got %T", d.stack[k-1])
+	}
+	for _, x := range d.stack[k+1:] {
+		x = d.dictKey(x)
+		if !setTryAdd(s, x) {
+			err := fmt.Errorf("pickle: loadAddItems: set: invalid item type %T", x)
+			if !d.warn(err) {
+				return err
+			}
+		}
+	}
+	d.stack = d.stack[:k]
+	return nil
+}
+
+func (d *Decoder) loadFrozenSet() error {
+	k, err := d.marker()
+	if err != nil {
+		return err
+	}
+
+	s := NewSetWithSizeHint(len(d.stack) - (k + 1))
+	for _, x := range d.stack[k+1:] {
+		x = d.dictKey(x)
+		if !setTryAdd(s, x) {
+			err := fmt.Errorf("pickle: loadFrozenSet: invalid item type %T", x)
+			if !d.warn(err) {
+				return err
+			}
+		}
+	}
+	d.stack = d.stack[:k]
+	d.push(FrozenSet{s})
+	return nil
+}
+
 func (d *Decoder) binFloat() error {
 	var b [8]byte
 	_, err := io.ReadFull(d.r, b[:])
@@ -1330,12 +2786,92 @@ func (d *Decoder) binFloat() error {
 
 // loadFrame discards the framing opcode+information, this information is useful to do one large read (instead of many small reads)
 // https://www.python.org/dev/peps/pep-3154/#framing
+//
+// In StrictFrames mode the frame length is remembered to be checked by frameCheck.
 func (d *Decoder) loadFrame() error {
 	var b [8]byte
 	_, err := io.ReadFull(d.r, b[:])
 	if err != nil {
 		return err
 	}
+	l := binary.LittleEndian.Uint64(b[:])
+	err = d.prefetchFrame(l)
+	if !d.config.StrictFrames {
+		return nil
+	}
+
+	if d.frameEnd >= 0 {
+		return fmt.Errorf("pickle: frame: new frame starts %d bytes before end of previous frame",
+			d.frameEnd - (d.pos() - (1+8)))
+	}
+	if l > math.MaxInt64 - uint64(d.pos()) {
+		return fmt.Errorf("pickle: frame: length overflow")
+	}
+	if err == io.EOF {
+		return fmt.Errorf("pickle: frame: frame of %d bytes runs past end of data", l)
+	}
+	d.frameEnd = d.pos() + int64(l)
+	return d.frameCheck()
+}
+
+// maxFramePrefetch limits size of frames, that are prefetched by the decoder.
+//
+// Python pickler targets frames of 64KiB and emits large objects outside of
+// frames, so real frames are rarely much bigger than that.
+const maxFramePrefetch = 1 << 20
+
+// prefetchFrame reads data of frame with length l into read buffer at once.
+//
+// This way decoding of the frame does not need many small reads from
+// the underlying reader. Frames of in-memory input and frames larger than
+// maxFramePrefetch are not prefetched.
+//
+// It returns io.EOF if the input is known to end before the frame does.
+func (d *Decoder) prefetchFrame(l uint64) error {
+	if d.input != nil {
+		if l > uint64(int64(len(d.input)) - d.pos()) {
+			return io.EOF
+		}
+		return nil
+	}
+	if l > maxFramePrefetch || l <= uint64(d.r.Buffered()) {
+		return nil
+	}
+	n := int(l)
+
+	// grow read buffer to fit the frame; data already in the buffer is
+	// read again from its copy. The copy is consumed by Peek below as a
+	// whole, and so pos stays correct.
+	if d.r.Size() < n {
+		buffered, _ := d.r.Peek(d.r.Buffered())
+		prefix := append([]byte(nil), buffered...)
+		d.r = bufio.NewReaderSize(io.MultiReader(bytes.NewReader(prefix), d.rc), n)
+	}
+
+	// other errors, if any, are reported when the frame data is actually read
+	_, err := d.r.Peek(n)
+	if err == io.EOF {
+		return io.EOF
+	}
+	return nil
+}
+
+// frameCheck verifies that decoding did not run past the end of current frame.
+//
+// It is called after every opcode is handled. If current frame ends
+// exactly at current position, the frame is considered to be finished.
+func (d *Decoder) frameCheck() error {
+	if !d.config.StrictFrames || d.frameEnd < 0 {
+		return nil
+	}
+
+	pos := d.pos()
+	switch {
+	case pos > d.frameEnd:
+		return fmt.Errorf("pickle: frame: opcode runs %d bytes past frame end", pos - d.frameEnd)
+	case pos == d.frameEnd:
+		d.frameEnd = -1
+	}
 	return nil
 }
 
@@ -1351,7 +2887,7 @@ func (d *Decoder) loadShortBinUnicode() error {
 	if err != nil {
 		return err
 	}
-	d.push(d.buf.String())
+	d.push(d.intern(d.buf.Bytes()))
 	return nil
 }
 
@@ -1380,6 +2916,10 @@ func (d *Decoder) loadMemoize() error {
 }
 
 func (d *Decoder) loadBytearray8() error {
+	if d.config.ZeroCopy && d.input != nil {
+		return d.loadBytearray8ZeroCopy()
+	}
+
 	err := d.bufLoadBinData8()
 	if err != nil {
 		return err
@@ -1389,14 +2929,75 @@ func (d *Decoder) loadBytearray8() error {
 	return nil
 }
 
+// loadBytearray8ZeroCopy serves loadBytearray8 in ZeroCopy mode.
+//
+// The data is taken directly from d.input and is skipped in the input stream.
+func (d *Decoder) loadBytearray8ZeroCopy() error {
+	var b [8]byte
+	_, err := io.ReadFull(d.r, b[:])
+	if err != nil {
+		return err
+	}
+	l := binary.LittleEndian.Uint64(b[:])
+
+	pos := d.pos()
+	if l > uint64(int64(len(d.input)) - pos) {
+		return io.EOF
+	}
+	end := pos + int64(l)
+	data := d.input[pos:end:end] // limit cap so that append to data does not clobber the input
+
+	// skip data: first what is buffered, and then the rest directly in underlying reader
+	n := int64(l)
+	nbuf := int64(d.r.Buffered())
+	if n > nbuf {
+		d.r.Discard(int(nbuf))
+		_, err = d.rc.r.(io.Seeker).Seek(n-nbuf, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+		d.rc.n += n-nbuf
+	} else {
+		d.r.Discard(int(n))
+	}
+
+	d.push(data)
+	return nil
+}
+
+// loadNextBuffer handles NEXT_BUFFER opcode.
 func (d *Decoder) loadNextBuffer() error {
-	// TODO consider adding support for out-of-band data in the future
-	return fmt.Errorf("next_buffer: no out-of-band data")
+	if d.config.Buffers == nil {
+		return fmt.Errorf("next_buffer: no out-of-band data")
+	}
+	if d.nextBuffer >= len(d.config.Buffers) {
+		return fmt.Errorf("next_buffer: not enough out-of-band buffers")
+	}
+	buf := d.config.Buffers[d.nextBuffer]
+	d.nextBuffer++
+	if buf == nil {
+		buf = []byte{}
+	}
+	d.push(buf)
+	return nil
 }
 
+// readOnlyBuffer handles READONLY_BUFFER opcode.
 func (d *Decoder) readOnlyBuffer() error {
-	// TODO consider adding support for out-of-band data in the future
-	return fmt.Errorf("read_only_buffer: stack top is not buffer")
+	if len(d.stack) < 1 {
+		return fmt.Errorf("read_only_buffer: stack top is not buffer")
+	}
+	switch buf := d.stack[len(d.stack)-1].(type) {
+	case []byte:
+		if !d.config.BytesAsSlice {
+			d.stack[len(d.stack)-1] = Bytes(buf)
+		}
+	case Bytes:
+		// already read-only
+	default:
+		return fmt.Errorf("read_only_buffer: stack top is not buffer")
+	}
+	return nil
 }
 
 // unquoteChar is like strconv.UnquoteChar, but returns io.ErrUnexpectedEOF
@@ -1506,3 +3107,13 @@ func pybuiltin(protocol int, name string) Class {
 
 	return Class{Module: module, Name: name}
 }
+
+// pycopyreg returns Class corresponding to name from Python copyreg module.
+func pycopyreg(protocol int, name string) Class {
+	module := "copyreg" // py3
+	if protocol <= 2 {
+		module = "copy_reg" // py2
+	}
+
+	return Class{Module: module, Name: name}
+}
@@ -2,14 +2,19 @@ package ogórek
 
 import (
 	"bytes"
+	"encoding/binary"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"math/big"
+	"net/netip"
+	"reflect"
 	"strconv"
 	"strings"
 	"testing"
+	"unsafe"
 )
 
 func bigInt(s string) *big.Int {
@@ -328,9 +333,11 @@ var tests = []TestEntry{
 		I("(lp0\n.")),
 
 	X("list([1,2,3,True])", []any{int64(1), int64(2), int64(3), true},
-		P0("(I1\nI2\nI3\nI01\nl."),    // MARK + INT + INT(True) + LIST
-		P1("(K\x01K\x02K\x03I01\nl."), // MARK + BININT1 + INT(True) + LIST
-		P2_("(K\x01K\x02K\x03\x88l."), // MARK + BININT1 + NEW_TRUE + LIST
+		P0("(lI1\naI2\naI3\naI01\na."),  // MARK + LIST + (INT + APPEND)*
+		P1("](K\x01K\x02K\x03I01\ne."),   // EMPTY_LIST + MARK + BININT1 + INT(True) + APPENDS
+		P2_("](K\x01K\x02K\x03\x88e."),   // EMPTY_LIST + MARK + BININT1 + NEW_TRUE + APPENDS
+		I("(I1\nI2\nI3\nI01\nl."),        // MARK + INT + INT(True) + LIST
+		I("(K\x01K\x02K\x03\x88l."),      // MARK + BININT1 + NEW_TRUE + LIST
 		I("(lp0\nI1\naI2\naI3\naI01\na.")),
 
 	// strings in default StrictUnicode=n mode
@@ -351,8 +358,8 @@ var tests = []TestEntry{
 		P4_("\x8c\x09\xe6\x97\xa5\xe6\x9c\xac\xe8\xaa\x9e."), // SHORT_BINUNICODE
 
 		I("V\\u65e5\\u672c\\u8a9e\np0\n."),                           // UNICODE
-		I("X\x09\x00\x00\x00\xe6\x97\xa5\xe6\x9c\xac\xe8\xaa\x9e.")), // BINUNICODE
-		// TODO BINUNICODE8
+		I("X\x09\x00\x00\x00\xe6\x97\xa5\xe6\x9c\xac\xe8\xaa\x9e."),                 // BINUNICODE
+		I("\x8d\x09\x00\x00\x00\x00\x00\x00\x00\xe6\x97\xa5\xe6\x9c\xac\xe8\xaa\x9e.")), // BINUNICODE8
 
 	Xuauto("unicode('\\' 知事少时烦恼少、识人多处是非多。')", "' 知事少时烦恼少、识人多处是非多。",
 		// UNICODE
@@ -362,9 +369,10 @@ var tests = []TestEntry{
 		P3("X\x32\x00\x00\x00' \xe7\x9f\xa5\xe4\xba\x8b\xe5\xb0\x91\xe6\x97\xb6\xe7\x83\xa6\xe6\x81\xbc\xe5\xb0\x91\xe3\x80\x81\xe8\xaf\x86\xe4\xba\xba\xe5\xa4\x9a\xe5\xa4\x84\xe6\x98\xaf\xe9\x9d\x9e\xe5\xa4\x9a\xe3\x80\x82."),
 
 		// SHORT_BINUNICODE
-		P4_("\x8c\x32' \xe7\x9f\xa5\xe4\xba\x8b\xe5\xb0\x91\xe6\x97\xb6\xe7\x83\xa6\xe6\x81\xbc\xe5\xb0\x91\xe3\x80\x81\xe8\xaf\x86\xe4\xba\xba\xe5\xa4\x9a\xe5\xa4\x84\xe6\x98\xaf\xe9\x9d\x9e\xe5\xa4\x9a\xe3\x80\x82.")),
+		P4_("\x8c\x32' \xe7\x9f\xa5\xe4\xba\x8b\xe5\xb0\x91\xe6\x97\xb6\xe7\x83\xa6\xe6\x81\xbc\xe5\xb0\x91\xe3\x80\x81\xe8\xaf\x86\xe4\xba\xba\xe5\xa4\x9a\xe5\xa4\x84\xe6\x98\xaf\xe9\x9d\x9e\xe5\xa4\x9a\xe3\x80\x82."),
 
-		// TODO BINUNICODE8
+		// BINUNICODE8
+		I("\x8d\x32\x00\x00\x00\x00\x00\x00\x00' \xe7\x9f\xa5\xe4\xba\x8b\xe5\xb0\x91\xe6\x97\xb6\xe7\x83\xa6\xe6\x81\xbc\xe5\xb0\x91\xe3\x80\x81\xe8\xaf\x86\xe4\xba\xba\xe5\xa4\x9a\xe5\xa4\x84\xe6\x98\xaf\xe9\x9d\x9e\xe5\xa4\x9a\xe3\x80\x82.")),
 
 	// strings in StrictUnicode=y mode
 
@@ -378,8 +386,8 @@ var tests = []TestEntry{
 	Xustrict("unicode('abc')", "abc",
 		P0("Vabc\n."),                 // UNICODE
 		P123("X\x03\x00\x00\x00abc."), // BINUNICODE
-		P4_("\x8c\x03abc.")),          // SHORT_BINUNICODE
-		// TODO BINUNICODE8
+		P4_("\x8c\x03abc."),           // SHORT_BINUNICODE
+		I("\x8d\x03\x00\x00\x00\x00\x00\x00\x00abc.")), // BINUNICODE8
 
 	Xustrict("str('日本語')", ByteString("日本語"),
 		P0("S\"日本語\"\n."), // STRING
@@ -391,8 +399,8 @@ var tests = []TestEntry{
 		P4_("\x8c\x09\xe6\x97\xa5\xe6\x9c\xac\xe8\xaa\x9e."), // SHORT_BINUNICODE
 
 		I("V\\u65e5\\u672c\\u8a9e\np0\n."),                           // UNICODE
-		I("X\x09\x00\x00\x00\xe6\x97\xa5\xe6\x9c\xac\xe8\xaa\x9e.")), // BINUNICODE
-		// TODO BINUNICODE8
+		I("X\x09\x00\x00\x00\xe6\x97\xa5\xe6\x9c\xac\xe8\xaa\x9e."),                 // BINUNICODE
+		I("\x8d\x09\x00\x00\x00\x00\x00\x00\x00\xe6\x97\xa5\xe6\x9c\xac\xe8\xaa\x9e.")), // BINUNICODE8
 
 	Xustrict("unicode(non-utf8)", "\x93",
 		P0(errP0UnicodeUTF8Only),       // UNICODE cannot represent non-UTF8 sequences
@@ -426,8 +434,9 @@ var tests = []TestEntry{
 		// GLOBAL + BINUNICODE + SHORT_BINSTRING + TUPLE2 + REDUCE
 		P2("c_codecs\nencode\nX\x13\x00\x00\x00hello\n\xc3\x90\xc2\xbc\xc3\x90\xc2\xb8\xc3\x91\xc2\x80\x01U\x06latin1\x86R."),
 
-		P3_("C\x0dhello\nмир\x01."),            // SHORT_BINBYTES
-		I("B\x0d\x00\x00\x00hello\nмир\x01.")), // BINBYTES
+		P3_("C\x0dhello\nмир\x01."),                            // SHORT_BINBYTES
+		I("B\x0d\x00\x00\x00hello\nмир\x01."),                   // BINBYTES
+		I("\x8e\x0d\x00\x00\x00\x00\x00\x00\x00hello\nмир\x01.")), // BINBYTES8
 
 	X(`bytearray(b"hello\nмир\x01")`, []byte("hello\nмир\x01"),
 		// GLOBAL + MARK + UNICODE + STRING + TUPLE + REDUCE
@@ -459,10 +468,14 @@ var tests = []TestEntry{
 		I("(dp0\n.")),
 
 	Xuauto_dgo("dict({'a': '1'})", map[any]any{"a": "1"},
-		P0("(S\"a\"\nS\"1\"\nd."),                     // MARK + STRING + DICT
-		P12("(U\x01aU\x011d."),                        // MARK + SHORT_BINSTRING + DICT
-		P3("(X\x01\x00\x00\x00aX\x01\x00\x00\x001d."), // MARK + BINUNICODE + DICT
-		P4_("(\x8c\x01a\x8c\x011d.")),                 // MARK + SHORT_BINUNICODE + DICT
+		P0("(dS\"a\"\nS\"1\"\ns."),                     // MARK + DICT + STRING + SETITEM
+		P12("}U\x01aU\x011s."),                         // EMPTY_DICT + SHORT_BINSTRING + SETITEM
+		P3("}X\x01\x00\x00\x00aX\x01\x00\x00\x001s."),  // EMPTY_DICT + BINUNICODE + SETITEM
+		P4_("}\x8c\x01a\x8c\x011s."),                  // EMPTY_DICT + SHORT_BINUNICODE + SETITEM
+		I("(S\"a\"\nS\"1\"\nd."),                       // MARK + STRING + DICT
+		I("(U\x01aU\x011d."),                           // MARK + SHORT_BINSTRING + DICT
+		I("(X\x01\x00\x00\x00aX\x01\x00\x00\x001d."),   // MARK + BINUNICODE + DICT
+		I("(\x8c\x01a\x8c\x011d.")),                   // MARK + SHORT_BINUNICODE + DICT
 
 	Xuauto_dgo("dict({'a': '1', 'b': '2'})", map[any]any{"a": "1", "b": "2"},
 		// map iteration order is not stable - test only decoding
@@ -485,10 +498,14 @@ var tests = []TestEntry{
 		I("(dp0\n.")),
 
 	Xuauto_dpy("dict({'a': '1'})", NewDictWithData("a","1"),
-		P0("(S\"a\"\nS\"1\"\nd."),                     // MARK + STRING + DICT
-		P12("(U\x01aU\x011d."),                        // MARK + SHORT_BINSTRING + DICT
-		P3("(X\x01\x00\x00\x00aX\x01\x00\x00\x001d."), // MARK + BINUNICODE + DICT
-		P4_("(\x8c\x01a\x8c\x011d.")),                 // MARK + SHORT_BINUNICODE + DICT
+		P0("(dS\"a\"\nS\"1\"\ns."),                     // MARK + DICT + STRING + SETITEM
+		P12("}U\x01aU\x011s."),                         // EMPTY_DICT + SHORT_BINSTRING + SETITEM
+		P3("}X\x01\x00\x00\x00aX\x01\x00\x00\x001s."),  // EMPTY_DICT + BINUNICODE + SETITEM
+		P4_("}\x8c\x01a\x8c\x011s."),                  // EMPTY_DICT + SHORT_BINUNICODE + SETITEM
+		I("(S\"a\"\nS\"1\"\nd."),                       // MARK + STRING + DICT
+		I("(U\x01aU\x011d."),                           // MARK + SHORT_BINSTRING + DICT
+		I("(X\x01\x00\x00\x00aX\x01\x00\x00\x001d."),   // MARK + BINUNICODE + DICT
+		I("(\x8c\x01a\x8c\x011d.")),                   // MARK + SHORT_BINUNICODE + DICT
 
 	Xuauto_dpy("dict({'a': '1', 'b': '2'})", NewDictWithData("a","1", "b","2"),
 		// map iteration order is not stable - test only decoding
@@ -504,18 +521,21 @@ var tests = []TestEntry{
 		I("(dp0\nS'a'\np1\nS'1'\np2\nsS'b'\np3\nS'2'\np4\ns.")),
 
 	Xdpy("dict({123L: 0})", NewDictWithData(bigInt("123"), int64(0)),
-		P0("(L123L\nI0\nd."),    // MARK + LONG + INT + DICT
-		P1("(L123L\nK\x00d."),   // MARK + LONG + BININT1 + DICT
+		P0("(dL123L\nI0\ns."),   // MARK + DICT + LONG + INT + SETITEM
+		P1("}L123L\nK\x00s."),   // EMPTY_DICT + LONG + BININT1 + SETITEM
+		I("(L123L\nK\x00d."),    // MARK + LONG + BININT1 + DICT
 		I("(\x8a\x01{K\x00d.")), // MARK + LONG1 + BININT1 + DICT
 
 	Xdpy("dict(tuple(): 0)", NewDictWithData(Tuple{}, int64(0)),
-		P0("((tI0\nd."),   // MARK + MARK + TUPLE + INT + DICT
-		P1_("()K\x00d.")), // MARK + EMPTY_TUPLE + BININT1 + DICT
+		P0("(d(tI0\ns."),  // MARK + DICT + MARK + TUPLE + INT + SETITEM
+		P1_("})K\x00s."),  // EMPTY_DICT + EMPTY_TUPLE + BININT1 + SETITEM
+		I("()K\x00d.")),   // MARK + EMPTY_TUPLE + BININT1 + DICT
 
 	Xdpy("dict(tuple(1,2): 0)", NewDictWithData(Tuple{int64(1), int64(2)}, int64(0)),
-		P0("((I1\nI2\ntI0\nd."),        // MARK + MARK + INT + INT + TUPLE + INT + DICT
-		P1("((K\x01K\x02tK\x00d."),     // MARK + MARK + BININT1 + BININT1 + TUPLE + BININT1 + DICT
-		P2_("(K\x01K\x02\x86K\x00d.")), // MARK + BININT1 + BININT1 + TUPLE2 + BININT1 + DICT
+		P0("(d(I1\nI2\ntI0\ns."),       // MARK + DICT + MARK + INT + INT + TUPLE + INT + SETITEM
+		P1("}(K\x01K\x02tK\x00s."),     // EMPTY_DICT + MARK + BININT1 + BININT1 + TUPLE + BININT1 + SETITEM
+		P2_("}K\x01K\x02\x86K\x00s."),  // EMPTY_DICT + BININT1 + BININT1 + TUPLE2 + BININT1 + SETITEM
+		I("(K\x01K\x02\x86K\x00d.")),   // MARK + BININT1 + BININT1 + TUPLE2 + BININT1 + DICT
 
 
 	Xuauto("foo.bar  # global", Class{Module: "foo", Name: "bar"},
@@ -552,6 +572,28 @@ var tests = []TestEntry{
 		P2_("K\x01K\x02\x86Q."),       // BININT1 + TUPLE2 + BINPERSID
 		I("(I1\nI2\ntQ.")),
 
+	// builtin constructors; decode only
+	X(`bytes(b"abc")`, Bytes("abc"),
+		I("c__builtin__\nbytes\nC\x03abc\x85R."),                 // bytes(bytes)
+		I("cbuiltins\nbytes\n(K\x61K\x62K\x63l\x85R."),          // bytes([int])
+		I("cbuiltins\nbytes\nX\x03\x00\x00\x00abcU\x06latin1\x86R."), // bytes(unicode, encoding)
+		I("cbuiltins\nbytes\nX\x03\x00\x00\x00abcU\x05utf-8\x86R.")),
+
+	X(`bytes()`, Bytes(""),
+		I("cbuiltins\nbytes\n)R.")),
+
+	X(`str("мир")  # py3`, "мир",
+		I("cbuiltins\nstr\nX\x06\x00\x00\x00мир\x85R."),             // str(unicode)
+		I("cbuiltins\nstr\nC\x06мирU\x05utf-8\x86R."),                // str(bytes, encoding)
+		I("cbuiltins\nstr\nX\x06\x00\x00\x00мир\x85R.")),
+
+	Xuauto(`str("abc")  # py2`, "abc",
+		I("c__builtin__\nstr\nU\x03abc\x85R.")),
+
+	Xustrict(`str("abc")  # py2`, ByteString("abc"),
+		I("c__builtin__\nstr\nU\x03abc\x85R."),
+		I("c__builtin__\nstr\nX\x03\x00\x00\x00abc\x85R.")),
+
 	// decode only
 	// TODO PUT + GET + BINGET + LONG_BINGET
 	X("LONG_BINPUT", []any{int64(17)},
@@ -572,17 +614,23 @@ var tests = []TestEntry{
 	Xloosy_uauto_dgo("[]ogórek.foo{\"Qux\", 4}", []foo{{"Qux", 4}},
 		[]any{map[any]any{"Foo": "Qux", "Bar": int64(4)}},
 
-		// MARK + STRING + INT + DICT + LIST
-		P0("((S\"Foo\"\nS\"Qux\"\nS\"Bar\"\nI4\ndl."),
+		// MARK + LIST + MARK + DICT + STRING + INT + SETITEM + APPEND
+		P0("(l(dS\"Foo\"\nS\"Qux\"\nsS\"Bar\"\nI4\nsa."),
+
+		// EMPTY_LIST + EMPTY_DICT + MARK + SHORT_BINSTRING + BININT1 + SETITEMS + APPEND
+		P12("]}(U\x03FooU\x03QuxU\x03BarK\x04ua."),
 
-		// MARK + SHORT_BINSTRING + BININT1 + DICT + LIST
-		P12("((U\x03FooU\x03QuxU\x03BarK\x04dl."),
+		// EMPTY_LIST + EMPTY_DICT + MARK + BINUNICODE + BININT1 + SETITEMS + APPEND
+		P3("]}(X\x03\x00\x00\x00FooX\x03\x00\x00\x00QuxX\x03\x00\x00\x00BarK\x04ua."),
 
-		// MARK + BINUNICODE + BININT1 + DICT + LIST
-		P3("((X\x03\x00\x00\x00FooX\x03\x00\x00\x00QuxX\x03\x00\x00\x00BarK\x04dl."),
+		// EMPTY_LIST + EMPTY_DICT + MARK + SHORT_BINUNICODE + BININT1 + SETITEMS + APPEND
+		P4_("]}(\x8c\x03Foo\x8c\x03Qux\x8c\x03BarK\x04ua.")),
 
-		// MARK + SHORT_BINUNICODE + BININT1 + DICT + LIST
-		P4_("((\x8c\x03Foo\x8c\x03Qux\x8c\x03BarK\x04dl.")),
+	// Go structs with positional tags are encoded as tuples.
+	Xloosy("ogórek.fooTuple{1, 2}", fooTuple{Y: 2, X: 1}, Tuple{int64(1), int64(2)},
+		P0("(I1\nI2\nt."),       // MARK + INT + TUPLE
+		P1("(K\x01K\x02t."),     // MARK + BININT1 + TUPLE
+		P2_("K\x01K\x02\x86.")), // BININT1 + TUPLE2
 }
 
 // foo is a type to test how encoder handles Go structs.
@@ -591,6 +639,12 @@ type foo struct {
 	Bar int32
 }
 
+// fooTuple is a type to test how encoder handles Go structs with positional tags.
+type fooTuple struct {
+	Y int64 `pickle:"1"`
+	X int64 `pickle:"0"`
+}
+
 // if test pickle starts from protoPrefixTemplate, this prefix is changed to
 // concrete `PROTO ver` when checking decoding. When checking encoding the
 // protocol prefix is always automatically prepended and is always concrete.
@@ -819,242 +873,1633 @@ func testEncode(t *testing.T, proto int, encConfig EncoderConfig, decConfig Deco
 }
 
 
-// test that .Decode() decodes only until stop opcode, and can continue
-// decoding further on next call
-func TestDecodeMultiple(t *testing.T) {
-	input := "I5\n.I7\n.N."
-	expected := []any{int64(5), int64(7), None{}}
-
-	buf := bytes.NewBufferString(input)
-	dec := NewDecoder(buf)
-
-	for i, objOk := range expected {
-		obj, err := dec.Decode()
-		if err != nil {
-			t.Errorf("step #%v: %v", i, err)
-		}
-
-		if !deepEqual(obj, objOk) {
-			t.Errorf("step #%v: %q  ; want %q", i, obj, objOk)
-		}
-	}
-
-	obj, err := dec.Decode()
-	if !(obj == nil && err == io.EOF) {
-		t.Errorf("decode: no EOF at end: obj = %#v  err = %#v", obj, err)
-	}
-}
+// verify that DedupStrings=y makes encoder emit repeated strings via memo.
+func TestEncodeDedupStrings(t *testing.T) {
+	obj := []any{"abc", Bytes("abc"), "abc", Bytes("abc")}
 
-func TestDecodeLong(t *testing.T) {
-	var testv = []struct {
-		data  string
-		value int64 // converted to big.Int by test driver
+	testv := []struct {
+		proto  int
+		dataOk string
 	}{
-		{"", 0},
-		{"\xff\x00", 255},
-		{"\xff\x7f", 32767},
-		{"\x00\xff", -256},
-		{"\x00\x80", -32768},
-		{"\x80", -128},
-		{"\x7f", 127},
+		{0, "(lS\"abc\"\np0\nac_codecs\nencode\n(Vabc\np1\nS\"latin1\"\np2\ntRp3\nag0\nag3\na."},
+		{1, "](U\x03abcq\x00c_codecs\nencode\n(X\x03\x00\x00\x00abcq\x01U\x06latin1q\x02tRq\x03h\x00h\x03e."},
+		{3, "\x80\x03](X\x03\x00\x00\x00abcq\x00C\x03abcq\x01h\x00h\x01e."},
+		{4, "\x80\x04](\x8c\x03abc\x94C\x03abc\x94h\x00h\x01e."},
 	}
 
 	for _, tt := range testv {
-		value, err := decodeLong(tt.data)
+		buf := &bytes.Buffer{}
+		enc := NewEncoderWithConfig(buf, &EncoderConfig{Protocol: tt.proto, DedupStrings: true})
+		err := enc.Encode(obj)
 		if err != nil {
-			t.Errorf("data %q: %s", tt.data, err)
+			t.Errorf("proto %d: encode: %s", tt.proto, err)
 			continue
 		}
-
-		valueOk := big.NewInt(tt.value)
-		if valueOk.Cmp(value) != 0 {
-			t.Errorf("data %q: ->long: got %s  ; want %s", tt.data, value, valueOk)
+		data := buf.String()
+		if data != tt.dataOk {
+			t.Errorf("proto %d: encode:\nhave: %s\nwant: %s", tt.proto, pyquote(data), pyquote(tt.dataOk))
 		}
-	}
-}
 
-func BenchmarkDecodeLong(b *testing.B) {
-	for i := 0; i < b.N; i++ {
-		data := "\x00\x80"
-		_, err := decodeLong(data)
+		dec := NewDecoder(bytes.NewBufferString(data))
+		v, err := dec.Decode()
 		if err != nil {
-			b.Errorf("Error from decodeLong - %v\n", err)
+			t.Errorf("proto %d: decode back: %s", tt.proto, err)
+			continue
+		}
+		if !deepEqual(v, obj) {
+			t.Errorf("proto %d: decode back:\nhave: %#v\nwant: %#v", tt.proto, v, obj)
 		}
 	}
 }
 
-func TestMemoOpCode(t *testing.T) {
-	buf := bytes.NewBufferString("I5\n\x94.")
-	dec := NewDecoder(buf)
-	_, err := dec.Decode()
+// verify that PreserveRefs=y decodes all references to the same list or
+// object to the same Go object.
+func TestDecodePreserveRefs(t *testing.T) {
+	// l = [1]; c = C(); c.x = l; [l, l, c, c]
+	data := "\x80\x04\x95,\x00\x00\x00\x00\x00\x00\x00]\x94(]\x94K\x01ah\x01" +
+		"\x8c\x08__main__\x94\x8c\x01C\x94\x93\x94)\x81\x94}\x94\x8c\x01x\x94h\x01sbh\x05e."
+
+	dec := NewDecoderWithConfig(strings.NewReader(data), &DecoderConfig{PreserveRefs: true})
+	v, err := dec.Decode()
 	if err != nil {
-		t.Errorf("Error from TestMemoOpCode - %v\n", err)
+		t.Fatal(err)
 	}
-	if dec.memo["0"] != int64(5) {
-		t.Errorf("Error from TestMemoOpCode - Top stack value was not added to memo")
+
+	pv, ok := v.(*[]any)
+	if !(ok && len(*pv) == 4) {
+		t.Fatalf("decode: got %#v", v)
+	}
+	r := *pv
+	l, ok := r[0].(*[]any)
+	if !(ok && l == r[1] && reflect.DeepEqual(*l, []any{int64(1)})) {
+		t.Errorf("list: got %#v, %#v", r[0], r[1])
+	}
+	obj, ok := r[2].(*Object)
+	if !(ok && obj == r[3]) {
+		t.Fatalf("object: got %#v, %#v", r[2], r[3])
+	}
+	if state, ok := obj.State.(map[any]any); !(ok && state["x"] == l) {
+		t.Errorf("object state: got %#v", obj.State)
 	}
 
+	// the references are preserved when encoding back with Memoize
+	buf := &bytes.Buffer{}
+	err = NewEncoderWithConfig(buf, &EncoderConfig{Protocol: 2, Memoize: true}).Encode(v)
+	if want := "\x80\x02](]K\x01aq\x00h\x00c__main__\nC\n)\x81}U\x01xq\x01h\x00sq\x02bq\x03h\x03eq\x04."; !(err == nil && buf.String() == want) {
+		t.Errorf("encode:\nhave: %s %v\nwant: %s", pyquote(buf.String()), err, pyquote(want))
+	}
 }
 
-// verify that decode of erroneous input produces error
-func TestDecodeError(t *testing.T) {
-	testv := []string{
-		// all kinds of opcodes to read memo but key is not there
-		"}g1\n.",
-		"}h\x01.",
-		"}j\x01\x02\x03\x04.",
+// verify that containers, that are modified after being memoized, are loaded
+// from memo with the modifications.
+func TestDecodeMemoModified(t *testing.T) {
+	// l = [1]; c = C(); c.x = l; [l, l, c, c]
+	data := "\x80\x04\x95,\x00\x00\x00\x00\x00\x00\x00]\x94(]\x94K\x01ah\x01" +
+		"\x8c\x08__main__\x94\x8c\x01C\x94\x93\x94)\x81\x94}\x94\x8c\x01x\x94h\x01sbh\x05e."
 
-		// invalid long format
-		"L123\n.",
-		"L12qL\n.",
+	l := []any{int64(1)}
+	obj := Object{Class: Class{"__main__", "C"}, Args: Tuple{}, State: map[any]any{"x": l}}
+	want := []any{l, l, obj, obj}
 
-		// invalid protocol version
-		"\x80\xffI1\n.",
+	v, err := NewDecoder(strings.NewReader(data)).Decode()
+	if !(err == nil && reflect.DeepEqual(v, want)) {
+		t.Errorf("decode:\nhave: %#v, %v\nwant: %#v", v, err, want)
+	}
+}
 
-		// BINSTRING and BINUNICODE with big len and no data
-		// (might cause out-of-memory DOS if buffer is preallocated blindly)
-		"T\xff\xff\xff\xff.",
-		"X\xff\xff\xff\xff.",
+// verify how recursive structures are decoded.
+func TestDecodeRecursive(t *testing.T) {
+	// l = []; l.append(l)
+	listSelf := "\x80\x02]q\x00h\x00a."
+	// l = []; t = (l,); l.append(t); t
+	tupleSelf := "(]q\x00(h\x00tq\x01a1h\x01."
+	// d = {}; d['s'] = d
+	dictSelf := "\x80\x02}q\x00X\x01\x00\x00\x00sq\x01h\x00s."
+
+	// without PreserveRefs recursive lists cannot be represented, and the
+	// recursive reference sees the list as it was at that time
+	v, err := NewDecoder(strings.NewReader(listSelf)).Decode()
+	if want := []any{[]any{}}; !(err == nil && reflect.DeepEqual(v, want)) {
+		t.Errorf("list: decode: have %#v, %v; want %#v", v, err, want)
+	}
 
+	// with PreserveRefs recursive lists are decoded as is
+	v, err = NewDecoderWithConfig(strings.NewReader(listSelf), &DecoderConfig{PreserveRefs: true}).Decode()
+	if p, ok := v.(*[]any); !(err == nil && ok && len(*p) == 1 && (*p)[0] == p) {
+		t.Errorf("list: decode: %#v, %v", v, err)
+	}
+	v, err = NewDecoderWithConfig(strings.NewReader(tupleSelf), &DecoderConfig{PreserveRefs: true}).Decode()
+	if tup, ok := v.(Tuple); !(err == nil && ok && len(tup) == 1) {
+		t.Errorf("tuple: decode: %#v, %v", v, err)
+	} else if p, ok := tup[0].(*[]any); !(ok && len(*p) == 1 && reflect.DeepEqual((*p)[0], tup)) {
+		t.Errorf("tuple: decode: %#v", tup[0])
+	}
+	if s, want := Sprint("%v", v, &FormatLimits{}), "[&[[&[…]]]]"; s != want {
+		t.Errorf("tuple: sprint: have %q; want %q", s, want)
+	}
+	err = NewEncoderWithConfig(&bytes.Buffer{}, &EncoderConfig{Protocol: 2, Memoize: true}).Encode(v)
+	if want := "pickle: encode: [0][0]: recursive structures are not supported"; !(err != nil && err.Error() == want) {
+		t.Errorf("tuple: encode: have %v; want %s", err, want)
+	}
 
-		// it is invalid to expose mark object
-		"(.",                        // MARK
-		"(\x85.",                    // MARK + TUPLE1
-		"((\x86.",                   // MARK·2 + TUPLE2
-		"(((\x87.",                  // MARK·3 + TUPLE3
-		"](a.",                      // EMPTY_LIST + MARK + APPEND
-		"(p0\n0g0\nt.",              // MARK + PUT + POP + GET + TUPLE
-		"(q\x000g0\nt.",             // MARK + BINPUT + ...
-		"(r\x00\x00\x00\x000g0\nt.", // MARK + LONG_BINPUT + ...
-		"(\x940g0\nt.",              // MARK + MEMOIZE + ...
-		"}I1\n(s.",                  // EMPTY_DICT + INT + MARK + SETITEM
-		"}(I1\ns.",                  // EMPTY_DICT + MARK + INT + SETITEM
-		"(Q.",                       // MARK + BINPERSID
+	// dicts are references and can be recursive without PreserveRefs
+	v, err = NewDecoder(strings.NewReader(dictSelf)).Decode()
+	if m, ok := v.(map[any]any); !(err == nil && ok && len(m) == 1 && reflect.ValueOf(m["s"]).UnsafePointer() == reflect.ValueOf(m).UnsafePointer()) {
+		t.Errorf("dict: decode: %v, %v", ok, err)
+	}
+	v, err = NewDecoderWithConfig(strings.NewReader(dictSelf), &DecoderConfig{NumbersAsFloat: true}).Decode()
+	if m, ok := v.(map[any]any); !(err == nil && ok && reflect.ValueOf(m["s"]).UnsafePointer() == reflect.ValueOf(m).UnsafePointer()) {
+		t.Errorf("dict: decode with NumbersAsFloat: %v, %v", ok, err)
+	}
+	_, err = NewDecoderWithConfig(strings.NewReader(dictSelf), &DecoderConfig{JSONSafe: true}).Decode()
+	if want := "jsonsafe: recursive structure"; !(err != nil && err.Error() == want) {
+		t.Errorf("dict: decode with JSONSafe: have %v; want %s", err, want)
+	}
+}
 
-		// \r\n should not be read as combined EOL - only \n is
-		"L123L\r\n.",
-		"S'abc'\r\n.",
+// verify that shared, but not recursive, structures are converted once.
+func TestDecodeSharedDAG(t *testing.T) {
+	// t = 1; t = (t, t) 30 times with every level memoized
+	tupleDAG := "\x80\x02K\x01" + strings.Repeat("q\x00h\x00\x86", 30) + "."
+	// d = {}; [d, {'a': d}, d]
+	dictDAG := "\x80\x02]q\x00(}q\x01}q\x02X\x01\x00\x00\x00aq\x03h\x01sh\x01e."
 
-		// out-of-band data  (TODO might consider to add support for it in the future)
-		"\x97.", // NEXT_BUFFER
-		"\x98.", // READONLY_BUFFER
+	v, err := NewDecoderWithConfig(strings.NewReader(tupleDAG), &DecoderConfig{JSONSafe: true}).Decode()
+	if err != nil {
+		t.Fatalf("tuple: decode with JSONSafe: %v", err)
 	}
-	for _, tt := range testv {
-		buf := bytes.NewBufferString(tt)
-		dec := NewDecoder(buf)
-		v, err := dec.Decode()
-		if !(v == nil && err != nil) {
-			t.Errorf("%q: no decode error  ; got %#v, %#v", tt, v, err)
+	for i := 0; i < 30; i++ {
+		l, ok := v.([]any)
+		if !(ok && len(l) == 2) {
+			t.Fatalf("tuple: level %d: have %#v", i, v)
 		}
+		v = l[0]
+	}
+	if v != int64(1) {
+		t.Errorf("tuple: leaf: have %#v  ; want 1", v)
 	}
-}
 
-// verify how decoder/encoder handle application-level settings wrt Refs.
-func TestPersistentRefs(t *testing.T) {
-	// ZBTree mimics BTree from ZODB.
-	type ZBTree struct {
-		oid string
+	v, err = NewDecoderWithConfig(strings.NewReader(dictDAG), &DecoderConfig{JSONSafe: true}).Decode()
+	want := []any{map[string]any{}, map[string]any{"a": map[string]any{}}, map[string]any{}}
+	if !(err == nil && reflect.DeepEqual(v, want)) {
+		t.Errorf("dict: decode with JSONSafe:\nhave: %#v, %v\nwant: %#v", v, err, want)
 	}
+}
 
-	errInvalidRef := errors.New("invalid reference")
+// verify that Memoize=y makes encoder emit repeated objects via memo.
+func TestEncodeMemoize(t *testing.T) {
+	m := map[any]any{"a": int64(1)}
+	l := []any{int64(2)}
+	d := NewDictWithData("k", "v")
+	obj := []any{m, l, m, l, l[:0], d, d, "s", "s"}
+	dm := map[any]any{"k": "v"}
+	objOk := []any{m, l, m, l, l[:0], dm, dm, "s", "s"}
 
-	// Ref -> ? object
-	loadref := func(ref Ref) (any, error) {
-		// pretend we handle "zodb.BTree" -> ZBTree.
-		t, ok := ref.Pid.(Tuple)
-		if !ok || len(t) != 2 {
-			return nil, errInvalidRef
-		}
+	testv := []struct {
+		proto  int
+		dataOk string
+	}{
+		{0, "(l(dS\"a\"\np0\nI1\nsp1\na(lI2\nap2\nag1\nag2\na(la(dS\"k\"\np3\nS\"v\"\np4\nsp5\nag5\naS\"s\"\np6\nag6\nap7\n."},
+		{2, "\x80\x02](}U\x01aq\x00K\x01sq\x01]K\x02aq\x02h\x01h\x02]}U\x01kq\x03U\x01vq\x04sq\x05h\x05U\x01sq\x06h\x06eq\x07."},
+		{4, "\x80\x04](}\x8c\x01a\x94K\x01s\x94]K\x02a\x94h\x01h\x02]}\x8c\x01k\x94\x8c\x01v\x94s\x94h\x05\x8c\x01s\x94h\x06e\x94."},
+	}
 
-		class, ok1 := t[0].(Class)
-		oid, ok2   := t[1].(string)
-		if !(ok1 && ok2) {
-			return nil, errInvalidRef
+	for _, tt := range testv {
+		buf := &bytes.Buffer{}
+		enc := NewEncoderWithConfig(buf, &EncoderConfig{Protocol: tt.proto, Memoize: true})
+		err := enc.Encode(obj)
+		if err != nil {
+			t.Errorf("proto %d: encode: %s", tt.proto, err)
+			continue
+		}
+		data := buf.String()
+		if data != tt.dataOk {
+			t.Errorf("proto %d: encode:\nhave: %s\nwant: %s", tt.proto, pyquote(data), pyquote(tt.dataOk))
 		}
 
-		switch class {
-		case Class{Module: "zodb", Name: "BTree"}:
-			return &ZBTree{oid}, nil
-
-		default:
-			// leave it as is
-			return nil, nil
+		dec := NewDecoder(bytes.NewBufferString(data))
+		v, err := dec.Decode()
+		if err != nil {
+			t.Errorf("proto %d: decode back: %s", tt.proto, err)
+			continue
+		}
+		if !deepEqual(v, objOk) {
+			t.Errorf("proto %d: decode back:\nhave: %#v\nwant: %#v", tt.proto, v, objOk)
 		}
 	}
+}
 
-	// object -> ? Ref
-	getref := func(obj any) *Ref {
-		// pretend we handle ZBTree.
-		switch obj := obj.(type) {
-		default:
-			return nil
+// verify that Memoize=y preserves identity of pointed-to objects.
+func TestEncodeMemoizePointers(t *testing.T) {
+	p := &foo{Foo: "a", Bar: 1}
+	arr := &[2]int64{1, 2}
+	obj := []any{p, p, arr, arr, &foo{Foo: "a", Bar: 1}}
 
-		case *ZBTree:
-			return &Ref{Pid: Tuple{Class{Module: "zodb", Name: "BTree"}, obj.oid}}
-		}
+	buf := &bytes.Buffer{}
+	err := NewEncoderWithConfig(buf, &EncoderConfig{Protocol: 4, Memoize: true}).Encode(obj)
+	if err != nil {
+		t.Fatal(err)
 	}
+	// the second foo is different object and is emitted in full
+	want := "\x80\x04](}(\x8c\x03Foo\x94\x8c\x01a\x94\x8c\x03Bar\x94K\x01u\x94h\x03" +
+		"](K\x01K\x02e\x94h\x04" +
+		"}(h\x00h\x01h\x02K\x01u\x94e\x94."
+	if buf.String() != want {
+		t.Errorf("encode:\nhave: %s\nwant: %s", pyquote(buf.String()), pyquote(want))
+	}
+}
 
-	dconf := &DecoderConfig{PersistentLoad: loadref}
-	econf := &EncoderConfig{PersistentRef: getref, Protocol: 1}
+// verify that SortKeys=y makes encoder output deterministic.
+func TestEncodeSortKeys(t *testing.T) {
+	m := map[any]any{"b": int64(1), "a": int64(2), int64(10): int64(3), 2.5: int64(4)}
+	d := NewDictWithData("z", int64(1), Tuple{int64(1), "x"}, int64(2), "y", int64(3), int64(10), int64(4))
+	st := struct {
+		Z int64 `pickle:"z"`
+		Y int64 `pickle:"y"`
+	}{1, 2}
 
 	testv := []struct {
-		input    string
-		expected any
+		obj    any
+		dataOk string
 	}{
-		{"Pabc\n.", errInvalidRef},
-		{"\x80\x01S'abc'\nQ.", errInvalidRef},
-		{"\x80\x01S'abc'\nS'123'\n\x86Q.", errInvalidRef},
-		{"\x80\x01cfoo\nbar\nS'123'\n\x86Q.", Ref{Tuple{Class{Module: "foo", Name: "bar"}, "123"}}},
-		{"\x80\x01czodb\nBTree\nS'123'\n\x86Q.", &ZBTree{oid: "123"}},
+		{m, "\x80\x02}(G@\x04\x00\x00\x00\x00\x00\x00K\x04K\nK\x03U\x01aK\x02U\x01bK\x01u."},
+		{d, "\x80\x02}(K\nK\x04U\x01yK\x03U\x01zK\x01K\x01U\x01x\x86K\x02u."},
+		{st, "\x80\x02}(U\x01yK\x02U\x01zK\x01u."},
 	}
 
 	for _, tt := range testv {
-		// decode(input) -> expected
-		buf := bytes.NewBufferString(tt.input)
-		dec := NewDecoderWithConfig(buf, dconf)
-		v, err := dec.Decode()
-
-		expected := tt.expected
-		errExpect := ""
-		if e, iserr := expected.(error); iserr {
-			expected = nil
-			errExpect = "pickle: handleRef: " + e.Error()
+		for i := 0; i < 10; i++ {
+			buf := &bytes.Buffer{}
+			err := NewEncoderWithConfig(buf, &EncoderConfig{Protocol: 2, SortKeys: true}).Encode(tt.obj)
+			if err != nil {
+				t.Fatalf("%#v: encode: %s", tt.obj, err)
+			}
+			if buf.String() != tt.dataOk {
+				t.Fatalf("%#v: encode:\nhave: %s\nwant: %s", tt.obj, pyquote(buf.String()), pyquote(tt.dataOk))
+			}
 		}
+	}
+}
 
-		if !(deepEqual(v, expected) &&
-			((err == nil && errExpect == "") || err.Error() == errExpect)) {
-			t.Errorf("%q: decode -> %#v, %q; want %#v, %q",
-				tt.input, v, err, expected, errExpect)
-		}
+// verify handling of `pickle:"-"` and omitempty struct tag options.
+func TestEncodeStructTagOptions(t *testing.T) {
+	type tagged struct {
+		A        string `pickle:"a"`
+		B        int    `pickle:"b,omitempty"`
+		C        []int  `pickle:",omitempty"`
+		Skip     int    `pickle:"-"`
+		Dash     int    `pickle:"-,"`
+		Untagged int
+	}
+	type untagged struct {
+		A int
+		B int  `pickle:"-"`
+		C *int `pickle:",omitempty"`
+	}
+
+	testv := []struct {
+		obj    any
+		dataOk string
+	}{
+		{tagged{A: "x", Skip: 1, Dash: 2, Untagged: 3}, "\x80\x02}(U\x01aU\x01xU\x01-K\x02u."},
+		{tagged{A: "x", B: 1, C: []int{1}}, "\x80\x02}(U\x01aU\x01xU\x01bK\x01U\x01C]K\x01aU\x01-K\x00u."},
+		{untagged{A: 1, B: 2}, "\x80\x02}U\x01AK\x01s."},
+	}
 
+	for _, tt := range testv {
+		buf := &bytes.Buffer{}
+		err := NewEncoderWithConfig(buf, &EncoderConfig{Protocol: 2}).Encode(tt.obj)
 		if err != nil {
-			continue
+			t.Fatalf("%#v: encode: %s", tt.obj, err)
+		}
+		if buf.String() != tt.dataOk {
+			t.Errorf("%#v: encode:\nhave: %s\nwant: %s", tt.obj, pyquote(buf.String()), pyquote(tt.dataOk))
 		}
+	}
 
-		// expected -> encode -> decode = identity
-		buf.Reset()
-		enc := NewEncoderWithConfig(buf, econf)
-		err = enc.Encode(tt.expected)
+	// "-" fields are skipped on decoding as well
+	var u untagged
+	err := NewDecoder(bytes.NewBufferString("\x80\x02}(U\x01AK\x01U\x01BK\x02u.")).DecodeInto(&u)
+	if !(err == nil && u == untagged{A: 1}) {
+		t.Errorf("decode into: %#v, %v", u, err)
+	}
+}
+
+// verify how structs marked with Python class are encoded and decoded.
+func TestStructClass(t *testing.T) {
+	type point struct {
+		_ struct{} `pickle:"myapp.models.Point"`
+		X int64    `pickle:"x"`
+		Y int64    `pickle:"y"`
+	}
+	type inner struct {
+		_ struct{} `pickle:"myapp:Outer.Inner"`
+		A int64
+	}
+	type pair struct {
+		_ struct{} `pickle:"myapp.Pair"`
+		A string   `pickle:"0"`
+		B int64    `pickle:"1"`
+	}
+
+	testv := []struct {
+		obj    any
+		dataOk string
+	}{
+		{point{X: 1, Y: 2}, "\x80\x02cmyapp.models\nPoint\n)\x81}(U\x01xK\x01U\x01yK\x02ub."},
+		{inner{A: 1}, "\x80\x02cmyapp\nOuter.Inner\n)\x81}U\x01AK\x01sb."},
+		{pair{A: "a", B: 1}, "\x80\x02cmyapp\nPair\nU\x01aK\x01\x86\x81."},
+	}
+
+	for _, tt := range testv {
+		buf := &bytes.Buffer{}
+		err := NewEncoderWithConfig(buf, &EncoderConfig{Protocol: 2}).Encode(tt.obj)
 		if err != nil {
-			t.Errorf("%q: encode(expected) -> %q", tt.input, err)
-			continue
+			t.Fatalf("%#v: encode: %s", tt.obj, err)
+		}
+		if buf.String() != tt.dataOk {
+			t.Errorf("%#v: encode:\nhave: %s\nwant: %s", tt.obj, pyquote(buf.String()), pyquote(tt.dataOk))
 		}
 
-		dec = NewDecoderWithConfig(buf, dconf)
-		v, err = dec.Decode()
+		v := reflect.New(reflect.TypeOf(tt.obj))
+		err = NewDecoder(buf).DecodeInto(v.Interface())
 		if err != nil {
-			t.Errorf("%q: expected -> encode -> decode: %q", tt.input, err)
-			continue
+			t.Errorf("%#v: decode into: %s", tt.obj, err)
+		} else if !reflect.DeepEqual(v.Elem().Interface(), tt.obj) {
+			t.Errorf("%#v: decode into: have %#v", tt.obj, v.Elem().Interface())
 		}
+	}
 
-		if !deepEqual(v, tt.expected) {
-			t.Errorf("%q: expected -> encode -> decode != identity\nhave: %#v\nwant: %#v",
-				tt.input, v, tt.expected)
+	var in inner
+	err := NewDecoder(bytes.NewBufferString(testv[0].dataOk)).DecodeInto(&in)
+	if err == nil || !strings.HasSuffix(err.Error(), ": class myapp.models.Point != myapp.Outer.Inner") {
+		t.Errorf("decode into struct of other class: %v", err)
+	}
+}
+
+// verify that EncodedSize matches length of actually encoded data.
+func TestEncodedSize(t *testing.T) {
+	for _, test := range tests {
+		for proto := 0; proto <= highestProtocol; proto++ {
+			config := &EncoderConfig{Protocol: proto}
+
+			buf := &bytes.Buffer{}
+			enc := NewEncoderWithConfig(buf, config)
+			errOk := enc.Encode(test.objectIn)
+
+			n, err := EncodedSize(test.objectIn, config)
+			if err != errOk {
+				t.Errorf("%s: proto %d: EncodedSize: err = %v  ; want %v", test.name, proto, err, errOk)
+				continue
+			}
+			if err == nil && n != int64(buf.Len()) {
+				t.Errorf("%s: proto %d: EncodedSize = %d  ; want %d", test.name, proto, n, buf.Len())
+			}
 		}
 	}
 }
 
-func TestFuzzCrashers(t *testing.T) {
+// verify how encoder handles values that need higher protocol in different protocol modes.
+func TestEncodeProtocolMode(t *testing.T) {
+	testv := []struct {
+		obj   any
+		proto int // minimum protocol to represent obj natively
+	}{
+		{Bytes("abc"), 3},
+		{[]byte("abc"), 5},
+		{[]any{int64(1), Bytes("abc"), []byte("def")}, 5},
+		{[]any{int64(1), "abc"}, 0},
+	}
+
+	for _, tt := range testv {
+		for proto := 0; proto <= highestProtocol; proto++ {
+			// emulate: default encoding
+			buf := &bytes.Buffer{}
+			err := NewEncoderWithConfig(buf, &EncoderConfig{Protocol: proto}).Encode(tt.obj)
+			if err != nil {
+				t.Fatalf("%#v: proto %d: emulate: %s", tt.obj, proto, err)
+			}
+			emulated := buf.String()
+
+			// strict: error iff proto is too low
+			buf.Reset()
+			err = NewEncoderWithConfig(buf, &EncoderConfig{Protocol: proto, ProtocolMode: ProtocolStrict}).Encode(tt.obj)
+			var perr *ProtocolError
+			if proto < tt.proto {
+				if !(errors.As(err, &perr) && perr.Protocol <= tt.proto && perr.Protocol > proto) {
+					t.Errorf("%#v: proto %d: strict: have error %v  ; want ProtocolError", tt.obj, proto, err)
+				}
+			} else if !(err == nil && buf.String() == emulated) {
+				t.Errorf("%#v: proto %d: strict: have %q, %v  ; want %q", tt.obj, proto, buf.String(), err, emulated)
+			}
+
+			// bump: encoding at max(proto, tt.proto)
+			proto2 := proto
+			if proto2 < tt.proto {
+				proto2 = tt.proto
+			}
+			want := &bytes.Buffer{}
+			err = NewEncoderWithConfig(want, &EncoderConfig{Protocol: proto2}).Encode(tt.obj)
+			if err != nil {
+				t.Fatal(err)
+			}
+			buf.Reset()
+			config := &EncoderConfig{Protocol: proto, ProtocolMode: ProtocolBump}
+			err = NewEncoderWithConfig(buf, config).Encode(tt.obj)
+			if !(err == nil && buf.String() == want.String()) {
+				t.Errorf("%#v: proto %d: bump:\nhave: %q, %v\nwant: %q", tt.obj, proto, buf.String(), err, want.String())
+			}
+			if config.Protocol != proto {
+				t.Errorf("%#v: proto %d: bump: config changed: protocol=%d", tt.obj, proto, config.Protocol)
+			}
+		}
+	}
+
+	// error names the value and the protocol
+	err := NewEncoderWithConfig(&bytes.Buffer{}, &EncoderConfig{Protocol: 2, ProtocolMode: ProtocolStrict}).Encode(Bytes("abc"))
+	if want := `pickle: encode: ogórek.Bytes("abc"): requires protocol >= 3`; err == nil || err.Error() != want {
+		t.Errorf("strict: error message:\nhave: %v\nwant: %s", err, want)
+	}
+}
+
+// verify that encoder in Strict mode rejects values whose encoding does not round-trip.
+func TestEncodeStrict(t *testing.T) {
+	type S struct{ X int }
+
+	testv := []struct {
+		obj   any
+		errOk string // "" if encoding must succeed
+	}{
+		{int64(1), ""},
+		{uint64(math.MaxInt64), ""},
+		{[]any{"a", Bytes("b"), Tuple{None{}}, NewDictWithData("a", int64(1))}, ""},
+		{Call{Class{"mod", "f"}, Tuple{bigInt("12345678901234567890")}}, ""},
+		{uint64(math.MaxInt64 + 1), "pickle: encode: 0x8000000000000000: lossy encoding: uint64 > MaxInt64 decodes as *big.Int"},
+		{S{1}, "pickle: encode: ogórek.S{X:1}: lossy encoding: Go struct decodes as dict"},
+		{&S{1}, "pickle: encode: ogórek.S{X:1}: lossy encoding: Go struct decodes as dict"},
+		{fooTuple{1, 2}, "pickle: encode: ogórek.fooTuple{Y:1, X:2}: lossy encoding: Go struct decodes as tuple"},
+		{[]any{int64(1), map[any]any{"a": S{2}}}, "pickle: encode: [1].a: ogórek.S{X:2}: lossy encoding: Go struct decodes as dict"},
+	}
+
+	for _, tt := range testv {
+		for proto := 0; proto <= highestProtocol; proto++ {
+			err := NewEncoderWithConfig(&bytes.Buffer{}, &EncoderConfig{Protocol: proto, Strict: true}).Encode(tt.obj)
+			var lerr *LossyError
+			switch {
+			case tt.errOk == "" && err != nil:
+				t.Errorf("%#v: proto %d: unexpected error: %s", tt.obj, proto, err)
+			case tt.errOk != "" && !(errors.As(err, &lerr) && err.Error() == tt.errOk):
+				t.Errorf("%#v: proto %d: error:\nhave: %v\nwant: %s", tt.obj, proto, err, tt.errOk)
+			}
+		}
+	}
+}
+
+// verify that encode errors report path to the offending value.
+func TestEncodeErrorPath(t *testing.T) {
+	type Payload struct {
+		Ch chan int
+	}
+	type Result struct {
+		Payload Payload `pickle:"payload"`
+	}
+
+	testv := []struct {
+		obj    any
+		config EncoderConfig
+		errOk  string
+	}{
+		// top-level TypeError keeps its text without "pickle: encode:" prefix
+		{make(chan int), EncoderConfig{Protocol: 2}, "no support for type 'chan'"},
+		{func() {}, EncoderConfig{Protocol: 2}, "no support for type 'func'"},
+		// nested TypeError is prefixed and carries path
+		{[]any{make(chan int)}, EncoderConfig{Protocol: 2}, "pickle: encode: [0]: no support for type 'chan'"},
+		{map[any]any{"results": []any{1, 2, 3, Result{}}}, EncoderConfig{Protocol: 2},
+			"pickle: encode: .results[3].Payload.Ch: no support for type 'chan'"},
+		{Tuple{1, map[any]any{int64(2): Ref{Pid: func() {}}}}, EncoderConfig{Protocol: 2},
+			"pickle: encode: [1][2].Pid: no support for type 'func'"},
+		{NewDictWithData("a b", Call{Class{"mod", "f"}, Tuple{[]byte("x")}}), EncoderConfig{Protocol: 4, ProtocolMode: ProtocolStrict},
+			`pickle: encode: ["a b"].Args[0]: []byte{0x78}: requires protocol >= 5`},
+		{[]KV{{"a", Ref{"x\n"}}}, EncoderConfig{Protocol: 0},
+			"pickle: encode: [0].Value: protocol 0: persistent ID must be string without \\n"},
+		{[]any{Class{"a\nb", "c"}}, EncoderConfig{Protocol: 0},
+			"pickle: encode: [0]: protocol 0-3: global: module & name must be string without \\n"},
+		{[]any{struct{X int `pickle:"0"`; Ch chan int `pickle:"1"`}{}}, EncoderConfig{Protocol: 2},
+			"pickle: encode: [0].Ch: no support for type 'chan'"},
+		{Object{Class: Class{"mod", "C"}, Args: Tuple{1, make(chan int)}}, EncoderConfig{Protocol: 1},
+			"pickle: encode: .Args[1]: no support for type 'chan'"},
+		{Object{Class: Class{"mod", "C"}, Args: Tuple{make(chan int)}, KwArgs: map[string]any{"a": 1}}, EncoderConfig{Protocol: 2},
+			"pickle: encode: .Args[0]: no support for type 'chan'"},
+		{Object{Class: Class{"mod", "C"}, KwArgs: map[string]any{"a": func() {}}}, EncoderConfig{Protocol: 2},
+			"pickle: encode: .KwArgs.a: no support for type 'func'"},
+	}
+
+	for _, tt := range testv {
+		err := NewEncoderWithConfig(&bytes.Buffer{}, &tt.config).Encode(tt.obj)
+		if err == nil || err.Error() != tt.errOk {
+			t.Errorf("%#v:\nhave: %v\nwant: %s", tt.obj, err, tt.errOk)
+		}
+	}
+
+	// TypeError reports the unsupported type
+	var terr *TypeError
+	err := NewEncoder(&bytes.Buffer{}).Encode([]any{make(chan int)})
+	if !(errors.As(err, &terr) && terr.Type == reflect.TypeOf(make(chan int)) && terr.Path == "[0]") {
+		t.Errorf("encode chan: %#v", err)
+	}
+
+	// nested encode errors unwrap to the original error
+	err = NewEncoderWithConfig(&bytes.Buffer{}, &EncoderConfig{Protocol: 0}).Encode([]any{Class{"a\nb", "c"}})
+	if !errors.Is(err, errP0123GlobalStringLineOnly) {
+		t.Errorf("errors.Is(%v, errP0123GlobalStringLineOnly) = false", err)
+	}
+}
+
+// verify that invalid positional struct tags are rejected by encoder.
+func TestEncodeStructTupleInvalid(t *testing.T) {
+	testv := []struct {
+		obj   any
+		errOk string // error suffix
+	}{
+		{struct{X int `pickle:"0"`; Y int `pickle:"y"`}{}, ": mixed tuple positions and named fields"},
+		{struct{X int `pickle:"0"`; Y int `pickle:"2"`}{}, ": tuple position 1 is missing"},
+		{struct{X int `pickle:"0"`; Y int `pickle:"0"`}{}, ": duplicate tuple position 0"},
+	}
+
+	for _, tt := range testv {
+		err := NewEncoder(&bytes.Buffer{}).Encode(tt.obj)
+		if !(err != nil && strings.HasSuffix(err.Error(), tt.errOk)) {
+			t.Errorf("%#v: encode:\nhave: %v\nwant: ...%s", tt.obj, err, tt.errOk)
+		}
+	}
+}
+
+// test that .Decode() decodes only until stop opcode, and can continue
+// decoding further on next call
+func TestDecodeMultiple(t *testing.T) {
+	input := "I5\n.I7\n.N."
+	expected := []any{int64(5), int64(7), None{}}
+
+	buf := bytes.NewBufferString(input)
+	dec := NewDecoder(buf)
+
+	for i, objOk := range expected {
+		obj, err := dec.Decode()
+		if err != nil {
+			t.Errorf("step #%v: %v", i, err)
+		}
+
+		if !deepEqual(obj, objOk) {
+			t.Errorf("step #%v: %q  ; want %q", i, obj, objOk)
+		}
+	}
+
+	obj, err := dec.Decode()
+	if !(obj == nil && err == io.EOF) {
+		t.Errorf("decode: no EOF at end: obj = %#v  err = %#v", obj, err)
+	}
+}
+
+// verify EncodeN and DecodeN.
+func TestEncodeDecodeN(t *testing.T) {
+	buf := &bytes.Buffer{}
+	enc := NewEncoder(buf)
+	for i, obj := range []any{int64(1), "hello", Tuple{}} {
+		n, err := enc.EncodeN(obj)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if nOk := [...]int64{5, 10, 4}[i]; n != nOk {
+			t.Errorf("encode %#v: n = %d  ; want %d", obj, n, nOk)
+		}
+	}
+
+	// encode error -> n reports what was written till error
+	n, err := NewEncoder(LimitWriter(&bytes.Buffer{}, 3)).EncodeN("hello")
+	if !(n == 3 && err == io.EOF) {
+		t.Errorf("encode | limited writer: n = %d, err = %v  ; want 3, EOF", n, err)
+	}
+
+	dec := NewDecoder(buf)
+	for _, nOk := range []int64{5, 10, 4} {
+		_, n, err := dec.DecodeN()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != nOk {
+			t.Errorf("decode: n = %d  ; want %d", n, nOk)
+		}
+	}
+}
+
+// TestEncodeUnicode verifies that Unicode encodes as unicode object even at protocols ≤ 2.
+func TestEncodeUnicode(t *testing.T) {
+	obj := []any{Unicode("мир"), "abc"}
+	for _, tt := range []struct {
+		protocol int
+		pickle   string
+	}{
+		{0, "(lV\\u043c\\u0438\\u0440\naS\"abc\"\na."},
+		{1, "](X\x06\x00\x00\x00мирU\x03abce."},
+		{2, "\x80\x02](X\x06\x00\x00\x00мирU\x03abce."},
+		{3, "\x80\x03](X\x06\x00\x00\x00мирX\x03\x00\x00\x00abce."},
+	} {
+		buf := &bytes.Buffer{}
+		err := NewEncoderWithConfig(buf, &EncoderConfig{Protocol: tt.protocol}).Encode(obj)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := buf.String(); got != tt.pickle {
+			t.Errorf("protocol %d:\nhave: %q\nwant: %q", tt.protocol, got, tt.pickle)
+		}
+	}
+}
+
+// stringWriter is test writer, that rejects large writes via Write, so that
+// large strings must be written via WriteString without copying.
+type stringWriter struct {
+	bytes.Buffer
+}
+
+func (w *stringWriter) Write(p []byte) (int, error) {
+	if len(p) > 64 {
+		return 0, fmt.Errorf("Write of %d bytes", len(p))
+	}
+	return w.Buffer.Write(p)
+}
+
+// TestEncodeStringNoCopy verifies that string data is written as is.
+func TestEncodeStringNoCopy(t *testing.T) {
+	s := strings.Repeat("x", 1000)
+	for _, tt := range []struct {
+		obj    any
+		config EncoderConfig
+	}{
+		{s,        EncoderConfig{Protocol: 3}},
+		{s,        EncoderConfig{Protocol: 4, Framing: true, FrameSize: 4}},
+		{Bytes(s), EncoderConfig{Protocol: 3}},
+		{Bytes(s), EncoderConfig{Protocol: 4, Framing: true, FrameSize: 4}},
+	} {
+		w := &stringWriter{}
+		_, err := NewEncoderWithConfig(w, &tt.config).EncodeN(tt.obj)
+		if err != nil {
+			t.Errorf("%T: protocol %d: %s", tt.obj, tt.config.Protocol, err)
+			continue
+		}
+		obj, err := NewDecoder(&w.Buffer).Decode()
+		if !(err == nil && obj == tt.obj) {
+			t.Errorf("%T: protocol %d: decode: %v", tt.obj, tt.config.Protocol, err)
+		}
+	}
+}
+
+// TestEncodeDictOrder verifies that decode→encode of a Python dict, decoded
+// in PyDict mode, preserves the order of its keys.
+func TestEncodeDictOrder(t *testing.T) {
+	keys := "zyxwvutsrqponm" // more than fits into small Dict
+
+	// pickle.dumps({k: i for i, k in enumerate(keys)}, 2)
+	in := "\x80\x02}q\x00("
+	out := "\x80\x02}("
+	for i, k := range keys {
+		in += fmt.Sprintf("X\x01\x00\x00\x00%cq%cK%c", k, i+1, i)
+		out += fmt.Sprintf("U\x01%cK%c", k, i)
+	}
+	in += "u."
+	out += "u."
+
+	obj, err := NewDecoderWithConfig(strings.NewReader(in), &DecoderConfig{PyDict: true}).Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := &bytes.Buffer{}
+	err = NewEncoderWithConfig(buf, &EncoderConfig{Protocol: 2}).Encode(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != out {
+		t.Errorf("encode:\nhave: %q\nwant: %q", got, out)
+	}
+}
+
+// TestEncodeBatches verifies that dict entries and list items are added in batches.
+func TestEncodeBatches(t *testing.T) {
+	m := map[any]any{}
+	for i := 0; i < 2*dictBatchSize+1; i++ {
+		m[int64(i)] = int64(i)
+	}
+	l := []any{}
+	for i := 0; i < 2*listBatchSize+1; i++ {
+		l = append(l, int64(i))
+	}
+
+	for _, tt := range []struct {
+		obj   any
+		proto int
+		op1   byte // opcode to add one item
+		n1    int  // expected number of op1
+		opN   byte // opcode to add batch of items
+		nN    int  // expected number of opN
+	}{
+		{m, 0, opSetitem, len(m), opSetitems, 0},
+		{m, 2, opSetitem, 1, opSetitems, 2},
+		{l, 0, opAppend, len(l), opAppends, 0},
+		{l, 2, opAppend, 1, opAppends, 2},
+	} {
+		buf := &bytes.Buffer{}
+		err := NewEncoderWithConfig(buf, &EncoderConfig{Protocol: tt.proto}).Encode(tt.obj)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		n1, nN, maxDepth := 0, 0, 0
+		obj, err := NewDecoderWithConfig(buf, &DecoderConfig{
+			Trace: func(op byte, pos int, stackDepth int) {
+				switch op {
+				case tt.op1:
+					n1++
+				case tt.opN:
+					nN++
+				}
+				if stackDepth > maxDepth {
+					maxDepth = stackDepth
+				}
+			},
+		}).Decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(obj, tt.obj) {
+			t.Errorf("%T: protocol %d: decode back: mismatch", tt.obj, tt.proto)
+		}
+		if !(n1 == tt.n1 && nN == tt.nN) {
+			t.Errorf("%T: protocol %d: %s×%d %s×%d  ; want %s×%d %s×%d", tt.obj, tt.proto,
+				OpcodeInfoOf(tt.op1).Name, n1, OpcodeInfoOf(tt.opN).Name, nN,
+				OpcodeInfoOf(tt.op1).Name, tt.n1, OpcodeInfoOf(tt.opN).Name, tt.nN)
+		}
+		if maxDepth > 2*dictBatchSize+2 {
+			t.Errorf("%T: protocol %d: stack depth %d is too big", tt.obj, tt.proto, maxDepth)
+		}
+	}
+}
+
+// TestDecodeDictAsItems verifies decoding of dicts in DictAsItems mode and
+// encoding of []KV.
+func TestDecodeDictAsItems(t *testing.T) {
+	for _, tt := range []struct {
+		pickle string
+		want   any
+	}{
+		{"(dp0\nS'b'\nI1\nsS'a'\nI2\nsS'b'\nI3\ns.", []KV{{"b", int64(1)}, {"a", int64(2)}, {"b", int64(3)}}},
+		{"\x80\x02}q\x00(U\x01bK\x01U\x01aK\x02U\x01bK\x03u.", []KV{{"b", int64(1)}, {"a", int64(2)}, {"b", int64(3)}}},
+		{"(S'x'\nI1\nI1\nI2\nd.", []KV{{"x", int64(1)}, {int64(1), int64(2)}}},
+		{"(]I1\nd.", []KV{{[]any{}, int64(1)}}},
+		{"\x80\x02]q\x00}q\x01a.", []any{[]KV{}}},
+	} {
+		obj, err := NewDecoderWithConfig(strings.NewReader(tt.pickle), &DecoderConfig{DictAsItems: true, PyDict: true}).Decode()
+		if err != nil {
+			t.Errorf("%q: %s", tt.pickle, err)
+			continue
+		}
+		if !reflect.DeepEqual(obj, tt.want) {
+			t.Errorf("%q:\nhave: %#v\nwant: %#v", tt.pickle, obj, tt.want)
+		}
+	}
+
+	for _, tt := range []struct {
+		kv     []KV
+		pickle string
+	}{
+		{[]KV{}, "\x80\x02}."},
+		{[]KV{{"b", 1}, {"a", 2}, {"b", 3}}, "\x80\x02}(U\x01bK\x01U\x01aK\x02U\x01bK\x03u."},
+	} {
+		buf := &bytes.Buffer{}
+		err := NewEncoderWithConfig(buf, &EncoderConfig{Protocol: 2}).Encode(tt.kv)
+		if err != nil {
+			t.Errorf("%#v: %s", tt.kv, err)
+			continue
+		}
+		if got := buf.String(); got != tt.pickle {
+			t.Errorf("%#v:\nhave: %q\nwant: %q", tt.kv, got, tt.pickle)
+		}
+	}
+}
+
+// TestPickleBuffer verifies encoding of PickleBuffer and decoding of
+// pickle.PickleBuffer calls.
+func TestPickleBuffer(t *testing.T) {
+	for _, tt := range []struct {
+		protocol int
+		readonly bool
+		pickle   string
+	}{
+		{3, true,  "\x80\x03cpickle\nPickleBuffer\nC\x03abc\x85R."},
+		{3, false, "\x80\x03cpickle\nPickleBuffer\ncbuiltins\nbytearray\nC\x03abc\x85R\x85R."},
+		{5, true,  "\x80\x05C\x03abc."},
+		{5, false, "\x80\x05\x96\x03\x00\x00\x00\x00\x00\x00\x00abc."},
+	} {
+		buf := &bytes.Buffer{}
+		pb := PickleBuffer{Data: []byte("abc"), ReadOnly: tt.readonly}
+		err := NewEncoderWithConfig(buf, &EncoderConfig{Protocol: tt.protocol}).Encode(pb)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := buf.String(); got != tt.pickle {
+			t.Errorf("protocol %d: encode %#v:\nhave: %q\nwant: %q", tt.protocol, pb, got, tt.pickle)
+		}
+	}
+
+	// all protocols decode back to Bytes or []byte
+	for proto := 0; proto <= highestProtocol; proto++ {
+		for _, readonly := range []bool{true, false} {
+			buf := &bytes.Buffer{}
+			pb := PickleBuffer{Data: []byte("abc"), ReadOnly: readonly}
+			err := NewEncoderWithConfig(buf, &EncoderConfig{Protocol: proto}).Encode(pb)
+			if err != nil {
+				t.Fatal(err)
+			}
+			obj, err := NewDecoder(buf).Decode()
+			if err != nil {
+				t.Fatalf("protocol %d: decode %#v: %s", proto, pb, err)
+			}
+			var want any = []byte("abc")
+			if readonly {
+				want = Bytes("abc")
+			}
+			if !reflect.DeepEqual(obj, want) {
+				t.Errorf("protocol %d: decode %#v: have %#v  ; want %#v", proto, pb, obj, want)
+			}
+		}
+	}
+
+	// PickleBuffer needs protocol 5 to be emitted natively
+	err := NewEncoderWithConfig(&bytes.Buffer{}, &EncoderConfig{Protocol: 4, ProtocolMode: ProtocolStrict}).Encode(PickleBuffer{})
+	var eproto *ProtocolError
+	if !(errors.As(err, &eproto) && eproto.Protocol == 5) {
+		t.Errorf("protocol 4 strict: have %v  ; want ProtocolError(5)", err)
+	}
+
+	_, err = NewDecoder(strings.NewReader("cpickle\nPickleBuffer\n(I1\ntR.")).Decode()
+	if err == nil {
+		t.Errorf("PickleBuffer(int): no error")
+	}
+}
+
+// TestMemoryView verifies encoding of MemoryView and decoding of memoryview calls.
+func TestMemoryView(t *testing.T) {
+	for _, tt := range []struct {
+		protocol int
+		readonly bool
+		pickle   string
+	}{
+		{3, true,  "\x80\x03cbuiltins\nmemoryview\nC\x03abc\x85R."},
+		{3, false, "\x80\x03cbuiltins\nmemoryview\ncbuiltins\nbytearray\nC\x03abc\x85R\x85R."},
+		{5, true,  "\x80\x05\x8c\x08builtins\x8c\nmemoryview\x93C\x03abc\x85R."},
+		{5, false, "\x80\x05\x8c\x08builtins\x8c\nmemoryview\x93\x96\x03\x00\x00\x00\x00\x00\x00\x00abc\x85R."},
+	} {
+		buf := &bytes.Buffer{}
+		mv := MemoryView{Data: []byte("abc"), ReadOnly: tt.readonly}
+		err := NewEncoderWithConfig(buf, &EncoderConfig{Protocol: tt.protocol}).Encode(mv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := buf.String(); got != tt.pickle {
+			t.Errorf("protocol %d: encode %#v:\nhave: %q\nwant: %q", tt.protocol, mv, got, tt.pickle)
+		}
+	}
+
+	// all protocols decode back to Bytes or []byte
+	for proto := 0; proto <= highestProtocol; proto++ {
+		for _, readonly := range []bool{true, false} {
+			buf := &bytes.Buffer{}
+			mv := MemoryView{Data: []byte("abc"), ReadOnly: readonly}
+			err := NewEncoderWithConfig(buf, &EncoderConfig{Protocol: proto}).Encode(mv)
+			if err != nil {
+				t.Fatal(err)
+			}
+			obj, err := NewDecoder(buf).Decode()
+			if err != nil {
+				t.Fatalf("protocol %d: decode %#v: %s", proto, mv, err)
+			}
+			var want any = []byte("abc")
+			if readonly {
+				want = Bytes("abc")
+			}
+			if !reflect.DeepEqual(obj, want) {
+				t.Errorf("protocol %d: decode %#v: have %#v  ; want %#v", proto, mv, obj, want)
+			}
+		}
+	}
+
+	_, err := NewDecoder(strings.NewReader("cbuiltins\nmemoryview\n(I1\ntR.")).Decode()
+	if err == nil {
+		t.Errorf("memoryview(int): no error")
+	}
+}
+
+// TestDecodeZeroCopy verifies that in ZeroCopy mode bytearrays reference the
+// input buffer, and that the rest of the input is decoded correctly.
+func TestDecodeZeroCopy(t *testing.T) {
+	bytearray8 := func(data string) string {
+		var b [8]byte
+		binary.LittleEndian.PutUint64(b[:], uint64(len(data)))
+		return "\x96" + string(b[:]) + data
+	}
+
+	big := strings.Repeat("x", 3*4096+5) // larger than buffer of bufio.Reader
+	input := []byte("\x80\x05(" + bytearray8("abc") + bytearray8(big) + "X\x01\x00\x00\x00z" + bytearray8("") + "t.")
+	want := Tuple{[]byte("abc"), []byte(big), "z", []byte("")}
+
+	for _, zeroCopy := range []bool{false, true} {
+		d := NewDecoderBytes(input, &DecoderConfig{ZeroCopy: zeroCopy})
+		obj, err := d.Decode()
+		if err != nil {
+			t.Fatalf("zerocopy=%v: %s", zeroCopy, err)
+		}
+		if !reflect.DeepEqual(obj, want) {
+			t.Fatalf("zerocopy=%v: decode:\nhave: %#v\nwant: %#v", zeroCopy, obj, want)
+		}
+
+		t0 := obj.(Tuple)
+		for i, off := range []int{3+9, 3+9+3+9} {
+			data := t0[i].([]byte)
+			aliased := &data[0] == &input[off]
+			if aliased != zeroCopy {
+				t.Errorf("zerocopy=%v: [%d]: aliased=%v", zeroCopy, i, aliased)
+			}
+			if cap(data) != len(data) && zeroCopy {
+				t.Errorf("zerocopy=%v: [%d]: cap=%d  ; want %d", zeroCopy, i, cap(data), len(data))
+			}
+		}
+
+		if n := d.Stats().Bytes; n != int64(len(input)) {
+			t.Errorf("zerocopy=%v: stats.bytes=%d  ; want %d", zeroCopy, n, len(input))
+		}
+	}
+
+	// truncated input
+	for _, l := range []int{10, 3+9+3+9+10} {
+		_, err := NewDecoderBytes(input[:l], &DecoderConfig{ZeroCopy: true}).Decode()
+		if err != io.ErrUnexpectedEOF {
+			t.Errorf("truncated at %d: have %v  ; want %v", l, err, io.ErrUnexpectedEOF)
+		}
+	}
+}
+
+// TestDecodeBytesAsSlice verifies decoding of Python bytes in BytesAsSlice mode.
+func TestDecodeBytesAsSlice(t *testing.T) {
+	big := strings.Repeat("ab", 200)
+
+	// pickle.dumps([b'ab', {b'k': b'v'}, b'ab'*200, bytearray(b'x')], 3)
+	p3 := "\x80\x03]q\x00(C\x02abq\x01}q\x02C\x01kq\x03C\x01vq\x04sB\x90\x01\x00\x00" + big +
+		"q\x05cbuiltins\nbytearray\nq\x06C\x01xq\x07\x85q\x08Rq\te."
+
+	// pickle.dumps([b'ab', {b'k': b'v'}], 2)
+	p2 := "\x80\x02]q\x00(c_codecs\nencode\nq\x01X\x02\x00\x00\x00abq\x02X\x06\x00\x00\x00latin1q\x03\x86q\x04Rq\x05}" +
+		"q\x06h\x01X\x01\x00\x00\x00kq\x07h\x03\x86q\x08Rq\th\x01X\x01\x00\x00\x00vq\nh\x03\x86q\x0bRq\x0cse."
+
+	for _, pyDict := range []bool{false, true} {
+		var dict any = map[any]any{Bytes("k"): []byte("v")}
+		if pyDict {
+			dict = NewDictWithData(Bytes("k"), []byte("v"))
+		}
+
+		for _, tt := range []struct {
+			pickle string
+			want   any
+		}{
+			{p3, []any{[]byte("ab"), dict, []byte(big), []byte("x")}},
+			{p2, []any{[]byte("ab"), dict}},
+			{"cbuiltins\nbytes\n((lp0\nI1\naI2\natR.", []byte{1, 2}},
+			{"\x80\x03cpickle\nPickleBuffer\nC\x03abc\x85R.", []byte("abc")},
+			{"\x80\x03cbuiltins\nstr\nC\x03abcX\x05\x00\x00\x00utf-8\x86R.", "abc"},
+		} {
+			config := &DecoderConfig{BytesAsSlice: true, PyDict: pyDict}
+			obj, err := NewDecoderWithConfig(strings.NewReader(tt.pickle), config).Decode()
+			if err != nil {
+				t.Errorf("pydict=%v: %q: %s", pyDict, tt.pickle, err)
+				continue
+			}
+			if !deepEqual(obj, tt.want) {
+				t.Errorf("pydict=%v: %q:\nhave: %#v\nwant: %#v", pyDict, tt.pickle, obj, tt.want)
+			}
+		}
+	}
+
+	// data of decoded bytes is not shared with decoder buffers
+	obj, err := NewDecoderWithConfig(strings.NewReader("(C\x01aC\x01bl."), &DecoderConfig{BytesAsSlice: true}).Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []any{[]byte("a"), []byte("b")}; !reflect.DeepEqual(obj, want) {
+		t.Errorf("aliasing:\nhave: %#v\nwant: %#v", obj, want)
+	}
+}
+
+// TestDecodeMaxExpandedSize verifies protection against pickles that
+// amplify their logical size via memo references.
+func TestDecodeMaxExpandedSize(t *testing.T) {
+	// [[[...['x']*10...]*10]*10 with every level memoized and referenced 10 times
+	amplify := func(nlevel int) string {
+		p := "(" + strings.Repeat("X\x01\x00\x00\x00x", 10) + "lq\x00"
+		for i := 1; i < nlevel; i++ {
+			p += "(" + strings.Repeat(fmt.Sprintf("h%c", i-1), 10) + fmt.Sprintf("lq%c", i)
+		}
+		return p + "."
+	}
+
+	for _, tt := range []struct {
+		pickle string
+		max    int64
+		ok     bool
+	}{
+		{amplify(2), 0, true},
+		{amplify(2), 1000, true},
+		{amplify(9), 0, true}, // 10⁹ objects logically, but small in memory
+		{amplify(9), 1000000, false},
+		{amplify(3), 500, false},
+		{"(" + strings.Repeat("I1\n", 100) + "l.", 50, false}, // no amplification, but still big
+	} {
+		config := &DecoderConfig{MaxExpandedSize: tt.max}
+		_, err := NewDecoderWithConfig(strings.NewReader(tt.pickle), config).Decode()
+		if ok := err == nil; ok != tt.ok {
+			t.Errorf("%q: max=%d: err=%v", tt.pickle, tt.max, err)
+		}
+	}
+
+	// t = (t, t) 40 times via DUP, without memo: 2⁴⁰ objects logically
+	dup := "\x80\x02]" + strings.Repeat("2\x86", 40) + "."
+	for _, config := range []*DecoderConfig{
+		{MaxExpandedSize: 1000},
+		{MaxExpandedSize: 1000, NumbersAsFloat: true},
+	} {
+		_, err := NewDecoderWithConfig(strings.NewReader(dup), config).Decode()
+		if err == nil {
+			t.Errorf("%q: %+v: no error", dup, *config)
+		}
+	}
+}
+
+// TestDecodeMaxLineLength verifies limit on length of protocol 0 lines.
+func TestDecodeMaxLineLength(t *testing.T) {
+	digits := strings.Repeat("1", 10000)
+	for _, tt := range []struct {
+		pickle string
+		max    int
+		ok     bool
+	}{
+		{"I" + digits + "\n.", 0, true},
+		{"I" + digits + "\n.", len(digits), true},
+		{"I" + digits + "\n.", len(digits)-1, false},
+		{"I" + digits, 100, false}, // no \n at all
+		{"I1\n.", 1, true},
+		{"c" + strings.Repeat("m", 200) + "\nf\n.", 100, false},
+	} {
+		config := &DecoderConfig{MaxLineLength: tt.max}
+		_, err := NewDecoderWithConfig(strings.NewReader(tt.pickle), config).Decode()
+		if ok := err == nil; ok != tt.ok {
+			t.Errorf("%.20q...: max=%d: err=%v", tt.pickle, tt.max, err)
+		}
+		if err != nil && !tt.ok && !strings.Contains(err.Error(), "line longer than") {
+			t.Errorf("%.20q...: max=%d: unexpected error %v", tt.pickle, tt.max, err)
+		}
+	}
+}
+
+func TestDecodeLong(t *testing.T) {
+	var testv = []struct {
+		data  string
+		value int64 // converted to big.Int by test driver
+	}{
+		{"", 0},
+		{"\xff\x00", 255},
+		{"\xff\x7f", 32767},
+		{"\x00\xff", -256},
+		{"\x00\x80", -32768},
+		{"\x80", -128},
+		{"\x7f", 127},
+	}
+
+	for _, tt := range testv {
+		value, err := decodeLong(tt.data)
+		if err != nil {
+			t.Errorf("data %q: %s", tt.data, err)
+			continue
+		}
+
+		valueOk := big.NewInt(tt.value)
+		if valueOk.Cmp(value) != 0 {
+			t.Errorf("data %q: ->long: got %s  ; want %s", tt.data, value, valueOk)
+		}
+	}
+}
+
+func BenchmarkDecodeLong(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		data := "\x00\x80"
+		_, err := decodeLong(data)
+		if err != nil {
+			b.Errorf("Error from decodeLong - %v\n", err)
+		}
+	}
+}
+
+func TestMemoOpCode(t *testing.T) {
+	buf := bytes.NewBufferString("I5\n\x94.")
+	dec := NewDecoder(buf)
+	_, err := dec.Decode()
+	if err != nil {
+		t.Errorf("Error from TestMemoOpCode - %v\n", err)
+	}
+	if dec.memo["0"] != int64(5) {
+		t.Errorf("Error from TestMemoOpCode - Top stack value was not added to memo")
+	}
+
+}
+
+// verify that decode of erroneous input produces error
+func TestDecodeError(t *testing.T) {
+	testv := []string{
+		// all kinds of opcodes to read memo but key is not there
+		"}g1\n.",
+		"}h\x01.",
+		"}j\x01\x02\x03\x04.",
+
+		// invalid long format
+		"L123\n.",
+		"L12qL\n.",
+
+		// invalid protocol version
+		"\x80\xffI1\n.",
+
+		// BINSTRING and BINUNICODE with big len and no data
+		// (might cause out-of-memory DOS if buffer is preallocated blindly)
+		"T\xff\xff\xff\xff.",
+		"X\xff\xff\xff\xff.",
+
+
+		// it is invalid to expose mark object
+		"(.",                        // MARK
+		"(\x85.",                    // MARK + TUPLE1
+		"((\x86.",                   // MARK·2 + TUPLE2
+		"(((\x87.",                  // MARK·3 + TUPLE3
+		"](a.",                      // EMPTY_LIST + MARK + APPEND
+		"(p0\n0g0\nt.",              // MARK + PUT + POP + GET + TUPLE
+		"(q\x000g0\nt.",             // MARK + BINPUT + ...
+		"(r\x00\x00\x00\x000g0\nt.", // MARK + LONG_BINPUT + ...
+		"(\x940g0\nt.",              // MARK + MEMOIZE + ...
+		"}I1\n(s.",                  // EMPTY_DICT + INT + MARK + SETITEM
+		"}(I1\ns.",                  // EMPTY_DICT + MARK + INT + SETITEM
+		"(Q.",                       // MARK + BINPERSID
+
+		// \r\n should not be read as combined EOL - only \n is
+		"L123L\r\n.",
+		"S'abc'\r\n.",
+
+		// builtin constructors with unsupported arguments
+		"cbuiltins\nbytes\n(K\x01K\x02t\x85R.",  // bytes(tuple)
+		"cbuiltins\nbytes\n(]I256\na\x85R.",      // bytes([256])
+		"cbuiltins\nstr\nK\x01\x85R.",            // str(int)
+		"cbuiltins\nstr\nC\x01aU\x05ascii\x86R.", // str(bytes, unsupported encoding)
+
+		// out-of-band data without DecoderConfig.Buffers
+		"\x97.", // NEXT_BUFFER
+		"\x98.", // READONLY_BUFFER
+	}
+	for _, tt := range testv {
+		buf := bytes.NewBufferString(tt)
+		dec := NewDecoder(buf)
+		v, err := dec.Decode()
+		if !(v == nil && err != nil) {
+			t.Errorf("%q: no decode error  ; got %#v, %#v", tt, v, err)
+		}
+	}
+}
+
+// verify FRAME consistency checks in StrictFrames mode.
+func TestDecodeStrictFrames(t *testing.T) {
+	testv := []struct {
+		input string
+		ok    bool
+	}{
+		{"\x95\x00\x00\x00\x00\x00\x00\x00\x00I5\n.", true},                // empty frame
+		{"\x95\x04\x00\x00\x00\x00\x00\x00\x00I5\n.", true},                // frame covers whole pickle
+		{"\x95\x03\x00\x00\x00\x00\x00\x00\x00I5\n\x95\x01\x00\x00\x00\x00\x00\x00\x00.", true}, // 2 frames
+		{"\x95\x02\x00\x00\x00\x00\x00\x00\x00I5\n.", false},               // INT straddles frame end
+		{"\x95\x09\x00\x00\x00\x00\x00\x00\x00I5\n.", false},               // frame longer than the pickle
+		{"\x95\x0d\x00\x00\x00\x00\x00\x00\x00\x95\x01\x00\x00\x00\x00\x00\x00\x00I5\n.", false}, // frame in frame
+	}
+
+	for _, tt := range testv {
+		// without StrictFrames all pickles decode ok
+		dec := NewDecoder(bytes.NewBufferString(tt.input))
+		v, err := dec.Decode()
+		if !(v == int64(5) && err == nil) {
+			t.Errorf("%q: StrictFrames=n: decode -> %#v, %v", tt.input, v, err)
+		}
+
+		dec = NewDecoderWithConfig(bytes.NewBufferString(tt.input), &DecoderConfig{StrictFrames: true})
+		v, err = dec.Decode()
+		if tt.ok && !(v == int64(5) && err == nil) {
+			t.Errorf("%q: StrictFrames=y: decode -> %#v, %v  ; want ok", tt.input, v, err)
+		}
+		if !tt.ok && err == nil {
+			t.Errorf("%q: StrictFrames=y: no decode error  ; got %#v", tt.input, v)
+		}
+	}
+}
+
+// verify errors reported in StrictFrames mode.
+func TestDecodeStrictFramesError(t *testing.T) {
+	// frame of 0x100 bytes, and frame of 2MiB, that is too big to be prefetched
+	big := "\x95\x00\x01\x00\x00\x00\x00\x00\x00(" + strings.Repeat("K\x01", 0x7f) + "l."
+	huge := "\x95\x00\x00\x20\x00\x00\x00\x00\x00(" + strings.Repeat("K\x01", 0x7f)
+
+	testv := []struct {
+		input string
+		err   string
+	}{
+		{"\x95\x02\x00\x00\x00\x00\x00\x00\x00I5\n.", "pickle: frame: opcode runs 1 bytes past frame end"},
+		{"\x95\x09\x00\x00\x00\x00\x00\x00\x00I5\n.", "pickle: frame: frame of 9 bytes runs past end of data"},
+		{"\x95\x03\x00\x00\x00\x00\x00\x00\x00I5\n\x95\x01\x00\x00\x00\x00\x00\x00\x00", "pickle: frame: frame of 1 bytes runs past end of data"},
+		{big[:len(big)-2], "pickle: frame: frame of 256 bytes runs past end of data"},
+		{huge, "pickle: frame: data ends 2096897 bytes before frame end: unexpected EOF"},
+	}
+
+	for _, tt := range testv {
+		_, err := NewDecoderWithConfig(strings.NewReader(tt.input), &DecoderConfig{StrictFrames: true}).Decode()
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("%q: have error %v  ; want %q", tt.input, err, tt.err)
+		}
+
+		// size of in-memory input is known upfront
+		if tt.input == huge {
+			tt.err = "pickle: frame: frame of 2097152 bytes runs past end of data"
+		}
+		_, err = NewDecoderBytes([]byte(tt.input), &DecoderConfig{StrictFrames: true}).Decode()
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("%q: bytes: have error %v  ; want %q", tt.input, err, tt.err)
+		}
+	}
+
+	_, err := NewDecoderWithConfig(strings.NewReader(huge), &DecoderConfig{StrictFrames: true}).Decode()
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("huge: have error %v  ; want %v", err, io.ErrUnexpectedEOF)
+	}
+}
+
+// readCounter counts Read calls to underlying reader.
+type readCounter struct {
+	r     io.Reader
+	nread int
+}
+
+func (r *readCounter) Read(p []byte) (int, error) {
+	r.nread++
+	return r.r.Read(p)
+}
+
+// verify that frames are read from underlying reader at once.
+func TestDecodeFramePrefetch(t *testing.T) {
+	// pickle with 2 frames, each larger than default read buffer:
+	// [1, 1, ...] and 'aaa...'
+	n := 10000
+	frame1 := "(" + strings.Repeat("K\x01", n) + "l"
+	frame2 := "X" + string(binary.LittleEndian.AppendUint32(nil, uint32(n))) + strings.Repeat("a", n) + "\x86."
+	frame := func(data string) string {
+		return "\x95" + string(binary.LittleEndian.AppendUint64(nil, uint64(len(data)))) + data
+	}
+	input := "\x80\x04" + frame(frame1) + frame(frame2) + "I1\n."
+
+	want := Tuple{make([]any, n), strings.Repeat("a", n)}
+	for i := range want[0].([]any) {
+		want[0].([]any)[i] = int64(1)
+	}
+
+	for _, strict := range []bool{false, true} {
+		r := &readCounter{r: strings.NewReader(input)}
+		dec := NewDecoderWithConfig(r, &DecoderConfig{StrictFrames: strict})
+		v, err := dec.Decode()
+		if !(err == nil && reflect.DeepEqual(v, want)) {
+			t.Fatalf("strict=%v: decode: %v", strict, err)
+		}
+		// 1 read to fill the buffer initially + 1 read per frame
+		if r.nread > 3 {
+			t.Errorf("strict=%v: decode: %d reads  ; want ≤ 3", strict, r.nread)
+		}
+		if pos := dec.pos(); pos != int64(len(input) - len("I1\n.")) {
+			t.Errorf("strict=%v: pos: %d  ; want %d", strict, pos, len(input) - len("I1\n."))
+		}
+
+		// decoding continues after the frames
+		v, err = dec.Decode()
+		if !(err == nil && v == int64(1)) {
+			t.Errorf("strict=%v: decode #2: %#v, %v", strict, v, err)
+		}
+	}
+
+	// truncated frame
+	_, err := NewDecoder(strings.NewReader(input[:len(input)/3])).Decode()
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("truncated: decode: %v  ; want %v", err, io.ErrUnexpectedEOF)
+	}
+}
+
+// verify that DecoderConfig.Trace is called for every opcode.
+func TestDecodeTrace(t *testing.T) {
+	type traceEntry struct {
+		op         byte
+		pos        int
+		stackDepth int
+	}
+
+	var tracev []traceEntry
+	trace := func(op byte, pos int, stackDepth int) {
+		tracev = append(tracev, traceEntry{op, pos, stackDepth})
+	}
+
+	input := "\x80\x02(K\x01I2\nt.I3\n."
+	dec := NewDecoderWithConfig(bytes.NewBufferString(input), &DecoderConfig{Trace: trace})
+	for i := 0; i < 2; i++ {
+		_, err := dec.Decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	traceOk := []traceEntry{
+		{opProto,   0, 0},
+		{opMark,    2, 0},
+		{opBinint1, 3, 1},
+		{opInt,     5, 2},
+		{opTuple,   8, 3},
+		{opStop,    9, 1},
+		{opInt,    10, 0},
+		{opStop,   13, 1},
+	}
+	if !reflect.DeepEqual(tracev, traceOk) {
+		t.Errorf("trace:\nhave: %v\nwant: %v", tracev, traceOk)
+	}
+}
+
+// verify Decoder.Stats.
+func TestDecodeStats(t *testing.T) {
+	input := "\x80\x02(K\x01I2\nq\x00t.]h\x00a."
+	dec := NewDecoder(bytes.NewBufferString(input))
+
+	statsv := []DecoderStats{
+		{Bytes: 12, Opcodes: 7, Objects: 3, MemoSize: 1, MaxDepth: 3},
+		{Bytes: 5,  Opcodes: 4, Objects: 2, MemoSize: 1, MaxDepth: 2},
+	}
+	for i, statsOk := range statsv {
+		_, err := dec.Decode()
+		if err != nil {
+			t.Fatalf("step #%d: %s", i, err)
+		}
+		stats := dec.Stats()
+		if stats != statsOk {
+			t.Errorf("step #%d: stats:\nhave: %+v\nwant: %+v", i, stats, statsOk)
+		}
+	}
+}
+
+// verify that in InternStrings mode equal strings share storage.
+func TestDecodeInternStrings(t *testing.T) {
+	// [S'abc', U'abc', X'abc', V'abc', \x8c'abc']
+	input := "(S'abc'\nU\x03abcX\x03\x00\x00\x00abcVabc\n\x8c\x03abcl."
+
+	strdata := func(s string) uintptr {
+		return (*reflect.StringHeader)(unsafe.Pointer(&s)).Data
+	}
+
+	for _, intern := range []bool{false, true} {
+		dec := NewDecoderWithConfig(bytes.NewBufferString(input), &DecoderConfig{InternStrings: intern})
+		v, err := dec.Decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		l := v.([]any)
+		s0 := l[0].(string)
+		for i, x := range l {
+			s := x.(string)
+			if s != "abc" {
+				t.Fatalf("intern=%s: [%d] = %q", yn(intern), i, s)
+			}
+			shared := strdata(s) == strdata(s0)
+			if i > 0 && shared != intern {
+				t.Errorf("intern=%s: [%d]: shared storage = %s", yn(intern), i, yn(shared))
+			}
+		}
+	}
+}
+
+// verify DecoderConfig.OnUnknownOpcode.
+func TestDecodeOnUnknownOpcode(t *testing.T) {
+	errAbort := errors.New("abort")
+
+	// pretend that \xf0 is an opcode with 2-byte argument
+	hook := func(op byte, r io.Reader) error {
+		if op != 0xf0 {
+			return errAbort
+		}
+		var b [2]byte
+		_, err := io.ReadFull(r, b[:])
+		return err
+	}
+
+	testv := []struct {
+		input    string
+		expected any
+		errOk    error
+	}{
+		{"(K\x01\xf0abK\x02l.", []any{int64(1), int64(2)}, nil},
+		{"(K\x01\xf0a",          nil, io.ErrUnexpectedEOF},
+		{"(K\x01\xf1K\x02l.",   nil, errAbort},
+	}
+
+	for _, tt := range testv {
+		dec := NewDecoderWithConfig(bytes.NewBufferString(tt.input), &DecoderConfig{OnUnknownOpcode: hook})
+		v, err := dec.Decode()
+		if !(deepEqual(v, tt.expected) && err == tt.errOk) {
+			t.Errorf("%q: decode -> %#v, %v  ; want %#v, %v", tt.input, v, err, tt.expected, tt.errOk)
+		}
+
+		// without the hook -> OpcodeError
+		dec = NewDecoder(bytes.NewBufferString(tt.input))
+		_, err = dec.Decode()
+		if _, ok := err.(OpcodeError); !ok {
+			t.Errorf("%q: no hook: decode -> %#v  ; want OpcodeError", tt.input, err)
+		}
+	}
+}
+
+// verify how decoder/encoder handle application-level settings wrt Refs.
+func TestPersistentRefs(t *testing.T) {
+	// ZBTree mimics BTree from ZODB.
+	type ZBTree struct {
+		oid string
+	}
+
+	errInvalidRef := errors.New("invalid reference")
+
+	// Ref -> ? object
+	loadref := func(ref Ref) (any, error) {
+		// pretend we handle "zodb.BTree" -> ZBTree.
+		t, ok := ref.Pid.(Tuple)
+		if !ok || len(t) != 2 {
+			return nil, errInvalidRef
+		}
+
+		class, ok1 := t[0].(Class)
+		oid, ok2   := t[1].(string)
+		if !(ok1 && ok2) {
+			return nil, errInvalidRef
+		}
+
+		switch class {
+		case Class{Module: "zodb", Name: "BTree"}:
+			return &ZBTree{oid}, nil
+
+		default:
+			// leave it as is
+			return nil, nil
+		}
+	}
+
+	// object -> ? Ref
+	getref := func(obj any) *Ref {
+		// pretend we handle ZBTree.
+		switch obj := obj.(type) {
+		default:
+			return nil
+
+		case *ZBTree:
+			return &Ref{Pid: Tuple{Class{Module: "zodb", Name: "BTree"}, obj.oid}}
+		}
+	}
+
+	dconf := &DecoderConfig{PersistentLoad: loadref}
+	econf := &EncoderConfig{PersistentRef: getref, Protocol: 1}
+
+	testv := []struct {
+		input    string
+		expected any
+	}{
+		{"Pabc\n.", errInvalidRef},
+		{"\x80\x01S'abc'\nQ.", errInvalidRef},
+		{"\x80\x01S'abc'\nS'123'\n\x86Q.", errInvalidRef},
+		{"\x80\x01cfoo\nbar\nS'123'\n\x86Q.", Ref{Tuple{Class{Module: "foo", Name: "bar"}, "123"}}},
+		{"\x80\x01czodb\nBTree\nS'123'\n\x86Q.", &ZBTree{oid: "123"}},
+	}
+
+	for _, tt := range testv {
+		// decode(input) -> expected
+		buf := bytes.NewBufferString(tt.input)
+		dec := NewDecoderWithConfig(buf, dconf)
+		v, err := dec.Decode()
+
+		expected := tt.expected
+		errExpect := ""
+		if e, iserr := expected.(error); iserr {
+			expected = nil
+			errExpect = "pickle: handleRef: " + e.Error()
+		}
+
+		if !(deepEqual(v, expected) &&
+			((err == nil && errExpect == "") || err.Error() == errExpect)) {
+			t.Errorf("%q: decode -> %#v, %q; want %#v, %q",
+				tt.input, v, err, expected, errExpect)
+		}
+
+		if err != nil {
+			continue
+		}
+
+		// expected -> encode -> decode = identity
+		buf.Reset()
+		enc := NewEncoderWithConfig(buf, econf)
+		err = enc.Encode(tt.expected)
+		if err != nil {
+			t.Errorf("%q: encode(expected) -> %q", tt.input, err)
+			continue
+		}
+
+		dec = NewDecoderWithConfig(buf, dconf)
+		v, err = dec.Decode()
+		if err != nil {
+			t.Errorf("%q: expected -> encode -> decode: %q", tt.input, err)
+			continue
+		}
+
+		if !deepEqual(v, tt.expected) {
+			t.Errorf("%q: expected -> encode -> decode != identity\nhave: %#v\nwant: %#v",
+				tt.input, v, tt.expected)
+		}
+	}
+}
+
+// verify decoding in Lenient mode.
+func TestDecodeLenient(t *testing.T) {
+	errLoad := errors.New("load failed")
+	loadref := func(ref Ref) (any, error) {
+		return nil, errLoad
+	}
+
+	testv := []struct {
+		input     string
+		expected  any
+		warningOk string
+	}{
+		// bytearray with invalid argument -> left as Call
+		{"c__builtin__\nbytearray\nK\x01\x85R.",
+			Call{Class{"__builtin__", "bytearray"}, Tuple{int64(1)}},
+			"position 26: bytearray: want (bytes,)  ; got (int64,)"},
+
+		// failed persistent load -> left as Ref
+		{"(Pabc\nl.", []any{Ref{"abc"}},
+			"position 1: pickle: handleRef: load failed"},
+
+		// dict with unhashable key -> the item is dropped
+		{"(]K\x01K\x02K\x03d.", map[any]any{int64(2): int64(3)},
+			"position 8: pickle: loadDict: map: invalid key type []interface {}"},
+		{"}]K\x01s.", map[any]any{},
+			"position 4: pickle: loadSetItem: map: invalid key type []interface {}"},
+		{"}(]K\x01u.", map[any]any{},
+			"position 5: pickle: loadSetItems: map: invalid key type []interface {}"},
+
+		// unregistered extension code -> placeholder class
+		{"\x80\x02\x82\x07)\x81.", Object{Class: Class{"copyreg", "ext7"}, Args: Tuple{}},
+			"position 2: pickle: ext: unregistered extension code 7"},
+	}
+
+	for _, tt := range testv {
+		// Lenient=n -> error
+		dconf := &DecoderConfig{PersistentLoad: loadref}
+		dec := NewDecoderWithConfig(bytes.NewBufferString(tt.input), dconf)
+		v, err := dec.Decode()
+		if err == nil {
+			t.Errorf("%q: Lenient=n: no decode error  ; got %#v", tt.input, v)
+		}
+
+		// Lenient=y -> value + warning
+		dconf.Lenient = true
+		dec = NewDecoderWithConfig(bytes.NewBufferString(tt.input), dconf)
+		v, err = dec.Decode()
+		if err != nil {
+			t.Errorf("%q: Lenient=y: decode error: %s", tt.input, err)
+			continue
+		}
+		if !deepEqual(v, tt.expected) {
+			t.Errorf("%q: Lenient=y: decode:\nhave: %#v\nwant: %#v", tt.input, v, tt.expected)
+		}
+		warnv := dec.Warnings()
+		if !(len(warnv) == 1 && error(warnv[0]).Error() == tt.warningOk) {
+			t.Errorf("%q: Lenient=y: warnings:\nhave: %v\nwant: [%s]", tt.input, warnv, tt.warningOk)
+		}
+	}
+}
+
+func TestFuzzCrashers(t *testing.T) {
 	crashers := []string{
 		"(dS''\n(lc\n\na2a2a22aasS''\na",
 		"S\n",
@@ -1125,6 +2570,26 @@ func BenchmarkDecode(b *testing.B) {
 	}
 }
 
+// BenchmarkDecodeOpcodes measures opcode dispatch overhead on a pickle with
+// many cheap opcodes of different kinds.
+func BenchmarkDecodeOpcodes(b *testing.B) {
+	input := []byte("\x80\x02]q\x00(")
+	for i := 0; i < 1000; i++ {
+		input = append(input, "K\x01N\x88\x89)\x86\x86h\x00\x860"...)
+	}
+	input = append(input, "e."...)
+
+	b.SetBytes(int64(len(input)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		dec := NewDecoder(bytes.NewReader(input))
+		_, err := dec.Decode()
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
 func BenchmarkEncode(b *testing.B) {
 	// prepare one large slice from all test vector values
 	input := make([]any, 0)
@@ -1202,7 +2667,7 @@ func TestStringsFmt(t *testing.T) {
 		{"мир",             `"мир"`},
 		{Bytes("мир"),      `ogórek.Bytes("мир")`},
 		{ByteString("мир"), `ogórek.ByteString("мир")`},
-		{unicode("мир"),    `ogórek.unicode("мир")`},
+		{Unicode("мир"),    `ogórek.Unicode("мир")`},
 	}
 
 	for _, tt := range tvhash {
@@ -1242,3 +2707,769 @@ func yn(b bool) string {
 	}
 	return "n"
 }
+
+// TestEncodeDecimal verifies encoding of big.Float and big.Rat as decimal.Decimal.
+func TestEncodeDecimal(t *testing.T) {
+	pi, _, _ := big.ParseFloat("3.14159265358979323846264338327950288", 10, 200, big.ToNearestEven)
+	decimal := func(s string) string {
+		return fmt.Sprintf("\x80\x02cdecimal\nDecimal\nX%c\x00\x00\x00%s\x85R.", len(s), s)
+	}
+
+	for _, tt := range []struct {
+		v      any
+		pickle string
+	}{
+		{big.NewFloat(1.5), decimal("1.5")},
+		{pi, decimal("3.14159265358979323846264338327950288")},
+		{new(big.Float).SetInf(true), decimal("-Inf")},
+		{big.NewRat(1, 8), decimal("0.125")},
+		{*big.NewRat(-7, 20), decimal("-0.35")},
+		{big.NewRat(3, 1), decimal("3")},
+	} {
+		buf := &bytes.Buffer{}
+		err := NewEncoderWithConfig(buf, &EncoderConfig{Protocol: 2, Decimal: true}).Encode(tt.v)
+		if err != nil {
+			t.Errorf("%v: %s", tt.v, err)
+			continue
+		}
+		if got := buf.String(); got != tt.pickle {
+			t.Errorf("%v:\nhave: %q\nwant: %q", tt.v, got, tt.pickle)
+		}
+	}
+
+	err := NewEncoderWithConfig(&bytes.Buffer{}, &EncoderConfig{Protocol: 2, Decimal: true}).Encode(big.NewRat(1, 3))
+	if err == nil {
+		t.Errorf("1/3: no error")
+	}
+}
+
+// TestDecodeRawCalls verifies that in RawCalls mode built-in handling of calls is not applied.
+func TestDecodeRawCalls(t *testing.T) {
+	for _, tt := range []struct {
+		pickle string
+		want   any
+	}{
+		// pickle.dumps(b'ab', 2)
+		{"\x80\x02c_codecs\nencode\nq\x00X\x02\x00\x00\x00abq\x01X\x06\x00\x00\x00latin1q\x02\x86q\x03Rq\x04.",
+			Call{Class{"_codecs", "encode"}, Tuple{"ab", "latin1"}}},
+		// pickle.dumps(bytearray(b'x'), 3)
+		{"\x80\x03cbuiltins\nbytearray\nq\x00C\x01xq\x01\x85q\x02Rq\x03.",
+			Call{Class{"builtins", "bytearray"}, Tuple{Bytes("x")}}},
+		{"\x80\x03cbuiltins\nmemoryview\nC\x03abc\x85R.",
+			Call{Class{"builtins", "memoryview"}, Tuple{Bytes("abc")}}},
+		// modes are still applied
+		{"\x80\x03cipaddress\nip_address\nK\x01\x85R.", netip.MustParseAddr("0.0.0.1")},
+	} {
+		obj, err := NewDecoderWithConfig(strings.NewReader(tt.pickle), &DecoderConfig{RawCalls: true, NetIP: true}).Decode()
+		if err != nil {
+			t.Errorf("%q: %s", tt.pickle, err)
+			continue
+		}
+		if !reflect.DeepEqual(obj, tt.want) {
+			t.Errorf("%q:\nhave: %#v\nwant: %#v", tt.pickle, obj, tt.want)
+		}
+	}
+}
+
+// TestDecodeCallHandlers verifies translation of calls via CallHandlers.
+func TestDecodeCallHandlers(t *testing.T) {
+	type money struct {
+		Amount   string
+		Currency string
+	}
+	errCurrency := errors.New("no currency")
+	config := &DecoderConfig{CallHandlers: map[Class]func(Tuple) (any, error){
+		{"app", "Money"}: func(args Tuple) (any, error) {
+			if len(args) != 2 {
+				return nil, errCurrency
+			}
+			return money{args[0].(string), args[1].(string)}, nil
+		},
+		// handlers take precedence over built-in handling
+		{"builtins", "bytearray"}: func(args Tuple) (any, error) {
+			return "bytearray", nil
+		},
+		// nil handlers are ignored
+		{"app", "Other"}: nil,
+	}}
+
+	for _, raw := range []bool{false, true} {
+		config.RawCalls = raw
+		for _, tt := range []struct {
+			pickle string
+			want   any
+		}{
+			{"\x80\x03capp\nMoney\nX\x04\x00\x00\x001.50X\x03\x00\x00\x00EUR\x86R.", money{"1.50", "EUR"}},
+			{"capp\nMoney\n(V2\nVUSD\ntR.", money{"2", "USD"}},
+			{"(capp\nMoney\nV2\nVUSD\no.", money{"2", "USD"}},
+			{"(V2\nVUSD\niapp\nMoney\n.", money{"2", "USD"}},
+			{"\x80\x03]capp\nMoney\nX\x01\x00\x00\x003X\x03\x00\x00\x00EUR\x86Rq\x00ah\x00a.", []any{money{"3", "EUR"}, money{"3", "EUR"}}},
+			{"\x80\x03cbuiltins\nbytearray\nC\x01x\x85R.", "bytearray"},
+			// other calls are not affected
+			{"\x80\x03capp\nOther\n)R.", Call{Class{"app", "Other"}, Tuple{}}},
+		} {
+			obj, err := NewDecoderWithConfig(strings.NewReader(tt.pickle), config).Decode()
+			if err != nil {
+				t.Errorf("%q: raw=%v: %s", tt.pickle, raw, err)
+				continue
+			}
+			if !reflect.DeepEqual(obj, tt.want) {
+				t.Errorf("%q: raw=%v:\nhave: %#v\nwant: %#v", tt.pickle, raw, obj, tt.want)
+			}
+		}
+	}
+
+	_, err := NewDecoderWithConfig(strings.NewReader("capp\nMoney\n(V2\ntR."), config).Decode()
+	if !(errors.Is(err, errCurrency) && strings.Contains(err.Error(), "app.Money: ")) {
+		t.Errorf("handler error: %v", err)
+	}
+}
+
+// TestEncodeSession verifies encoding of sequential pickles in one session.
+func TestEncodeSession(t *testing.T) {
+	for _, tt := range []struct {
+		config    EncoderConfig
+		shareMemo bool
+		values    []any
+		pickle    string
+	}{
+		{EncoderConfig{Protocol: 2, DedupStrings: true}, false, []any{"abc", []any{"abc", "abc"}, int64(1)},
+			"\x80\x02U\x03abcq\x00.](U\x03abcq\x00h\x00e.K\x01."},
+		{EncoderConfig{Protocol: 2, DedupStrings: true}, true, []any{"abc", []any{"abc", "abc"}, int64(1)},
+			"\x80\x02U\x03abcq\x00.](h\x00h\x00e.K\x01."},
+		{EncoderConfig{Protocol: 1}, false, []any{int64(1), int64(2)},
+			"K\x01.K\x02."},
+		// protocol change is announced with new PROTO
+		{EncoderConfig{Protocol: 2, ProtocolMode: ProtocolBump, DedupStrings: true}, true, []any{"a", PickleBuffer{Data: []byte("b"), ReadOnly: true}, "a"},
+			"\x80\x02U\x01aq\x00.\x80\x05C\x01b\x94.\x80\x02h\x00."},
+	} {
+		buf := &bytes.Buffer{}
+		s := NewEncodeSession(buf, &tt.config, tt.shareMemo)
+		for _, v := range tt.values {
+			err := s.Encode(v)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if got := buf.String(); got != tt.pickle {
+			t.Errorf("%#v:\nhave: %q\nwant: %q", tt.values, got, tt.pickle)
+		}
+
+		// all pickles decode back with one Decoder
+		d := NewDecoder(buf)
+		for _, v := range tt.values {
+			obj, err := d.Decode()
+			if err != nil {
+				t.Fatalf("%q: decode: %s", tt.pickle, err)
+			}
+			if pb, ok := v.(PickleBuffer); ok {
+				v = Bytes(pb.Data)
+			}
+			if !reflect.DeepEqual(obj, v) {
+				t.Errorf("%q: decode:\nhave: %#v\nwant: %#v", tt.pickle, obj, v)
+			}
+		}
+	}
+}
+
+// orderedMap is test map-like container, that implements Mapping.
+type orderedMap struct {
+	keys []string
+	vals map[string]any
+}
+
+func (m *orderedMap) Len() int { return len(m.keys) }
+func (m *orderedMap) Iterate(yield func(k, v any) bool) {
+	for _, k := range m.keys {
+		if !yield(k, m.vals[k]) {
+			return
+		}
+	}
+}
+
+// lenMap is test Mapping, whose Len does not match its entries.
+type lenMap struct {
+	*orderedMap
+	n int
+}
+
+func (m lenMap) Len() int { return m.n }
+
+// TestEncodeMapping verifies encoding of containers implementing Mapping.
+func TestEncodeMapping(t *testing.T) {
+	m := &orderedMap{[]string{"b", "a"}, map[string]any{"a": int64(1), "b": int64(2)}}
+	var nilm *orderedMap
+
+	for _, tt := range []struct {
+		v      any
+		pickle string
+	}{
+		{m, "\x80\x02}(X\x01\x00\x00\x00bK\x02X\x01\x00\x00\x00aK\x01u."},
+		{[]any{m}, "\x80\x02]}(X\x01\x00\x00\x00bK\x02X\x01\x00\x00\x00aK\x01ua."},
+		{&orderedMap{}, "\x80\x02}."},
+		{nilm, "\x80\x02N."},
+	} {
+		buf := &bytes.Buffer{}
+		err := NewEncoderWithConfig(buf, &EncoderConfig{Protocol: 2, StrictUnicode: true}).Encode(tt.v)
+		if err != nil {
+			t.Errorf("%#v: %s", tt.v, err)
+			continue
+		}
+		if got := buf.String(); got != tt.pickle {
+			t.Errorf("%#v:\nhave: %q\nwant: %q", tt.v, got, tt.pickle)
+		}
+	}
+
+	for _, tt := range []struct {
+		v     Mapping
+		errOk string
+	}{
+		{lenMap{m, 3}, "mapping: Len is 3, but Iterate yielded 2 entries"},
+		{lenMap{m, 1}, "mapping: Len is 1, but Iterate yielded more than 1 entries"},
+	} {
+		for _, sortKeys := range []bool{false, true} {
+			err := NewEncoderWithConfig(&bytes.Buffer{}, &EncoderConfig{Protocol: 2, SortKeys: sortKeys}).Encode(tt.v)
+			if err == nil || err.Error() != tt.errOk {
+				t.Errorf("%#v (SortKeys=%v):\nhave: %v\nwant: %s", tt.v, sortKeys, err, tt.errOk)
+			}
+		}
+	}
+
+	m.vals["a"] = make(chan int)
+	err := NewEncoder(&bytes.Buffer{}).Encode(m)
+	if err == nil || !strings.Contains(err.Error(), ".a:") {
+		t.Errorf("unsupported value: unexpected error %v", err)
+	}
+}
+
+// rangeSeq is test lazily generated sequence, that implements Sequence.
+type rangeSeq struct {
+	n     int
+	tuple bool
+	extra int // how many items Iterate yields beyond n
+}
+
+func (r rangeSeq) Len() int      { return r.n }
+func (r rangeSeq) IsTuple() bool { return r.tuple }
+func (r rangeSeq) Iterate(yield func(v any) bool) {
+	for i := 0; i < r.n+r.extra; i++ {
+		if !yield(int64(i)) {
+			return
+		}
+	}
+}
+
+// TestEncodeSequence verifies encoding of containers implementing Sequence.
+func TestEncodeSequence(t *testing.T) {
+	for _, tt := range []struct {
+		v      any
+		proto  int
+		pickle string
+	}{
+		{rangeSeq{n: 3}, 2, "\x80\x02](K\x00K\x01K\x02e."},
+		{rangeSeq{n: 0}, 2, "\x80\x02]."},
+		{rangeSeq{n: 0}, 0, "(l."},
+		{rangeSeq{n: 2, tuple: true}, 2, "\x80\x02K\x00K\x01\x86."},
+		{rangeSeq{n: 4, tuple: true}, 2, "\x80\x02(K\x00K\x01K\x02K\x03t."},
+		{rangeSeq{n: 0, tuple: true}, 1, ")."},
+		{&rangeSeq{n: 1}, 1, "]K\x00a."},
+		{Tuple{rangeSeq{n: 1, tuple: true}}, 2, "\x80\x02K\x00\x85\x85."},
+	} {
+		buf := &bytes.Buffer{}
+		err := NewEncoderWithConfig(buf, &EncoderConfig{Protocol: tt.proto}).Encode(tt.v)
+		if err != nil {
+			t.Errorf("%#v: %s", tt.v, err)
+			continue
+		}
+		if got := buf.String(); got != tt.pickle {
+			t.Errorf("%#v:\nhave: %q\nwant: %q", tt.v, got, tt.pickle)
+		}
+	}
+
+	for _, v := range []any{
+		rangeSeq{n: 2, extra: 1},
+		rangeSeq{n: 2, extra: -1},
+		rangeSeq{n: 2, tuple: true, extra: -1},
+	} {
+		err := NewEncoder(&bytes.Buffer{}).Encode(v)
+		if err == nil {
+			t.Errorf("%#v: no error", v)
+		}
+	}
+}
+
+// TestDecodeExt verifies decoding of EXT1, EXT2 and EXT4 opcodes.
+func TestDecodeExt(t *testing.T) {
+	registry := map[int]Class{
+		1:     {"collections", "OrderedDict"},
+		300:   {"decimal", "Decimal"},
+		70000: {"fractions", "Fraction"},
+	}
+
+	// copyreg.add_extension(...) for registry ^^^
+	// pickle.dumps([collections.OrderedDict, decimal.Decimal, fractions.Fraction], 2)
+	data := "\x80\x02]q\x00(\x82\x01\x83,\x01\x84p\x11\x01\x00e."
+	obj, err := NewDecoderWithConfig(strings.NewReader(data), &DecoderConfig{ExtensionRegistry: registry}).Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []any{registry[1], registry[300], registry[70000]}
+	if !reflect.DeepEqual(obj, want) {
+		t.Errorf("decode:\nhave: %#v\nwant: %#v", obj, want)
+	}
+
+	// extension code used as callable
+	obj, err = NewDecoderWithConfig(strings.NewReader("\x80\x02\x83,\x01X\x03\x00\x00\x003.5\x85R."), &DecoderConfig{ExtensionRegistry: registry}).Decode()
+	if want := (Call{registry[300], Tuple{"3.5"}}); !(reflect.DeepEqual(obj, want) && err == nil) {
+		t.Errorf("decode call:\nhave: %#v, %v\nwant: %#v", obj, err, want)
+	}
+
+	for _, tt := range []struct {
+		pickle string
+		err    string
+	}{
+		{"\x80\x02\x82\x02.", "pickle: ext: unregistered extension code 2"},
+		{"\x80\x02\x82\x00.", "pickle: ext: invalid extension code 0"},
+		{"\x80\x02\x84\x00\x00\x00\x80.", "pickle: ext: invalid extension code -2147483648"},
+		{"\x80\x02\x83\x01", "unexpected EOF"},
+	} {
+		_, err := NewDecoderWithConfig(strings.NewReader(tt.pickle), &DecoderConfig{ExtensionRegistry: registry}).Decode()
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("%q: have error %v  ; want %q", tt.pickle, err, tt.err)
+		}
+	}
+}
+
+// TestEncodeExt verifies that classes from extension registry are encoded with EXT opcodes.
+func TestEncodeExt(t *testing.T) {
+	odict := Class{"collections", "OrderedDict"}
+	decimal := Class{"decimal", "Decimal"}
+	fraction := Class{"fractions", "Fraction"}
+	registry := map[Class]int{odict: 1, decimal: 300, fraction: 70000}
+	v := []any{odict, decimal, Call{fraction, Tuple{}}, Class{"a", "b"}}
+
+	for _, tt := range []struct {
+		proto  int
+		pickle string
+	}{
+		{1, "](ccollections\nOrderedDict\ncdecimal\nDecimal\ncfractions\nFraction\n)Rca\nb\ne."},
+		{2, "\x80\x02](\x82\x01\x83,\x01\x84p\x11\x01\x00)Rca\nb\ne."},
+		{4, "\x80\x04](\x82\x01\x83,\x01\x84p\x11\x01\x00)R\x8c\x01a\x8c\x01b\x93e."},
+	} {
+		buf := &bytes.Buffer{}
+		err := NewEncoderWithConfig(buf, &EncoderConfig{Protocol: tt.proto, ExtensionRegistry: registry}).Encode(v)
+		if err != nil {
+			t.Errorf("protocol %d: %s", tt.proto, err)
+			continue
+		}
+		if got := buf.String(); got != tt.pickle {
+			t.Errorf("protocol %d:\nhave: %q\nwant: %q", tt.proto, got, tt.pickle)
+		}
+
+		// decode back with inverse registry
+		dregistry := map[int]Class{}
+		for class, code := range registry {
+			dregistry[code] = class
+		}
+		obj, err := NewDecoderWithConfig(buf, &DecoderConfig{ExtensionRegistry: dregistry}).Decode()
+		if !(reflect.DeepEqual(obj, v) && err == nil) {
+			t.Errorf("protocol %d: decode back:\nhave: %#v, %v\nwant: %#v", tt.proto, obj, err, v)
+		}
+	}
+
+	for _, code := range []int{0, -1, 0x80000000} {
+		err := NewEncoderWithConfig(&bytes.Buffer{}, &EncoderConfig{Protocol: 2, ExtensionRegistry: map[Class]int{odict: code}}).Encode(odict)
+		if err == nil {
+			t.Errorf("code %d: no error", code)
+		}
+	}
+}
+
+// TestDecodeInst verifies decoding of class instances pickled with INST and OBJ opcodes.
+func TestDecodeInst(t *testing.T) {
+	decimal := Class{"decimal", "Decimal"}
+
+	for _, tt := range []struct {
+		pickle string
+		want   any
+	}{
+		// Python2 old-style class instance with __getinitargs__, protocol 0
+		{"(S'3.5'\nidecimal\nDecimal\np0\n.", Call{decimal, Tuple{"3.5"}}},
+		{"(idecimal\nDecimal\n.", Call{decimal, Tuple{}}},
+
+		// the same with protocol 1
+		{"(cdecimal\nDecimal\nq\x00U\x033.5o.", Call{decimal, Tuple{"3.5"}}},
+		{"(cdecimal\nDecimal\no.", Call{decimal, Tuple{}}},
+
+		// calls are handled the same way as with REDUCE
+		{"(]q\x00(K\x01K\x02eibuiltins\nset\n.", NewSetWithData(int64(1), int64(2))},
+		{"(cbuiltins\nset\n]q\x00(K\x01K\x02eo.", NewSetWithData(int64(1), int64(2))},
+	} {
+		obj, err := NewDecoder(strings.NewReader(tt.pickle)).Decode()
+		if !(err == nil && equal(obj, tt.want)) {
+			t.Errorf("%q: decode:\nhave: %#v, %v\nwant: %#v", tt.pickle, obj, err, tt.want)
+		}
+	}
+
+	for _, tt := range []struct {
+		pickle string
+		err    string
+	}{
+		{"idecimal\nDecimal\n.", "no marker in stack"},
+		{"(idecimal\n",          "unexpected EOF"},
+		{"o.",                   "no marker in stack"},
+		{"(o.",                  "pickle: stack underflow"},
+		{"(K\x01o.",             "pickle: obj: invalid class: int64"},
+	} {
+		_, err := NewDecoder(strings.NewReader(tt.pickle)).Decode()
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("%q: have error %v  ; want %q", tt.pickle, err, tt.err)
+		}
+	}
+}
+
+// TestObject verifies decoding and encoding of objects created via cls.__new__ .
+func TestObject(t *testing.T) {
+	obj := Object{Class: Class{"mod_a", "C"}, Args: Tuple{int64(1), "a"}}
+
+	// pickle.dumps(mod_a.C(), proto) for class C with __getnewargs__ -> (1, 'a')
+	for _, data := range []string{
+		"ccopy_reg\n__newobj__\np0\n(cmod_a\nC\np1\nI1\nVa\np2\ntp3\nRp4\n.",
+		"\x80\x02cmod_a\nC\nq\x00K\x01X\x01\x00\x00\x00aq\x01\x86q\x02\x81q\x03.",
+		"\x80\x04\x95\x19\x00\x00\x00\x00\x00\x00\x00\x8c\x05mod_a\x94\x8c\x01C\x94\x93\x94K\x01\x8c\x01a\x94\x86\x94\x81\x94.",
+	} {
+		v, err := NewDecoder(strings.NewReader(data)).Decode()
+		if !(err == nil && reflect.DeepEqual(v, obj)) {
+			t.Errorf("%q: decode:\nhave: %#v, %v\nwant: %#v", data, v, err, obj)
+		}
+	}
+
+	// RawCalls leaves copyreg.__newobj__ call as is
+	v, err := NewDecoderWithConfig(strings.NewReader("ccopy_reg\n__newobj__\n(cmod_a\nC\nI1\ntR."), &DecoderConfig{RawCalls: true}).Decode()
+	if want := (Call{Class{"copy_reg", "__newobj__"}, Tuple{Class{"mod_a", "C"}, int64(1)}}); !(err == nil && reflect.DeepEqual(v, want)) {
+		t.Errorf("rawcalls: decode:\nhave: %#v, %v\nwant: %#v", v, err, want)
+	}
+
+	// protocol < 2 emulates NEWOBJ via copyreg.__newobj__
+	for proto, want := range map[int]string{
+		1: "ccopy_reg\n__newobj__\n(cmod_a\nC\nK\x01U\x01atR.",
+		2: "\x80\x02cmod_a\nC\nK\x01U\x01a\x86\x81.",
+	} {
+		buf := &bytes.Buffer{}
+		err := NewEncoderWithConfig(buf, &EncoderConfig{Protocol: proto}).Encode(obj)
+		if !(err == nil && buf.String() == want) {
+			t.Errorf("protocol %d: encode:\nhave: %q, %v\nwant: %q", proto, buf.String(), err, want)
+		}
+	}
+
+	if err := VerifyRoundTrip(obj, nil, nil); err != nil {
+		t.Error(err)
+	}
+
+	if v := ValueOf(obj); v.Kind() != KindObject {
+		t.Errorf("kind: have %s  ; want object", v.Kind())
+	} else if class, args, err := v.Object(); !(err == nil && class == obj.Class && len(args) == 2) {
+		t.Errorf("value: %v %v %v", class, args, err)
+	}
+
+	for _, tt := range []struct {
+		pickle string
+		err    string
+	}{
+		{"\x80\x02)\x81.",                             "pickle: stack underflow"},
+		{"\x80\x02K\x01)\x81.",                        "pickle: newobj: invalid class: int64"},
+		{"\x80\x02cmod_a\nC\nK\x01\x81.",              "pickle: newobj: invalid args: int64"},
+		{"\x80\x02ccopyreg\n__newobj__\nK\x01\x85R.", "__newobj__: invalid class: int64"},
+	} {
+		_, err := NewDecoder(strings.NewReader(tt.pickle)).Decode()
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("%q: have error %v  ; want %q", tt.pickle, err, tt.err)
+		}
+	}
+}
+
+// TestObjectKwArgs verifies decoding and encoding of objects created via
+// cls.__new__ with keyword arguments.
+func TestObjectKwArgs(t *testing.T) {
+	obj := Object{Class: Class{"mod_b", "D"}, Args: Tuple{int64(1)}, KwArgs: map[string]any{"x": int64(2)}}
+
+	// pickle.dumps(mod_b.D(), 4) for class D with __getnewargs_ex__ -> ((1,), {'x': 2})
+	data := "\x80\x04\x95\x1e\x00\x00\x00\x00\x00\x00\x00\x8c\x05mod_b\x94\x8c\x01D\x94\x93\x94K\x01\x85\x94}\x94\x8c\x01x\x94K\x02s\x92\x94."
+	for _, config := range []DecoderConfig{{}, {PyDict: true}, {DictAsItems: true}} {
+		v, err := NewDecoderWithConfig(strings.NewReader(data), &config).Decode()
+		if !(err == nil && reflect.DeepEqual(v, obj)) {
+			t.Errorf("%+v: decode:\nhave: %#v, %v\nwant: %#v", config, v, err, obj)
+		}
+	}
+
+	// protocol < 4 emulates NEWOBJ_EX via copyreg.__newobj_ex__
+	for proto, want := range map[int]string{
+		0: "ccopy_reg\n__newobj_ex__\n(cmod_b\nD\n(I1\nt(dS\"x\"\nI2\nstR.",
+		3: "\x80\x03ccopyreg\n__newobj_ex__\ncmod_b\nD\nK\x01\x85}X\x01\x00\x00\x00xK\x02s\x87R.",
+		4: "\x80\x04\x8c\x05mod_b\x8c\x01D\x93K\x01\x85}\x8c\x01xK\x02s\x92.",
+	} {
+		buf := &bytes.Buffer{}
+		err := NewEncoderWithConfig(buf, &EncoderConfig{Protocol: proto}).Encode(obj)
+		if !(err == nil && buf.String() == want) {
+			t.Errorf("protocol %d: encode:\nhave: %q, %v\nwant: %q", proto, buf.String(), err, want)
+		}
+	}
+
+	if err := VerifyRoundTrip(obj, nil, nil); err != nil {
+		t.Error(err)
+	}
+
+	for _, tt := range []struct {
+		pickle string
+		err    string
+	}{
+		{"\x80\x04)}\x92.",                                "pickle: stack underflow"},
+		{"\x80\x04K\x01)}\x92.",                           "pickle: newobj_ex: invalid class: int64"},
+		{"\x80\x04\x8c\x01m\x8c\x01C\x93K\x01}\x92.",        "pickle: newobj_ex: invalid args: int64"},
+		{"\x80\x04\x8c\x01m\x8c\x01C\x93)K\x01\x92.",        "pickle: newobj_ex: invalid kwargs: int64"},
+		{"\x80\x04\x8c\x01m\x8c\x01C\x93)}K\x01K\x02s\x92.", "pickle: newobj_ex: invalid kwargs key: expect unicode|bytestr; got int64"},
+	} {
+		_, err := NewDecoder(strings.NewReader(tt.pickle)).Decode()
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("%q: have error %v  ; want %q", tt.pickle, err, tt.err)
+		}
+	}
+}
+
+// TestObjectReconstructor verifies decoding of objects created via
+// copyreg._reconstructor, as pickled at protocols < 2.
+func TestObjectReconstructor(t *testing.T) {
+	for _, tt := range []struct {
+		data string
+		want any
+	}{
+		// mod_a.C() with attribute v=1
+		{"ccopy_reg\n_reconstructor\np0\n(cmod_a\nC\np1\nc__builtin__\nobject\np2\nNtp3\nRp4\n(dp5\nVv\np6\nI1\nsb.",
+			Object{Class: Class{"mod_a", "C"}, Args: Tuple{}, State: map[any]any{"v": int64(1)}}},
+		{"ccopy_reg\n_reconstructor\nq\x00(cmod_a\nC\nq\x01c__builtin__\nobject\nq\x02Ntq\x03Rq\x04}q\x05U\x01vq\x06K\x01sb.",
+			Object{Class: Class{"mod_a", "C"}, Args: Tuple{}, State: map[any]any{"v": int64(1)}}},
+		// mod_a.D(a=1) with attribute z=2, for class D(dict)
+		{"ccopy_reg\n_reconstructor\nq\x00(cmod_a\nD\nq\x01c__builtin__\ndict\nq\x02}q\x03X\x01\x00\x00\x00aq\x04K\x01stq\x05Rq\x06}q\x07X\x01\x00\x00\x00zq\x08K\x02sb.",
+			Object{Class: Class{"mod_a", "D"}, Args: Tuple{map[any]any{"a": int64(1)}}, State: map[any]any{"z": int64(2)}}},
+	} {
+		v, err := NewDecoder(strings.NewReader(tt.data)).Decode()
+		if !(err == nil && reflect.DeepEqual(v, tt.want)) {
+			t.Errorf("%q: decode:\nhave: %#v, %v\nwant: %#v", tt.data, v, err, tt.want)
+		}
+	}
+
+	// RawCalls leaves copyreg._reconstructor call as is
+	v, err := NewDecoderWithConfig(strings.NewReader("ccopy_reg\n_reconstructor\n(cmod_a\nC\nc__builtin__\nobject\nNtR."), &DecoderConfig{RawCalls: true}).Decode()
+	if want := (Call{Class{"copy_reg", "_reconstructor"}, Tuple{Class{"mod_a", "C"}, Class{"__builtin__", "object"}, None{}}}); !(err == nil && reflect.DeepEqual(v, want)) {
+		t.Errorf("rawcalls: decode:\nhave: %#v, %v\nwant: %#v", v, err, want)
+	}
+
+	for _, tt := range []struct {
+		pickle string
+		err    string
+	}{
+		{"ccopy_reg\n_reconstructor\n(I1\nc__builtin__\nobject\nNtR.", "_reconstructor: invalid class: int64"},
+		{"ccopy_reg\n_reconstructor\n(cmod_a\nC\nI1\nNtR.",              "_reconstructor: invalid base: int64"},
+	} {
+		_, err := NewDecoder(strings.NewReader(tt.pickle)).Decode()
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("%q: have error %v  ; want %q", tt.pickle, err, tt.err)
+		}
+	}
+}
+
+// TestObjectState verifies decoding and encoding of objects with state set via BUILD.
+func TestObjectState(t *testing.T) {
+	state := map[any]any{"x": int64(1), "y": "a"}
+	obj := Object{Class: Class{"mod_c", "P"}, Args: Tuple{}, State: state}
+
+	for _, tt := range []struct {
+		pickle string
+		want   any
+	}{
+		// pickle.dumps(mod_c.P(), proto) for class P with x=1 and y='a' attributes
+		{"\x80\x02cmod_c\nP\nq\x00)\x81q\x01}q\x02(X\x01\x00\x00\x00xq\x03K\x01X\x01\x00\x00\x00yq\x04X\x01\x00\x00\x00aq\x05ub.", obj},
+		{"\x80\x04\x95%\x00\x00\x00\x00\x00\x00\x00\x8c\x05mod_c\x94\x8c\x01P\x94\x93\x94)\x81\x94}\x94(\x8c\x01x\x94K\x01\x8c\x01y\x94\x8c\x01a\x94ub.", obj},
+
+		// protocol 0 reconstructs the object via copyreg._reconstructor
+		{"ccopy_reg\n_reconstructor\np0\n(cmod_c\nP\np1\nc__builtin__\nobject\np2\nNtp3\nRp4\n(dp5\nVx\np6\nI1\nsVy\np7\nVa\np8\nsb.", obj},
+
+		// pickle.dumps(mod_c.R(), 2) for class R with __reduce__ -> (R, (1,), {'z': 2})
+		{"\x80\x02cmod_c\nR\nq\x00K\x01\x85q\x01Rq\x02}q\x03X\x01\x00\x00\x00zq\x04K\x02sb.",
+			Object{Class: Class{"mod_c", "R"}, Args: Tuple{int64(1)}, State: map[any]any{"z": int64(2)}, Called: true}},
+	} {
+		v, err := NewDecoder(strings.NewReader(tt.pickle)).Decode()
+		if !(err == nil && reflect.DeepEqual(v, tt.want)) {
+			t.Errorf("%q: decode:\nhave: %#v, %v\nwant: %#v", tt.pickle, v, err, tt.want)
+		}
+	}
+
+	for proto, want := range map[int]string{
+		2: "\x80\x02cmod_c\nR\nK\x01\x85R}U\x01zK\x02sb.",
+		4: "\x80\x04\x8c\x05mod_c\x8c\x01R\x93K\x01\x85R}\x8c\x01zK\x02sb.",
+	} {
+		called := Object{Class: Class{"mod_c", "R"}, Args: Tuple{int64(1)}, State: map[any]any{"z": int64(2)}, Called: true}
+		buf := &bytes.Buffer{}
+		err := NewEncoderWithConfig(buf, &EncoderConfig{Protocol: proto}).Encode(called)
+		if !(err == nil && buf.String() == want) {
+			t.Errorf("protocol %d: encode:\nhave: %q, %v\nwant: %q", proto, buf.String(), err, want)
+		}
+	}
+
+	for _, v := range []any{
+		obj,
+		Object{Class: Class{"mod_c", "P"}, Args: Tuple{}, State: None{}},
+		Object{Class: Class{"mod_c", "P"}, Args: Tuple{}, KwArgs: map[string]any{"a": int64(1)}, State: Tuple{None{}, state}},
+		Object{Class: Class{"mod_c", "R"}, Args: Tuple{int64(1)}, State: state, Called: true},
+	} {
+		if err := VerifyRoundTrip(v, nil, nil); err != nil {
+			t.Error(err)
+		}
+	}
+
+	err := NewEncoder(&bytes.Buffer{}).Encode(Object{Class: Class{"mod_c", "R"}, KwArgs: map[string]any{"a": int64(1)}, Called: true})
+	if err == nil || err.Error() != "object: keyword arguments are not supported for call" {
+		t.Errorf("encode call with kwargs: have error %v", err)
+	}
+
+	for _, tt := range []struct {
+		pickle string
+		err    string
+	}{
+		{"Nb.",                           "pickle: stack underflow"},
+		{"I1\nNb.",                       "pickle: build: unsupported object int64"},
+		{"\x80\x02cmod_c\nP\n)\x81NbNb.", "pickle: build: mod_c.P object already has state"},
+	} {
+		_, err := NewDecoder(strings.NewReader(tt.pickle)).Decode()
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("%q: have error %v  ; want %q", tt.pickle, err, tt.err)
+		}
+	}
+
+	// Lenient mode leaves unsupported object as is
+	v, err := NewDecoderWithConfig(strings.NewReader("I1\nNb."), &DecoderConfig{Lenient: true}).Decode()
+	if !(err == nil && v == int64(1)) {
+		t.Errorf("lenient: decode: have %#v, %v  ; want 1", v, err)
+	}
+}
+
+// TestDecodeOutOfBandBuffers verifies decoding of protocol 5 pickles with out-of-band buffers.
+func TestDecodeOutOfBandBuffers(t *testing.T) {
+	// bufs = []
+	// pickle.dumps([PickleBuffer(b'abc'), PickleBuffer(bytearray(b'de'))], 5, buffer_callback=bufs.append)
+	data := "\x80\x05\x95\x08\x00\x00\x00\x00\x00\x00\x00]\x94(\x97\x98\x97e."
+
+	bufs := [][]byte{[]byte("abc"), []byte("de")}
+	v, err := NewDecoderWithConfig(strings.NewReader(data), &DecoderConfig{Buffers: bufs}).Decode()
+	if want := []any{Bytes("abc"), []byte("de")}; !(err == nil && reflect.DeepEqual(v, want)) {
+		t.Errorf("decode:\nhave: %#v, %v\nwant: %#v", v, err, want)
+	}
+	// writable buffer is referenced, not copied
+	if l, ok := v.([]any); ok && len(l) == 2 {
+		if b, ok := l[1].([]byte); !(ok && &b[0] == &bufs[1][0]) {
+			t.Errorf("decode: writable buffer was copied")
+		}
+	}
+
+	v, err = NewDecoderWithConfig(strings.NewReader(data), &DecoderConfig{Buffers: bufs, BytesAsSlice: true}).Decode()
+	if want := []any{[]byte("abc"), []byte("de")}; !(err == nil && reflect.DeepEqual(v, want)) {
+		t.Errorf("bytesAsSlice: decode:\nhave: %#v, %v\nwant: %#v", v, err, want)
+	}
+
+	// buffers are consumed across Decode calls
+	dec := NewDecoderWithConfig(strings.NewReader("\x80\x05\x97.\x80\x05\x97\x98.\x80\x05\x97."), &DecoderConfig{Buffers: bufs})
+	for i, want := range []any{[]byte("abc"), Bytes("de")} {
+		v, err := dec.Decode()
+		if !(err == nil && reflect.DeepEqual(v, want)) {
+			t.Errorf("decode #%d:\nhave: %#v, %v\nwant: %#v", i, v, err, want)
+		}
+	}
+	_, err = dec.Decode()
+	if err == nil || err.Error() != "next_buffer: not enough out-of-band buffers" {
+		t.Errorf("decode #2: have error %v", err)
+	}
+
+	_, err = NewDecoderWithConfig(strings.NewReader("\x80\x05K\x01\x98."), &DecoderConfig{Buffers: bufs}).Decode()
+	if err == nil || err.Error() != "read_only_buffer: stack top is not buffer" {
+		t.Errorf("readonly int: have error %v", err)
+	}
+}
+
+// TestEncodeOutOfBandBuffers verifies emitting of out-of-band buffers with BufferCallback.
+func TestEncodeOutOfBandBuffers(t *testing.T) {
+	v := []any{PickleBuffer{Data: []byte("abc"), ReadOnly: true}, []byte("de"), Bytes("f"), Bytes("large")}
+
+	var bufs [][]byte
+	config := &EncoderConfig{Protocol: 5, BufferCallback: func(data []byte, readOnly bool) bool {
+		if len(data) == 1 {
+			return true // small data is emitted in-band
+		}
+		bufs = append(bufs, data)
+		return false
+	}}
+
+	buf := &bytes.Buffer{}
+	err := NewEncoderWithConfig(buf, config).Encode(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "\x80\x05](\x97\x98\x97C\x01f\x97\x98e."; buf.String() != want {
+		t.Errorf("encode:\nhave: %q\nwant: %q", buf.String(), want)
+	}
+	if want := [][]byte{[]byte("abc"), []byte("de"), []byte("large")}; !reflect.DeepEqual(bufs, want) {
+		t.Errorf("buffers:\nhave: %q\nwant: %q", bufs, want)
+	}
+
+	obj, err := NewDecoderWithConfig(bytes.NewReader(buf.Bytes()), &DecoderConfig{Buffers: bufs}).Decode()
+	if want := []any{Bytes("abc"), []byte("de"), Bytes("f"), Bytes("large")}; !(err == nil && reflect.DeepEqual(obj, want)) {
+		t.Errorf("decode:\nhave: %#v, %v\nwant: %#v", obj, err, want)
+	}
+
+	// protocol < 5: BufferCallback is not used
+	bufs = nil
+	config.Protocol = 4
+	err = NewEncoderWithConfig(&bytes.Buffer{}, config).Encode(v)
+	if !(err == nil && bufs == nil) {
+		t.Errorf("protocol 4: encode: %v, buffers: %q", err, bufs)
+	}
+}
+
+func TestEncodeFraming(t *testing.T) {
+	testv := []struct {
+		config EncoderConfig
+		obj    any
+		want   string
+	}{
+		// small pickles are not framed
+		{EncoderConfig{Protocol: 4, Framing: true}, int64(1), "\x80\x04K\x01."},
+		{EncoderConfig{Protocol: 4, Framing: true}, "hello", "\x80\x04\x95\x08\x00\x00\x00\x00\x00\x00\x00\x8c\x05hello."},
+		{EncoderConfig{Protocol: 5, Framing: true}, "hello", "\x80\x05\x95\x08\x00\x00\x00\x00\x00\x00\x00\x8c\x05hello."},
+
+		// protocol < 4 and framing disabled
+		{EncoderConfig{Protocol: 3, Framing: true}, "hello", "\x80\x03X\x05\x00\x00\x00hello."},
+		{EncoderConfig{Protocol: 4}, "hello", "\x80\x04\x8c\x05hello."},
+
+		// frame is ended when it reaches target size; large data goes outside of frames
+		{EncoderConfig{Protocol: 4, Framing: true, FrameSize: 4}, Tuple{"ab", "cd", Bytes(strings.Repeat("x", 256))},
+			"\x80\x04\x95\x04\x00\x00\x00\x00\x00\x00\x00\x8c\x02ab" +
+				"\x95\x04\x00\x00\x00\x00\x00\x00\x00\x8c\x02cd" +
+				"B\x00\x01\x00\x00" + strings.Repeat("x", 256) + "\x87."},
+	}
+
+	for _, tt := range testv {
+		buf := &bytes.Buffer{}
+		err := NewEncoderWithConfig(buf, &tt.config).Encode(tt.obj)
+		if err != nil {
+			t.Errorf("%#v: %+v: encode: %s", tt.obj, tt.config, err)
+			continue
+		}
+		if buf.String() != tt.want {
+			t.Errorf("%#v: %+v: encode:\nhave: %q\nwant: %q", tt.obj, tt.config, buf.String(), tt.want)
+		}
+
+		obj, err := NewDecoderWithConfig(buf, &DecoderConfig{StrictFrames: true}).Decode()
+		if !(err == nil && reflect.DeepEqual(obj, tt.obj)) {
+			t.Errorf("%#v: %+v: decode: %#v, %v", tt.obj, tt.config, obj, err)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package ogórek
+// Public metadata about pickle opcodes.
+
+// ArgKind describes how argument of a pickle opcode is encoded.
+type ArgKind int
+
+const (
+	ArgUnknown ArgKind = iota // opcode is not known
+	ArgNone                   // no argument
+	ArgLine                   // \n-terminated line
+	ArgLine2                  // two \n-terminated lines
+	ArgFixed                  // ArgSize bytes
+	ArgCounted                // ArgSize-byte little-endian length, followed by data of that length
+)
+
+// OpcodeInfo describes a pickle opcode.
+type OpcodeInfo struct {
+	Code    byte
+	Name    string  // name of the opcode as in Python pickletools, e.g. "BININT1"
+	Arg     ArgKind // how the argument of the opcode is encoded
+	ArgSize int     // size of ArgFixed argument, or of length of ArgCounted argument
+	Proto   int     // minimum protocol that has the opcode
+	Doc     string  // short description
+}
+
+// Known returns whether the opcode is a valid pickle opcode.
+func (op *OpcodeInfo) Known() bool {
+	return op.Arg != ArgUnknown
+}
+
+// SignedLen returns whether length of the ArgCounted argument is signed, as it
+// is for BINSTRING and LONG4. Negative lengths are invalid for such opcodes,
+// while e.g. BINBYTES and BINUNICODE lengths are unsigned.
+func (op *OpcodeInfo) SignedLen() bool {
+	return op.Code == opBinstring || op.Code == opLong4
+}
+
+// opcodes describes all opcodes of pickle protocols 0-5, indexed by opcode byte.
+//
+// Entries for invalid opcodes have Arg=ArgUnknown. The table is private, so
+// that users cannot modify it under the decoder; Opcodes returns its copy.
+var opcodes = func() (tab [256]OpcodeInfo) {
+	for _, op := range []OpcodeInfo{
+		// Protocol 0
+		{opMark,            "MARK",             ArgNone,    0, 0, "push special markobject on stack"},
+		{opStop,            "STOP",             ArgNone,    0, 0, "every pickle ends with STOP"},
+		{opPop,             "POP",              ArgNone,    0, 0, "discard topmost stack item"},
+		{opDup,             "DUP",              ArgNone,    0, 0, "duplicate top stack item"},
+		{opFloat,           "FLOAT",            ArgLine,    0, 0, "push float object; decimal string argument"},
+		{opInt,             "INT",              ArgLine,    0, 0, "push integer or bool; decimal string argument"},
+		{opLong,            "LONG",             ArgLine,    0, 0, "push long; decimal string argument"},
+		{opNone,            "NONE",             ArgNone,    0, 0, "push None"},
+		{opPersid,          "PERSID",           ArgLine,    0, 0, "push persistent object; id is taken from string arg"},
+		{opReduce,          "REDUCE",           ArgNone,    0, 0, "apply callable to argtuple, both on stack"},
+		{opString,          "STRING",           ArgLine,    0, 0, "push string; NL-terminated string argument"},
+		{opUnicode,         "UNICODE",          ArgLine,    0, 0, "push Unicode string; raw-unicode-escaped argument"},
+		{opAppend,          "APPEND",           ArgNone,    0, 0, "append stack top to list below it"},
+		{opBuild,           "BUILD",            ArgNone,    0, 0, "call __setstate__ or __dict__.update()"},
+		{opGlobal,          "GLOBAL",           ArgLine2,   0, 0, "push self.find_class(modname, name); 2 string args"},
+		{opDict,            "DICT",             ArgNone,    0, 0, "build a dict from stack items"},
+		{opGet,             "GET",              ArgLine,    0, 0, "push item from memo on stack; index is string arg"},
+		{opInst,            "INST",             ArgLine2,   0, 0, "build & push class instance"},
+		{opList,            "LIST",             ArgNone,    0, 0, "build list from topmost stack items"},
+		{opPut,             "PUT",              ArgLine,    0, 0, "store stack top in memo; index is string arg"},
+		{opSetitem,         "SETITEM",          ArgNone,    0, 0, "add key+value pair to dict"},
+		{opTuple,           "TUPLE",            ArgNone,    0, 0, "build tuple from topmost stack items"},
+
+		// Protocol 1
+		{opPopMark,         "POP_MARK",         ArgNone,    0, 1, "discard stack top through topmost markobject"},
+		{opBinint,          "BININT",           ArgFixed,   4, 1, "push four-byte signed int"},
+		{opBinint1,         "BININT1",          ArgFixed,   1, 1, "push 1-byte unsigned int"},
+		{opBinint2,         "BININT2",          ArgFixed,   2, 1, "push 2-byte unsigned int"},
+		{opBinpersid,       "BINPERSID",        ArgNone,    0, 1, "push persistent object; id is taken from stack"},
+		{opBinstring,       "BINSTRING",        ArgCounted, 4, 1, "push string; counted binary string argument"},
+		{opShortBinstring,  "SHORT_BINSTRING",  ArgCounted, 1, 1, "push string; counted binary string argument < 256 bytes"},
+		{opBinunicode,      "BINUNICODE",       ArgCounted, 4, 1, "push Unicode string; counted UTF-8 string argument"},
+		{opAppends,         "APPENDS",          ArgNone,    0, 1, "extend list on stack by topmost stack slice"},
+		{opBinget,          "BINGET",           ArgFixed,   1, 1, "push item from memo on stack; index is 1-byte arg"},
+		{opLongBinget,      "LONG_BINGET",      ArgFixed,   4, 1, "push item from memo on stack; index is 4-byte arg"},
+		{opEmptyList,       "EMPTY_LIST",       ArgNone,    0, 1, "push empty list"},
+		{opEmptyTuple,      "EMPTY_TUPLE",      ArgNone,    0, 1, "push empty tuple"},
+		{opEmptyDict,       "EMPTY_DICT",       ArgNone,    0, 1, "push empty dict"},
+		{opObj,             "OBJ",              ArgNone,    0, 1, "build & push class instance"},
+		{opBinput,          "BINPUT",           ArgFixed,   1, 1, "store stack top in memo; index is 1-byte arg"},
+		{opLongBinput,      "LONG_BINPUT",      ArgFixed,   4, 1, "store stack top in memo; index is 4-byte arg"},
+		{opSetitems,        "SETITEMS",         ArgNone,    0, 1, "modify dict by adding topmost key+value pairs"},
+		{opBinfloat,        "BINFLOAT",         ArgFixed,   8, 1, "push float; arg is 8-byte float encoding"},
+
+		// Protocol 2
+		{opProto,           "PROTO",            ArgFixed,   1, 2, "identify pickle protocol"},
+		{opNewobj,          "NEWOBJ",           ArgNone,    0, 2, "build object: cls argv -> cls.__new__(*argv)"},
+		{opExt1,            "EXT1",             ArgFixed,   1, 2, "push object from extension registry; 1-byte index"},
+		{opExt2,            "EXT2",             ArgFixed,   2, 2, "push object from extension registry; 2-byte index"},
+		{opExt4,            "EXT4",             ArgFixed,   4, 2, "push object from extension registry; 4-byte index"},
+		{opTuple1,          "TUPLE1",           ArgNone,    0, 2, "build 1-tuple from stack top"},
+		{opTuple2,          "TUPLE2",           ArgNone,    0, 2, "build 2-tuple from two topmost stack items"},
+		{opTuple3,          "TUPLE3",           ArgNone,    0, 2, "build 3-tuple from three topmost stack items"},
+		{opNewtrue,         "NEWTRUE",          ArgNone,    0, 2, "push True"},
+		{opNewfalse,        "NEWFALSE",         ArgNone,    0, 2, "push False"},
+		{opLong1,           "LONG1",            ArgCounted, 1, 2, "push long from < 256 bytes"},
+		{opLong4,           "LONG4",            ArgCounted, 4, 2, "push really big long"},
+
+		// Protocol 3
+		{opBinbytes,        "BINBYTES",         ArgCounted, 4, 3, "push a Python bytes object (len ule32; [len]data)"},
+		{opShortBinbytes,   "SHORT_BINBYTES",   ArgCounted, 1, 3, "push a Python bytes object (len ule8; [len]data)"},
+
+		// Protocol 4
+		{opShortBinUnicode, "SHORT_BINUNICODE", ArgCounted, 1, 4, "push short string; UTF-8 length < 256 bytes"},
+		{opBinunicode8,     "BINUNICODE8",      ArgCounted, 8, 4, "push Unicode string (len ule64; [len]data)"},
+		{opBinbytes8,       "BINBYTES8",        ArgCounted, 8, 4, "push a Python bytes object (len ule64; [len]data)"},
+		{opEmptySet,        "EMPTY_SET",        ArgNone,    0, 4, "push empty set"},
+		{opAddItems,        "ADDITEMS",         ArgNone,    0, 4, "add items to existing set"},
+		{opFrozenSet,       "FROZENSET",        ArgNone,    0, 4, "build a frozenset out of mark..top"},
+		{opNewobjEx,        "NEWOBJ_EX",        ArgNone,    0, 4, "build object: cls argv kw -> cls.__new__(*argv, **kw)"},
+		{opStackGlobal,     "STACK_GLOBAL",     ArgNone,    0, 4, "same as GLOBAL but using names on the stacks"},
+		{opMemoize,         "MEMOIZE",          ArgNone,    0, 4, "store top of the stack in memo"},
+		{opFrame,           "FRAME",            ArgFixed,   8, 4, "indicate the beginning of a new frame"},
+
+		// Protocol 5
+		{opBytearray8,      "BYTEARRAY8",       ArgCounted, 8, 5, "push a Python bytearray object (len ule64; [len]data)"},
+		{opNextBuffer,      "NEXT_BUFFER",      ArgNone,    0, 5, "push next out-of-band buffer"},
+		{opReadOnlyBuffer,  "READONLY_BUFFER",  ArgNone,    0, 5, "turn out-of-band buffer at stack top to be read-only"},
+	} {
+		tab[op.Code] = op
+	}
+	return tab
+}()
+
+// Opcodes returns information about all opcodes of pickle protocols 0-5,
+// indexed by opcode byte.
+//
+// The returned table is a copy, which the caller is free to modify. Entries
+// for invalid opcodes have Arg=ArgUnknown.
+func Opcodes() [256]OpcodeInfo {
+	return opcodes
+}
+
+// OpcodeInfoOf returns information about opcode op of pickle protocols 0-5.
+//
+// For invalid opcodes the returned info has Arg=ArgUnknown.
+func OpcodeInfoOf(op byte) OpcodeInfo {
+	return opcodes[op]
+}
+
+// OpcodeByName returns information about opcode with given pickletools name, e.g. "BININT1".
+func OpcodeByName(name string) (OpcodeInfo, bool) {
+	for i := range opcodes {
+		if opcodes[i].Known() && opcodes[i].Name == name {
+			return opcodes[i], true
+		}
+	}
+	return OpcodeInfo{}, false
+}
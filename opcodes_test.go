@@ -0,0 +1,75 @@
+package ogórek
+
+import (
+	"testing"
+)
+
+// verify that opcodes table is consistent and describes all opcodes handled by the decoder.
+func TestOpcodes(t *testing.T) {
+	names := map[string]bool{}
+	for i := range opcodes {
+		op := OpcodeInfoOf(byte(i))
+		if dispatch[i] != nil && !op.Known() {
+			t.Errorf("opcode %q: handled by decoder, but not known", byte(i))
+		}
+		if !op.Known() {
+			continue
+		}
+		if op.Code != byte(i) {
+			t.Errorf("opcode %q: code %q", byte(i), op.Code)
+		}
+		if op.Name == "" || names[op.Name] {
+			t.Errorf("opcode %q: invalid or duplicate name %q", byte(i), op.Name)
+		}
+		names[op.Name] = true
+		if !(0 <= op.Proto && op.Proto <= highestProtocol) {
+			t.Errorf("opcode %q: invalid protocol %d", byte(i), op.Proto)
+		}
+	}
+
+	for _, tt := range []struct {
+		name    string
+		code    byte
+		arg     ArgKind
+		argSize int
+		proto   int
+	}{
+		{"MARK", opMark, ArgNone, 0, 0},
+		{"GLOBAL", opGlobal, ArgLine2, 0, 0},
+		{"BININT2", opBinint2, ArgFixed, 2, 1},
+		{"LONG4", opLong4, ArgCounted, 4, 2},
+		{"FRAME", opFrame, ArgFixed, 8, 4},
+		{"BYTEARRAY8", opBytearray8, ArgCounted, 8, 5},
+	} {
+		op, ok := OpcodeByName(tt.name)
+		if !ok {
+			t.Errorf("%s: not found", tt.name)
+			continue
+		}
+		if !(op.Code == tt.code && op.Arg == tt.arg && op.ArgSize == tt.argSize && op.Proto == tt.proto) {
+			t.Errorf("%s: have %+v", tt.name, op)
+		}
+	}
+	if _, ok := OpcodeByName("XXX"); ok {
+		t.Errorf("XXX: found")
+	}
+
+	// Opcodes returns copy of the table, that does not affect the decoder
+	tab := Opcodes()
+	for i := range tab {
+		if tab[i] != OpcodeInfoOf(byte(i)) {
+			t.Errorf("Opcodes: opcode %q: have %+v  ; want %+v", byte(i), tab[i], OpcodeInfoOf(byte(i)))
+		}
+	}
+	tab[opBinbytes].ArgSize = 1
+	if OpcodeInfoOf(opBinbytes).ArgSize != 4 {
+		t.Errorf("Opcodes: modification of returned table affects the decoder")
+	}
+
+	for name, signed := range map[string]bool{"BINSTRING": true, "LONG4": true, "BINBYTES": false, "BINUNICODE": false} {
+		op, _ := OpcodeByName(name)
+		if op.SignedLen() != signed {
+			t.Errorf("%s: SignedLen=%v", name, !signed)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package ogórek
+// Support for custom opcodes.
+
+import (
+	"io"
+)
+
+// DecoderState gives custom opcode handlers limited access to the state of
+// [Decoder]: to the input stream and to the stack.
+//
+// See DecoderConfig.OpcodeHandlers for details.
+type DecoderState struct {
+	d *Decoder
+}
+
+// Read reads opcode argument from the input stream.
+func (s *DecoderState) Read(p []byte) (int, error) {
+	return s.d.r.Read(p)
+}
+
+// ReadFull reads exactly len(p) bytes of opcode argument from the input stream.
+func (s *DecoderState) ReadFull(p []byte) error {
+	_, err := io.ReadFull(s.d.r, p)
+	return err
+}
+
+// ReadByte reads one byte of opcode argument from the input stream.
+func (s *DecoderState) ReadByte() (byte, error) {
+	return s.d.r.ReadByte()
+}
+
+// ReadLine reads \n-terminated line of opcode argument from the input stream.
+//
+// The returned line does not include trailing \n and is valid only until
+// next call to ReadLine.
+func (s *DecoderState) ReadLine() ([]byte, error) {
+	return s.d.readLine()
+}
+
+// Protocol returns protocol version seen in last PROTO opcode; 0 by default.
+func (s *DecoderState) Protocol() int {
+	return s.d.protocol
+}
+
+// Push pushes v onto the stack.
+func (s *DecoderState) Push(v any) {
+	s.d.push(v)
+}
+
+// Pop pops value from the top of the stack.
+//
+// It is an error if the stack is empty, or if the top of the stack is MARK.
+func (s *DecoderState) Pop() (any, error) {
+	return s.d.popUser()
+}
+
+// PopMark pops all values from the stack down to topmost MARK, and the MARK itself.
+//
+// The values are returned in the order they were pushed.
+func (s *DecoderState) PopMark() ([]any, error) {
+	k, err := s.d.marker()
+	if err != nil {
+		return nil, err
+	}
+	v := append([]any{}, s.d.stack[k+1:]...)
+	s.d.stack = s.d.stack[:k]
+	return v, nil
+}
@@ -0,0 +1,102 @@
+package ogórek
+
+import (
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestOpcodeHandlers verifies decoding with custom opcode handlers.
+func TestOpcodeHandlers(t *testing.T) {
+	handlers := map[byte]func(s *DecoderState) error{
+		// push 2-byte big-endian int
+		0xf0: func(s *DecoderState) error {
+			var b [2]byte
+			err := s.ReadFull(b[:])
+			if err != nil {
+				return err
+			}
+			s.Push(int64(binary.BigEndian.Uint16(b[:])))
+			return nil
+		},
+		// build reversed list from mark..top
+		0xf1: func(s *DecoderState) error {
+			v, err := s.PopMark()
+			if err != nil {
+				return err
+			}
+			for i, j := 0, len(v)-1; i < j; i, j = i+1, j-1 {
+				v[i], v[j] = v[j], v[i]
+			}
+			s.Push(v)
+			return nil
+		},
+		// push name given as text line, tagged with protocol
+		0xf2: func(s *DecoderState) error {
+			line, err := s.ReadLine()
+			if err != nil {
+				return err
+			}
+			s.Push(Tuple{string(line), int64(s.Protocol())})
+			return nil
+		},
+		// pop top and replace it with its string representation
+		0xf3: func(s *DecoderState) error {
+			v, err := s.Pop()
+			if err != nil {
+				return err
+			}
+			s.Push(Sprint("%v", v, nil))
+			return nil
+		},
+		// builtin opcodes can be overridden
+		opNone: func(s *DecoderState) error {
+			s.Push("none")
+			return nil
+		},
+		0xf4: func(s *DecoderState) error {
+			return errors.New("bad opcode")
+		},
+	}
+
+	for _, tt := range []struct {
+		pickle string
+		want   any
+	}{
+		{"\x80\x02\xf0\x01\x02.", int64(0x102)},
+		{"\x80\x02(K\x01K\x02K\x03\xf1.", []any{int64(3), int64(2), int64(1)}},
+		{"\x80\x03\xf2abc\n.", Tuple{"abc", int64(3)}},
+		{"\x80\x02K\x05\xf3.", "5"},
+		{"\x80\x02N.", "none"},
+	} {
+		obj, err := NewDecoderWithConfig(strings.NewReader(tt.pickle), &DecoderConfig{OpcodeHandlers: handlers}).Decode()
+		if err != nil {
+			t.Errorf("%q: %s", tt.pickle, err)
+			continue
+		}
+		if !reflect.DeepEqual(obj, tt.want) {
+			t.Errorf("%q:\nhave: %#v\nwant: %#v", tt.pickle, obj, tt.want)
+		}
+	}
+
+	for _, pickle := range []string{
+		"\x80\x02\xf4.",       // handler error
+		"\x80\x02\xf0\x01",    // short argument
+		"\x80\x02K\x01\xf1.",  // no mark
+		"\x80\x02(\xf3.",      // pop of mark
+		"\x80\x02\xf5.",       // unknown opcode
+	} {
+		_, err := NewDecoderWithConfig(strings.NewReader(pickle), &DecoderConfig{OpcodeHandlers: handlers}).Decode()
+		if err == nil {
+			t.Errorf("%q: no error", pickle)
+		}
+	}
+
+	// nil handlers are ignored
+	obj, err := NewDecoderWithConfig(strings.NewReader("\x80\x02K\x05."), &DecoderConfig{OpcodeHandlers: map[byte]func(*DecoderState) error{'K': nil}}).Decode()
+	if !(err == nil && obj == int64(5)) {
+		t.Errorf("nil handler: have %#v, %v  ; want 5", obj, err)
+	}
+}
@@ -0,0 +1,93 @@
+package ogórek
+// Support for Python functools.partial objects.
+//
+// partial objects are pickled as functools.partial(func) call followed by
+// BUILD with (func, args, keywords, __dict__) state.
+
+import (
+	"fmt"
+)
+
+// Partial represents Python functools.partial object - func with some of
+// its arguments fixed.
+type Partial struct {
+	Func   any            // the callable, e.g. Class for functions referenced by name
+	Args   Tuple          // fixed positional arguments
+	KwArgs map[string]any // fixed keyword arguments; nil if there are none
+	Dict   any            // attributes of the partial object; nil if there are none
+}
+
+var pyPartial = Class{Module: "functools", Name: "partial"}
+
+// handlePartialCall serves handleCall for functools.partial(func, *args).
+func (d *Decoder) handlePartialCall(argv Tuple) error {
+	if len(argv) < 1 {
+		return fmt.Errorf("functools.partial: no func")
+	}
+	d.push(Partial{Func: argv[0], Args: append(Tuple{}, argv[1:]...)})
+	return nil
+}
+
+// buildPartial serves BUILD for partial objects.
+//
+// The state is (func, args, keywords, __dict__), where keywords and __dict__
+// can be None.
+func (d *Decoder) buildPartial(p Partial, state any) error {
+	t, ok := state.(Tuple)
+	if !(ok && len(t) == 4) {
+		return fmt.Errorf("pickle: build: functools.partial: invalid state %s", Sprint("%#v", state, nil))
+	}
+	args, ok := t[1].(Tuple)
+	if !ok {
+		return fmt.Errorf("pickle: build: functools.partial: invalid args: %T", t[1])
+	}
+
+	p.Func = t[0]
+	p.Args = args
+	p.KwArgs = nil
+	if _, none := t[2].(None); !none {
+		kwargs, err := kwargsOf(t[2])
+		if err != nil {
+			return fmt.Errorf("pickle: build: functools.partial: %s", err)
+		}
+		p.KwArgs = kwargs
+	}
+	p.Dict = nil
+	if _, none := t[3].(None); !none {
+		p.Dict = t[3]
+	}
+
+	d.stack[len(d.stack)-1] = p
+	d.updateCells(len(d.stack)-1, p)
+	return nil
+}
+
+// encodePartial encodes Partial as functools.partial object, the same way
+// Python pickles it.
+func (e *Encoder) encodePartial(p *Partial) error {
+	err := e.encodeCallAt(&Call{Callable: pyPartial, Args: Tuple{p.Func}}, func(int) string {
+		return ".Func"
+	})
+	if err != nil {
+		return err
+	}
+
+	args := p.Args
+	if args == nil {
+		args = Tuple{}
+	}
+	var kwargs, dict any = None{}, None{}
+	if len(p.KwArgs) > 0 {
+		kwargs = p.KwArgs
+	}
+	if p.Dict != nil {
+		dict = p.Dict
+	}
+	err = e.encodeTupleAt(Tuple{p.Func, args, kwargs, dict}, func(i int) string {
+		return [...]string{".Func", ".Args", ".KwArgs", ".Dict"}[i]
+	})
+	if err != nil {
+		return err
+	}
+	return e.emit(opBuild)
+}
@@ -0,0 +1,83 @@
+package ogórek
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPartialDecode(t *testing.T) {
+	f := Class{"app", "f"}
+	p := Partial{Func: f, Args: Tuple{int64(1), "a"}, KwArgs: map[string]any{"k": int64(2)}}
+
+	for _, tt := range []struct {
+		data string
+		want any
+	}{
+		// functools.partial(app.f, 1, 'a', k=2), as pickled by Python
+		{"cfunctools\npartial\np0\n(capp\nf\np1\ntp2\nRp3\n(g1\n(I1\nVa\np4\ntp5\n(dp6\nVk\np7\nI2\nsNtp8\nb.", p},
+		{"\x80\x02cfunctools\npartial\nq\x00capp\nf\nq\x01\x85q\x02Rq\x03(h\x01K\x01X\x01\x00\x00\x00aq\x04\x86q\x05}q\x06X\x01\x00\x00\x00kq\x07K\x02sNtq\x08b.", p},
+		{"\x80\x04\x95A\x00\x00\x00\x00\x00\x00\x00\x8c\tfunctools\x94\x8c\x07partial\x94\x93\x94\x8c\x03app\x94\x8c\x01f\x94\x93\x94\x85\x94R\x94(h\x05K\x01\x8c\x01a\x94\x86\x94}\x94\x8c\x01k\x94K\x02sNt\x94b.", p},
+		// functools.partial(app.f) with attr=5 attribute
+		{"\x80\x02cfunctools\npartial\nq\x00capp\nf\nq\x01\x85q\x02Rq\x03(h\x01)}q\x04}q\x05X\x04\x00\x00\x00attrq\x06K\x05stq\x07b.",
+			Partial{Func: f, Args: Tuple{}, Dict: map[any]any{"attr": int64(5)}}},
+		// partial referenced twice via memo
+		{"\x80\x02(cfunctools\npartial\ncapp\nf\n\x85Rq\x00(capp\nf\n)NNtbh\x00l.", []any{Partial{Func: f, Args: Tuple{}}, Partial{Func: f, Args: Tuple{}}}},
+		// functools.partial(app.f, 1) without state
+		{"cfunctools\npartial\n(capp\nf\nI1\ntR.", Partial{Func: f, Args: Tuple{int64(1)}}},
+	} {
+		obj, err := NewDecoder(strings.NewReader(tt.data)).Decode()
+		if err != nil {
+			t.Errorf("%q: %s", tt.data, err)
+			continue
+		}
+		if !reflect.DeepEqual(obj, tt.want) {
+			t.Errorf("%q:\nhave: %#v\nwant: %#v", tt.data, obj, tt.want)
+		}
+	}
+
+	for _, data := range []string{
+		"cfunctools\npartial\n)R.",
+		"cfunctools\npartial\n(capp\nf\ntR(capp\nf\nNNNtb.",
+		"cfunctools\npartial\n(capp\nf\ntR(capp\nf\nI1\nNNtb.",
+		"cfunctools\npartial\n(capp\nf\ntR(capp\nf\n)I1\nNtb.",
+	} {
+		_, err := NewDecoder(strings.NewReader(data)).Decode()
+		if err == nil {
+			t.Errorf("%q: no error", data)
+		}
+	}
+
+	// with RawCalls partial is left as is
+	obj, err := NewDecoderWithConfig(strings.NewReader("cfunctools\npartial\n(capp\nf\ntR."), &DecoderConfig{RawCalls: true}).Decode()
+	if want := (Call{pyPartial, Tuple{f}}); !(err == nil && reflect.DeepEqual(obj, want)) {
+		t.Errorf("raw calls: have %#v, %v  ; want %#v", obj, err, want)
+	}
+}
+
+func TestPartialEncode(t *testing.T) {
+	p := Partial{Func: Class{"app", "f"}, Args: Tuple{int64(1)}, KwArgs: map[string]any{"k": int64(2)}}
+
+	buf := &bytes.Buffer{}
+	err := NewEncoderWithConfig(buf, &EncoderConfig{Protocol: 2}).Encode(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "\x80\x02cfunctools\npartial\ncapp\nf\n\x85R(capp\nf\nK\x01\x85}U\x01kK\x02sNtb."
+	if buf.String() != want {
+		t.Errorf("encode:\nhave: %q\nwant: %q", buf.String(), want)
+	}
+
+	for _, in := range []any{p, Partial{Func: Class{"app", "f"}, Args: Tuple{}, Dict: map[any]any{"attr": int64(5)}}} {
+		err = VerifyRoundTrip(in, nil, nil)
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	err = NewEncoder(&bytes.Buffer{}).Encode(Partial{Func: Class{"app", "f"}, Args: Tuple{make(chan int)}})
+	if err == nil || !strings.Contains(err.Error(), ".Args[0]") {
+		t.Errorf("encode error: %v", err)
+	}
+}
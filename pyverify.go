@@ -0,0 +1,129 @@
+//go:build pyverify
+
+package ogórek
+// Cross-verification of ogórek against CPython pickle implementation.
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+)
+
+// ErrNoPython is returned by VerifyPython when python3 is not available.
+var ErrNoPython = errors.New("pyverify: python3 not found")
+
+// PythonPath is the python interpreter used by VerifyPython.
+//
+// If it is not an absolute path, it is looked up in $PATH.
+var PythonPath = "python3"
+
+// pyverifyScript is run by python to load a pickle from stdin and to dump
+// loaded object back to stdout with the same protocol.
+//
+// Classes that cannot be imported are replaced with placeholders that
+// remember their call arguments, and persistent references are kept as
+// is. This way arbitrary Class, Call and Ref objects round-trip.
+const pyverifyScript = `
+import sys, types, pickle, io
+
+protocol = int(sys.argv[1])
+encoding = sys.argv[2]
+
+def stub(module, name):
+	mod = sys.modules.get(module)
+	if mod is None:
+		mod = types.ModuleType(module)
+		sys.modules[module] = mod
+	cls = getattr(mod, name, None)
+	if cls is None:
+		def __init__(self, *args):
+			self._args = args
+		def __reduce__(self):
+			return (type(self), self._args)
+		cls = type(name, (), {'__module__': module, '__qualname__': name,
+				      '__init__': __init__, '__reduce__': __reduce__})
+		setattr(mod, name, cls)
+	return cls
+
+class PersRef:
+	def __init__(self, pid):
+		self.pid = pid
+
+class Unpickler(pickle.Unpickler):
+	def find_class(self, module, name):
+		try:
+			return super().find_class(module, name)
+		except (ImportError, AttributeError):
+			return stub(module, name)
+	def persistent_load(self, pid):
+		return PersRef(pid)
+
+class Pickler(pickle.Pickler):
+	def persistent_id(self, obj):
+		if isinstance(obj, PersRef):
+			return obj.pid
+		return None
+
+data = sys.stdin.buffer.read()
+obj = Unpickler(io.BytesIO(data), encoding=encoding).load()
+out = io.BytesIO()
+Pickler(out, protocol=protocol).dump(obj)
+sys.stdout.buffer.write(out.getvalue())
+`
+
+// VerifyPython verifies that CPython understands pickle encoding of v the same way as ogórek.
+//
+// It encodes v with encConfig, lets python3 load the pickle and dump the
+// loaded object back with the same protocol, and then checks that decoding
+// both pickles with decConfig gives results that are equal in Python sense.
+//
+// In StrictUnicode mode Python2 strings are loaded by python3 as bytes;
+// otherwise they are loaded as UTF-8 encoded text.
+//
+// ErrNoPython is returned if python3 is not available.
+func VerifyPython(v any, encConfig *EncoderConfig, decConfig *DecoderConfig) error {
+	python, err := exec.LookPath(PythonPath)
+	if err != nil {
+		return ErrNoPython
+	}
+
+	buf := &bytes.Buffer{}
+	err = NewEncoderWithConfig(buf, encConfig).Encode(v)
+	if err != nil {
+		return fmt.Errorf("pyverify: encode: %w", err)
+	}
+	data := buf.Bytes()
+
+	obj, err := NewDecoderWithConfig(bytes.NewReader(data), decConfig).Decode()
+	if err != nil {
+		return fmt.Errorf("pyverify: decode: %w", err)
+	}
+
+	encoding := "utf-8"
+	if decConfig.StrictUnicode {
+		encoding = "bytes"
+	}
+	cmd := exec.Command(python, "-c", pyverifyScript, strconv.Itoa(encConfig.Protocol), encoding)
+	cmd.Stdin = bytes.NewReader(data)
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	pydata, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("pyverify: python: %s\n%s\npickle: %q", err, stderr, data)
+	}
+
+	pyobj, err := NewDecoderWithConfig(bytes.NewReader(pydata), decConfig).Decode()
+	if err != nil {
+		return fmt.Errorf("pyverify: decode python pickle: %w\npickle: %q", err, pydata)
+	}
+
+	// compare with Python equality, because python might dump an object
+	// with different, but equal, type, e.g. int64 as long.
+	if !equal(obj, pyobj) {
+		return fmt.Errorf("pyverify: python loads·dumps != identity:\nhave: %#v\nwant: %#v\npickle:        %q\npython pickle: %q",
+			pyobj, obj, data, pydata)
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+//go:build pyverify
+
+package ogórek
+
+import (
+	"fmt"
+	"testing"
+)
+
+// TestPyVerify cross-verifies objects from main tests against CPython.
+//
+// Run it via `go test -tags pyverify -run TestPyVerify`.
+func TestPyVerify(t *testing.T) {
+	for _, test := range tests {
+		test.WithEachMode(t, func(t *testing.T, decConfig DecoderConfig, encConfig EncoderConfig) {
+			for proto := 0; proto <= highestProtocol; proto++ {
+				if test.encodeErrAt(proto) {
+					continue
+				}
+
+				t.Run(fmt.Sprintf("proto=%d", proto), func(t *testing.T) {
+					if why := pyverifySkip(test, decConfig, proto); why != "" {
+						t.Skip(why)
+					}
+					econf := encConfig
+					econf.Protocol = proto
+					err := VerifyPython(test.objectIn, &econf, &decConfig)
+					if err == ErrNoPython {
+						t.Skip(err)
+					}
+					if err != nil {
+						t.Error(err)
+					}
+				})
+			}
+		})
+	}
+}
+
+// encodeErrAt returns whether test entry expects encoding to fail at protocol proto.
+func (test TestEntry) encodeErrAt(proto int) bool {
+	for _, pickle := range test.picklev {
+		for _, p := range pickle.protov {
+			if p == proto && pickle.err != nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// pyverifySkip returns why test entry is known not to round-trip through python3 at given mode.
+//
+// "" is returned if there is no known reason.
+func pyverifySkip(test TestEntry, decConfig DecoderConfig, proto int) string {
+	switch test.name {
+	case "unicode(non-utf8)":
+		return "python3 rejects unicode with invalid UTF-8"
+
+	case `bytes(b"hello\nмир\x01")`, `bytearray(b"hello\nмир\x01")`:
+		if decConfig.StrictUnicode && proto <= 2 {
+			return "python3 with encoding='bytes' cannot load _codecs.encode(..., bytestr)"
+		}
+	}
+	return ""
+}
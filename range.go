@@ -0,0 +1,64 @@
+package ogórek
+// Support for Python range objects.
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Range represents Python range object, or xrange object of Python 2.
+type Range struct {
+	Start, Stop, Step int64
+}
+
+// Len returns the number of integers in range r, as Python len(range) does.
+func (r Range) Len() int64 {
+	switch {
+	case r.Step > 0 && r.Start < r.Stop:
+		return 1 + int64((uint64(r.Stop)-uint64(r.Start)-1)/uint64(r.Step))
+	case r.Step < 0 && r.Start > r.Stop:
+		return 1 + int64((uint64(r.Start)-uint64(r.Stop)-1)/(0-uint64(r.Step)))
+	}
+	return 0
+}
+
+var errRangeZeroStep = errors.New("range: step cannot be zero")
+
+// handleRangeCall serves handleCall for range(stop) and range(start, stop[, step]).
+func (d *Decoder) handleRangeCall(argv Tuple) error {
+	if !(1 <= len(argv) && len(argv) <= 3) {
+		return fmt.Errorf("range: unexpected number of args %d", len(argv))
+	}
+	v := []int64{0, 0, 1}
+	for i, arg := range argv {
+		n, ok := arg.(int64)
+		if !ok {
+			return fmt.Errorf("range: expect int; got %T", arg)
+		}
+		v[i] = n
+	}
+	if len(argv) == 1 {
+		v[0], v[1] = 0, v[0]
+	}
+	if v[2] == 0 {
+		return errRangeZeroStep
+	}
+	d.push(Range{Start: v[0], Stop: v[1], Step: v[2]})
+	return nil
+}
+
+// encodeRange encodes Range as range(start, stop, step) call, or as
+// xrange(start, stop, step) at protocol ≤ 2, as Python does.
+func (e *Encoder) encodeRange(r *Range) error {
+	if r.Step == 0 {
+		return errRangeZeroStep
+	}
+	name := "range"
+	if e.config.Protocol <= 2 {
+		name = "xrange"
+	}
+	return e.encodeCall(&Call{
+		Callable: pybuiltin(e.config.Protocol, name),
+		Args:     Tuple{r.Start, r.Stop, r.Step},
+	})
+}
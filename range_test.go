@@ -0,0 +1,96 @@
+package ogórek
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRangeDecode(t *testing.T) {
+	for _, tt := range []struct {
+		data string
+		want any
+	}{
+		// range(1, 10, 2) and xrange(5), as pickled by Python
+		{"\x80\x02c__builtin__\nxrange\nq\x00K\x01K\nK\x02\x87q\x01Rq\x02.", Range{1, 10, 2}},
+		{"\x80\x04\x95 \x00\x00\x00\x00\x00\x00\x00\x8c\x08builtins\x94\x8c\x05range\x94\x93\x94K\x01K\nK\x02\x87\x94R\x94.", Range{1, 10, 2}},
+		{"c__builtin__\nxrange\np0\n(I0\nI5\nI1\ntp1\nRp2\n.", Range{0, 5, 1}},
+		// range(5), range(1, 5)
+		{"cbuiltins\nrange\n(I5\ntR.", Range{0, 5, 1}},
+		{"cbuiltins\nrange\n(I1\nI5\ntR.", Range{1, 5, 1}},
+	} {
+		obj, err := NewDecoder(strings.NewReader(tt.data)).Decode()
+		if err != nil {
+			t.Errorf("%q: %s", tt.data, err)
+			continue
+		}
+		if !reflect.DeepEqual(obj, tt.want) {
+			t.Errorf("%q:\nhave: %#v\nwant: %#v", tt.data, obj, tt.want)
+		}
+	}
+
+	for _, data := range []string{
+		"cbuiltins\nrange\n)R.",
+		"cbuiltins\nrange\n(I1\nI5\nI0\ntR.",
+		"cbuiltins\nrange\n(Va\ntR.",
+		"cbuiltins\nrange\n(I1\nI2\nI3\nI4\ntR.",
+	} {
+		_, err := NewDecoder(strings.NewReader(data)).Decode()
+		if err == nil {
+			t.Errorf("%q: no error", data)
+		}
+	}
+}
+
+func TestRangeEncode(t *testing.T) {
+	for _, tt := range []struct {
+		proto int
+		want  string
+	}{
+		// the same as CPython produces, modulo memoization
+		{0, "c__builtin__\nxrange\n(I1\nI10\nI2\ntR."},
+		{2, "\x80\x02c__builtin__\nxrange\nK\x01K\nK\x02\x87R."},
+		{4, "\x80\x04\x8c\x08builtins\x8c\x05range\x93K\x01K\nK\x02\x87R."},
+	} {
+		buf := &bytes.Buffer{}
+		err := NewEncoderWithConfig(buf, &EncoderConfig{Protocol: tt.proto}).Encode(Range{1, 10, 2})
+		if err != nil {
+			t.Errorf("protocol %d: %s", tt.proto, err)
+			continue
+		}
+		if buf.String() != tt.want {
+			t.Errorf("protocol %d:\nhave: %q\nwant: %q", tt.proto, buf.String(), tt.want)
+		}
+	}
+
+	err := VerifyRoundTrip(Range{-5, 5, -1}, nil, nil)
+	if err != nil {
+		t.Error(err)
+	}
+	err = NewEncoder(&bytes.Buffer{}).Encode(Range{})
+	if err == nil {
+		t.Errorf("zero step: no error")
+	}
+}
+
+func TestRangeLen(t *testing.T) {
+	for _, tt := range []struct {
+		r    Range
+		want int64
+	}{
+		// verified with Python len(range(...))
+		{Range{0, 5, 1}, 5},
+		{Range{1, 10, 2}, 5},
+		{Range{1, 10, 3}, 3},
+		{Range{10, 1, 1}, 0},
+		{Range{10, 1, -3}, 3},
+		{Range{-5, 5, -1}, 0},
+		{Range{math.MinInt64, math.MaxInt64, math.MaxInt64}, 3},
+	} {
+		if n := tt.r.Len(); n != tt.want {
+			t.Errorf("%#v.Len(): have %d  ; want %d", tt.r, n, tt.want)
+		}
+	}
+}
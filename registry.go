@@ -0,0 +1,313 @@
+package ogórek
+// Mapping of Python classes to Go types.
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// TypeRegistry maps Python classes to Go struct types.
+//
+// With registry set via [DecoderConfig.Types], the decoder creates instances
+// of registered classes as values of corresponding Go types instead of
+// [Object] and [Call]: arguments of the class call, or of cls.__new__, are
+// stored into the struct as tuple with positional `pickle:"0"` tags, and
+// keyword arguments and the object state, set via BUILD, are stored into
+// struct fields by name, the same way as [Decoder.DecodeInto] does. Types,
+// that implement [PickleUnmarshaler], are instead given the [Object] when
+// the instance is created, and again Object with only the State, if the
+// pickle sets state of the instance. The instances are decoded as values,
+// or as pointers with [DecoderConfig.PreserveRefs].
+//
+// This way instances of Python dataclasses, including frozen ones and ones
+// with slots, are decoded directly into Go structs, that mirror them. For
+// example instances of dataclass Point with fields x and y defined in module
+// geo are decoded into
+//
+//	type Point struct {
+//		X int `pickle:"x"`
+//		Y int `pickle:"y"`
+//	}
+//
+// with Point registered as r.Register(Class{"geo", "Point"}, reflect.TypeOf(Point{})).
+// Frozen dataclasses with slots pickle their state as list of field values,
+// which is stored into struct fields in the order they are declared.
+//
+// Namedtuple classes can be registered with their field names via
+// [TypeRegistry.RegisterNamedTuple] to be decoded into [NamedTuple], and
+// exception classes via [TypeRegistry.RegisterException] to be decoded into
+// [Exception].
+//
+// With registry set via [EncoderConfig.Types], the encoder emits values of,
+// and pointers to, registered Go types as instances of corresponding Python
+// classes created via cls.__new__, with struct fields as the object state.
+// Structs with positional pickle tags are emitted with fields as arguments
+// of cls.__new__ instead.
+//
+// The registry must not be modified while it is in use by decoders or
+// encoders.
+type TypeRegistry struct {
+	types       map[Class]reflect.Type
+	classes     map[reflect.Type]Class
+	namedTuples map[Class][]string
+	exceptions  map[Class]bool
+}
+
+// NewTypeRegistry returns new empty type registry.
+func NewTypeRegistry() *TypeRegistry {
+	return &TypeRegistry{
+		types:       make(map[Class]reflect.Type),
+		classes:     make(map[reflect.Type]Class),
+		namedTuples: make(map[Class][]string),
+		exceptions:  make(map[Class]bool),
+	}
+}
+
+// Register registers Go type typ to represent Python class.
+//
+// typ must be a struct type, e.g. reflect.TypeOf(MyStruct{}). Register panics
+// if typ is not a struct, or if class or typ is already registered.
+func (r *TypeRegistry) Register(class Class, typ reflect.Type) {
+	if typ.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("pickle: register: %s is not a struct type", typ))
+	}
+	r.checkClass(class)
+	if c, ok := r.classes[typ]; ok {
+		panic(fmt.Sprintf("pickle: register: %s is already registered as class %s.%s", typ, c.Module, c.Name))
+	}
+	r.types[class] = typ
+	r.classes[typ] = class
+}
+
+// checkClass panics if class is already registered.
+func (r *TypeRegistry) checkClass(class Class) {
+	if t, ok := r.types[class]; ok {
+		panic(fmt.Sprintf("pickle: register: class %s.%s is already registered as %s", class.Module, class.Name, t))
+	}
+	if _, ok := r.namedTuples[class]; ok {
+		panic(fmt.Sprintf("pickle: register: class %s.%s is already registered as namedtuple", class.Module, class.Name))
+	}
+	if r.exceptions[class] {
+		panic(fmt.Sprintf("pickle: register: class %s.%s is already registered as exception", class.Module, class.Name))
+	}
+}
+
+// typeOf returns Go type registered for class.
+func (r *TypeRegistry) typeOf(class Class) (reflect.Type, bool) {
+	if r == nil {
+		return nil, false
+	}
+	typ, ok := r.types[class]
+	return typ, ok
+}
+
+// classOf returns class registered for Go type typ.
+func (r *TypeRegistry) classOf(typ reflect.Type) (Class, bool) {
+	if r == nil {
+		return Class{}, false
+	}
+	class, ok := r.classes[typ]
+	return class, ok
+}
+
+// isInstance returns whether x is value of a registered type.
+func (r *TypeRegistry) isInstance(x any) bool {
+	if r == nil || x == nil {
+		return false
+	}
+	_, ok := r.classes[reflect.TypeOf(x)]
+	return ok
+}
+
+// newInstance serves creation of objects in the decoder.
+//
+// If class of obj is registered, instance of corresponding Go type, or
+// NamedTuple for namedtuple classes, is pushed onto the stack, and ok=true is
+// returned.
+func (d *Decoder) newInstance(obj Object) (ok bool, err error) {
+	if fields, isNT := d.config.Types.namedTupleFields(obj.Class); isNT {
+		t, err := newNamedTuple(obj, fields)
+		if err != nil {
+			return true, fmt.Errorf("%s.%s: %w", obj.Class.Module, obj.Class.Name, err)
+		}
+		d.push(t)
+		return true, nil
+	}
+
+	typ, ok := d.config.Types.typeOf(obj.Class)
+	if !ok {
+		return false, nil
+	}
+
+	pv := reflect.New(typ)
+	if u, isU := pv.Interface().(PickleUnmarshaler); isU {
+		err = u.UnmarshalPickle(obj)
+	} else {
+		err = storeArgs(pv.Elem(), obj.Args, obj.KwArgs)
+	}
+	if err != nil {
+		return true, fmt.Errorf("%s.%s: %w", obj.Class.Module, obj.Class.Name, err)
+	}
+
+	if d.config.PreserveRefs {
+		d.push(pv.Interface())
+	} else {
+		d.push(pv.Elem().Interface())
+	}
+	return true, nil
+}
+
+// buildInstance serves BUILD for instances of registered types.
+//
+// If x is such instance, state is stored into it, and ok=true is returned.
+// The stack entry of x is updated if x is not a pointer.
+func (d *Decoder) buildInstance(x, state any) (ok bool, err error) {
+	rv := reflect.ValueOf(x)
+	if !rv.IsValid() {
+		return false, nil
+	}
+	ptr := rv.Kind() == reflect.Ptr
+	typ := rv.Type()
+	if ptr {
+		typ = typ.Elem()
+	}
+	class, ok := d.config.Types.classOf(typ)
+	if !ok || (ptr && rv.IsNil()) {
+		return false, nil
+	}
+
+	pv := rv
+	if !ptr {
+		pv = reflect.New(typ)
+		pv.Elem().Set(rv)
+	}
+	if u, isU := pv.Interface().(PickleUnmarshaler); isU {
+		err = u.UnmarshalPickle(Object{Class: class, State: state})
+	} else {
+		err = storeState(pv.Elem(), state)
+	}
+	if err != nil {
+		return true, fmt.Errorf("pickle: build: %s.%s: %w", class.Module, class.Name, err)
+	}
+
+	if !ptr {
+		v := pv.Elem().Interface()
+		d.stack[len(d.stack)-1] = v
+		d.updateCells(len(d.stack)-1, v)
+	}
+	return true, nil
+}
+
+// storeArgs stores arguments of class call, or of cls.__new__, into struct dst.
+func storeArgs(dst reflect.Value, args Tuple, kwargs map[string]any) error {
+	if len(args) > 0 {
+		err := storeInto(dst, args)
+		if err != nil {
+			return err
+		}
+	}
+	if len(kwargs) > 0 {
+		items := make([]ValueItem, 0, len(kwargs))
+		for k, v := range kwargs {
+			items = append(items, ValueItem{ValueOf(k), ValueOf(v)})
+		}
+		return storeStructItems(dst, items)
+	}
+	return nil
+}
+
+// storeState stores object state, as set by BUILD, into struct dst.
+//
+// The state is either dict of object attributes, (dict, slots) tuple for
+// objects with __slots__, where either dict can be None, or list of field
+// values, as Python pickles dataclasses with both frozen=True and slots=True.
+func storeState(dst reflect.Value, state any) error {
+	switch s := state.(type) {
+	case Tuple:
+		if len(s) == 2 {
+			for _, d := range s {
+				err := storeStateDict(dst, d)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	case []any, *[]any:
+		return storeStateList(dst, s)
+	}
+	return storeStateDict(dst, state)
+}
+
+// storeStateDict serves storeState.
+func storeStateDict(dst reflect.Value, state any) error {
+	if _, ok := state.(None); ok {
+		return nil
+	}
+	items, err := ValueOf(state).Items()
+	if err != nil {
+		return &DecodeTypeError{Value: state, Type: dst.Type(), Reason: "invalid state"}
+	}
+	return storeStructItems(dst, items)
+}
+
+// storeStateList serves storeState for list state.
+//
+// The values are stored into fields with positional tags, if the struct has
+// them, or into dict fields in the order they are declared otherwise.
+func storeStateList(dst reflect.Value, state any) error {
+	tupleFields, err := getStructTupleFields(dst)
+	if err != nil {
+		return err
+	}
+	if tupleFields != nil {
+		return storeInto(dst, state)
+	}
+
+	items, _ := ValueOf(state).Elems()
+	fields := getStructDictFields(dst.Type())
+	if len(items) > len(fields) {
+		return &DecodeTypeError{Value: state, Type: dst.Type(), Reason: fmt.Sprintf("%d values for %d fields", len(items), len(fields))}
+	}
+	for i, item := range items {
+		f := fields[i].field
+		if !dst.Field(f).CanSet() {
+			continue
+		}
+		err = storeInto(dst.Field(f), item.Interface())
+		if err != nil {
+			return storeErrorAt(err, "." + dst.Type().Field(f).Name)
+		}
+	}
+	return nil
+}
+
+// encodeInstance encodes struct st of type registered for class as instance
+// of that class.
+func (e *Encoder) encodeInstance(class Class, st reflect.Value) error {
+	tupleFields, err := getStructTupleFields(st)
+	if err != nil {
+		return err
+	}
+	if tupleFields != nil {
+		args := make(Tuple, len(tupleFields))
+		for i, f := range tupleFields {
+			args[i] = st.Field(f)
+		}
+		return e.encodeObjectNewAt(&Object{Class: class, Args: args}, pathFields(st.Type(), tupleFields))
+	}
+
+	err = e.encodeObjectNew(&Object{Class: class, Args: Tuple{}})
+	if err != nil {
+		return err
+	}
+	entries := getStructDictEntries(st)
+	if len(entries) == 0 {
+		return nil
+	}
+	err = e.encodeStructDict(st, entries)
+	if err != nil {
+		return err
+	}
+	return e.emit(opBuild)
+}
@@ -0,0 +1,226 @@
+package ogórek
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type regPoint struct {
+	X int64 `pickle:"x"`
+	Y int64 `pickle:"y"`
+}
+
+type regPair struct {
+	A string `pickle:"0"`
+	B int64  `pickle:"1"`
+}
+
+// regColor reconstructs itself from 'Color(r, g, b)' calls and state.
+type regColor struct {
+	Args  Tuple
+	State any
+}
+
+func (c *regColor) UnmarshalPickle(obj any) error {
+	o := obj.(Object)
+	if o.State != nil {
+		c.State = o.State
+	} else {
+		c.Args = o.Args
+	}
+	return nil
+}
+
+func newTestRegistry() *TypeRegistry {
+	r := NewTypeRegistry()
+	r.Register(Class{"geo", "Point"}, reflect.TypeOf(regPoint{}))
+	r.Register(Class{"geo", "Pair"}, reflect.TypeOf(regPair{}))
+	r.Register(Class{"geo", "Color"}, reflect.TypeOf(regColor{}))
+	return r
+}
+
+func TestTypeRegistryDecode(t *testing.T) {
+	types := newTestRegistry()
+
+	// [p, p] with p = geo.Point(1, 2), as pickled by Python
+	pickles := []string{
+		"\x80\x02]q\x00(cgeo\nPoint\nq\x01)\x81q\x02}q\x03(X\x01\x00\x00\x00xq\x04K\x01X\x01\x00\x00\x00yq\x05K\x02ubh\x02e.",
+		"\x80\x04\x95+\x00\x00\x00\x00\x00\x00\x00]\x94(\x8c\x03geo\x94\x8c\x05Point\x94\x93\x94)\x81\x94}\x94(\x8c\x01x\x94K\x01\x8c\x01y\x94K\x02ubh\x04e.",
+	}
+	for _, data := range pickles {
+		obj, err := NewDecoderWithConfig(bytes.NewBufferString(data), &DecoderConfig{Types: types}).Decode()
+		if err != nil {
+			t.Fatalf("%q: %s", data, err)
+		}
+		want := []any{regPoint{1, 2}, regPoint{1, 2}}
+		if !reflect.DeepEqual(obj, want) {
+			t.Errorf("%q:\nhave: %#v\nwant: %#v", data, obj, want)
+		}
+
+		obj, err = NewDecoderWithConfig(bytes.NewBufferString(data), &DecoderConfig{Types: types, PreserveRefs: true}).Decode()
+		if err != nil {
+			t.Fatalf("%q: preserve refs: %s", data, err)
+		}
+		l, ok := obj.(*[]any)
+		if !(ok && len(*l) == 2 && (*l)[0] == (*l)[1] && *(*l)[0].(*regPoint) == regPoint{1, 2}) {
+			t.Errorf("%q: preserve refs: %#v", data, obj)
+		}
+	}
+
+	for _, tt := range []struct {
+		data string
+		want any
+	}{
+		// geo.Pair('a', 1)
+		{"cgeo\nPair\n(Va\nI1\ntR.", regPair{"a", 1}},
+		// geo.Color(1) with state {'a': 2}
+		{"cgeo\nColor\n(I1\ntR(dVa\nI2\nsb.", regColor{Args: Tuple{int64(1)}, State: map[any]any{"a": int64(2)}}},
+		// geo.Point.__new__(geo.Point, y=3) with (None, {'x': 4}) state
+		{"\x80\x04\x8c\x03geo\x8c\x05Point\x93)}\x8c\x01yK\x03s\x92N}\x8c\x01xK\x04s\x86b.", regPoint{4, 3}},
+		// not registered
+		{"cgeo\nLine\n(tR.", Call{Callable: Class{"geo", "Line"}, Args: Tuple{}}},
+	} {
+		obj, err := NewDecoderWithConfig(bytes.NewBufferString(tt.data), &DecoderConfig{Types: types}).Decode()
+		if err != nil {
+			t.Errorf("%q: %s", tt.data, err)
+			continue
+		}
+		if !reflect.DeepEqual(obj, tt.want) {
+			t.Errorf("%q:\nhave: %#v\nwant: %#v", tt.data, obj, tt.want)
+		}
+	}
+
+	for _, tt := range []struct {
+		data string
+		err  string
+	}{
+		{"cgeo\nPoint\n(I1\ntR.", "geo.Point: pickle: decode: cannot store ogórek.Tuple{1} into ogórek.regPoint"},
+		{"\x80\x02cgeo\nPoint\n)\x81(Vx\nVa\nVb\ntb.", `pickle: build: geo.Point: pickle: decode: cannot store ogórek.Tuple{"x", "a", "b"} into ogórek.regPoint: invalid state`},
+		{"\x80\x02cgeo\nPoint\n)\x81(dVx\nVa\nsb.", `pickle: build: geo.Point: pickle: decode: .X: cannot store "a" into int64`},
+	} {
+		_, err := NewDecoderWithConfig(bytes.NewBufferString(tt.data), &DecoderConfig{Types: types}).Decode()
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("%q:\nhave: %v\nwant: %s", tt.data, err, tt.err)
+		}
+	}
+}
+
+// regLabel mirrors Python dataclass with fields x and y.
+type regLabel struct {
+	X int64  `pickle:"x"`
+	Y string `pickle:"y"`
+}
+
+func TestTypeRegistryDataclass(t *testing.T) {
+	// app.X(1, 'a') for dataclasses defined as
+	//
+	//	@dataclass(frozen=..., slots=...)
+	//	class X:
+	//	    x: int
+	//	    y: str = 'q'
+	//
+	// as pickled by Python
+	for _, tt := range []struct {
+		class string
+		data  string
+	}{
+		// plain
+		{"A", "ccopy_reg\n_reconstructor\np0\n(capp\nA\np1\nc__builtin__\nobject\np2\nNtp3\nRp4\n(dp5\nVx\np6\nI1\nsVy\np7\nVa\np8\nsb."},
+		{"A", "\x80\x02capp\nA\nq\x00)\x81q\x01}q\x02(X\x01\x00\x00\x00xq\x03K\x01X\x01\x00\x00\x00yq\x04X\x01\x00\x00\x00aq\x05ub."},
+		{"A", "\x80\x04\x95#\x00\x00\x00\x00\x00\x00\x00\x8c\x03app\x94\x8c\x01A\x94\x93\x94)\x81\x94}\x94(\x8c\x01x\x94K\x01\x8c\x01y\x94\x8c\x01a\x94ub."},
+		// frozen
+		{"F", "\x80\x04\x95#\x00\x00\x00\x00\x00\x00\x00\x8c\x03app\x94\x8c\x01F\x94\x93\x94)\x81\x94}\x94(\x8c\x01x\x94K\x01\x8c\x01y\x94\x8c\x01a\x94ub."},
+		// slots
+		{"S", "\x80\x02capp\nS\nq\x00)\x81q\x01N}q\x02(X\x01\x00\x00\x00xq\x03K\x01X\x01\x00\x00\x00yq\x04X\x01\x00\x00\x00aq\x05u\x86q\x06b."},
+		// frozen, slots
+		{"FS", "\x80\x02capp\nFS\nq\x00)\x81q\x01]q\x02(K\x01X\x01\x00\x00\x00aq\x03eb."},
+		{"FS", "\x80\x04\x95\x1c\x00\x00\x00\x00\x00\x00\x00\x8c\x03app\x94\x8c\x02FS\x94\x93\x94)\x81\x94]\x94(K\x01\x8c\x01a\x94eb."},
+	} {
+		types := NewTypeRegistry()
+		types.Register(Class{"app", tt.class}, reflect.TypeOf(regLabel{}))
+		for _, preserveRefs := range []bool{false, true} {
+			obj, err := NewDecoderWithConfig(bytes.NewBufferString(tt.data), &DecoderConfig{Types: types, PreserveRefs: preserveRefs}).Decode()
+			if err != nil {
+				t.Errorf("%q: %s", tt.data, err)
+				continue
+			}
+			if p, ok := obj.(*regLabel); ok {
+				obj = *p
+			}
+			if obj != (regLabel{1, "a"}) {
+				t.Errorf("%q: preserveRefs=%v: have %#v", tt.data, preserveRefs, obj)
+			}
+		}
+	}
+
+	// frozen with slots into struct with positional tags and into class-marked struct
+	types := NewTypeRegistry()
+	types.Register(Class{"app", "FS"}, reflect.TypeOf(regPair{}))
+	obj, err := NewDecoderWithConfig(bytes.NewBufferString("\x80\x02capp\nFS\n)\x81(Va\nI1\nlb."), &DecoderConfig{Types: types}).Decode()
+	if !(err == nil && obj == regPair{"a", 1}) {
+		t.Errorf("positional struct: %#v, %v", obj, err)
+	}
+	var label struct {
+		_ struct{} `pickle:"app.FS"`
+		X int64    `pickle:"x"`
+		Y string   `pickle:"y"`
+	}
+	err = NewDecoder(bytes.NewBufferString("\x80\x02capp\nFS\n)\x81(I1\nVa\nlb.")).DecodeInto(&label)
+	if !(err == nil && label.X == 1 && label.Y == "a") {
+		t.Errorf("decode into class-marked struct: %#v, %v", label, err)
+	}
+	err = NewDecoder(bytes.NewBufferString("\x80\x02capp\nFS\n)\x81(I1\nVa\nI2\nlb.")).DecodeInto(&label)
+	if err == nil || !strings.HasSuffix(err.Error(), ": 3 values for 2 fields") {
+		t.Errorf("decode into class-marked struct: too many values: %v", err)
+	}
+}
+
+func TestTypeRegistryEncode(t *testing.T) {
+	types := newTestRegistry()
+	p := &regPoint{1, 2}
+	for _, tt := range []struct {
+		proto int
+		obj   any
+		want  string
+	}{
+		{2, []any{p, p}, "\x80\x02](cgeo\nPoint\n)\x81}(U\x01xq\x00K\x01U\x01yq\x01K\x02ubq\x02h\x02eq\x03."},
+		{1, regPair{"a", 1}, "ccopy_reg\n__newobj__\n(cgeo\nPair\nU\x01aq\x00K\x01tR."},
+	} {
+		buf := &bytes.Buffer{}
+		err := NewEncoderWithConfig(buf, &EncoderConfig{Protocol: tt.proto, Types: types, Memoize: true}).Encode(tt.obj)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != tt.want {
+			t.Errorf("%#v:\nhave: %q\nwant: %q", tt.obj, buf.String(), tt.want)
+		}
+	}
+
+	for _, obj := range []any{regPoint{1, 2}, regPair{"a", 1}} {
+		err := VerifyRoundTrip(obj, &EncoderConfig{Types: types}, &DecoderConfig{Types: types})
+		if err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestTypeRegistryRegister(t *testing.T) {
+	for _, f := range []func(r *TypeRegistry){
+		func(r *TypeRegistry) { r.Register(Class{"geo", "Point"}, reflect.TypeOf(0)) },
+		func(r *TypeRegistry) { r.Register(Class{"geo", "Point"}, reflect.TypeOf(regPair{})) },
+		func(r *TypeRegistry) { r.Register(Class{"geo", "Line"}, reflect.TypeOf(regPoint{})) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("register: no panic")
+				}
+			}()
+			r := NewTypeRegistry()
+			r.Register(Class{"geo", "Point"}, reflect.TypeOf(regPoint{}))
+			f(r)
+		}()
+	}
+}
@@ -0,0 +1,38 @@
+package ogórek
+// Recovery from corrupt pickles in streams of concatenated pickles.
+
+// Resync skips input up to the start of next plausible pickle after Decode
+// failed.
+//
+// It is intended for recovery in streams of concatenated pickles, where one
+// corrupt pickle would otherwise make the rest of the stream inaccessible:
+// after Decode returns an error, Resync scans the input forward, and the next
+// Decode resumes decoding from the found point. The returned range covers
+// skipped input starting from the beginning of the failed pickle.
+//
+// The start of a pickle is detected heuristically as PROTO opcode with valid
+// protocol, or as a valid opcode, other than STOP, right after STOP. If the input ends before
+// such point is found, Resync returns io.EOF, with the range covering the rest
+// of the input.
+func (d *Decoder) Resync() (PickleRange, error) {
+	d.stack = d.stack[:0]
+	d.frameEnd = -1
+
+	for {
+		b, err := d.r.Peek(2)
+		if len(b) == 0 {
+			return PickleRange{d.start, d.pos()}, err
+		}
+		if len(b) == 2 {
+			if b[0] == opProto && b[1] <= highestProtocol {
+				break
+			}
+			if b[0] == opStop && b[1] != opStop && opcodes[b[1]].Known() {
+				d.r.Discard(1)
+				break
+			}
+		}
+		d.r.Discard(1)
+	}
+	return PickleRange{d.start, d.pos()}, nil
+}
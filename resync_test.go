@@ -0,0 +1,77 @@
+package ogórek
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestResync verifies recovery from corrupt pickles in concatenated stream.
+func TestResync(t *testing.T) {
+	for _, tt := range []struct {
+		pickles []string // good pickles, or corrupt ones prefixed with "!"
+	}{
+		{[]string{"\x80\x02K\x01.", "!\x80\x02]q\x00(K\x01\xffK\x02e.", "\x80\x02K\x03."}},
+		{[]string{"I1\n.", "!(I2\nZ.", "I3\n."}},
+		{[]string{"!(I2\nZ.", "!\x80\x02K\x01\xfe.", "\x80\x04\x95\x03\x00\x00\x00\x00\x00\x00\x00K\x03."}},
+		{[]string{"I1\n.", "!(I2\nZ..", "I3\n."}},
+	} {
+		stream := ""
+		for _, p := range tt.pickles {
+			stream += strings.TrimPrefix(p, "!")
+		}
+
+		d := NewDecoder(strings.NewReader(stream))
+		pos := int64(0)
+		for i, p := range tt.pickles {
+			corrupt := strings.HasPrefix(p, "!")
+			p = strings.TrimPrefix(p, "!")
+			end := pos + int64(len(p))
+
+			obj, err := d.Decode()
+			if !corrupt {
+				if err != nil {
+					t.Fatalf("%q: pickle #%d: %s", stream, i, err)
+				}
+				if want := int64(i + 1); !reflect.DeepEqual(obj, want) {
+					t.Errorf("%q: pickle #%d: have %#v  ; want %#v", stream, i, obj, want)
+				}
+				pos = end
+				continue
+			}
+
+			if err == nil {
+				t.Fatalf("%q: pickle #%d: decoded ok: %#v", stream, i, obj)
+			}
+			skipped, err := d.Resync()
+			if err != nil {
+				t.Fatalf("%q: pickle #%d: resync: %s", stream, i, err)
+			}
+			if want := (PickleRange{pos, end}); skipped != want {
+				t.Errorf("%q: pickle #%d: skipped %v  ; want %v", stream, i, skipped, want)
+			}
+			pos = end
+		}
+
+		_, err := d.Decode()
+		if err != io.EOF {
+			t.Errorf("%q: at end: %v", stream, err)
+		}
+	}
+
+	// corrupt pickle at the end of stream
+	d := NewDecoder(strings.NewReader("I1\n.(I2\nZI3\n"))
+	_, err := d.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = d.Decode()
+	if err == nil {
+		t.Fatal("corrupt pickle decoded ok")
+	}
+	skipped, err := d.Resync()
+	if want := (PickleRange{4, 12}); !(err == io.EOF && skipped == want) {
+		t.Errorf("resync at end: have %v, %v  ; want %v, EOF", skipped, err, want)
+	}
+}
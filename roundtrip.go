@@ -0,0 +1,85 @@
+package ogórek
+// Verification that values survive encode·decode cycle.
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// VerifyRoundTrip verifies that v survives encode·decode cycle at every protocol.
+//
+// For every protocol from 0 to the highest supported one, v is encoded with
+// encConfig, that has Protocol set to that protocol, and the pickle is decoded
+// back with decConfig. The decoded object is then compared to v with Python
+// equality, e.g. int64(1) is considered equal to big.Int(1), the same way
+// [Dict] compares keys. nil configs are treated as default configurations.
+//
+// Protocols at which v cannot be represented, e.g. protocol 0 for persistent
+// references with non-string pid, or protocols below the one reported via
+// [ProtocolError], are skipped. All other encoding errors, decoding errors
+// and mismatches are reported in the returned error.
+//
+// VerifyRoundTrip is intended to be used in application tests to guard
+// custom type mappings, e.g. PersistentRef/PersistentLoad pairs, against
+// regressions.
+func VerifyRoundTrip(v any, encConfig *EncoderConfig, decConfig *DecoderConfig) error {
+	if encConfig == nil {
+		encConfig = &EncoderConfig{}
+	}
+	if decConfig == nil {
+		decConfig = &DecoderConfig{}
+	}
+
+	var errv []string
+	buf := &bytes.Buffer{}
+	for proto := 0; proto <= highestProtocol; proto++ {
+		config := *encConfig
+		config.Protocol = proto
+
+		buf.Reset()
+		err := NewEncoderWithConfig(buf, &config).Encode(v)
+		if err != nil {
+			if roundTripUnrepresentable(err, proto) {
+				continue
+			}
+			errv = append(errv, fmt.Sprintf("protocol %d: encode: %s", proto, err))
+			continue
+		}
+		data := buf.String()
+
+		obj, err := NewDecoderWithConfig(strings.NewReader(data), decConfig).Decode()
+		if err != nil {
+			errv = append(errv, fmt.Sprintf("protocol %d: decode: %s\npickle: %q", proto, err, data))
+			continue
+		}
+
+		if !equal(obj, v) {
+			errv = append(errv, fmt.Sprintf("protocol %d: decode·encode != identity:\nhave: %#v\nwant: %#v\npickle: %q",
+				proto, obj, v, data))
+		}
+	}
+
+	if len(errv) != 0 {
+		return fmt.Errorf("roundtrip: %s", strings.Join(errv, "\nroundtrip: "))
+	}
+	return nil
+}
+
+// roundTripUnrepresentable returns whether encoding error err means that the
+// value cannot be represented at protocol proto at all.
+func roundTripUnrepresentable(err error, proto int) bool {
+	var eproto *ProtocolError
+	switch {
+	case errors.As(err, &eproto):
+		return proto < eproto.Protocol
+	case proto == 0 && errors.Is(err, errP0PersIDStringLineOnly):
+		return true
+	case proto == 0 && errors.Is(err, errP0UnicodeUTF8Only):
+		return true
+	case proto <= 3 && errors.Is(err, errP0123GlobalStringLineOnly):
+		return true
+	}
+	return false
+}
@@ -0,0 +1,41 @@
+package ogórek
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+)
+
+func TestVerifyRoundTrip(t *testing.T) {
+	// values that round-trip
+	for _, v := range []any{
+		int64(1),
+		big.NewInt(1), // decodes as int64(1), which is equal in Python sense
+		[]any{"a", Tuple{int64(1), 2.5}, None{}},
+		map[any]any{"a": []any{true}},
+		NewDictWithData(Tuple{int64(1)}, "x"),
+		Ref{Tuple{int64(1)}}, // non-string pid is not representable at protocol 0
+		Class{"a\nb", "c"},   // \n in module is representable only at protocol ≥ 4
+	} {
+		err := VerifyRoundTrip(v, nil, &DecoderConfig{PyDict: true})
+		if err != nil {
+			t.Errorf("%#v: %s", v, err)
+		}
+	}
+
+	// custom mapping, that does not round-trip
+	type point struct{ X, Y int64 }
+	err := VerifyRoundTrip(point{1, 2}, nil, nil)
+	if err == nil {
+		t.Fatalf("struct: no error")
+	}
+	if n := strings.Count(err.Error(), "roundtrip: protocol"); n != highestProtocol+1 {
+		t.Errorf("struct: expect mismatch reported for every protocol; got %d:\n%s", n, err)
+	}
+
+	// encoding errors are reported
+	err = VerifyRoundTrip(make(chan int), nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "roundtrip: protocol 0: encode:") {
+		t.Errorf("chan: unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,201 @@
+package ogórek
+// Python-like Set that handles elements by Python-like equality.
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Set represents set from Python.
+//
+// Similarly to [Dict] it mirrors Python with respect to which types are
+// allowed to be used as set elements, and with respect to elements equality.
+// For example Tuple is allowed to be used as element, and all int(1),
+// float64(1.0) and big.Int(1) are considered to be the same element.
+//
+// Set preserves insertion order of its elements, and the Encoder emits them
+// in that order.
+//
+// Note: similarly to [Dict] Set is pointer-like type: its zero-value
+// represents nil set that is empty and invalid to use Add on.
+type Set struct {
+	d Dict // element -> nil
+}
+
+// NewSet returns new empty set.
+func NewSet() Set {
+	return NewSetWithSizeHint(0)
+}
+
+// NewSetWithSizeHint returns new empty set with preallocated space for size elements.
+func NewSetWithSizeHint(size int) Set {
+	return Set{NewDictWithSizeHint(size)}
+}
+
+// NewSetWithData returns new set with preset elements.
+func NewSetWithData(items ...any) Set {
+	s := NewSetWithSizeHint(len(items))
+	for _, x := range items {
+		s.Add(x)
+	}
+	return s
+}
+
+// Has returns whether the set contains element equal to x.
+//
+// Has panics if x's type is not allowed to be used as Set element.
+func (s Set) Has(x any) bool {
+	_, ok := s.d.Get_(x)
+	return ok
+}
+
+// Add adds x to the set.
+//
+// Similarly to Python, if the set already contains element equal to x, the
+// set is left unchanged.
+//
+// Add panics if x's type is not allowed to be used as Set element.
+func (s Set) Add(x any) {
+	if s.d.d == nil {
+		panic("Add called on nil set")
+	}
+	if !s.Has(x) {
+		s.d.Set(x, nil)
+	}
+}
+
+// Del removes elements equal to x from the set.
+//
+// Del panics if x's type is not allowed to be used as Set element.
+func (s Set) Del(x any) {
+	s.d.Del(x)
+}
+
+// Len returns the number of elements in the set.
+func (s Set) Len() int {
+	return s.d.Len()
+}
+
+// Iter returns iterator over all elements in the set.
+//
+// The elements are visited in insertion order.
+func (s Set) Iter() /* iter.Seq */ func(yield func(any) bool) {
+	iter := s.d.Iter()
+	return func(yield func(any) bool) {
+		iter(func(x, _ any) bool {
+			return yield(x)
+		})
+	}
+}
+
+// String returns human-readable representation of the set.
+//
+// All set data is printed. Use [Sprint] to print huge sets with limited
+// output size.
+func (s Set) String() string {
+	return s.sprintf("%v")
+}
+
+// GoString returns detailed human-readable representation of the set.
+func (s Set) GoString() string {
+	return fmt.Sprintf("%T%s", s, s.sprintf("%#v"))
+}
+
+// sprintf serves String and GoString.
+func (s Set) sprintf(format string) string {
+	vx := make([]string, 0, s.Len())
+	s.Iter()(func(x any) bool {
+		vx = append(vx, fmt.Sprintf(format, x))
+		return true
+	})
+	sort.Strings(vx)
+	return "{" + strings.Join(vx, ", ") + "}"
+}
+
+// FrozenSet represents frozenset from Python.
+//
+// It is immutable version of [Set] with the same element semantics. Contrary
+// to builtin map and [Dict] its zero value is valid empty frozenset.
+//
+// As in Python, frozenset is hashable and can be used as [Dict] key and as
+// element of [Set] and other FrozenSet. Frozensets with equal elements are
+// equal irregardless of elements order.
+type FrozenSet struct {
+	s Set
+}
+
+// NewFrozenSet returns new frozenset with given elements.
+//
+// NewFrozenSet panics if type of an element is not allowed to be used as
+// FrozenSet element.
+func NewFrozenSet(items ...any) FrozenSet {
+	return FrozenSet{NewSetWithData(items...)}
+}
+
+// Has returns whether the frozenset contains element equal to x.
+//
+// Has panics if x's type is not allowed to be used as FrozenSet element.
+func (s FrozenSet) Has(x any) bool {
+	return s.s.Has(x)
+}
+
+// Len returns the number of elements in the frozenset.
+func (s FrozenSet) Len() int {
+	return s.s.Len()
+}
+
+// Iter returns iterator over all elements in the frozenset.
+//
+// The elements are visited in the order they were given to NewFrozenSet.
+func (s FrozenSet) Iter() /* iter.Seq */ func(yield func(any) bool) {
+	return s.s.Iter()
+}
+
+// String returns human-readable representation of the frozenset.
+func (s FrozenSet) String() string {
+	return s.s.sprintf("%v")
+}
+
+// GoString returns detailed human-readable representation of the frozenset.
+func (s FrozenSet) GoString() string {
+	return fmt.Sprintf("%T%s", s, s.s.sprintf("%#v"))
+}
+
+// setTryAdd is like Set.Add but returns ok=false instead of panicking if x
+// is not allowed to be used as Set element.
+func setTryAdd(s Set, x any) (ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			ok = false
+		}
+	}()
+
+	s.Add(x)
+	return true
+}
+
+// eq_Set_Set implements equal for sets and frozensets.
+//
+// Similarly to eq_Dict_Dict sets S₁ and S₂ are considered equal if they have
+// the same length and every element of each set is present in the other one.
+// As in Python, set and frozenset with the same elements are equal.
+func eq_Set_Set(a, b Set) bool {
+	if a.Len() != b.Len() {
+		return false
+	}
+
+	eq := true
+	a.Iter()(func(x any) bool {
+		eq = b.Has(x)
+		return eq
+	})
+	if !eq {
+		return false
+	}
+	b.Iter()(func(x any) bool {
+		eq = a.Has(x)
+		return eq
+	})
+	return eq
+}
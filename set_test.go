@@ -0,0 +1,349 @@
+package ogórek
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+func TestSet(t *testing.T) {
+	s := NewSetWithData(int64(1), "a", Tuple{int64(2)})
+
+	// Python equality for membership
+	for _, x := range []any{int64(1), 1.0, true, big.NewInt(1), "a", ByteString("a"), Tuple{2.0}} {
+		if !s.Has(x) {
+			t.Errorf("%v: Has(%#v) = false", s, x)
+		}
+	}
+	for _, x := range []any{int64(2), Bytes("a"), Tuple{}} {
+		if s.Has(x) {
+			t.Errorf("%v: Has(%#v) = true", s, x)
+		}
+	}
+
+	// adding equal element keeps the original one
+	s.Add(1.0)
+	if s.Len() != 3 {
+		t.Errorf("Add(1.0): len = %d", s.Len())
+	}
+	var items []any
+	s.Iter()(func(x any) bool {
+		items = append(items, x)
+		return true
+	})
+	if !deepEqual(items, []any{int64(1), "a", Tuple{int64(2)}}) {
+		t.Errorf("Iter: %#v", items)
+	}
+
+	s.Del(true)
+	if s.Len() != 2 || s.Has(int64(1)) {
+		t.Errorf("Del(true): %v", s)
+	}
+
+	// unhashable elements
+	func() {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Errorf("Add([]any): no panic")
+			}
+		}()
+		s.Add([]any{})
+	}()
+
+	// equality and hash
+	if !equal(NewSetWithData(int64(1), "b"), NewSetWithData("b", 1.0)) {
+		t.Errorf("equal sets are not equal")
+	}
+	if equal(NewSetWithData(int64(1)), NewSetWithData(int64(2))) || equal(NewSetWithData(), NewDict()) {
+		t.Errorf("different sets are equal")
+	}
+	if dictTryAssign(NewDict(), NewSet(), 1) {
+		t.Errorf("set is hashable")
+	}
+
+	if str := NewSetWithData("b", int64(1)).String(); str != "{1, b}" {
+		t.Errorf("String: %q", str)
+	}
+	if str := Sprint("%v", NewSetWithData(int64(3), int64(1), int64(2)), &FormatLimits{MaxItems: 2}); str != "{1, 2, …(+1 items)}" {
+		t.Errorf("Sprint: %q", str)
+	}
+}
+
+func TestDecodeSet(t *testing.T) {
+	for _, tt := range []struct {
+		pickle string
+		config DecoderConfig
+		want   any
+	}{
+		// pickle.dumps({1, 'a', (2,3)}, 4)
+		{"\x80\x04\x95\x11\x00\x00\x00\x00\x00\x00\x00\x8f\x94(K\x02K\x03\x86\x94K\x01\x8c\x01a\x94\x90.", DecoderConfig{},
+			NewSetWithData(Tuple{int64(2), int64(3)}, int64(1), "a")},
+
+		// pickle.dumps([set(), {1.0, True}], 4)
+		{"\x80\x04\x95\x14\x00\x00\x00\x00\x00\x00\x00]\x94(\x8f\x94\x8f\x94(G?\xf0\x00\x00\x00\x00\x00\x00\x90e.", DecoderConfig{},
+			[]any{NewSet(), NewSetWithData(1.0)}},
+
+		// pickle.dumps({b'x'}, 4)
+		{"\x80\x04\x95\t\x00\x00\x00\x00\x00\x00\x00\x8f\x94(C\x01x\x94\x90.", DecoderConfig{BytesAsSlice: true},
+			NewSetWithData(Bytes("x"))},
+	} {
+		obj, err := NewDecoderWithConfig(strings.NewReader(tt.pickle), &tt.config).Decode()
+		if err != nil {
+			t.Errorf("%q: %s", tt.pickle, err)
+			continue
+		}
+		if !equal(obj, tt.want) {
+			t.Errorf("%q:\nhave: %#v\nwant: %#v", tt.pickle, obj, tt.want)
+		}
+	}
+
+	for _, pickle := range []string{
+		"\x80\x04\x8f(]\x90.",   // unhashable element
+		"\x80\x04](K\x01\x90.",   // ADDITEMS to non-set
+		"\x80\x04\x8fK\x01\x90.", // no mark
+	} {
+		_, err := NewDecoder(strings.NewReader(pickle)).Decode()
+		if err == nil {
+			t.Errorf("%q: no error", pickle)
+		}
+	}
+}
+
+func TestFrozenSet(t *testing.T) {
+	var zero FrozenSet
+	if zero.Len() != 0 || zero.Has(int64(1)) {
+		t.Errorf("zero frozenset is not empty")
+	}
+
+	s := NewFrozenSet(int64(1), "a", 1.0)
+	if s.Len() != 2 || !s.Has(true) || s.Has("b") {
+		t.Errorf("%v: unexpected content", s)
+	}
+
+	// frozenset compares equal to set with the same elements
+	for _, x := range []any{NewFrozenSet("a", int64(1)), NewSetWithData(big.NewInt(1), "a")} {
+		if !(equal(s, x) && equal(x, s)) {
+			t.Errorf("%#v != %#v", s, x)
+		}
+	}
+	if equal(s, NewFrozenSet("a")) || equal(s, Tuple{int64(1), "a"}) {
+		t.Errorf("%v: equal to different object", s)
+	}
+
+	if str := NewFrozenSet("b", int64(1)).GoString(); str != `ogórek.FrozenSet{"b", 1}` {
+		t.Errorf("GoString: %q", str)
+	}
+}
+
+func TestDecodeFrozenSet(t *testing.T) {
+	for _, tt := range []struct {
+		pickle string
+		want   any
+	}{
+		// pickle.dumps(frozenset({1, 'a'}), 4)
+		{"\x80\x04\x95\n\x00\x00\x00\x00\x00\x00\x00(K\x01\x8c\x01a\x94\x91\x94.", NewFrozenSet(int64(1), "a")},
+		{"\x80\x04]((\x91(K\x01K\x01\x91e.", []any{FrozenSet{}, NewFrozenSet(int64(1))}},
+	} {
+		obj, err := NewDecoder(strings.NewReader(tt.pickle)).Decode()
+		if err != nil {
+			t.Errorf("%q: %s", tt.pickle, err)
+			continue
+		}
+		if !equal(obj, tt.want) {
+			t.Errorf("%q:\nhave: %#v\nwant: %#v", tt.pickle, obj, tt.want)
+		}
+	}
+
+	for _, pickle := range []string{
+		"\x80\x04(]\x91.", // unhashable element
+		"\x80\x04K\x01\x91.", // no mark
+	} {
+		_, err := NewDecoder(strings.NewReader(pickle)).Decode()
+		if err == nil {
+			t.Errorf("%q: no error", pickle)
+		}
+	}
+}
+
+func TestDecodePySets(t *testing.T) {
+	for _, tt := range []struct {
+		pickle string
+		want   any
+	}{
+		// sets.Set([1, 2]) and sets.ImmutableSet(['a']), as pickled by Python 2 at protocols 0 and 2
+		{"ccopy_reg\n_reconstructor\np0\n(csets\nSet\np1\nc__builtin__\nobject\np2\nNtp3\nRp4\n((dp5\nI1\nI01\nsI2\nI01\nstp6\nb.", NewSetWithData(int64(1), int64(2))},
+		{"ccopy_reg\n_reconstructor\np0\n(csets\nImmutableSet\np1\nc__builtin__\nobject\np2\nNtp3\nRp4\n((dp5\nS'a'\np6\nI01\nsNtp7\nb.", NewFrozenSet("a")},
+		{"\x80\x02csets\nSet\nq\x00)\x81q\x01}q\x02(K\x01\x88K\x02\x88u\x85q\x03b.", NewSetWithData(int64(1), int64(2))},
+		{"\x80\x02csets\nImmutableSet\nq\x00)\x81q\x01}q\x02U\x01aq\x03\x88sN\x86q\x04b.", NewFrozenSet("a")},
+
+		// [sets.Set([3]), sets.Set([3])]
+		{"(lp0\nccopy_reg\n_reconstructor\np1\n(csets\nSet\np2\nc__builtin__\nobject\np3\nNtp4\nRp5\n((dp6\nI3\nI01\nstp7\nbag1\n(g2\ng3\nNtp8\nRp9\n((dp10\nI3\nI01\nstp11\nba.", []any{NewSetWithData(int64(3)), NewSetWithData(int64(3))}},
+		{"\x80\x02]q\x00(csets\nSet\nq\x01)\x81q\x02}q\x03K\x03\x88s\x85q\x04bh\x01)\x81q\x05}q\x06K\x03\x88s\x85q\x07be.", []any{NewSetWithData(int64(3)), NewSetWithData(int64(3))}},
+	} {
+		obj, err := NewDecoder(strings.NewReader(tt.pickle)).Decode()
+		if err != nil {
+			t.Errorf("%q: %s", tt.pickle, err)
+			continue
+		}
+		// frozenset compares equal to set, so check the type too
+		if !(equal(obj, tt.want) && fmt.Sprintf("%T", obj) == fmt.Sprintf("%T", tt.want)) {
+			t.Errorf("%q:\nhave: %#v\nwant: %#v", tt.pickle, obj, tt.want)
+		}
+	}
+
+	for _, pickle := range []string{
+		"\x80\x02csets\nSet\n)\x81K\x01b.",       // invalid state
+		"\x80\x02csets\nSet\n)\x81K\x01\x85b.",   // invalid data
+	} {
+		_, err := NewDecoder(strings.NewReader(pickle)).Decode()
+		if err == nil {
+			t.Errorf("%q: no error", pickle)
+		}
+	}
+
+	// with RawCalls the objects are left as is
+	pickle := "\x80\x02csets\nSet\n)\x81}K\x01\x88s\x85b."
+	obj, err := NewDecoderWithConfig(strings.NewReader(pickle), &DecoderConfig{RawCalls: true}).Decode()
+	if _, ok := obj.(Object); !(err == nil && ok) {
+		t.Errorf("RawCalls: have %#v, %v", obj, err)
+	}
+}
+
+func TestEncodeSet(t *testing.T) {
+	s := NewSetWithData(int64(1), int64(2))
+	for _, tt := range []struct {
+		proto  int
+		pickle string
+	}{
+		{0, "c__builtin__\nset\n((lI1\naI2\natR."},
+		{2, "\x80\x02c__builtin__\nset\n](K\x01K\x02e\x85R."},
+		{3, "\x80\x03cbuiltins\nset\n](K\x01K\x02e\x85R."},
+		{4, "\x80\x04\x8f(K\x01K\x02\x90."},
+	} {
+		buf := &bytes.Buffer{}
+		err := NewEncoderWithConfig(buf, &EncoderConfig{Protocol: tt.proto}).Encode(s)
+		if err != nil {
+			t.Errorf("protocol %d: %s", tt.proto, err)
+			continue
+		}
+		if got := buf.String(); got != tt.pickle {
+			t.Errorf("protocol %d:\nhave: %q\nwant: %q", tt.proto, got, tt.pickle)
+		}
+	}
+
+	// empty set, and elements added in batches
+	big := NewSet()
+	for i := 0; i < 2*setBatchSize+1; i++ {
+		big.Add(int64(i))
+	}
+	for _, s := range []Set{NewSet(), big} {
+		err := VerifyRoundTrip(s, nil, nil)
+		if err != nil {
+			t.Errorf("set of %d elements: %s", s.Len(), err)
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	err := NewEncoderWithConfig(buf, &EncoderConfig{Protocol: 4}).Encode(big)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	_, err = NewDecoderWithConfig(buf, &DecoderConfig{
+		Trace: func(op byte, pos int, stackDepth int) {
+			if op == opAddItems {
+				n++
+			}
+		},
+	}).Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Errorf("set of %d elements: expect 3 ADDITEMS; got %d", big.Len(), n)
+	}
+}
+
+func TestEncodeFrozenSet(t *testing.T) {
+	s := NewFrozenSet(int64(1), int64(2))
+	for _, tt := range []struct {
+		proto  int
+		pickle string
+	}{
+		{0, "c__builtin__\nfrozenset\n((lI1\naI2\natR."},
+		{2, "\x80\x02c__builtin__\nfrozenset\n](K\x01K\x02e\x85R."},
+		{3, "\x80\x03cbuiltins\nfrozenset\n](K\x01K\x02e\x85R."},
+		{4, "\x80\x04(K\x01K\x02\x91."},
+	} {
+		buf := &bytes.Buffer{}
+		err := NewEncoderWithConfig(buf, &EncoderConfig{Protocol: tt.proto}).Encode(s)
+		if err != nil {
+			t.Errorf("protocol %d: %s", tt.proto, err)
+			continue
+		}
+		if got := buf.String(); got != tt.pickle {
+			t.Errorf("protocol %d:\nhave: %q\nwant: %q", tt.proto, got, tt.pickle)
+		}
+	}
+
+	for _, v := range []any{s, FrozenSet{}, []any{NewFrozenSet("a"), NewSetWithData("a")}} {
+		err := VerifyRoundTrip(v, nil, nil)
+		if err != nil {
+			t.Errorf("%v: %s", v, err)
+		}
+
+		// frozenset must not decode back as set, that is equal to it
+		buf := &bytes.Buffer{}
+		err = NewEncoder(buf).Encode(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		obj, err := NewDecoder(buf).Decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !(equal(obj, v) && fmt.Sprintf("%#v", obj) == fmt.Sprintf("%#v", v)) {
+			t.Errorf("%#v: decoded as %#v", v, obj)
+		}
+	}
+}
+
+func TestFrozenSetDictKey(t *testing.T) {
+	for _, tt := range []struct {
+		pickle string
+		want   any
+	}{
+		// pickle.dumps({frozenset([1,2]): 'a', frozenset(): 'b'}, 4)
+		{"\x80\x04\x95\x17\x00\x00\x00\x00\x00\x00\x00}\x94((K\x01K\x02\x91\x94\x8c\x01a\x94(\x91\x94\x8c\x01b\x94u.",
+			NewDictWithData(NewFrozenSet(int64(1), int64(2)), "a", FrozenSet{}, "b")},
+
+		// pickle.dumps({frozenset([1,2]): 'a'}, 2)
+		{"\x80\x02}q\x00c__builtin__\nfrozenset\nq\x01]q\x02(K\x01K\x02e\x85q\x03Rq\x04X\x01\x00\x00\x00aq\x05s.",
+			NewDictWithData(NewFrozenSet(int64(1), int64(2)), "a")},
+
+		// pickle.dumps([frozenset(), {frozenset([2])}], 4)
+		{"\x80\x04\x95\x11\x00\x00\x00\x00\x00\x00\x00]\x94((\x91\x94\x8f\x94((K\x02\x91\x94\x90e.",
+			[]any{FrozenSet{}, NewSetWithData(NewFrozenSet(int64(2)))}},
+	} {
+		obj, err := NewDecoderWithConfig(strings.NewReader(tt.pickle), &DecoderConfig{PyDict: true}).Decode()
+		if err != nil {
+			t.Errorf("%q: %s", tt.pickle, err)
+			continue
+		}
+		if !equal(obj, tt.want) {
+			t.Errorf("%q:\nhave: %#v\nwant: %#v", tt.pickle, obj, tt.want)
+		}
+	}
+
+	// lookup by frozenset with equal elements in different order
+	d := NewDictWithData(NewFrozenSet(int64(1), "a"), "x")
+	if v := d.Get(NewFrozenSet("a", 1.0)); v != "x" {
+		t.Errorf("Get(frozenset): %#v", v)
+	}
+	// set is not hashable, even if it is equal to frozenset key
+	if dictTryAssign(d, NewSetWithData(int64(1), "a"), "y") {
+		t.Errorf("set used as Dict key")
+	}
+}
@@ -0,0 +1,121 @@
+package ogórek
+// Support for Python slice objects.
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Slice represents Python slice object, e.g. as created by x[start:stop:step].
+//
+// Start, Stop and Step are typically int64, or nil if omitted, which
+// corresponds to None on Python side.
+type Slice struct {
+	Start, Stop, Step any
+}
+
+// Indices returns start, stop and step of slice s applied to sequence of
+// given length, as Python slice.indices does.
+//
+// Indices returns error if slice values are not integers, or if the step
+// is zero.
+func (s Slice) Indices(length int) (start, stop, step int, err error) {
+	step, err = sliceIndex(s.Step, 1)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("slice: step: %s", err)
+	}
+	if step == 0 {
+		return 0, 0, 0, errors.New("slice: step cannot be zero")
+	}
+
+	lower, upper := 0, length
+	if step < 0 {
+		lower, upper = -1, length-1
+	}
+	clamp := func(i int) int {
+		if i < 0 {
+			i += length
+			if i < lower {
+				i = lower
+			}
+		} else if i > upper {
+			i = upper
+		}
+		return i
+	}
+
+	def := lower
+	if step < 0 {
+		def = upper
+	}
+	start, err = sliceIndex(s.Start, def)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("slice: start: %s", err)
+	}
+	if s.Start != nil {
+		start = clamp(start)
+	}
+
+	def = upper
+	if step < 0 {
+		def = lower
+	}
+	stop, err = sliceIndex(s.Stop, def)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("slice: stop: %s", err)
+	}
+	if s.Stop != nil {
+		stop = clamp(stop)
+	}
+
+	return start, stop, step, nil
+}
+
+// sliceIndex returns value of slice index x, or def if x is omitted.
+func sliceIndex(x any, def int) (int, error) {
+	switch x := x.(type) {
+	case nil:
+		return def, nil
+	case int64:
+		if int64(int(x)) != x {
+			return 0, fmt.Errorf("%d overflows int", x)
+		}
+		return int(x), nil
+	case int:
+		return x, nil
+	}
+	return 0, fmt.Errorf("expect int; got %T", x)
+}
+
+// handleSliceCall serves handleCall for slice(stop) and slice(start, stop[, step]).
+func (d *Decoder) handleSliceCall(argv Tuple) error {
+	v := make([]any, 3)
+	switch len(argv) {
+	case 1:
+		v[1] = argv[0]
+	case 2, 3:
+		copy(v, argv)
+	default:
+		return fmt.Errorf("slice: unexpected number of args %d", len(argv))
+	}
+	for i := range v {
+		if _, none := v[i].(None); none {
+			v[i] = nil
+		}
+	}
+	d.push(Slice{Start: v[0], Stop: v[1], Step: v[2]})
+	return nil
+}
+
+// encodeSlice encodes Slice as slice(start, stop, step) call, as Python does.
+func (e *Encoder) encodeSlice(s *Slice) error {
+	args := Tuple{s.Start, s.Stop, s.Step}
+	for i := range args {
+		if args[i] == nil {
+			args[i] = None{}
+		}
+	}
+	return e.encodeCallAt(&Call{Callable: pybuiltin(e.config.Protocol, "slice"), Args: args}, func(i int) string {
+		return [...]string{".Start", ".Stop", ".Step"}[i]
+	})
+}
@@ -0,0 +1,106 @@
+package ogórek
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSliceDecode(t *testing.T) {
+	for _, tt := range []struct {
+		data string
+		want any
+	}{
+		// slice(1, None, 2), as pickled by Python
+		{"c__builtin__\nslice\np0\n(I1\nNI2\ntp1\nRp2\n.", Slice{int64(1), nil, int64(2)}},
+		{"\x80\x02c__builtin__\nslice\nq\x00K\x01NK\x02\x87q\x01Rq\x02.", Slice{int64(1), nil, int64(2)}},
+		{"\x80\x04\x95\x1f\x00\x00\x00\x00\x00\x00\x00\x8c\x08builtins\x94\x8c\x05slice\x94\x93\x94K\x01NK\x02\x87\x94R\x94.", Slice{int64(1), nil, int64(2)}},
+		// slice(5), slice(1, 5)
+		{"cbuiltins\nslice\n(I5\ntR.", Slice{nil, int64(5), nil}},
+		{"cbuiltins\nslice\n(I1\nI5\ntR.", Slice{int64(1), int64(5), nil}},
+	} {
+		obj, err := NewDecoder(strings.NewReader(tt.data)).Decode()
+		if err != nil {
+			t.Errorf("%q: %s", tt.data, err)
+			continue
+		}
+		if !reflect.DeepEqual(obj, tt.want) {
+			t.Errorf("%q:\nhave: %#v\nwant: %#v", tt.data, obj, tt.want)
+		}
+	}
+
+	_, err := NewDecoder(strings.NewReader("cbuiltins\nslice\n)R.")).Decode()
+	if err == nil {
+		t.Errorf("slice(): no error")
+	}
+}
+
+func TestSliceEncode(t *testing.T) {
+	for _, tt := range []struct {
+		proto int
+		want  string
+	}{
+		// the same as CPython produces, modulo memoization
+		{0, "c__builtin__\nslice\n(I1\nNI2\ntR."},
+		{2, "\x80\x02c__builtin__\nslice\nK\x01NK\x02\x87R."},
+		{3, "\x80\x03cbuiltins\nslice\nK\x01NK\x02\x87R."},
+	} {
+		buf := &bytes.Buffer{}
+		err := NewEncoderWithConfig(buf, &EncoderConfig{Protocol: tt.proto}).Encode(Slice{int64(1), nil, int64(2)})
+		if err != nil {
+			t.Errorf("protocol %d: %s", tt.proto, err)
+			continue
+		}
+		if buf.String() != tt.want {
+			t.Errorf("protocol %d:\nhave: %q\nwant: %q", tt.proto, buf.String(), tt.want)
+		}
+	}
+
+	for _, in := range []any{Slice{}, Slice{int64(-1), int64(10), int64(-2)}} {
+		err := VerifyRoundTrip(in, nil, nil)
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	err := NewEncoder(&bytes.Buffer{}).Encode(Slice{Stop: make(chan int)})
+	if err == nil || !strings.Contains(err.Error(), ".Stop") {
+		t.Errorf("encode error: %v", err)
+	}
+}
+
+func TestSliceIndices(t *testing.T) {
+	i := func(x int64) any { return x }
+	for _, tt := range []struct {
+		s                 Slice
+		length            int
+		start, stop, step int
+	}{
+		// verified with Python slice(...).indices(length)
+		{Slice{}, 10, 0, 10, 1},
+		{Slice{nil, nil, i(-1)}, 10, 9, -1, -1},
+		{Slice{i(2), i(-2), nil}, 10, 2, 8, 1},
+		{Slice{i(-20), i(20), i(3)}, 10, 0, 10, 3},
+		{Slice{i(20), i(-20), i(-3)}, 10, 9, -1, -3},
+		{Slice{i(5), nil, i(-1)}, 3, 2, -1, -1},
+		{Slice{nil, i(1), nil}, 0, 0, 0, 1},
+	} {
+		start, stop, step, err := tt.s.Indices(tt.length)
+		if err != nil {
+			t.Errorf("%#v.Indices(%d): %s", tt.s, tt.length, err)
+			continue
+		}
+		if !(start == tt.start && stop == tt.stop && step == tt.step) {
+			t.Errorf("%#v.Indices(%d): have (%d, %d, %d)  ; want (%d, %d, %d)",
+				tt.s, tt.length, start, stop, step, tt.start, tt.stop, tt.step)
+		}
+	}
+
+	for _, s := range []Slice{{Step: i(0)}, {Start: "a"}, {Stop: 1.5}} {
+		_, _, _, err := s.Indices(10)
+		if err == nil {
+			t.Errorf("%#v.Indices: no error", s)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package ogórek
+// Splitting of pickle streams into individual pickles without decoding them.
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// PickleRange is byte range [Start, End) of one pickle in a stream.
+type PickleRange struct {
+	Start, End int64
+}
+
+// SplitPickles returns byte ranges of all pickles in stream of concatenated pickles.
+//
+// The stream is scanned opcode by opcode without building any objects and
+// without loading whole pickles into memory. This is cheap and can be used
+// e.g. for sharding, archiving or parallel decoding of multi-pickle files.
+//
+// It is an error if the stream ends in the middle of a pickle.
+func SplitPickles(r io.Reader) ([]PickleRange, error) {
+	var ranges []PickleRange
+	rc := &countReader{r: r}
+	br := bufio.NewReader(rc)
+	pos := func() int64 {
+		return rc.n - int64(br.Buffered())
+	}
+
+	start := int64(0)
+	insn := 0
+	for {
+		op, err := br.ReadByte()
+		if err != nil {
+			if err == io.EOF && insn != 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			if err == io.EOF {
+				err = nil
+			}
+			return ranges, err
+		}
+		insn++
+
+		err = skipOpArg(br, op)
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			if oe, ok := err.(OpcodeError); ok {
+				oe.Pos = insn
+				err = oe
+			}
+			return ranges, err
+		}
+
+		if op == opStop {
+			end := pos()
+			ranges = append(ranges, PickleRange{start, end})
+			start = end
+			insn = 0
+		}
+	}
+}
+
+// skipOpArg skips argument of opcode op in br.
+func skipOpArg(br *bufio.Reader, op byte) error {
+	arg := &opcodes[op]
+	switch arg.Arg {
+	case ArgNone:
+		return nil
+
+	case ArgLine, ArgLine2:
+		nline := 1
+		if arg.Arg == ArgLine2 {
+			nline = 2
+		}
+		for ; nline > 0; nline-- {
+			for {
+				_, err := br.ReadSlice('\n')
+				if err == nil {
+					break
+				}
+				if err != bufio.ErrBufferFull {
+					return err
+				}
+			}
+		}
+		return nil
+
+	case ArgFixed:
+		_, err := br.Discard(arg.ArgSize)
+		return err
+
+	case ArgCounted:
+		var b [8]byte
+		_, err := io.ReadFull(br, b[:arg.ArgSize])
+		if err != nil {
+			return err
+		}
+		l := binary.LittleEndian.Uint64(b[:])
+		if arg.SignedLen() && int32(l) < 0 {
+			return fmt.Errorf("pickle: split: negative length %d", int32(l))
+		}
+		for l > 0 {
+			n := l
+			if n > 1<<30 {
+				n = 1<<30
+			}
+			_, err = br.Discard(int(n))
+			if err != nil {
+				return err
+			}
+			l -= n
+		}
+		return nil
+	}
+
+	return OpcodeError{Key: op}
+}
+
+// ScanPickles is a split function for [bufio.Scanner] that returns each
+// complete pickle from stream of concatenated pickles as token.
+//
+// Note that bufio.Scanner limits maximum token size, and so the buffer of
+// the scanner has to be set big enough to hold the largest pickle. Use
+// [SplitPickles] to scan streams with pickles of arbitrary size.
+func ScanPickles(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	scan := 0
+	insn := 0
+	for {
+		n, complete, err := opLen(data[scan:])
+		if err != nil {
+			if oe, ok := err.(OpcodeError); ok {
+				oe.Pos = insn+1
+				err = oe
+			}
+			return 0, nil, err
+		}
+		if !complete {
+			break
+		}
+		op := data[scan]
+		scan += n
+		insn++
+		if op == opStop {
+			return scan, data[:scan], nil
+		}
+	}
+
+	if atEOF && len(data) > 0 {
+		return 0, nil, io.ErrUnexpectedEOF
+	}
+	// request more data
+	return 0, nil, nil
+}
@@ -0,0 +1,96 @@
+package ogórek
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestSplitPickles verifies that SplitPickles and ScanPickles find boundaries
+// of pickles in a stream.
+func TestSplitPickles(t *testing.T) {
+	var pickles []string
+	for _, test := range tests {
+		for _, pickle := range test.picklev {
+			if pickle.err == nil {
+				pickles = append(pickles, pickle.data)
+			}
+		}
+	}
+	// big bytes, that does not fit into bufio buffer, and protocol 0 lines
+	big := strings.Repeat("x", 3*4096)
+	pickles = append(pickles, "\x80\x03B\x00\x30\x00\x00" + big + ".", "cfoo\nbar\n(I1\nS'a'\ntR.")
+
+	stream := strings.Join(pickles, "")
+	var want []PickleRange
+	start := int64(0)
+	for _, p := range pickles {
+		want = append(want, PickleRange{start, start + int64(len(p))})
+		start += int64(len(p))
+	}
+
+	ranges, err := SplitPickles(strings.NewReader(stream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ranges, want) {
+		t.Fatalf("split: ranges mismatch:\nhave: %v\nwant: %v", ranges, want)
+	}
+
+	s := bufio.NewScanner(strings.NewReader(stream))
+	s.Buffer(nil, 1<<20)
+	s.Split(ScanPickles)
+	i := 0
+	for s.Scan() {
+		if i >= len(pickles) {
+			t.Fatalf("scan: extra token %q", s.Bytes())
+		}
+		if got := s.Text(); got != pickles[i] {
+			t.Errorf("scan: token %d:\nhave: %q\nwant: %q", i, got, pickles[i])
+		}
+		i++
+	}
+	if err := s.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if i != len(pickles) {
+		t.Errorf("scan: got %d tokens  ; want %d", i, len(pickles))
+	}
+
+	// empty stream
+	ranges, err = SplitPickles(&bytes.Buffer{})
+	if !(len(ranges) == 0 && err == nil) {
+		t.Errorf("empty: have %v, %v", ranges, err)
+	}
+}
+
+func TestSplitPicklesErrors(t *testing.T) {
+	for _, tt := range []struct {
+		stream string
+		nok    int
+		err    error
+	}{
+		{"I1\n.I2\n", 1, io.ErrUnexpectedEOF},
+		{"I1\n.\x80\x03B\xff\x00\x00\x00abc", 1, io.ErrUnexpectedEOF},
+		{"I1\n.\x80\x03B\x00\x00\x00\x80abc", 1, io.ErrUnexpectedEOF}, // unsigned length ≥ 2GiB
+		{"I1\n.(\xff", 1, OpcodeError{Key: 0xff, Pos: 2}},
+	} {
+		ranges, err := SplitPickles(strings.NewReader(tt.stream))
+		if !(len(ranges) == tt.nok && err == tt.err) {
+			t.Errorf("split %q: have %v, %v  ; want %d ranges, %v", tt.stream, ranges, err, tt.nok, tt.err)
+		}
+
+		s := bufio.NewScanner(strings.NewReader(tt.stream))
+		s.Split(ScanPickles)
+		n := 0
+		for s.Scan() {
+			n++
+		}
+		if err := s.Err(); !(n == tt.nok && err == tt.err) {
+			t.Errorf("scan %q: have %d tokens, %v  ; want %d, %v", tt.stream, n, err, tt.nok, tt.err)
+		}
+	}
+}
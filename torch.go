@@ -0,0 +1,307 @@
+package ogórek
+// Helpers for PyTorch (https://pytorch.org) checkpoints saved with torch.save.
+//
+// torch.save pickles tensors as calls to torch._utils._rebuild_tensor_v2,
+// while the tensor data is stored out of the pickle in sidecar blobs, e.g.
+// data/<key> files in the zip archive. The pickle refers to the blobs via
+// persistent IDs of form ('storage', storage_type, key, location, numel).
+
+import (
+	"fmt"
+	"io"
+	"math"
+)
+
+// TorchStorage represents PyTorch storage, that holds raw data of tensors.
+type TorchStorage struct {
+	Type     Class  // storage type, e.g. torch.FloatStorage
+	Key      string // key of the blob with storage data
+	Location string // device of the storage, e.g. "cpu" or "cuda:0"
+	Numel    int64  // number of elements in the storage
+	Data     []byte // raw data; nil if not loaded
+}
+
+// torchDTypes maps storage types to dtype names and element sizes.
+var torchDTypes = map[string]struct {
+	dtype string
+	size  int
+}{
+	"DoubleStorage":        {"float64", 8},
+	"FloatStorage":         {"float32", 4},
+	"HalfStorage":          {"float16", 2},
+	"BFloat16Storage":      {"bfloat16", 2},
+	"LongStorage":          {"int64", 8},
+	"IntStorage":           {"int32", 4},
+	"ShortStorage":         {"int16", 2},
+	"CharStorage":          {"int8", 1},
+	"ByteStorage":          {"uint8", 1},
+	"BoolStorage":          {"bool", 1},
+	"ComplexDoubleStorage": {"complex128", 16},
+	"ComplexFloatStorage":  {"complex64", 8},
+	"UntypedStorage":       {"uint8", 1},
+}
+
+// DType returns name of the storage element type, e.g. "float32".
+//
+// "" is returned if the storage type is not known.
+func (s *TorchStorage) DType() string {
+	if s.Type.Module != "torch" {
+		return ""
+	}
+	return torchDTypes[s.Type.Name].dtype
+}
+
+// ElemSize returns size of the storage element in bytes, or 0 if the storage type is not known.
+func (s *TorchStorage) ElemSize() int {
+	if s.Type.Module != "torch" {
+		return 0
+	}
+	return torchDTypes[s.Type.Name].size
+}
+
+// ParseTorchStorage parses persistent ID of PyTorch storage.
+//
+// pid should be ('storage', storage_type, key, location, numel) tuple. The
+// data of the storage is not loaded.
+func ParseTorchStorage(pid any) (*TorchStorage, error) {
+	t, ok := pid.(Tuple)
+	if !(ok && len(t) == 5) {
+		return nil, fmt.Errorf("torch: storage: expect ('storage', type, key, location, numel); got %T", pid)
+	}
+	kind, err := AsString(t[0])
+	if err != nil || kind != "storage" {
+		return nil, fmt.Errorf("torch: storage: unexpected kind %#v", t[0])
+	}
+
+	s := &TorchStorage{}
+	s.Type, ok = t[1].(Class)
+	if !ok {
+		return nil, fmt.Errorf("torch: storage: type: expect class; got %T", t[1])
+	}
+	s.Key, err = AsString(t[2])
+	if err != nil {
+		return nil, fmt.Errorf("torch: storage: key: %s", err)
+	}
+	s.Location, err = AsString(t[3])
+	if err != nil {
+		return nil, fmt.Errorf("torch: storage: location: %s", err)
+	}
+	s.Numel, err = AsInt64(t[4])
+	if err != nil || s.Numel < 0 {
+		return nil, fmt.Errorf("torch: storage: invalid numel %#v", t[4])
+	}
+	return s, nil
+}
+
+// TorchPersistentLoad returns function, that can be used as
+// [DecoderConfig.PersistentLoad] to decode pickles saved by torch.save.
+//
+// The function loads references to storages as *[TorchStorage]. If openBlob
+// is not nil, it is used to open blob with storage data by its key, and the
+// data is loaded into TorchStorage.Data. Every storage is loaded only once.
+// If the opened blob implements io.Closer, it is closed after reading.
+func TorchPersistentLoad(openBlob func(key string) (io.Reader, error)) func(ref Ref) (any, error) {
+	loaded := map[string]*TorchStorage{}
+	return func(ref Ref) (any, error) {
+		s, err := ParseTorchStorage(ref.Pid)
+		if err != nil {
+			return nil, err
+		}
+		if s_, ok := loaded[s.Key]; ok {
+			return s_, nil
+		}
+
+		if openBlob != nil {
+			err = s.load(openBlob)
+			if err != nil {
+				return nil, fmt.Errorf("torch: storage %s: %w", s.Key, err)
+			}
+		}
+		loaded[s.Key] = s
+		return s, nil
+	}
+}
+
+// load loads storage data from its blob.
+func (s *TorchStorage) load(openBlob func(key string) (io.Reader, error)) (err error) {
+	r, err := openBlob(s.Key)
+	if err != nil {
+		return err
+	}
+	if c, ok := r.(io.Closer); ok {
+		defer func() {
+			if err2 := c.Close(); err == nil {
+				err = err2
+			}
+		}()
+	}
+
+	s.Data, err = io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if esize := s.ElemSize(); esize != 0 && int64(len(s.Data)) != s.Numel*int64(esize) {
+		return fmt.Errorf("data size %d does not match %d × %d", len(s.Data), s.Numel, esize)
+	}
+	return nil
+}
+
+// TorchTensor represents metadata of PyTorch tensor together with its storage.
+type TorchTensor struct {
+	Storage      *TorchStorage
+	Offset       int64   // offset of the tensor in the storage, in elements
+	Size         []int64 // tensor shape
+	Stride       []int64 // strides, in elements
+	RequiresGrad bool
+}
+
+// AsTorchTensor converts decoded call of torch._utils._rebuild_tensor_v2, or
+// of torch._utils._rebuild_parameter, into TorchTensor.
+//
+// The storage argument of the call should be *[TorchStorage], as returned
+// by [TorchPersistentLoad], or Ref with storage persistent ID.
+func AsTorchTensor(x any) (*TorchTensor, error) {
+	call, ok := x.(Call)
+	if !(ok && call.Callable.Module == "torch._utils") {
+		return nil, fmt.Errorf("torch: tensor: expect torch._utils call; got %T", x)
+	}
+	argv := call.Args
+
+	switch call.Callable.Name {
+	// _rebuild_parameter(data, requires_grad, backward_hooks)
+	case "_rebuild_parameter":
+		if len(argv) != 3 {
+			return nil, fmt.Errorf("torch: parameter: unexpected number of args %d", len(argv))
+		}
+		t, err := AsTorchTensor(argv[0])
+		if err != nil {
+			return nil, err
+		}
+		t.RequiresGrad, ok = argv[1].(bool)
+		if !ok {
+			return nil, fmt.Errorf("torch: parameter: requires_grad: expect bool; got %T", argv[1])
+		}
+		return t, nil
+
+	// _rebuild_tensor_v2(storage, storage_offset, size, stride, requires_grad, backward_hooks[, metadata])
+	case "_rebuild_tensor_v2":
+		if !(6 <= len(argv) && len(argv) <= 7) {
+			return nil, fmt.Errorf("torch: tensor: unexpected number of args %d", len(argv))
+		}
+
+	default:
+		return nil, fmt.Errorf("torch: tensor: unexpected call %s.%s", call.Callable.Module, call.Callable.Name)
+	}
+
+	t := &TorchTensor{}
+	var err error
+	switch s := argv[0].(type) {
+	case *TorchStorage:
+		t.Storage = s
+	case Ref:
+		t.Storage, err = ParseTorchStorage(s.Pid)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("torch: tensor: storage: unexpected type %T", argv[0])
+	}
+
+	t.Offset, err = AsInt64(argv[1])
+	if err != nil {
+		return nil, fmt.Errorf("torch: tensor: offset: %s", err)
+	}
+	t.Size, err = torchInts(argv[2])
+	if err != nil {
+		return nil, fmt.Errorf("torch: tensor: size: %s", err)
+	}
+	t.Stride, err = torchInts(argv[3])
+	if err != nil {
+		return nil, fmt.Errorf("torch: tensor: stride: %s", err)
+	}
+	if len(t.Size) != len(t.Stride) {
+		return nil, fmt.Errorf("torch: tensor: size and stride have different lengths: %d != %d", len(t.Size), len(t.Stride))
+	}
+	t.RequiresGrad, ok = argv[4].(bool)
+	if !ok {
+		return nil, fmt.Errorf("torch: tensor: requires_grad: expect bool; got %T", argv[4])
+	}
+	return t, nil
+}
+
+// torchInts decodes tuple of ints.
+func torchInts(x any) ([]int64, error) {
+	t, ok := x.(Tuple)
+	if !ok {
+		return nil, fmt.Errorf("expect tuple; got %T", x)
+	}
+	v := make([]int64, len(t))
+	for i := range t {
+		n, err := AsInt64(t[i])
+		if err != nil {
+			return nil, err
+		}
+		v[i] = n
+	}
+	return v, nil
+}
+
+// Numel returns number of elements in the tensor.
+func (t *TorchTensor) Numel() int64 {
+	n := int64(1)
+	for _, s := range t.Size {
+		n *= s
+	}
+	return n
+}
+
+// Data returns raw data of the tensor in row-major order.
+//
+// Only contiguous tensors are supported. The returned slice shares memory
+// with the storage data.
+func (t *TorchTensor) Data() ([]byte, error) {
+	s := t.Storage
+	if s.Data == nil {
+		return nil, fmt.Errorf("torch: tensor: storage %s is not loaded", s.Key)
+	}
+	esize := int64(s.ElemSize())
+	if esize == 0 {
+		return nil, fmt.Errorf("torch: tensor: unknown storage type %s.%s", s.Type.Module, s.Type.Name)
+	}
+
+	if len(t.Size) != len(t.Stride) {
+		return nil, fmt.Errorf("torch: tensor: size and stride have different lengths: %d != %d", len(t.Size), len(t.Stride))
+	}
+	if t.Offset < 0 {
+		return nil, fmt.Errorf("torch: tensor: negative offset %d", t.Offset)
+	}
+
+	// size of the data in bytes; don't let invalid shape overflow it
+	size := esize
+	for i, dim := range t.Size {
+		if dim < 0 || t.Stride[i] < 0 {
+			return nil, fmt.Errorf("torch: tensor: negative size %v or stride %v", t.Size, t.Stride)
+		}
+		if dim != 0 && size > math.MaxInt64/dim {
+			return nil, fmt.Errorf("torch: tensor: size %v overflows", t.Size)
+		}
+		size *= dim
+	}
+
+	// verify contiguity
+	expect := int64(1)
+	for i := len(t.Size)-1; i >= 0; i-- {
+		if t.Size[i] != 1 && t.Stride[i] != expect {
+			return nil, fmt.Errorf("torch: tensor: not contiguous")
+		}
+		expect *= t.Size[i]
+	}
+
+	have := int64(len(s.Data))
+	if !(t.Offset <= have/esize && size <= have - t.Offset*esize) {
+		return nil, fmt.Errorf("torch: tensor: %d bytes at offset %d out of storage of %d bytes", size, t.Offset, have)
+	}
+	start := t.Offset * esize
+	end := start + size
+	return s.Data[start:end:end], nil
+}
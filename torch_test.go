@@ -0,0 +1,135 @@
+package ogórek
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestTorch verifies decoding of tensors from pickles saved by torch.save.
+func TestTorch(t *testing.T) {
+	// state dict with weight (2,3) and bias (2,) sharing one FloatStorage of 6 elements
+	pickle := "\x80\x02}q\x00(X\x06\x00\x00\x00weightq\x01ctorch._utils\n_rebuild_tensor_v2\nq\x02((X\x07\x00\x00\x00storageq\x03ctorch\nFloatStorage\nq\x04X\x01\x00\x00\x000q\x05X\x03\x00\x00\x00cpuq\x06K\x06tq\x07QK\x00K\x02K\x03\x86q\x08K\x03K\x01\x86q\t\x89ccollections\nOrderedDict\nq\n)Rq\x0btq\x0cRq\rX\x04\x00\x00\x00biasq\x0eh\x02((h\x03h\x04h\x05h\x06K\x06tq\x0fQK\x04K\x02\x85q\x10K\x01\x85q\x11\x89h\n)Rq\x12tq\x13Rq\x14u."
+
+	var blob bytes.Buffer
+	for _, f := range []float32{1, 2, 3, 4, 5, 6} {
+		binary.Write(&blob, binary.LittleEndian, math.Float32bits(f))
+	}
+	nopen := 0
+	openBlob := func(key string) (io.Reader, error) {
+		nopen++
+		if key != "0" {
+			return nil, fmt.Errorf("no blob %q", key)
+		}
+		return bytes.NewReader(blob.Bytes()), nil
+	}
+
+	d := NewDecoderWithConfig(strings.NewReader(pickle), &DecoderConfig{
+		PersistentLoad: TorchPersistentLoad(openBlob),
+	})
+	obj, err := d.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nopen != 1 {
+		t.Errorf("storage opened %d times; want 1", nopen)
+	}
+
+	state := obj.(map[any]any)
+	weight, err := AsTorchTensor(state["weight"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	bias, err := AsTorchTensor(state["bias"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if weight.Storage != bias.Storage {
+		t.Errorf("tensors do not share storage")
+	}
+
+	s := weight.Storage
+	if !(s.Key == "0" && s.Location == "cpu" && s.Numel == 6 && s.DType() == "float32" && s.ElemSize() == 4) {
+		t.Errorf("storage: %+v", s)
+	}
+	if !(reflect.DeepEqual(weight.Size, []int64{2, 3}) && reflect.DeepEqual(weight.Stride, []int64{3, 1}) && weight.Offset == 0) {
+		t.Errorf("weight: %+v", weight)
+	}
+	if !(reflect.DeepEqual(bias.Size, []int64{2}) && reflect.DeepEqual(bias.Stride, []int64{1}) && bias.Offset == 4) {
+		t.Errorf("bias: %+v", bias)
+	}
+
+	data, err := weight.Data()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, blob.Bytes()) {
+		t.Errorf("weight data: %x", data)
+	}
+	data, err = bias.Data()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, blob.Bytes()[16:]) {
+		t.Errorf("bias data: %x", data)
+	}
+
+	// non-contiguous tensor
+	weight.Size, weight.Stride = []int64{3, 2}, []int64{1, 3}
+	_, err = weight.Data()
+	if err == nil {
+		t.Errorf("transposed tensor: no error")
+	}
+
+	// invalid shapes and offsets, that must not overflow into valid data range
+	for _, tt := range []struct {
+		offset       int64
+		size, stride []int64
+	}{
+		{-2,             []int64{2},            []int64{1}},
+		{0,              []int64{-2, -3},       []int64{-3, 1}},
+		{0,              []int64{2},            []int64{-1}},
+		{0,              []int64{1 << 62, 4},   []int64{4, 1}},
+		{1 << 62,        []int64{1},            []int64{1}},
+		{math.MaxInt64,  []int64{0},            []int64{1}},
+		{0,              []int64{2, 3},         []int64{3}},
+	} {
+		bad := &TorchTensor{Storage: weight.Storage, Offset: tt.offset, Size: tt.size, Stride: tt.stride}
+		_, err = bad.Data()
+		if err == nil {
+			t.Errorf("offset %d size %v stride %v: no error", tt.offset, tt.size, tt.stride)
+		}
+	}
+
+	// without PersistentLoad storages are left as Ref
+	obj, err = NewDecoder(strings.NewReader(pickle)).Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bias, err = AsTorchTensor(obj.(map[any]any)["bias"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bias.Storage.Numel != 6 || bias.Storage.Data != nil {
+		t.Errorf("bias storage: %+v", bias.Storage)
+	}
+	_, err = bias.Data()
+	if err == nil {
+		t.Errorf("unloaded storage: no error")
+	}
+
+	// storage size mismatch
+	_, err = NewDecoderWithConfig(strings.NewReader(pickle), &DecoderConfig{
+		PersistentLoad: TorchPersistentLoad(func(key string) (io.Reader, error) {
+			return bytes.NewReader(blob.Bytes()[:8]), nil
+		}),
+	}).Decode()
+	if err == nil {
+		t.Errorf("short storage: no error")
+	}
+}
@@ -3,7 +3,9 @@ package ogórek
 
 import (
 	"fmt"
+	"math"
 	"math/big"
+	"reflect"
 )
 
 
@@ -61,6 +63,392 @@ func AsString(x any) (string, error) {
 }
 
 
+// FloatPolicy specifies how integers, that float64 cannot represent exactly,
+// are converted to float64 by [AsFloat64].
+type FloatPolicy int
+
+const (
+	// FloatRound rounds integers to nearest float64. Integers outside of
+	// float64 range become ±Inf.
+	FloatRound FloatPolicy = iota
+
+	// FloatNoOverflow rounds integers to nearest float64, but fails for
+	// integers outside of float64 range.
+	FloatNoOverflow
+
+	// FloatExact fails for integers, that float64 cannot represent exactly.
+	FloatExact
+)
+
+// AsFloat64 tries to represent unpickled number as float64.
+//
+// It succeeds for float, and for int and long, that are converted according
+// to policy.
+func AsFloat64(x any, policy FloatPolicy) (float64, error) {
+	switch x := x.(type) {
+	case float64:
+		return x, nil
+
+	case int64:
+		f := float64(x)
+		if policy == FloatExact && !(f != 0x1p63 && int64(f) == x) {
+			return 0, fmt.Errorf("int %d cannot be represented as float exactly", x)
+		}
+		return f, nil
+
+	case *big.Int:
+		f, acc := bigInt_Float64(x)
+		if policy == FloatExact && acc != big.Exact {
+			return 0, fmt.Errorf("long %s cannot be represented as float exactly", x)
+		}
+		if policy == FloatNoOverflow && math.IsInf(f, 0) {
+			return 0, fmt.Errorf("long %s is outside of float range", x)
+		}
+		return f, nil
+	}
+	return 0, fmt.Errorf("expect float|int64|long; got %T", x)
+}
+
+// asFloatNumbers converts all numbers in unpickled value x to float64.
+//
+// It serves DecoderConfig.NumbersAsFloat. Containers with numbers are copied.
+// Shared and recursive maps, Dicts and lists decoded with PreserveRefs are
+// copied once, so that the copy has the same structure. Lists and tuples,
+// that share their data, e.g. via memo, are copied once as well, so that
+// converting a small pickle with many shared references is cheap.
+func asFloatNumbers(x any, policy FloatPolicy) (any, error) {
+	c := &floatConverter{policy: policy}
+	return c.convert(x)
+}
+
+// floatConverter serves asFloatNumbers.
+type floatConverter struct {
+	policy FloatPolicy
+	copies map[any]any // reference containers, and sliceKeys of lists and tuples -> their copies
+}
+
+func (c *floatConverter) convert(x any) (any, error) {
+	switch x := x.(type) {
+	case int64, *big.Int:
+		return AsFloat64(x, c.policy)
+
+	case Tuple:
+		l, err := c.list(x)
+		return Tuple(l), err
+	case []any:
+		return c.list(x)
+	case *[]any:
+		if y, ok := c.copies[x]; ok {
+			return y, nil
+		}
+		l := &[]any{}
+		c.copy(x, l)
+		var err error
+		*l, err = c.list(*x)
+		return l, err
+
+	case map[any]any:
+		key := reflect.ValueOf(x).UnsafePointer()
+		if y, ok := c.copies[key]; ok {
+			return y, nil
+		}
+		m := make(map[any]any, len(x))
+		c.copy(key, m)
+		for k, v := range x {
+			kf, vf, err := c.item(k, v)
+			if err != nil {
+				return nil, err
+			}
+			m[kf] = vf
+		}
+		return m, nil
+
+	case Dict:
+		if y, ok := c.copies[x.d]; ok {
+			return y, nil
+		}
+		d := NewDictWithSizeHint(x.Len())
+		c.copy(x.d, d)
+		var err error
+		x.Iter()(func(k, v any) bool {
+			var kf, vf any
+			kf, vf, err = c.item(k, v)
+			if err == nil {
+				d.Set(kf, vf)
+			}
+			return err == nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		return d, nil
+
+	case Set:
+		return c.set(x.Iter(), x.Len())
+	case FrozenSet:
+		s, err := c.set(x.Iter(), x.Len())
+		return FrozenSet{s}, err
+
+	case []KV:
+		items := make([]KV, len(x))
+		for i, kv := range x {
+			k, v, err := c.item(kv.Key, kv.Value)
+			if err != nil {
+				return nil, err
+			}
+			items[i] = KV{k, v}
+		}
+		return items, nil
+
+	case Call:
+		args, err := c.list(x.Args)
+		if err != nil {
+			return nil, err
+		}
+		return Call{x.Callable, Tuple(args)}, nil
+	}
+
+	return x, nil
+}
+
+// copy remembers y as copy of reference container x.
+func (c *floatConverter) copy(x, y any) {
+	if c.copies == nil {
+		c.copies = make(map[any]any)
+	}
+	c.copies[x] = y
+}
+
+// list serves convert for lists and tuples.
+func (c *floatConverter) list(l []any) ([]any, error) {
+	key := keyOfSlice(l)
+	if y, ok := c.copies[key]; ok {
+		return y.([]any), nil
+	}
+	out := make([]any, len(l))
+	for i, v := range l {
+		vf, err := c.convert(v)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = vf
+	}
+	if len(l) > 0 {
+		c.copy(key, out)
+	}
+	return out, nil
+}
+
+// sliceKey identifies data of a list or a tuple, so that lists and tuples,
+// that share their data, e.g. because they were loaded from memo, can be
+// converted only once.
+type sliceKey struct {
+	data uintptr
+	len  int
+}
+
+func keyOfSlice(l []any) sliceKey {
+	return sliceKey{reflect.ValueOf(l).Pointer(), len(l)}
+}
+
+// set serves convert for sets and frozensets.
+func (c *floatConverter) set(iter func(yield func(any) bool), n int) (Set, error) {
+	set := NewSetWithSizeHint(n)
+	var err error
+	iter(func(item any) bool {
+		var f any
+		f, err = c.convert(item)
+		if err == nil {
+			set.Add(f)
+		}
+		return err == nil
+	})
+	return set, err
+}
+
+// item serves convert for dict items.
+func (c *floatConverter) item(k, v any) (kf, vf any, err error) {
+	kf, err = c.convert(k)
+	if err == nil {
+		vf, err = c.convert(v)
+	}
+	return kf, vf, err
+}
+
+// AsJSONSafe tries to represent unpickled value with only JSON-compatible types.
+//
+// The result contains only nil, bool, int64, float64, string, []any and
+// map[string]any and can be passed directly to encoding/json. The conversion
+// is performed recursively as follows:
+//
+//	None                       →  nil
+//	*big.Int                   →  int64           (error if outside of int64 range)
+//	float64                    →  float64         (error for NaN and ±Inf)
+//	ByteString, Bytes, []byte  →  string
+//	Tuple, []any, *[]any       →  []any
+//	map[any]any, Dict          →  map[string]any  (error if a key is not string)
+//
+// Any other value, for example Class, Call or Ref, results in error. Recursive
+// structures, e.g. dict that contains itself, result in error as well. Shared
+// containers are converted once, and the result shares them as well.
+func AsJSONSafe(x any) (any, error) {
+	c := &jsonConverter{}
+	return c.convert(x)
+}
+
+// jsonConverter serves AsJSONSafe.
+type jsonConverter struct {
+	path map[any]bool // reference containers that are being converted
+	done map[any]any  // converted reference containers, and sliceKeys of lists and tuples -> results
+}
+
+func (c *jsonConverter) convert(x any) (any, error) {
+	switch x := x.(type) {
+	case nil, bool, int64, string:
+		return x, nil
+
+	case None:
+		return nil, nil
+
+	case *big.Int:
+		return AsInt64(x)
+
+	case float64:
+		if math.IsNaN(x) || math.IsInf(x, 0) {
+			return nil, fmt.Errorf("jsonsafe: float %v cannot be represented", x)
+		}
+		return x, nil
+
+	case ByteString:
+		return string(x), nil
+	case Bytes:
+		return string(x), nil
+	case []byte:
+		return string(x), nil
+
+	case Tuple:
+		return c.list(x)
+	case []any:
+		return c.list(x)
+	case *[]any:
+		if y, ok := c.done[x]; ok {
+			return y, nil
+		}
+		if err := c.enter(x); err != nil {
+			return nil, err
+		}
+		defer c.leave(x)
+		l, err := c.list(*x)
+		if err != nil {
+			return nil, err
+		}
+		c.remember(x, l)
+		return l, nil
+
+	case map[any]any:
+		key := reflect.ValueOf(x).UnsafePointer()
+		if y, ok := c.done[key]; ok {
+			return y, nil
+		}
+		if err := c.enter(key); err != nil {
+			return nil, err
+		}
+		defer c.leave(key)
+		m := make(map[string]any, len(x))
+		for k, v := range x {
+			err := c.setItem(m, k, v)
+			if err != nil {
+				return nil, err
+			}
+		}
+		c.remember(key, m)
+		return m, nil
+
+	case Dict:
+		if y, ok := c.done[x.d]; ok {
+			return y, nil
+		}
+		if err := c.enter(x.d); err != nil {
+			return nil, err
+		}
+		defer c.leave(x.d)
+		m := make(map[string]any, x.Len())
+		var err error
+		x.Iter()(func(k, v any) bool {
+			err = c.setItem(m, k, v)
+			return err == nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		c.remember(x.d, m)
+		return m, nil
+	}
+
+	return nil, fmt.Errorf("jsonsafe: unsupported type %T", x)
+}
+
+// enter marks reference container x as being converted.
+// It is an error if x is already being converted, i.e. if x contains itself.
+func (c *jsonConverter) enter(x any) error {
+	if c.path[x] {
+		return fmt.Errorf("jsonsafe: recursive structure")
+	}
+	if c.path == nil {
+		c.path = make(map[any]bool)
+	}
+	c.path[x] = true
+	return nil
+}
+
+// leave marks reference container x as converted.
+func (c *jsonConverter) leave(x any) {
+	delete(c.path, x)
+}
+
+// remember records y as result of converting reference container x, so that
+// shared containers are converted only once.
+func (c *jsonConverter) remember(x, y any) {
+	if c.done == nil {
+		c.done = make(map[any]any)
+	}
+	c.done[x] = y
+}
+
+// list serves convert for lists and tuples.
+func (c *jsonConverter) list(l []any) ([]any, error) {
+	key := keyOfSlice(l)
+	if y, ok := c.done[key]; ok {
+		return y.([]any), nil
+	}
+	out := make([]any, len(l))
+	for i, v := range l {
+		vsafe, err := c.convert(v)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = vsafe
+	}
+	if len(l) > 0 {
+		c.remember(key, out)
+	}
+	return out, nil
+}
+
+// setItem serves convert for dicts.
+func (c *jsonConverter) setItem(m map[string]any, k, v any) error {
+	ks, err := AsString(k)
+	if err != nil {
+		return fmt.Errorf("jsonsafe: dict key: %s", err)
+	}
+	vsafe, err := c.convert(v)
+	if err != nil {
+		return err
+	}
+	m[ks] = vsafe
+	return nil
+}
+
 // stringEQ compares arbitrary x to string y.
 //
 // It succeeds only if AsString(x) succeeds and string data of x equals to y.
@@ -2,7 +2,9 @@ package ogórek
 
 import (
 	"fmt"
+	"math"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -111,3 +113,122 @@ func TestAsBytesString(t *testing.T) {
 		}
 	}
 }
+
+func TestAsJSONSafe(t *testing.T) {
+	Etype := func(typename string) error {
+		return fmt.Errorf("jsonsafe: unsupported type %s", typename)
+	}
+
+	testv := []struct {
+		in    any
+		outOK any
+	}{
+		{None{},                         nil},
+		{true,                           true},
+		{int64(1),                       int64(1)},
+		{bigInt("123"),                  int64(123)},
+		{bigInt("9223372036854775808"),  fmt.Errorf("long outside of int64 range")},
+		{1.5,                            1.5},
+		{math.Inf(+1),                   fmt.Errorf("jsonsafe: float +Inf cannot be represented")},
+		{"мир",                          "мир"},
+		{ByteString("мир"),              "мир"},
+		{Bytes("мир"),                   "мир"},
+		{[]byte("мир"),                  "мир"},
+		{Tuple{int64(1), None{}},        []any{int64(1), nil}},
+		{[]any{Tuple{}, Bytes("a")},     []any{[]any{}, "a"}},
+		{map[any]any{"a": Tuple{}},      map[string]any{"a": []any{}}},
+		{NewDictWithData(ByteString("a"), None{}), map[string]any{"a": nil}},
+		{map[any]any{int64(1): "a"},     fmt.Errorf("jsonsafe: dict key: expect unicode|bytestr; got int64")},
+		{Class{"a", "b"},                Etype("ogórek.Class")},
+		{[]any{Ref{"a"}},                Etype("ogórek.Ref")},
+	}
+
+	for _, tt := range testv {
+		out, err := AsJSONSafe(tt.in)
+		if err != nil {
+			out = err
+		}
+
+		if !reflect.DeepEqual(out, tt.outOK) {
+			t.Errorf("%T %#v -> %T %#v  ; want %T %#v",
+				tt.in, tt.in, out, out, tt.outOK, tt.outOK)
+		}
+	}
+}
+
+func TestAsFloat64(t *testing.T) {
+	huge := bigInt("1" + strings.Repeat("0", 400))
+	inexact := bigInt("9007199254740993") // 2^53 + 1
+
+	testv := []struct {
+		in     any
+		policy FloatPolicy
+		outOK  any
+	}{
+		{1.5,                         FloatExact,      1.5},
+		{int64(-3),                   FloatExact,      -3.0},
+		{int64(1<<53 + 1),            FloatRound,      float64(1 << 53)},
+		{int64(1<<53 + 1),            FloatExact,      fmt.Errorf("int 9007199254740993 cannot be represented as float exactly")},
+		{int64(math.MaxInt64),        FloatExact,      fmt.Errorf("int 9223372036854775807 cannot be represented as float exactly")},
+		{int64(math.MinInt64),        FloatExact,      -0x1p63},
+		{bigInt("123"),               FloatExact,      123.0},
+		{inexact,                     FloatNoOverflow, float64(1 << 53)},
+		{inexact,                     FloatExact,      fmt.Errorf("long 9007199254740993 cannot be represented as float exactly")},
+		{huge,                        FloatRound,      math.Inf(+1)},
+		{huge,                        FloatNoOverflow, fmt.Errorf("long %s is outside of float range", huge)},
+		{"1",                         FloatRound,      fmt.Errorf("expect float|int64|long; got string")},
+	}
+
+	for _, tt := range testv {
+		var out any
+		out, err := AsFloat64(tt.in, tt.policy)
+		if err != nil {
+			out = err
+		}
+
+		if !reflect.DeepEqual(out, tt.outOK) {
+			t.Errorf("%T %#v (policy %d) -> %T %#v  ; want %T %#v",
+				tt.in, tt.in, tt.policy, out, out, tt.outOK, tt.outOK)
+		}
+	}
+}
+
+func TestDecodeNumbersAsFloat(t *testing.T) {
+	// pickle.dumps([1, 2**70, 1.5, True, (3,), {4: 'a'}], 2)
+	data := "\x80\x02]q\x00(K\x01\x8a\t\x00\x00\x00\x00\x00\x00\x00\x00@G?\xf8\x00\x00\x00\x00\x00\x00\x88K\x03\x85q\x01}q\x02K\x04X\x01\x00\x00\x00aq\x03se."
+	obj, err := NewDecoderWithConfig(strings.NewReader(data), &DecoderConfig{NumbersAsFloat: true}).Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []any{1.0, 0x1p70, 1.5, true, Tuple{3.0}, map[any]any{4.0: "a"}}
+	if !reflect.DeepEqual(obj, want) {
+		t.Errorf("decode:\nhave: %#v\nwant: %#v", obj, want)
+	}
+
+	// 2**70 + 1 cannot be represented exactly
+	data = "\x80\x02\x8a\t\x01\x00\x00\x00\x00\x00\x00\x00@."
+	_, err = NewDecoderWithConfig(strings.NewReader(data), &DecoderConfig{NumbersAsFloat: true, FloatPolicy: FloatExact}).Decode()
+	if err == nil {
+		t.Errorf("decode exact: no error")
+	}
+}
+
+// verify that NumbersAsFloat converts memo-shared tuples only once.
+func TestDecodeNumbersAsFloatShared(t *testing.T) {
+	// t = (t, t) 30 times with every level memoized: 2³⁰ numbers logically
+	data := "\x80\x02K\x01" + strings.Repeat("q\x00h\x00\x86", 30) + "."
+	obj, err := NewDecoderWithConfig(strings.NewReader(data), &DecoderConfig{NumbersAsFloat: true}).Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 30; i++ {
+		tuple, ok := obj.(Tuple)
+		if !(ok && len(tuple) == 2) {
+			t.Fatalf("level %d: have %#v", i, obj)
+		}
+		obj = tuple[1]
+	}
+	if obj != 1.0 {
+		t.Errorf("leaf: have %#v  ; want 1.0", obj)
+	}
+}
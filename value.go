@@ -0,0 +1,423 @@
+package ogórek
+// Typed facade over decoded objects.
+
+import (
+	"fmt"
+	"math/big"
+)
+
+// Kind is the kind of Python object represented by [Value].
+type Kind int
+
+const (
+	KindInvalid   Kind = iota // not a value, e.g. zero Value
+	KindNone                  // None
+	KindBool                  // bool
+	KindInt                   // int or long
+	KindFloat                 // float
+	KindStr                   // str / unicode, including py2 str
+	KindBytes                 // bytes
+	KindByteArray             // bytearray
+	KindList                  // list
+	KindTuple                 // tuple
+	KindDict                  // dict
+	KindSet                   // set
+	KindFrozenSet             // frozenset
+	KindClass                 // class, e.g. reference to a global
+	KindCall                  // result of calling a class, e.g. object instance
+	KindObject                // object created via cls.__new__
+	KindRef                   // persistent reference
+	KindOther                 // other Go value, e.g. produced by PersistentLoad
+)
+
+var kindNames = [...]string{
+	KindInvalid:   "invalid",
+	KindNone:      "None",
+	KindBool:      "bool",
+	KindInt:       "int",
+	KindFloat:     "float",
+	KindStr:       "str",
+	KindBytes:     "bytes",
+	KindByteArray: "bytearray",
+	KindList:      "list",
+	KindTuple:     "tuple",
+	KindDict:      "dict",
+	KindSet:       "set",
+	KindFrozenSet: "frozenset",
+	KindClass:     "class",
+	KindCall:      "call",
+	KindObject:    "object",
+	KindRef:       "ref",
+	KindOther:     "other",
+}
+
+func (k Kind) String() string {
+	if 0 <= k && int(k) < len(kindNames) {
+		return kindNames[k]
+	}
+	return fmt.Sprintf("Kind(%d)", int(k))
+}
+
+// Value wraps a decoded object and provides uniform access to it.
+//
+// Decoded objects are represented by many Go types, e.g. Python dict can be
+// map[any]any, [Dict] or []KV depending on decoder configuration. Value allows
+// to inspect decoded objects via Kind and typed accessors without switching on
+// all those types. Accessors return an error if the value is of different kind.
+type Value struct {
+	x any
+}
+
+// ValueOf returns Value wrapping decoded object x.
+func ValueOf(x any) Value {
+	return Value{x}
+}
+
+// Interface returns the wrapped object.
+func (v Value) Interface() any {
+	return v.x
+}
+
+// obj returns the wrapped object, dereferenced if it is *[]any or *Object
+// as decoded with [DecoderConfig].PreserveRefs.
+func (v Value) obj() any {
+	switch p := v.x.(type) {
+	case *[]any:
+		return *p
+	case *Object:
+		return *p
+	}
+	return v.x
+}
+
+// Kind returns kind of Python object represented by v.
+func (v Value) Kind() Kind {
+	switch v.obj().(type) {
+	case nil:
+		return KindInvalid
+	case None:
+		return KindNone
+	case bool:
+		return KindBool
+	case int64, *big.Int:
+		return KindInt
+	case float64:
+		return KindFloat
+	case string, ByteString, Unicode:
+		return KindStr
+	case Bytes:
+		return KindBytes
+	case []byte:
+		return KindByteArray
+	case []any:
+		return KindList
+	case Tuple, NamedTuple:
+		return KindTuple
+	case map[any]any, Dict, []KV:
+		return KindDict
+	case Set:
+		return KindSet
+	case FrozenSet:
+		return KindFrozenSet
+	case Class:
+		return KindClass
+	case Call:
+		return KindCall
+	case Object:
+		return KindObject
+	case Ref:
+		return KindRef
+	}
+	return KindOther
+}
+
+// String returns string representation of the wrapped object.
+func (v Value) String() string {
+	return Sprint("%v", v.x, nil)
+}
+
+// errKind returns error about v being of unexpected kind.
+func (v Value) errKind(want string) error {
+	return fmt.Errorf("value: expect %s; got %s (%T)", want, v.Kind(), v.x)
+}
+
+// IsNone returns whether v is None.
+func (v Value) IsNone() bool {
+	return v.Kind() == KindNone
+}
+
+// Bool returns value of bool.
+func (v Value) Bool() (bool, error) {
+	b, ok := v.obj().(bool)
+	if !ok {
+		return false, v.errKind("bool")
+	}
+	return b, nil
+}
+
+// Int returns value of int or long, that must fit into int64.
+func (v Value) Int() (int64, error) {
+	if v.Kind() != KindInt {
+		return 0, v.errKind("int")
+	}
+	return AsInt64(v.x)
+}
+
+// BigInt returns value of int or long as big.Int.
+func (v Value) BigInt() (*big.Int, error) {
+	switch x := v.obj().(type) {
+	case int64:
+		return big.NewInt(x), nil
+	case *big.Int:
+		return x, nil
+	}
+	return nil, v.errKind("int")
+}
+
+// Float returns value of float.
+func (v Value) Float() (float64, error) {
+	f, ok := v.obj().(float64)
+	if !ok {
+		return 0, v.errKind("float")
+	}
+	return f, nil
+}
+
+// Str returns value of str.
+func (v Value) Str() (string, error) {
+	switch x := v.obj().(type) {
+	case string:
+		return x, nil
+	case ByteString:
+		return string(x), nil
+	case Unicode:
+		return string(x), nil
+	}
+	return "", v.errKind("str")
+}
+
+// Bytes returns data of bytes or bytearray.
+//
+// Python2 str is also accepted, as it can contain binary data. See [AsBytes]
+// for details.
+func (v Value) Bytes() (Bytes, error) {
+	switch x := v.obj().(type) {
+	case Bytes:
+		return x, nil
+	case ByteString:
+		return Bytes(x), nil
+	case []byte:
+		return Bytes(x), nil
+	}
+	return "", v.errKind("bytes|bytearray")
+}
+
+// Len returns the number of items in list, tuple, dict, set or frozenset.
+func (v Value) Len() (int, error) {
+	switch x := v.obj().(type) {
+	case []any:
+		return len(x), nil
+	case Tuple:
+		return len(x), nil
+	case NamedTuple:
+		return len(x.Values), nil
+	case map[any]any:
+		return len(x), nil
+	case Dict:
+		return x.Len(), nil
+	case []KV:
+		return len(x), nil
+	case Set:
+		return x.Len(), nil
+	case FrozenSet:
+		return x.Len(), nil
+	}
+	return 0, v.errKind("list|tuple|dict|set|frozenset")
+}
+
+// Elems returns items of list or tuple, or elements of set or frozenset.
+func (v Value) Elems() ([]Value, error) {
+	var l []any
+	switch x := v.obj().(type) {
+	case []any:
+		l = x
+	case Tuple:
+		l = x
+	case NamedTuple:
+		l = x.Values
+	case Set:
+		l = setItems(x.Iter(), x.Len())
+	case FrozenSet:
+		l = setItems(x.Iter(), x.Len())
+	default:
+		return nil, v.errKind("list|tuple|set|frozenset")
+	}
+	vv := make([]Value, len(l))
+	for i := range l {
+		vv[i] = Value{l[i]}
+	}
+	return vv, nil
+}
+
+// setItems returns elements of set or frozenset given its iterator.
+func setItems(iter func(yield func(any) bool), n int) []any {
+	l := make([]any, 0, n)
+	iter(func(item any) bool {
+		l = append(l, item)
+		return true
+	})
+	return l
+}
+
+// Index returns i-th item of list or tuple.
+func (v Value) Index(i int) (Value, error) {
+	var l []any
+	switch x := v.obj().(type) {
+	case []any:
+		l = x
+	case Tuple:
+		l = x
+	case NamedTuple:
+		l = x.Values
+	default:
+		return Value{}, v.errKind("list|tuple")
+	}
+	if !(0 <= i && i < len(l)) {
+		return Value{}, fmt.Errorf("value: index %d out of range [0:%d]", i, len(l))
+	}
+	return Value{l[i]}, nil
+}
+
+// ValueItem is key/value pair of a dict.
+type ValueItem struct {
+	Key, Value Value
+}
+
+// Items returns entries of dict.
+//
+// The entries are returned in the order they are stored in the dict. For
+// dicts decoded into Go maps the order is not specified.
+func (v Value) Items() ([]ValueItem, error) {
+	var items []ValueItem
+	switch x := v.obj().(type) {
+	case map[any]any:
+		items = make([]ValueItem, 0, len(x))
+		for k, kv := range x {
+			items = append(items, ValueItem{Value{k}, Value{kv}})
+		}
+	case Dict:
+		items = make([]ValueItem, 0, x.Len())
+		x.Iter()(func(k, kv any) bool {
+			items = append(items, ValueItem{Value{k}, Value{kv}})
+			return true
+		})
+	case []KV:
+		items = make([]ValueItem, len(x))
+		for i, kv := range x {
+			items[i] = ValueItem{Value{kv.Key}, Value{kv.Value}}
+		}
+	default:
+		return nil, v.errKind("dict")
+	}
+	return items, nil
+}
+
+// Get returns dict value corresponding to key.
+//
+// Keys are compared with Python semantics, e.g. int(1) and long(1) are
+// considered equal. ok=false is returned if v is not a dict, or if the dict
+// does not have the key. For dicts with duplicate keys the value of the last
+// matching entry is returned.
+func (v Value) Get(key any) (_ Value, ok bool) {
+	if k, isValue := key.(Value); isValue {
+		key = k.x
+	}
+
+	var value any
+	switch x := v.obj().(type) {
+	case map[any]any:
+		// try the key as is first, and scan the map only if it is not there
+		value, ok = mapTryGet(x, key)
+		if ok {
+			break
+		}
+		for k, kv := range x {
+			if equal(k, key) {
+				value, ok = kv, true
+				break
+			}
+		}
+	case Dict:
+		value, ok = dictTryGet(x, key)
+	case []KV:
+		for _, kv := range x {
+			if equal(kv.Key, key) {
+				value, ok = kv.Value, true
+			}
+		}
+	}
+	return Value{value}, ok
+}
+
+// dictTryGet is like Dict.Get_ but returns ok=false instead of panicking
+// if key is not allowed to be used as Dict key.
+func dictTryGet(d Dict, key any) (value any, ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			value, ok = nil, false
+		}
+	}()
+
+	return d.Get_(key)
+}
+
+// mapTryGet is like m[key] but returns ok=false instead of panicking if key
+// cannot be used as Go map key, e.g. Ref with slice Pid.
+func mapTryGet(m map[any]any, key any) (value any, ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			value, ok = nil, false
+		}
+	}()
+
+	value, ok = m[key]
+	return value, ok
+}
+
+// Class returns the class, that v represents.
+func (v Value) Class() (Class, error) {
+	c, ok := v.obj().(Class)
+	if !ok {
+		return Class{}, v.errKind("class")
+	}
+	return c, nil
+}
+
+// Call returns callable and arguments of call, that v represents.
+func (v Value) Call() (Class, []Value, error) {
+	c, ok := v.obj().(Call)
+	if !ok {
+		return Class{}, nil, v.errKind("call")
+	}
+	args, _ := Value{c.Args}.Elems()
+	return c.Callable, args, nil
+}
+
+// Object returns class and arguments of object, that v represents.
+func (v Value) Object() (Class, []Value, error) {
+	o, ok := v.obj().(Object)
+	if !ok {
+		return Class{}, nil, v.errKind("object")
+	}
+	args, _ := Value{o.Args}.Elems()
+	return o.Class, args, nil
+}
+
+// Pid returns persistent ID of persistent reference, that v represents.
+func (v Value) Pid() (Value, error) {
+	r, ok := v.obj().(Ref)
+	if !ok {
+		return Value{}, v.errKind("ref")
+	}
+	return Value{r.Pid}, nil
+}
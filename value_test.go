@@ -0,0 +1,116 @@
+package ogórek
+
+import (
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestValue verifies Value facade over decoded objects.
+func TestValue(t *testing.T) {
+	// pickle.dumps({'a': [1, 2**70, 1.5, None, True], 'b': (b'x', bytearray(b'y')), 1: decimal.Decimal('1')}, 3)
+	pickle := "\x80\x03}q\x00(X\x01\x00\x00\x00aq\x01]q\x02(K\x01\x8a\t\x00\x00\x00\x00\x00\x00\x00\x00@G?\xf8\x00\x00\x00\x00\x00\x00N\x88eX\x01\x00\x00\x00bq\x03C\x01xq\x04cbuiltins\nbytearray\nq\x05C\x01yq\x06\x85q\x07Rq\x08\x86q\tK\x01cdecimal\nDecimal\nq\nX\x01\x00\x00\x001q\x0b\x85q\x0cRq\ru."
+
+	for _, config := range []DecoderConfig{{}, {PyDict: true}, {DictAsItems: true}} {
+		obj, err := NewDecoderWithConfig(strings.NewReader(pickle), &config).Decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		v := ValueOf(obj)
+		if v.Kind() != KindDict {
+			t.Fatalf("%+v: kind %s", config, v.Kind())
+		}
+		if n, err := v.Len(); !(err == nil && n == 3) {
+			t.Errorf("%+v: len %d %v", config, n, err)
+		}
+		items, err := v.Items()
+		if !(err == nil && len(items) == 3) {
+			t.Errorf("%+v: items %v %v", config, items, err)
+		}
+
+		a, ok := v.Get("a")
+		if !ok || a.Kind() != KindList {
+			t.Fatalf("%+v: a: %v %v", config, a, ok)
+		}
+		elems, err := a.Elems()
+		if err != nil || len(elems) != 5 {
+			t.Fatalf("%+v: a: elems %v %v", config, elems, err)
+		}
+		var kinds []Kind
+		for _, e := range elems {
+			kinds = append(kinds, e.Kind())
+		}
+		if want := []Kind{KindInt, KindInt, KindFloat, KindNone, KindBool}; !reflect.DeepEqual(kinds, want) {
+			t.Errorf("%+v: a: kinds %v", config, kinds)
+		}
+		if i, err := elems[0].Int(); !(err == nil && i == 1) {
+			t.Errorf("%+v: a[0]: %v %v", config, i, err)
+		}
+		if _, err := elems[1].Int(); err == nil {
+			t.Errorf("%+v: a[1]: Int: no error", config)
+		}
+		b, _ := new(big.Int).SetString("1180591620717411303424", 10)
+		if i, err := elems[1].BigInt(); !(err == nil && i.Cmp(b) == 0) {
+			t.Errorf("%+v: a[1]: %v %v", config, i, err)
+		}
+		if f, err := elems[2].Float(); !(err == nil && f == 1.5) {
+			t.Errorf("%+v: a[2]: %v %v", config, f, err)
+		}
+		if _, err := elems[2].Int(); err == nil {
+			t.Errorf("%+v: a[2]: Int: no error", config)
+		}
+		if !elems[3].IsNone() {
+			t.Errorf("%+v: a[3]: not None", config)
+		}
+		if x, err := elems[4].Bool(); !(err == nil && x) {
+			t.Errorf("%+v: a[4]: %v %v", config, x, err)
+		}
+		if _, err := a.Index(5); err == nil {
+			t.Errorf("%+v: a[5]: no error", config)
+		}
+
+		bv, _ := v.Get("b")
+		x, _ := bv.Index(0)
+		y, _ := bv.Index(1)
+		if !(bv.Kind() == KindTuple && x.Kind() == KindBytes && y.Kind() == KindByteArray) {
+			t.Errorf("%+v: b: %v", config, bv)
+		}
+		if data, err := y.Bytes(); !(err == nil && data == "y") {
+			t.Errorf("%+v: b[1]: %v %v", config, data, err)
+		}
+		if _, err := x.Str(); err == nil {
+			t.Errorf("%+v: b[0]: Str: no error", config)
+		}
+
+		// key lookup with Python equality
+		one, ok := v.Get(big.NewInt(1))
+		if !ok || one.Kind() != KindCall {
+			t.Fatalf("%+v: 1: %v %v", config, one, ok)
+		}
+		class, args, err := one.Call()
+		if !(err == nil && class == (Class{"decimal", "Decimal"}) && len(args) == 1) {
+			t.Errorf("%+v: 1: %v %v %v", config, class, args, err)
+		}
+		if s, err := args[0].Str(); !(err == nil && s == "1") {
+			t.Errorf("%+v: 1: arg: %v %v", config, s, err)
+		}
+
+		if _, ok := v.Get([]any{}); ok {
+			t.Errorf("%+v: []: found", config)
+		}
+		if _, ok := v.Get(Ref{[]any{}}); ok {
+			t.Errorf("%+v: Ref{[]}: found", config)
+		}
+	}
+
+	if k := ValueOf(Ref{"a"}).Kind(); k != KindRef {
+		t.Errorf("ref: kind %s", k)
+	}
+	if k := (Value{}).Kind(); k != KindInvalid {
+		t.Errorf("zero: kind %s", k)
+	}
+	if k := ValueOf(struct{}{}).Kind(); k != KindOther {
+		t.Errorf("struct: kind %s", k)
+	}
+}
@@ -0,0 +1,213 @@
+package ogórek
+// Low-level emitter of pickle opcodes.
+
+import (
+	"encoding/binary"
+	"io"
+	"math/big"
+)
+
+// Writer emits pickle opcodes one by one.
+//
+// Contrary to [Encoder], that translates whole Go values into pickles, Writer
+// gives precise control over the produced opcode sequence, e.g. to generate
+// test fixtures or to experiment with the protocol.
+//
+// Methods named after an opcode, e.g. PutMark or PutReduce, emit exactly that
+// opcode, independently of the Writer protocol. Methods that put a value,
+// e.g. PutInt or PutGlobal, select the opcode and encoding of its argument
+// appropriate for the Writer protocol, the same way as Encoder does.
+//
+// Writer does not verify that the emitted opcodes form a valid pickle.
+type Writer struct {
+	e *Encoder
+}
+
+// NewWriter returns new [Writer] that emits opcodes of given pickle protocol into w.
+func NewWriter(w io.Writer, protocol int) *Writer {
+	return &Writer{e: NewEncoderWithConfig(w, &EncoderConfig{Protocol: protocol})}
+}
+
+// Protocol returns pickle protocol of the Writer.
+func (w *Writer) Protocol() int {
+	return w.e.config.Protocol
+}
+
+// Raw emits raw data as is.
+func (w *Writer) Raw(data []byte) error {
+	return w.e.emitb(data)
+}
+
+// PutProto emits PROTO with protocol of the Writer.
+func (w *Writer) PutProto() error {
+	return w.e.emit(opProto, byte(w.e.config.Protocol))
+}
+
+// PutFrame emits FRAME announcing frame of size n bytes.
+func (w *Writer) PutFrame(n uint64) error {
+	var b = [1+8]byte{opFrame}
+	binary.LittleEndian.PutUint64(b[1:], n)
+	return w.e.emitb(b[:])
+}
+
+// PutStop emits STOP.
+func (w *Writer) PutStop() error { return w.e.emit(opStop) }
+
+// PutMark emits MARK.
+func (w *Writer) PutMark() error { return w.e.emit(opMark) }
+
+// PutPop emits POP.
+func (w *Writer) PutPop() error { return w.e.emit(opPop) }
+
+// PutPopMark emits POP_MARK.
+func (w *Writer) PutPopMark() error { return w.e.emit(opPopMark) }
+
+// PutDup emits DUP.
+func (w *Writer) PutDup() error { return w.e.emit(opDup) }
+
+// PutReduce emits REDUCE.
+func (w *Writer) PutReduce() error { return w.e.emit(opReduce) }
+
+// PutBuild emits BUILD.
+func (w *Writer) PutBuild() error { return w.e.emit(opBuild) }
+
+// PutNewObj emits NEWOBJ.
+func (w *Writer) PutNewObj() error { return w.e.emit(opNewobj) }
+
+// PutNewObjEx emits NEWOBJ_EX.
+func (w *Writer) PutNewObjEx() error { return w.e.emit(opNewobjEx) }
+
+// PutStackGlobal emits STACK_GLOBAL.
+func (w *Writer) PutStackGlobal() error { return w.e.emit(opStackGlobal) }
+
+// PutTuple emits TUPLE.
+func (w *Writer) PutTuple() error { return w.e.emit(opTuple) }
+
+// PutEmptyTuple emits EMPTY_TUPLE.
+func (w *Writer) PutEmptyTuple() error { return w.e.emit(opEmptyTuple) }
+
+// PutTuple1 emits TUPLE1.
+func (w *Writer) PutTuple1() error { return w.e.emit(opTuple1) }
+
+// PutTuple2 emits TUPLE2.
+func (w *Writer) PutTuple2() error { return w.e.emit(opTuple2) }
+
+// PutTuple3 emits TUPLE3.
+func (w *Writer) PutTuple3() error { return w.e.emit(opTuple3) }
+
+// PutList emits LIST.
+func (w *Writer) PutList() error { return w.e.emit(opList) }
+
+// PutEmptyList emits EMPTY_LIST.
+func (w *Writer) PutEmptyList() error { return w.e.emit(opEmptyList) }
+
+// PutAppend emits APPEND.
+func (w *Writer) PutAppend() error { return w.e.emit(opAppend) }
+
+// PutAppends emits APPENDS.
+func (w *Writer) PutAppends() error { return w.e.emit(opAppends) }
+
+// PutDict emits DICT.
+func (w *Writer) PutDict() error { return w.e.emit(opDict) }
+
+// PutEmptyDict emits EMPTY_DICT.
+func (w *Writer) PutEmptyDict() error { return w.e.emit(opEmptyDict) }
+
+// PutSetItem emits SETITEM.
+func (w *Writer) PutSetItem() error { return w.e.emit(opSetitem) }
+
+// PutSetItems emits SETITEMS.
+func (w *Writer) PutSetItems() error { return w.e.emit(opSetitems) }
+
+// PutEmptySet emits EMPTY_SET.
+func (w *Writer) PutEmptySet() error { return w.e.emit(opEmptySet) }
+
+// PutAddItems emits ADDITEMS.
+func (w *Writer) PutAddItems() error { return w.e.emit(opAddItems) }
+
+// PutFrozenSet emits FROZENSET.
+func (w *Writer) PutFrozenSet() error { return w.e.emit(opFrozenSet) }
+
+// PutMemoize emits MEMOIZE.
+func (w *Writer) PutMemoize() error { return w.e.emit(opMemoize) }
+
+// PutBinPersID emits BINPERSID.
+func (w *Writer) PutBinPersID() error { return w.e.emit(opBinpersid) }
+
+// PutNextBuffer emits NEXT_BUFFER.
+func (w *Writer) PutNextBuffer() error { return w.e.emit(opNextBuffer) }
+
+// PutReadOnlyBuffer emits READONLY_BUFFER.
+func (w *Writer) PutReadOnlyBuffer() error { return w.e.emit(opReadOnlyBuffer) }
+
+// PutPersID emits PERSID with string persistent ID.
+func (w *Writer) PutPersID(pid string) error {
+	return w.e.emitf("%c%s\n", opPersid, pid)
+}
+
+// PutPut emits storing of stack top into memo[idx] with PUT, BINPUT or LONG_BINPUT.
+func (w *Writer) PutPut(idx int) error {
+	return w.e.emitMemoPut(idx)
+}
+
+// PutGet emits loading of memo[idx] onto the stack with GET, BINGET or LONG_BINGET.
+func (w *Writer) PutGet(idx int) error {
+	return w.e.emitMemoGet(idx)
+}
+
+// PutNone emits None.
+func (w *Writer) PutNone() error {
+	return w.e.emit(opNone)
+}
+
+// PutBool emits bool.
+func (w *Writer) PutBool(b bool) error {
+	return w.e.encodeBool(b)
+}
+
+// PutInt emits int.
+func (w *Writer) PutInt(i int64) error {
+	return w.e.encodeInt(i)
+}
+
+// PutLong emits long.
+func (w *Writer) PutLong(b *big.Int) error {
+	return w.e.encodeLong(b)
+}
+
+// PutFloat emits float.
+func (w *Writer) PutFloat(f float64) error {
+	return w.e.encodeFloat(f)
+}
+
+// PutString emits Go string the same way as Encoder does by default:
+// as py2 str for protocol ≤ 2, and as py3 str for protocol ≥ 3.
+func (w *Writer) PutString(s string) error {
+	return w.e.encodeString(s)
+}
+
+// PutUnicode emits py2 unicode / py3 str.
+func (w *Writer) PutUnicode(s string) error {
+	return w.e.encodeUnicode(s)
+}
+
+// PutByteString emits py2 str.
+func (w *Writer) PutByteString(s string) error {
+	return w.e.encodeByteString(s)
+}
+
+// PutBytes emits bytes.
+func (w *Writer) PutBytes(b Bytes) error {
+	return w.e.encodeBytes(b)
+}
+
+// PutByteArray emits bytearray.
+func (w *Writer) PutByteArray(b []byte) error {
+	return w.e.encodeByteArray(b)
+}
+
+// PutGlobal emits reference to module.name with GLOBAL, or, for protocol ≥ 4,
+// with STACK_GLOBAL.
+func (w *Writer) PutGlobal(module, name string) error {
+	return w.e.encodeClass(&Class{Module: module, Name: name})
+}
@@ -0,0 +1,60 @@
+package ogórek
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// TestWriter verifies that Writer emits opcodes with argument encoding
+// appropriate for its protocol.
+func TestWriter(t *testing.T) {
+	call := Call{Callable: Class{Module: "decimal", Name: "Decimal"}, Args: Tuple{"1"}}
+	want := Tuple{call, call, int64(300)}
+
+	for proto := 0; proto <= highestProtocol; proto++ {
+		buf := &bytes.Buffer{}
+		w := NewWriter(buf, proto)
+		var err error
+		for _, put := range []func() error{
+			w.PutMark,
+			func() error { return w.PutGlobal("decimal", "Decimal") },
+			w.PutMark,
+			func() error { return w.PutUnicode("1") },
+			w.PutTuple,
+			w.PutReduce,
+			func() error { return w.PutPut(0) },
+			func() error { return w.PutGet(0) },
+			func() error { return w.PutInt(300) },
+			w.PutTuple,
+			w.PutStop,
+		} {
+			if err == nil {
+				err = put()
+			}
+		}
+		if err != nil {
+			t.Fatalf("protocol %d: %s", proto, err)
+		}
+
+		pickle := buf.String()
+		switch proto {
+		case 0:
+			if want := "(cdecimal\nDecimal\n(V1\ntRp0\ng0\nI300\nt."; pickle != want {
+				t.Errorf("protocol 0:\nhave: %q\nwant: %q", pickle, want)
+			}
+		case 4:
+			if want := "(\x8c\adecimal\x8c\aDecimal\x93(\x8c\x011tRq\x00h\x00M,\x01t."; pickle != want {
+				t.Errorf("protocol 4:\nhave: %q\nwant: %q", pickle, want)
+			}
+		}
+
+		obj, err := NewDecoder(buf).Decode()
+		if err != nil {
+			t.Fatalf("protocol %d: decode: %s", proto, err)
+		}
+		if !reflect.DeepEqual(obj, want) {
+			t.Errorf("protocol %d: decode:\nhave: %#v\nwant: %#v", proto, obj, want)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+//go:build purego
+
+package ogórek
+
+import (
+	"reflect"
+)
+
+// structPrivateOK is whether structField can access private fields.
+const structPrivateOK = false
+
+// structField returns i'th field of struct *r in the form, that allows to
+// call .Interface() on it.
+//
+// Without unsafe private fields cannot be accessed, and ok=false is returned
+// for them. Dict then treats structs with private fields as unhashable, and
+// compares them with reflect.DeepEqual.
+func structField(r *reflect.Value, i int) (f reflect.Value, ok bool) {
+	if !r.Type().Field(i).IsExported() {
+		return reflect.Value{}, false
+	}
+	return r.Field(i), true
+}
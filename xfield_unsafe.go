@@ -0,0 +1,36 @@
+//go:build !purego
+
+package ogórek
+
+import (
+	"reflect"
+)
+
+// structPrivateOK is whether structField can access private fields.
+const structPrivateOK = true
+
+// structField returns i'th field of struct *r in the form, that allows to
+// call .Interface() on it.
+//
+// .Interface() is not allowed if the field is private. Work around the
+// protection via unsafe. We may need to switch *r to struct copy if it is not
+// addressable because Addr() is used in the workaround.
+// https://stackoverflow.com/a/43918797/9456786
+//
+// ok is always true here. See xfield_purego.go for the build without unsafe.
+func structField(r *reflect.Value, i int) (f reflect.Value, ok bool) {
+	f = r.Field(i)
+	ftyp := r.Type().Field(i)
+	if ftyp.IsExported() {
+		return f, true
+	}
+
+	if !f.CanAddr() {
+		// switch r to addressable copy
+		r_ := reflect.New(r.Type()).Elem()
+		r_.Set(*r)
+		*r = r_
+		f = r.Field(i)
+	}
+	return reflect.NewAt(ftyp.Type, f.Addr().UnsafePointer()).Elem(), true
+}
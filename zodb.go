@@ -0,0 +1,321 @@
+package ogórek
+// Helpers for ZODB persistent references and BTrees.
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// P64 converts oid to its 8-byte big-endian representation used by ZODB.
+//
+// It mirrors p64 from ZODB.utils.
+func P64(oid uint64) Bytes {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], oid)
+	return Bytes(b[:])
+}
+
+// U64 converts 8-byte big-endian oid representation used by ZODB to uint64.
+//
+// It mirrors u64 from ZODB.utils. x can be [Bytes], [ByteString], or string
+// - the latter is how Python2 oids are decoded outside of StrictUnicode mode,
+// or []byte - that is how Python3 oids are decoded in BytesAsSlice mode.
+func U64(x any) (uint64, error) {
+	var b string
+	switch x := x.(type) {
+	case Bytes:
+		b = string(x)
+	case ByteString:
+		b = string(x)
+	case string:
+		b = x
+	case []byte:
+		b = string(x)
+	default:
+		return 0, fmt.Errorf("oid: expect bytes|bytestr|unicode; got %T", x)
+	}
+	if len(b) != 8 {
+		return 0, fmt.Errorf("oid: expect 8 bytes; got %d", len(b))
+	}
+	return binary.BigEndian.Uint64([]byte(b)), nil
+}
+
+// ZPid represents persistent reference in one of canonical forms used by ZODB.
+//
+// The forms are:
+//
+//	oid                           ; Class and Database are not set
+//	(oid, class)                  ; Class is set
+//	['w', (oid,)]                 ; Weak is set
+//	['w', (oid, database)]        ; Weak and Database are set
+//	['n', (database, oid)]        ; Database is set
+//	['m', (database, oid, class)] ; Database and Class are set
+//
+// See ZODB.serialize for details.
+type ZPid struct {
+	Oid      uint64
+	Class    Class  // referenced object class; zero if not specified
+	Database string // name of referenced database for cross-database references
+	Weak     bool   // whether the reference is persistent weak reference
+}
+
+// Pid returns pid object in canonical ZODB form corresponding to p.
+//
+// The result can be used as [Ref.Pid]. Class is not part of weak references
+// and is ignored for them.
+func (p ZPid) Pid() any {
+	oid := P64(p.Oid)
+	haveClass := p.Class != Class{}
+
+	switch {
+	case p.Weak && p.Database != "":
+		return []any{"w", Tuple{oid, p.Database}}
+	case p.Weak:
+		return []any{"w", Tuple{oid}}
+	case p.Database != "" && haveClass:
+		return []any{"m", Tuple{p.Database, oid, p.Class}}
+	case p.Database != "":
+		return []any{"n", Tuple{p.Database, oid}}
+	case haveClass:
+		return Tuple{oid, p.Class}
+	default:
+		return oid
+	}
+}
+
+// ParseZPid parses pid object in canonical ZODB form.
+//
+// It is the reverse operation to [ZPid.Pid] and can be used in
+// [DecoderConfig.PersistentLoad] to handle Ref.Pid of decoded ZODB data.
+func ParseZPid(pid any) (ZPid, error) {
+	var p ZPid
+	var err error
+
+	switch pid := pid.(type) {
+	// oid
+	case Bytes, ByteString, string, []byte:
+		p.Oid, err = U64(pid)
+
+	// (oid, class)
+	case Tuple:
+		if len(pid) != 2 {
+			return p, fmt.Errorf("zpid: tuple: expect (oid, class); got %d items", len(pid))
+		}
+		p.Oid, err = U64(pid[0])
+		if err == nil {
+			p.Class, err = zpidClass(pid[1])
+		}
+
+	// [kind, args]
+	case []any:
+		if len(pid) != 2 {
+			return p, fmt.Errorf("zpid: list: expect [kind, args]; got %d items", len(pid))
+		}
+		kind, err := AsString(pid[0])
+		if err != nil {
+			return p, fmt.Errorf("zpid: list: kind: %s", err)
+		}
+		args, ok := pid[1].(Tuple)
+		if !ok {
+			return p, fmt.Errorf("zpid: list: args: expect tuple; got %T", pid[1])
+		}
+		return parseZPidList(kind, args)
+
+	default:
+		return p, fmt.Errorf("zpid: unexpected type %T", pid)
+	}
+
+	if err != nil {
+		return p, fmt.Errorf("zpid: %s", err)
+	}
+	return p, nil
+}
+
+// parseZPidList serves ParseZPid for [kind, args] form.
+func parseZPidList(kind string, args Tuple) (p ZPid, err error) {
+	nargsOK := false
+	switch kind {
+	case "w":
+		p.Weak = true
+		nargsOK = len(args) == 1 || len(args) == 2
+		if nargsOK {
+			p.Oid, err = U64(args[0])
+			if err == nil && len(args) == 2 {
+				p.Database, err = AsString(args[1])
+			}
+		}
+
+	case "n", "m":
+		nargsOK = (kind == "n" && len(args) == 2) || (kind == "m" && len(args) == 3)
+		if nargsOK {
+			p.Database, err = AsString(args[0])
+			if err == nil {
+				p.Oid, err = U64(args[1])
+			}
+			if err == nil && kind == "m" {
+				p.Class, err = zpidClass(args[2])
+			}
+		}
+
+	default:
+		return p, fmt.Errorf("zpid: list: unknown kind %q", kind)
+	}
+
+	if !nargsOK {
+		return p, fmt.Errorf("zpid: list: %q: unexpected number of args %d", kind, len(args))
+	}
+	if err != nil {
+		return p, fmt.Errorf("zpid: list: %q: %s", kind, err)
+	}
+	return p, nil
+}
+
+// zpidClass decodes class part of ZODB persistent reference.
+func zpidClass(x any) (Class, error) {
+	class, ok := x.(Class)
+	if !ok {
+		return class, fmt.Errorf("class: expect class; got %T", x)
+	}
+	return class, nil
+}
+
+
+// ZWeakRef represents ZODB persistent weak reference - persistent.wref.WeakRef object.
+//
+// ZODB does not pickle WeakRef objects themselves. Instead they are replaced
+// with persistent references of ['w', (oid,)] or ['w', (oid, database)] form.
+// ZWeakRef is encoded back to such persistent reference.
+type ZWeakRef struct {
+	Oid      uint64
+	Database string // name of referenced database for cross-database references
+}
+
+// ZPid returns persistent ID of the weak reference.
+func (w ZWeakRef) ZPid() ZPid {
+	return ZPid{Oid: w.Oid, Database: w.Database, Weak: true}
+}
+
+// ZPersistentLoad returns function, that can be used as
+// [DecoderConfig.PersistentLoad] to decode ZODB data.
+//
+// Persistent weak references are decoded as [ZWeakRef] without loading
+// referenced objects - similarly to Python, where WeakRef is resolved only
+// when called. All other references are resolved via load. If load is nil,
+// they are decoded as [ZPid].
+func ZPersistentLoad(load func(p ZPid) (any, error)) func(ref Ref) (any, error) {
+	return func(ref Ref) (any, error) {
+		p, err := ParseZPid(ref.Pid)
+		if err != nil {
+			return nil, err
+		}
+		if p.Weak {
+			return ZWeakRef{Oid: p.Oid, Database: p.Database}, nil
+		}
+		if load == nil {
+			return p, nil
+		}
+		return load(p)
+	}
+}
+
+// AsZWeakRef checks whether decoded x represents ZODB persistent weak reference.
+//
+// x can be [ZWeakRef], or [Ref] with weak reference persistent ID, as decoded
+// without [ZPersistentLoad].
+func AsZWeakRef(x any) (ZWeakRef, bool) {
+	switch x := x.(type) {
+	case ZWeakRef:
+		return x, true
+	case Ref:
+		p, err := ParseZPid(x.Pid)
+		if err == nil && p.Weak {
+			return ZWeakRef{Oid: p.Oid, Database: p.Database}, true
+		}
+	}
+	return ZWeakRef{}, false
+}
+
+// BTreeItems returns items of ZODB BTree with given state.
+//
+// state is decoded state of a mapping BTree, for example OOBTree or IOBTree,
+// as stored in the second pickle of its ZODB record. The items are returned
+// in key order.
+//
+// A small BTree stores its only bucket inline in its state. A bigger BTree
+// refers to its buckets via persistent references, and loadBucket is used
+// to retrieve decoded state of a bucket by its reference. The buckets are
+// visited starting from the first one, following their next links.
+func BTreeItems(state any, loadBucket func(ref any) (state any, err error)) ([]KV, error) {
+	var items []KV
+
+	switch state := state.(type) {
+	// empty tree
+	case None:
+		return items, nil
+
+	case Tuple:
+		switch {
+		// ((bucketState,),)  - tree with single inline bucket
+		case len(state) == 1:
+			children, ok := state[0].(Tuple)
+			if !(ok && len(children) == 1) {
+				return nil, fmt.Errorf("btree: expect ((bucket,),) or (children, firstbucket)")
+			}
+			items, next, err := BucketItems(children[0])
+			if err != nil {
+				return nil, err
+			}
+			if next != nil {
+				return nil, fmt.Errorf("btree: inline bucket has next")
+			}
+			return items, nil
+
+		// (children, firstbucket)
+		case len(state) == 2:
+			bucket := state[1]
+			for bucket != nil {
+				bstate, err := loadBucket(bucket)
+				if err != nil {
+					return nil, fmt.Errorf("btree: load bucket: %w", err)
+				}
+				var bitems []KV
+				bitems, bucket, err = BucketItems(bstate)
+				if err != nil {
+					return nil, err
+				}
+				items = append(items, bitems...)
+			}
+			return items, nil
+		}
+	}
+
+	return nil, fmt.Errorf("btree: unexpected state %T", state)
+}
+
+// BucketItems returns items of ZODB BTrees bucket with given state.
+//
+// state is decoded state of a mapping bucket, for example OOBucket, of form
+// ((k₁, v₁, k₂, v₂, ...),) or ((k₁, v₁, k₂, v₂, ...), next). next is returned
+// as is, or nil if the bucket is the last one.
+func BucketItems(state any) (items []KV, next any, err error) {
+	t, ok := state.(Tuple)
+	if !(ok && (len(t) == 1 || len(t) == 2)) {
+		return nil, nil, fmt.Errorf("bucket: expect (kv,) or (kv, next); got %T", state)
+	}
+	kv, ok := t[0].(Tuple)
+	if !ok {
+		return nil, nil, fmt.Errorf("bucket: kv: expect tuple; got %T", t[0])
+	}
+	if len(kv) % 2 != 0 {
+		return nil, nil, fmt.Errorf("bucket: kv: odd number of items %d", len(kv))
+	}
+
+	items = make([]KV, len(kv)/2)
+	for i := range items {
+		items[i] = KV{kv[2*i], kv[2*i+1]}
+	}
+	if len(t) == 2 {
+		next = t[1]
+	}
+	return items, next, nil
+}
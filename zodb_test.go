@@ -0,0 +1,217 @@
+package ogórek
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestP64U64(t *testing.T) {
+	for _, oid := range []uint64{0, 1, 0x0102030405060708, 0xffffffffffffffff} {
+		b := P64(oid)
+		for _, x := range []any{b, ByteString(b), string(b)} {
+			oid2, err := U64(x)
+			if !(oid2 == oid && err == nil) {
+				t.Errorf("U64(%#v) -> %x, %v  ; want %x", x, oid2, err, oid)
+			}
+		}
+	}
+
+	for _, x := range []any{Bytes("1234567"), Bytes("123456789"), int64(1)} {
+		_, err := U64(x)
+		if err == nil {
+			t.Errorf("U64(%#v): no error", x)
+		}
+	}
+}
+
+func TestZPid(t *testing.T) {
+	oid := P64(0x10)
+	class := Class{"BTrees.OOBTree", "OOBTree"}
+
+	testv := []struct {
+		zpid ZPid
+		pid  any
+	}{
+		{ZPid{Oid: 0x10}, oid},
+		{ZPid{Oid: 0x10, Class: class}, Tuple{oid, class}},
+		{ZPid{Oid: 0x10, Weak: true}, []any{"w", Tuple{oid}}},
+		{ZPid{Oid: 0x10, Weak: true, Database: "db2"}, []any{"w", Tuple{oid, "db2"}}},
+		{ZPid{Oid: 0x10, Database: "db2"}, []any{"n", Tuple{"db2", oid}}},
+		{ZPid{Oid: 0x10, Database: "db2", Class: class}, []any{"m", Tuple{"db2", oid, class}}},
+	}
+
+	for _, tt := range testv {
+		pid := tt.zpid.Pid()
+		if !reflect.DeepEqual(pid, tt.pid) {
+			t.Errorf("%#v.Pid():\nhave: %#v\nwant: %#v", tt.zpid, pid, tt.pid)
+		}
+		zpid, err := ParseZPid(tt.pid)
+		if !(zpid == tt.zpid && err == nil) {
+			t.Errorf("ParseZPid(%#v):\nhave: %#v, %v\nwant: %#v", tt.pid, zpid, err, tt.zpid)
+		}
+	}
+
+	// py2 ZODB pickle: (oid, class) with oid as str
+	data := "\x80\x02U\x08\x00\x00\x00\x00\x00\x00\x00\x10cBTrees.OOBTree\nOOBTree\n\x86Q."
+	for _, strict := range []bool{false, true} {
+		var zpid ZPid
+		d := NewDecoderWithConfig(bytes.NewReader([]byte(data)), &DecoderConfig{
+			StrictUnicode: strict,
+			PersistentLoad: func(ref Ref) (any, error) {
+				var err error
+				zpid, err = ParseZPid(ref.Pid)
+				return zpid, err
+			},
+		})
+		_, err := d.Decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := (ZPid{Oid: 0x10, Class: class}); zpid != want {
+			t.Errorf("decode strict=%v: have %#v  ; want %#v", strict, zpid, want)
+		}
+	}
+
+	// invalid pids
+	errv := []struct {
+		pid any
+		err string
+	}{
+		{int64(1), "zpid: unexpected type int64"},
+		{Bytes("abc"), "zpid: oid: expect 8 bytes; got 3"},
+		{Tuple{oid}, "zpid: tuple: expect (oid, class); got 1 items"},
+		{Tuple{oid, "a"}, "zpid: class: expect class; got string"},
+		{[]any{"x", Tuple{oid}}, `zpid: list: unknown kind "x"`},
+		{[]any{"w", oid}, "zpid: list: args: expect tuple; got ogórek.Bytes"},
+		{[]any{"w", Tuple{}}, `zpid: list: "w": unexpected number of args 0`},
+		{[]any{"n", Tuple{"db", oid, class}}, `zpid: list: "n": unexpected number of args 3`},
+		{[]any{"m", Tuple{"db", int64(1), class}}, `zpid: list: "m": oid: expect bytes|bytestr|unicode; got int64`},
+	}
+	for _, tt := range errv {
+		_, err := ParseZPid(tt.pid)
+		if err == nil || !strings.HasPrefix(err.Error(), tt.err) {
+			t.Errorf("ParseZPid(%#v): have error %v  ; want %q", tt.pid, err, tt.err)
+		}
+	}
+}
+
+func TestZWeakRef(t *testing.T) {
+	// [WeakRef(obj<0x11>), obj<0x12>] pickled by py3 ZODB
+	data := "\x80\x03]q\x00(]q\x01(X\x01\x00\x00\x00wq\x02C\x08\x00\x00\x00\x00\x00\x00\x00\x11q\x03\x85q\x04eQC\x08\x00\x00\x00\x00\x00\x00\x00\x12q\x05Qe."
+
+	// without ZPersistentLoad weak references are recognized via AsZWeakRef
+	obj, err := NewDecoder(strings.NewReader(data)).Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := obj.([]any)
+	if w, ok := AsZWeakRef(l[0]); !(ok && w == ZWeakRef{Oid: 0x11}) {
+		t.Errorf("AsZWeakRef(%#v) -> %#v, %v", l[0], w, ok)
+	}
+	if w, ok := AsZWeakRef(l[1]); ok {
+		t.Errorf("AsZWeakRef(%#v) -> %#v, %v  ; want !ok", l[1], w, ok)
+	}
+
+	// with ZPersistentLoad weak references are decoded as ZWeakRef and are not loaded
+	var loaded []ZPid
+	d := NewDecoderWithConfig(strings.NewReader(data), &DecoderConfig{
+		PersistentLoad: ZPersistentLoad(func(p ZPid) (any, error) {
+			loaded = append(loaded, p)
+			return "obj", nil
+		}),
+	})
+	obj, err = d.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []any{ZWeakRef{Oid: 0x11}, "obj"}; !reflect.DeepEqual(obj, want) {
+		t.Errorf("decode:\nhave: %#v\nwant: %#v", obj, want)
+	}
+	if want := []ZPid{{Oid: 0x12}}; !reflect.DeepEqual(loaded, want) {
+		t.Errorf("loaded:\nhave: %#v\nwant: %#v", loaded, want)
+	}
+
+	// ZWeakRef encodes back as weak persistent reference
+	w := ZWeakRef{Oid: 0x11, Database: "db2"}
+	buf := &bytes.Buffer{}
+	err = NewEncoderWithConfig(buf, &EncoderConfig{Protocol: 3}).Encode(w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj, err = NewDecoderWithConfig(buf, &DecoderConfig{PersistentLoad: ZPersistentLoad(nil)}).Decode()
+	if !(obj == w && err == nil) {
+		t.Errorf("encode/decode %#v: have %#v, %v", w, obj, err)
+	}
+}
+
+func TestBTreeItems(t *testing.T) {
+	kv := func(kv ...any) []KV {
+		var items []KV
+		for i := 0; i < len(kv); i += 2 {
+			items = append(items, KV{kv[i], kv[i+1]})
+		}
+		return items
+	}
+
+	// buckets of a big tree, referenced by oid
+	buckets := map[uint64]any{
+		1: Tuple{Tuple{"a", int64(1), "b", int64(2)}, Ref{P64(2)}},
+		2: Tuple{Tuple{"c", int64(3)}, Ref{P64(3)}},
+		3: Tuple{Tuple{"d", int64(4)}},
+	}
+	loadBucket := func(ref any) (any, error) {
+		oid, err := U64(ref.(Ref).Pid)
+		if err != nil {
+			return nil, err
+		}
+		return buckets[oid], nil
+	}
+
+	testv := []struct {
+		state any
+		items []KV
+	}{
+		{None{}, nil},
+		{Tuple{Tuple{Tuple{Tuple{"a", int64(1), "b", int64(2)}}}}, kv("a", int64(1), "b", int64(2))},
+		{Tuple{Tuple{Ref{P64(1)}, "c", Ref{P64(2)}}, Ref{P64(1)}},
+			kv("a", int64(1), "b", int64(2), "c", int64(3), "d", int64(4))},
+	}
+	for _, tt := range testv {
+		items, err := BTreeItems(tt.state, loadBucket)
+		if !(reflect.DeepEqual(items, tt.items) && err == nil) {
+			t.Errorf("BTreeItems(%#v):\nhave: %#v, %v\nwant: %#v", tt.state, items, err, tt.items)
+		}
+	}
+
+	// state decoded from pickle as saved by BTrees:
+	// pickle.dumps(OOBTree({'a':1, 'b':2}).__getstate__(), 2)
+	data := "\x80\x02(X\x01\x00\x00\x00aq\x00K\x01X\x01\x00\x00\x00bq\x01K\x02tq\x02\x85q\x03\x85q\x04\x85q\x05."
+	state, err := NewDecoder(bytes.NewReader([]byte(data))).Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	items, err := BTreeItems(state, nil)
+	if want := kv("a", int64(1), "b", int64(2)); !(reflect.DeepEqual(items, want) && err == nil) {
+		t.Errorf("BTreeItems(decoded):\nhave: %#v, %v\nwant: %#v", items, err, want)
+	}
+
+	// invalid states
+	errv := []struct {
+		state any
+		err   string
+	}{
+		{int64(1), "btree: unexpected state int64"},
+		{Tuple{Tuple{}}, "btree: expect ((bucket,),)"},
+		{Tuple{Tuple{Tuple{Tuple{"a"}}}}, "bucket: kv: odd number of items 1"},
+		{Tuple{Tuple{Tuple{Tuple{"a", int64(1)}, Ref{P64(2)}}}}, "btree: inline bucket has next"},
+		{Tuple{Tuple{}, Ref{P64(9)}}, "bucket: expect (kv,) or (kv, next); got <nil>"},
+	}
+	for _, tt := range errv {
+		_, err := BTreeItems(tt.state, loadBucket)
+		if err == nil || !strings.HasPrefix(err.Error(), tt.err) {
+			t.Errorf("BTreeItems(%#v): have error %v  ; want %q", tt.state, err, tt.err)
+		}
+	}
+}